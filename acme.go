@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultACMECacheDir    = "/var/lib/vsTaskViewer/acme"
+	defaultACMEHTTPAddress = ":80"
+)
+
+// newACMEManager creates an autocert manager that obtains and renews certificates for the configured domains
+func newACMEManager(cfg ACMEConfig) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		Email:      cfg.Email,
+	}
+}
+
+// prepareACMECacheDir creates the ACME cache directory and hands it to the exec user,
+// as certificates are renewed after dropping privileges
+func prepareACMECacheDir(cacheDir string, targetUser string) error {
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("cannot create ACME cache directory %s: %w", cacheDir, err)
+	}
+
+	if os.Getuid() != 0 {
+		// Not running as root, the directory already belongs to the current user
+		return nil
+	}
+
+	uid, gid, err := lookupUser(targetUser)
+	if err != nil {
+		return fmt.Errorf("failed to lookup target user %s: %w", targetUser, err)
+	}
+	if err := os.Chown(cacheDir, uid, gid); err != nil {
+		return fmt.Errorf("failed to set ownership of ACME cache directory %s to user %s: %w", cacheDir, targetUser, err)
+	}
+
+	log.Printf("Prepared ACME cache directory: %s (UID: %d, GID: %d)", cacheDir, uid, gid)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestACMEConfigWiring(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	configContent := `[auth]
+secret = "test-secret"
+
+[acme]
+domains = ["tasks.example.com"]
+email = "ops@example.com"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := loadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v, want no error", err)
+	}
+	if config.ACME.CacheDir != defaultACMECacheDir {
+		t.Errorf("ACME.CacheDir = %q; want default %q", config.ACME.CacheDir, defaultACMECacheDir)
+	}
+	if config.ACME.HTTPAddress != defaultACMEHTTPAddress {
+		t.Errorf("ACME.HTTPAddress = %q; want default %q", config.ACME.HTTPAddress, defaultACMEHTTPAddress)
+	}
+
+	manager := newACMEManager(config.ACME)
+	if manager.Email != "ops@example.com" {
+		t.Errorf("manager.Email = %q; want %q", manager.Email, "ops@example.com")
+	}
+	if cache, ok := manager.Cache.(autocert.DirCache); !ok || string(cache) != defaultACMECacheDir {
+		t.Errorf("manager.Cache = %#v; want DirCache(%q)", manager.Cache, defaultACMECacheDir)
+	}
+	if err := manager.HostPolicy(context.Background(), "tasks.example.com"); err != nil {
+		t.Errorf("HostPolicy(tasks.example.com) = %v; want nil", err)
+	}
+	if err := manager.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("HostPolicy(other.example.com) = nil; want error")
+	}
+
+	tlsConfig := buildServerTLSConfig(nil, manager.GetCertificate)
+	if tlsConfig.GetCertificate == nil {
+		t.Error("buildServerTLSConfig() did not set GetCertificate")
+	}
+
+	// The client auth config must not be modified when used as base
+	caPEM, _ := createTestClientCertificates(t, "test-client")
+	clientTLSConfig, err := buildClientAuthTLSConfig(caPEM)
+	if err != nil {
+		t.Fatalf("buildClientAuthTLSConfig() = %v", err)
+	}
+	tlsConfig = buildServerTLSConfig(clientTLSConfig, manager.GetCertificate)
+	tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	if tlsConfig.ClientCAs == nil {
+		t.Error("buildServerTLSConfig() dropped client CAs")
+	}
+	if clientTLSConfig.GetCertificate != nil || len(clientTLSConfig.NextProtos) != 0 {
+		t.Error("buildServerTLSConfig() modified the client auth config")
+	}
+}
+
+func TestPrepareACMECacheDir(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "acme-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skipf("Cannot get current user: %v", err)
+	}
+
+	cacheDir := filepath.Join(tmpDir, "acme", "cache")
+	if err := prepareACMECacheDir(cacheDir, currentUser.Username); err != nil {
+		t.Fatalf("prepareACMECacheDir() = %v; want nil", err)
+	}
+
+	info, err := os.Stat(cacheDir)
+	if err != nil {
+		t.Fatalf("cache directory not created: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("cache directory permissions = %o; want 700", info.Mode().Perm())
+	}
+}
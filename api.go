@@ -5,25 +5,94 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // StartTaskRequest represents a request to start a task
 type StartTaskRequest struct {
 	TaskName   string                 `json:"task_name"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"` // Optional parameters for the task
+	Label      string                 `json:"label,omitempty"`      // Optional label to tell concurrent runs apart
 }
 
 // StartTaskResponse represents the response when starting a task
 type StartTaskResponse struct {
-	TaskID    string `json:"task_id"`
-	ViewerURL string `json:"viewer_url"`
+	TaskID        string `json:"task_id"`
+	Label         string `json:"label,omitempty"`
+	ViewerURL     string `json:"viewer_url,omitempty"` // Viewer URL including the token (omitted if server.omit_tokenized_viewer_url is set)
+	ViewerURLBase string `json:"viewer_url_base"`      // Viewer URL without the token, safe for logging/sharing
+	ViewerToken   string `json:"viewer_token"`         // Viewer token, to be appended as "&token=..." to ViewerURLBase
+
+	// Set with ?wait=true once the task has finished within the wait timeout
+	Completed       bool   `json:"completed,omitempty"`
+	ExitCode        *int   `json:"exit_code,omitempty"`
+	Stdout          string `json:"stdout,omitempty"`           // Last waitOutputTailSize bytes of stdout
+	Stderr          string `json:"stderr,omitempty"`           // Last waitOutputTailSize bytes of stderr
+	OutputTruncated bool   `json:"output_truncated,omitempty"` // Whether stdout or stderr was cut to its tail
+}
+
+// Limits for /api/start?wait=true
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 300 * time.Second
+	waitOutputTailSize = 64 * 1024
+)
+
+// parseWaitOptions reads the wait and timeout (seconds) query parameters of /api/start
+func parseWaitOptions(query url.Values) (bool, time.Duration, error) {
+	wait := query.Get("wait") == "true"
+	timeoutParam := query.Get("timeout")
+	if !wait {
+		if timeoutParam != "" {
+			return false, 0, fmt.Errorf("timeout requires wait=true")
+		}
+		return false, 0, nil
+	}
+	if timeoutParam == "" {
+		return true, defaultWaitTimeout, nil
+	}
+	seconds, err := strconv.Atoi(timeoutParam)
+	if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxWaitTimeout {
+		return false, 0, fmt.Errorf("timeout must be between 1 and %d seconds", int(maxWaitTimeout.Seconds()))
+	}
+	return true, time.Duration(seconds) * time.Second, nil
+}
+
+// readFileTail returns up to maxBytes from the end of a file and whether earlier content was cut off
+func readFileTail(path string, maxBytes int64) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", false
+	}
+	var offset int64
+	if info.Size() > maxBytes {
+		offset = info.Size() - maxBytes
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+	if err != nil {
+		return "", false
+	}
+	return string(data), offset > 0
 }
 
 // normalizeJSON normalizes JSON by parsing and re-encoding it in compact form.
@@ -43,6 +112,46 @@ func normalizeJSON(data []byte) ([]byte, error) {
 	return normalized, nil
 }
 
+// formParamPrefix marks task parameters in form-encoded start requests (param_<name>=value)
+const formParamPrefix = "param_"
+
+// isFormRequest reports whether the request body is application/x-www-form-urlencoded
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// parseFormStartRequest maps a form-encoded body (task_name, label, param_<name>) to a StartTaskRequest.
+// It also returns the canonical form used for the body hash: the fields re-encoded with sorted keys,
+// so the order of the fields doesn't affect the hash. Parameter values are passed as strings.
+func parseFormStartRequest(body []byte) (*StartTaskRequest, []byte, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed form data")
+	}
+
+	req := &StartTaskRequest{}
+	for key, vals := range values {
+		if len(vals) != 1 {
+			return nil, nil, fmt.Errorf("field '%s' must be provided exactly once", key)
+		}
+		switch {
+		case key == "task_name":
+			req.TaskName = vals[0]
+		case key == "label":
+			req.Label = vals[0]
+		case strings.HasPrefix(key, formParamPrefix) && len(key) > len(formParamPrefix):
+			if req.Parameters == nil {
+				req.Parameters = make(map[string]interface{})
+			}
+			req.Parameters[strings.TrimPrefix(key, formParamPrefix)] = vals[0]
+		default:
+			return nil, nil, fmt.Errorf("unknown field '%s'", key)
+		}
+	}
+	return req, []byte(values.Encode()), nil
+}
+
 // computeSHA1Hex computes the SHA1 hash of the given data and returns it as a hex string.
 // This is used to bind API tokens to a specific request body for integrity protection.
 // The data is normalized JSON, so formatting differences (whitespace, line breaks) don't affect the hash.
@@ -54,102 +163,305 @@ func computeSHA1Hex(data []byte) string {
 
 // ErrorResponse represents an error response in JSON format
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error string `json:"error"` // Human-readable message
+	Code  string `json:"code"`  // Machine-readable error code (ErrCode*)
 }
 
+// Error codes returned in ErrorResponse.Code
+const (
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeInvalidParameter = "invalid_parameter"
+	ErrCodeRequestTooLarge  = "request_too_large"
+	ErrCodeTaskNotFound     = "task_not_found"
+	ErrCodeTaskDisabled     = "task_disabled"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeShuttingDown     = "shutting_down"
+	ErrCodeInternal         = "internal_error"
+
+	ErrCodeTooManyConnections  = "too_many_connections"
+	ErrCodeInsufficientStorage = "insufficient_storage"
+	ErrCodePreconditionFailed  = "precondition_failed"
+)
+
 // sendJSONError sends a JSON error response
-func sendJSONError(w http.ResponseWriter, statusCode int, message string) {
+func sendJSONError(w http.ResponseWriter, statusCode int, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
+
+// startErrors maps errors returned by StartTask to the HTTP status and error code of the response,
+// checked in order with errors.Is
+var startErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrTaskDisabled, http.StatusForbidden, ErrCodeTaskDisabled},
+	{ErrTaskNotFound, http.StatusNotFound, ErrCodeTaskNotFound},
+	{ErrServerDraining, http.StatusServiceUnavailable, ErrCodeShuttingDown},
+	{ErrTaskDirFull, http.StatusInsufficientStorage, ErrCodeInsufficientStorage},
+	{ErrCommandNotAllowed, http.StatusForbidden, ErrCodeForbidden},
+	{ErrPreCheckFailed, http.StatusPreconditionFailed, ErrCodePreconditionFailed},
+	{ErrParameterValidation, http.StatusBadRequest, ErrCodeInvalidParameter},
+	{ErrEmptyTaskName, http.StatusBadRequest, ErrCodeInvalidRequest},
+	{ErrTaskNameTooLong, http.StatusBadRequest, ErrCodeInvalidRequest},
+	{ErrInvalidTaskName, http.StatusBadRequest, ErrCodeInvalidRequest},
+	{ErrLabelTooLong, http.StatusBadRequest, ErrCodeInvalidRequest},
+	{ErrInvalidLabel, http.StatusBadRequest, ErrCodeInvalidRequest},
+	{ErrUnsafeHeader, http.StatusBadRequest, ErrCodeInvalidRequest},
+}
+
+// startErrorResponse returns the HTTP status and error code for an error returned by StartTask,
+// errors not listed in startErrors are internal
+func startErrorResponse(err error) (int, string) {
+	for _, e := range startErrors {
+		if errors.Is(err, e.err) {
+			return e.status, e.code
+		}
+	}
+	return http.StatusInternalServerError, ErrCodeInternal
+}
+
+// tokenSubject returns the "sub" claim of an API token (empty for other authentication methods)
+func tokenSubject(claims *Claims) string {
+	if claims == nil {
+		return ""
+	}
+	return claims.Subject
 }
 
 // handleStartTask handles requests to start a task
-func handleStartTask(w http.ResponseWriter, r *http.Request, taskManager *TaskManager, config *Config) {
+// Successful starts are recorded in the audit log (nil = disabled)
+func handleStartTask(w http.ResponseWriter, r *http.Request, taskManager *TaskManager, config *Config, auditLogger *AuditLogger) {
 	log.Printf("[API] Start task request from %s", r.RemoteAddr)
-	
-	// Authenticate request - API tokens should have no audience or empty audience
-	apiAudience := ""
-	claims, err := validateJWT(r, config.Auth.Secret, &apiAudience)
-	if err != nil {
-		log.Printf("[API] Authentication failed: %v", err)
-		sendJSONError(w, http.StatusUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+
+	// Request ID for correlating the audit log with the client, echoed back in the response
+	reqID := requestID(r)
+	w.Header().Set("X-Request-ID", reqID)
+
+	// No new tasks while the server waits for running tasks to finish before shutting down
+	if taskManager.IsDraining() {
+		sendJSONError(w, http.StatusServiceUnavailable, ErrCodeShuttingDown, "Server is shutting down")
 		return
 	}
 
+	// Authenticate request - a verified client certificate is sufficient if mTLS API auth is enabled,
+	// otherwise API tokens (signed with auth.secret or an API key) should have no audience or empty audience.
+	// Requests without a token may use the pre-shared X-API-Secret header if auth.api_header_secret is set.
+	var claims *Claims
+	clientCN := clientCertCommonName(r)
+	if config.Server.MTLSAPIAuth && clientCN != "" {
+		log.Printf("[API] Authenticated via client certificate: CN=%s", clientCN)
+	} else if config.Auth.APIHeaderSecret != "" && r.URL.Query().Get("token") == "" {
+		if err := validateAPIHeaderSecret(r, config.Auth.APIHeaderSecret); err != nil {
+			log.Printf("[API] Authentication failed: %v", err)
+			sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+			return
+		}
+		log.Printf("[API] Authenticated via %s header", APISecretHeader)
+	} else {
+		var err error
+		claims, err = validateAPIJWT(r, config.Auth)
+		if err != nil {
+			log.Printf("[API] Authentication failed: %v", err)
+			sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+			return
+		}
+		if claims.Subject != "" {
+			log.Printf("[API] Authenticated token subject: %q", claims.Subject)
+		}
+	}
+
 	if r.Method != http.MethodPost {
-		sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed. Use POST.")
+		sendJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed. Use POST.")
 		return
 	}
 
-	// Read complete request body (with size limit) for integrity check and JSON decoding
-	bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, maxJSONSize))
+	// Optionally block until the task has finished (for short tasks, avoids the WebSocket round trip)
+	wait, waitTimeout, err := parseWaitOptions(r.URL.Query())
 	if err != nil {
-		log.Printf("[API] Failed to read request body: %v", err)
-		sendJSONError(w, http.StatusBadRequest, "Invalid request body")
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid wait options: %v", err))
 		return
 	}
 
-	// Normalize JSON to ensure formatting differences (whitespace, line breaks) don't affect the hash.
-	// This allows clients to send JSON in any valid format while maintaining security.
-	normalizedBody, err := normalizeJSON(bodyBytes)
+	// Read complete request body for integrity check and JSON decoding.
+	// The route limit (MaxBodySizeMiddleware) bounds the body, requests for tasks without
+	// file parameters are limited to maxJSONSize once the task is known.
+	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("[API] Failed to normalize JSON body: %v", err)
-		sendJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Printf("[API] Request body too large: %v", err)
+			sendJSONError(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", maxBytesErr.Limit))
+			return
+		}
+		log.Printf("[API] Failed to read request body: %v", err)
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request body")
 		return
 	}
 
+	// Form-encoded bodies (e.g. from HTML forms) are mapped to the same request, JSON stays the default
+	var formReq *StartTaskRequest
+	var normalizedBody []byte
+	if isFormRequest(r) {
+		formReq, normalizedBody, err = parseFormStartRequest(bodyBytes)
+		if err != nil {
+			log.Printf("[API] Failed to parse form body: %v", err)
+			sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid form data: %v", err))
+			return
+		}
+	} else {
+		// Normalize JSON to ensure formatting differences (whitespace, line breaks) don't affect the hash.
+		// This allows clients to send JSON in any valid format while maintaining security.
+		normalizedBody, err = normalizeJSON(bodyBytes)
+		if err != nil {
+			log.Printf("[API] Failed to normalize JSON body: %v", err)
+			sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid JSON format")
+			return
+		}
+	}
+
 	// Compute SHA1 hash of the normalized body and compare with JWT claim.
 	// This binds the API token to the request payload and prevents body tampering,
 	// while being tolerant of JSON formatting differences.
-	bodyHash := computeSHA1Hex(normalizedBody)
-	if claims.BodySHA1 == "" || claims.BodySHA1 != bodyHash {
-		log.Printf("[API] Body hash mismatch: token_claim=%q, computed=%q", claims.BodySHA1, bodyHash)
-		sendJSONError(w, http.StatusUnauthorized, "Unauthorized: request body does not match token")
-		return
+	// Not needed for client certificate auth, where TLS already protects the body.
+	if claims != nil {
+		bodyHash := computeSHA1Hex(normalizedBody)
+		if claims.BodySHA1 == "" || claims.BodySHA1 != bodyHash {
+			log.Printf("[API] Body hash mismatch: token_claim=%q, computed=%q", claims.BodySHA1, bodyHash)
+			sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized: request body does not match token")
+			return
+		}
 	}
 
 	var req StartTaskRequest
-	// Use limited reader to prevent memory exhaustion
-	if err := decodeJSONRequest(bytes.NewReader(bodyBytes), &req, maxJSONSize); err != nil {
-		log.Printf("[API] Failed to decode request: %v", err)
-		sendJSONError(w, http.StatusBadRequest, "Invalid request format")
-		return
+	if formReq != nil {
+		req = *formReq
+	} else {
+		if err := decodeJSONRequest(bytes.NewReader(bodyBytes), &req, int64(len(bodyBytes))); err != nil {
+			log.Printf("[API] Failed to decode request: %v", err)
+			sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid request format")
+			return
+		}
 	}
 
 	if req.TaskName == "" {
-		sendJSONError(w, http.StatusBadRequest, "task_name is required")
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "task_name is required")
+		return
+	}
+
+	// Tokens of an API key may only start the key's tasks
+	if !tokenAllowsTask(config.Auth, claims, req.TaskName) {
+		log.Printf("[API] API key '%s' is not allowed to start task '%s'", claims.KeyID, req.TaskName)
+		sendJSONError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Forbidden: API key is not allowed to start task '%s'", req.TaskName))
+		return
+	}
+	if names := disallowedParameters(config.Auth, claims, req.Parameters); len(names) > 0 {
+		log.Printf("[API] API key '%s' is not allowed to set parameters %v of task '%s'", claims.KeyID, names, req.TaskName)
+		sendJSONError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Forbidden: API key is not allowed to set parameter(s) %s", strings.Join(names, ", ")))
 		return
 	}
 
+	// Only requests carrying file parameters may use the full route limit
+	if int64(len(bodyBytes)) > maxJSONSize && !hasFileParameters(findTaskConfig(config.Tasks, req.TaskName)) {
+		log.Printf("[API] Request body too large for task '%s': %d bytes (max %d)", req.TaskName, len(bodyBytes), maxJSONSize)
+		sendJSONError(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", maxJSONSize))
+		return
+	}
+
+	// Reject oversized parameter maps before validating each parameter
+	if err := checkParameterLimits(req.Parameters, config.Server.MaxParameters, config.Server.MaxParametersSize); err != nil {
+		log.Printf("[API] Parameter limits exceeded: %v", err)
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidParameter, fmt.Sprintf("Invalid parameters: %v", err))
+		return
+	}
+
+	// Label from the request body, or from the configured trusted header (e.g. set by a gateway)
+	label := req.Label
+	if label == "" && config.Server.LabelHeader != "" {
+		label = r.Header.Get(config.Server.LabelHeader)
+	}
+	if label != "" {
+		if err := validateTaskLabel(label); err != nil {
+			sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid label: %v", err))
+			return
+		}
+	}
+
 	// Start the task with parameters
-	taskID, err := taskManager.StartTask(req.TaskName, req.Parameters)
+	taskID, err := taskManager.StartTaskWithOptions(req.TaskName, req.Parameters, StartOptions{Label: label, Headers: r.Header})
 	if err != nil {
 		log.Printf("[API] Failed to start task '%s': %v", req.TaskName, err)
-		sendJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start task: %v", err))
+		status, code := startErrorResponse(err)
+		sendJSONError(w, status, code, fmt.Sprintf("Failed to start task: %v", err))
 		return
 	}
-	
+
 	log.Printf("[API] Task created: task_id=%s, task_name=%s", taskID, req.TaskName)
 
+	if err := auditLogger.Log(AuditEntry{
+		Timestamp:    time.Now().UTC(),
+		Trigger:      TriggerAPI,
+		ClientIP:     remoteIP(r),
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		ClientCN:     clientCN,
+		Subject:      tokenSubject(claims),
+		RequestID:    reqID,
+		TaskID:       taskID,
+		TaskName:     req.TaskName,
+		Label:        label,
+		Parameters:   parameterNames(req.Parameters, findTaskConfig(config.Tasks, req.TaskName).Parameters),
+	}); err != nil {
+		log.Printf("[AUDIT] Failed to record start of task %s: %v", taskID, err)
+	}
+
 	// Generate JWT token for viewer access
 	viewerToken, err := generateViewerToken(taskID, config.Auth.Secret, 24*time.Hour)
 	if err != nil {
-		sendJSONError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to generate viewer token: %v", err))
+		sendJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Failed to generate viewer token: %v", err))
 		return
 	}
 
-	// Build viewer URL
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
-	}
-	viewerURL := fmt.Sprintf("%s://%s/viewer?task_id=%s&token=%s", scheme, r.Host, taskID, viewerToken)
+	// Build viewer URL, the built-in viewer or an external front-end (server.viewer_url_template)
+	viewerURLBase, viewerURL := viewerURLs(r, config, taskID, viewerToken)
 
 	// Send response
 	response := StartTaskResponse{
-		TaskID:    taskID,
-		ViewerURL: viewerURL,
+		TaskID:        taskID,
+		Label:         label,
+		ViewerURLBase: viewerURLBase,
+		ViewerToken:   viewerToken,
+	}
+	if !config.Server.OmitTokenizedViewerURL {
+		response.ViewerURL = viewerURL
+	}
+
+	// If the task does not finish in time, the task ID is returned for async follow-up
+	if wait {
+		// The task is cleaned up like an unviewed run once its result has been read
+		taskManager.holdTask(taskID)
+		defer taskManager.releaseTask(taskID)
+
+		// Extend the server's write timeout, which would otherwise cut long waits
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(waitTimeout + 15*time.Second)); err != nil {
+			log.Printf("[API] Failed to extend write deadline for wait, the response may be cut by write_timeout: %v", err)
+		}
+		if exitCode, ok := taskManager.WaitExitCode(taskID, waitTimeout); ok {
+			response.Completed = true
+			response.ExitCode = &exitCode
+			if task, err := taskManager.GetTask(taskID); err == nil {
+				var stdoutTruncated, stderrTruncated bool
+				response.Stdout, stdoutTruncated = readOutputTail(task.OutputDir, "stdout", waitOutputTailSize)
+				response.Stderr, stderrTruncated = readOutputTail(task.OutputDir, "stderr", waitOutputTailSize)
+				response.OutputTruncated = stdoutTruncated || stderrTruncated
+			}
+		} else {
+			log.Printf("[API] Task did not finish within %v, returning task_id for follow-up: task_id=%s", waitTimeout, taskID)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -162,6 +474,7 @@ func generateViewerToken(taskID, secret string, expiration time.Duration) (strin
 	claims := &Claims{
 		TaskID: taskID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(), // Identifies the token for single-use viewer links
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiration)),
 			Audience:  []string{"viewer"}, // Set audience to "viewer" to prevent API token reuse
 		},
@@ -171,3 +484,214 @@ func generateViewerToken(taskID, secret string, expiration time.Duration) (strin
 	return token.SignedString([]byte(secret))
 }
 
+// TaskDefinition describes a configured task for API clients.
+// The raw command is intentionally omitted so that definitions can be shared with front-ends.
+type TaskDefinition struct {
+	Name             string                `json:"name"`
+	Description      string                `json:"description,omitempty"`
+	MaxExecutionTime int                   `json:"max_execution_time,omitempty"`
+	Disabled         bool                  `json:"disabled,omitempty"` // Task is configured but currently cannot be started
+	Parameters       []ParameterDefinition `json:"parameters"`
+}
+
+// ParameterDefinition describes a task parameter for API clients
+type ParameterDefinition struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Optional    bool     `json:"optional"`
+	Description string   `json:"description,omitempty"`
+	Min         *int     `json:"min,omitempty"`
+	Max         *int     `json:"max,omitempty"`
+	MaxLength   int      `json:"max_length,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Secret      bool     `json:"secret,omitempty"`
+	Encoding    string   `json:"encoding,omitempty"`
+	Transform   []string `json:"transform,omitempty"`
+}
+
+// DefinitionsResponse represents the response of the definitions endpoint
+type DefinitionsResponse struct {
+	Tasks []TaskDefinition `json:"tasks"`
+}
+
+// buildTaskDefinitions converts the task configuration into client-facing definitions
+func buildTaskDefinitions(tasks []TaskConfig) []TaskDefinition {
+	definitions := make([]TaskDefinition, 0, len(tasks))
+	for _, task := range tasks {
+		params := make([]ParameterDefinition, 0, len(task.Parameters))
+		for _, param := range task.Parameters {
+			params = append(params, ParameterDefinition{
+				Name:        param.Name,
+				Type:        param.Type,
+				Optional:    param.Optional,
+				Description: param.Description,
+				Min:         param.Min,
+				Max:         param.Max,
+				MaxLength:   param.MaxLength,
+				Pattern:     param.Pattern,
+				Secret:      param.Secret,
+				Encoding:    param.Encoding,
+				Transform:   param.Transform,
+			})
+		}
+		definitions = append(definitions, TaskDefinition{
+			Name:             task.Name,
+			Description:      task.Description,
+			MaxExecutionTime: task.MaxExecutionTime,
+			Disabled:         !task.IsEnabled(),
+			Parameters:       params,
+		})
+	}
+	return definitions
+}
+
+// handleDefinitions returns the configured tasks and their parameter metadata
+func handleDefinitions(w http.ResponseWriter, r *http.Request, config *Config) {
+	log.Printf("[API] Definitions request from %s", r.RemoteAddr)
+
+	// Authenticate request - API tokens should have no audience or empty audience
+	claims, err := validateAPIJWT(r, config.Auth)
+	if err != nil {
+		log.Printf("[API] Authentication failed: %v", err)
+		sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		sendJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+
+	// Tokens of an API key only see the key's tasks
+	tasks := make([]TaskConfig, 0, len(config.Tasks))
+	for _, task := range config.Tasks {
+		if tokenAllowsTask(config.Auth, claims, task.Name) {
+			tasks = append(tasks, task)
+		}
+	}
+	response := DefinitionsResponse{
+		Tasks: buildTaskDefinitions(tasks),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PingResponse represents the response of the ping endpoint
+type PingResponse struct {
+	OK         bool      `json:"ok"`
+	ServerTime time.Time `json:"server_time"`
+	KeyID      string    `json:"key_id,omitempty"` // API key that signed the token (empty = auth.secret)
+}
+
+// handlePing confirms that an API token is valid without starting a task, so clients can test
+// their credentials. Unlike /health it requires authentication; no body hash is needed.
+func handlePing(w http.ResponseWriter, r *http.Request, config *Config) {
+	// Authenticate request - API tokens should have no audience or empty audience
+	claims, err := validateAPIJWT(r, config.Auth)
+	if err != nil {
+		log.Printf("[API] Ping authentication failed from %s: %v", r.RemoteAddr, err)
+		sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		sendJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PingResponse{OK: true, ServerTime: time.Now().UTC(), KeyID: claims.KeyID})
+}
+
+// TaskStatusResponse represents the response of the status endpoint
+type TaskStatusResponse struct {
+	TaskID    string `json:"task_id"`
+	TaskName  string `json:"task_name"`
+	Label     string `json:"label,omitempty"`
+	State     string `json:"state"`
+	Completed bool   `json:"completed"`
+	ExitCode  *int   `json:"exit_code"` // null while the task is still running
+
+	StartLatencyMs float64 `json:"start_latency_ms"` // Time from the start request until the PID was written
+}
+
+// durationMillis converts a duration to fractional milliseconds
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// buildTaskStatus reports the state of a task, including the exit code once the process has ended.
+// The exit status from cmd.Wait (exitCode, if non-nil) takes precedence; otherwise the exit code is
+// read from the exitcode file, which the wrapper writes when the command finishes.
+func buildTaskStatus(task *RunningTask, state string, completed bool, exitCode *int) TaskStatusResponse {
+	response := TaskStatusResponse{
+		TaskID:    task.ID,
+		TaskName:  task.TaskName,
+		Label:     task.Label,
+		State:     state,
+		Completed: completed,
+
+		StartLatencyMs: durationMillis(task.StartLatency()),
+	}
+	if exitCode != nil {
+		response.ExitCode = exitCode
+		response.Completed = true
+		return response
+	}
+	exitCodePath := filepath.Join(task.OutputDir, "exitcode")
+	if _, err := os.Stat(exitCodePath); err == nil {
+		exitCode := readExitCode(exitCodePath)
+		response.ExitCode = &exitCode
+		response.Completed = true
+	}
+	return response
+}
+
+// handleTaskStatus returns the state and, after completion, the exit code of a task
+func handleTaskStatus(w http.ResponseWriter, r *http.Request, taskManager *TaskManager, config *Config) {
+	log.Printf("[API] Status request from %s", r.RemoteAddr)
+
+	// Authenticate request - API tokens should have no audience or empty audience
+	claims, err := validateAPIJWT(r, config.Auth)
+	if err != nil {
+		log.Printf("[API] Authentication failed: %v", err)
+		sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		sendJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+
+	taskID, err := requestTaskID(r, claims, config.Server.TaskIDScheme)
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	task, err := taskManager.GetTask(taskID)
+	if err != nil {
+		sendJSONError(w, http.StatusNotFound, ErrCodeTaskNotFound, fmt.Sprintf("Task not found: %v", err))
+		return
+	}
+	if !tokenAllowsTask(config.Auth, claims, task.TaskName) {
+		sendJSONError(w, http.StatusForbidden, ErrCodeForbidden, "Forbidden: API key is not allowed to access this task")
+		return
+	}
+
+	// Read state under the lock, the task may be completed concurrently
+	taskManager.mu.RLock()
+	state := task.State
+	completed := task.Completed
+	var exitCode *int
+	if task.Exited {
+		code := task.ExitCode
+		exitCode = &code
+	}
+	taskManager.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildTaskStatus(task, state, completed, exitCode))
+}
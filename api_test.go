@@ -3,10 +3,15 @@ package main
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,21 +33,25 @@ func TestSendJSONError(t *testing.T) {
 	tests := []struct {
 		name       string
 		statusCode int
+		code       string
 		message    string
 	}{
 		{
 			name:       "unauthorized error",
 			statusCode: http.StatusUnauthorized,
+			code:       ErrCodeUnauthorized,
 			message:    "Unauthorized access",
 		},
 		{
 			name:       "bad request error",
 			statusCode: http.StatusBadRequest,
+			code:       ErrCodeInvalidRequest,
 			message:    "Invalid input",
 		},
 		{
 			name:       "internal server error",
 			statusCode: http.StatusInternalServerError,
+			code:       ErrCodeInternal,
 			message:    "Server error",
 		},
 	}
@@ -50,7 +59,7 @@ func TestSendJSONError(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
-			sendJSONError(w, tt.statusCode, tt.message)
+			sendJSONError(w, tt.statusCode, tt.code, tt.message)
 
 			if w.Code != tt.statusCode {
 				t.Errorf("sendJSONError() status = %d; want %d", w.Code, tt.statusCode)
@@ -68,6 +77,9 @@ func TestSendJSONError(t *testing.T) {
 			if response.Error != tt.message {
 				t.Errorf("sendJSONError() error message = %q; want %q", response.Error, tt.message)
 			}
+			if response.Code != tt.code {
+				t.Errorf("sendJSONError() code = %q; want %q", response.Code, tt.code)
+			}
 		})
 	}
 }
@@ -178,7 +190,7 @@ func TestHandleStartTask(t *testing.T) {
 			name:           "non-existent task",
 			method:         http.MethodPost,
 			body:           `{"task_name": "non-existent"}`,
-			wantStatusCode: http.StatusInternalServerError,
+			wantStatusCode: http.StatusNotFound,
 			wantErr:        true,
 			errContains:    "Failed to start task",
 			tokenType:      "api",
@@ -261,7 +273,7 @@ func TestHandleStartTask(t *testing.T) {
 
 			w := httptest.NewRecorder()
 
-			handleStartTask(w, req, taskManager, config)
+			handleStartTask(w, req, taskManager, config, nil)
 
 			if w.Code != tt.wantStatusCode {
 				t.Errorf("handleStartTask() status = %d; want %d", w.Code, tt.wantStatusCode)
@@ -333,7 +345,7 @@ func TestHandleStartTaskWithTLS(t *testing.T) {
 	req.TLS = &tls.ConnectionState{} // Simulate TLS connection
 
 	w := httptest.NewRecorder()
-	handleStartTask(w, req, taskManager, config)
+	handleStartTask(w, req, taskManager, config, nil)
 
 	if w.Code != http.StatusOK {
 		t.Fatalf("handleStartTask() with TLS status = %d; want %d", w.Code, http.StatusOK)
@@ -424,7 +436,7 @@ func TestHandleStartTaskLargeRequest(t *testing.T) {
 	req.URL.RawQuery = "token=invalid-token"
 	w := httptest.NewRecorder()
 
-	handleStartTask(w, req, taskManager, config)
+	handleStartTask(w, req, taskManager, config, nil)
 
 	// With body-hash binding in place, an oversized body with invalid token should be
 	// rejected as unauthorized rather than by JSON size validation.
@@ -470,3 +482,1305 @@ func TestJSONNormalization(t *testing.T) {
 }
 
 
+
+func TestHandleDefinitions(t *testing.T) {
+	config := &Config{
+		Auth: AuthConfig{
+			Secret: "test-secret-key",
+		},
+		Tasks: []TaskConfig{
+			{
+				Name:             "param-task",
+				Command:          "echo {{message}} secret-command-marker",
+				Description:      "Task with parameters",
+				MaxExecutionTime: 60,
+				Parameters: []ParameterConfig{
+					{Name: "message", Type: "string", Optional: false, Description: "Message to print"},
+					{Name: "count", Type: "int", Optional: true},
+				},
+			},
+			{Name: "plain-task", Command: "echo plain", Enabled: new(bool)},
+		},
+	}
+
+	apiToken := func() string {
+		claims := &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte(config.Auth.Secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		return tokenString
+	}
+
+	t.Run("returns definitions without command", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/definitions?token="+apiToken(), nil)
+		w := httptest.NewRecorder()
+
+		handleDefinitions(w, req, config)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleDefinitions() status = %d; want %d", w.Code, http.StatusOK)
+		}
+		if containsString(w.Body.String(), "secret-command-marker") || containsString(w.Body.String(), `"command"`) {
+			t.Errorf("handleDefinitions() response leaks command: %s", w.Body.String())
+		}
+
+		var response DefinitionsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("handleDefinitions() response is not valid JSON: %v", err)
+		}
+		if len(response.Tasks) != 2 {
+			t.Fatalf("handleDefinitions() returned %d tasks; want 2", len(response.Tasks))
+		}
+
+		task := response.Tasks[0]
+		if task.Name != "param-task" || task.Description != "Task with parameters" {
+			t.Errorf("handleDefinitions() task = %+v; want name and description of param-task", task)
+		}
+		if len(task.Parameters) != 2 {
+			t.Fatalf("handleDefinitions() returned %d parameters; want 2", len(task.Parameters))
+		}
+		if task.Parameters[0].Name != "message" || task.Parameters[0].Type != "string" || task.Parameters[0].Optional {
+			t.Errorf("handleDefinitions() parameter[0] = %+v; want required string 'message'", task.Parameters[0])
+		}
+		if task.Parameters[0].Description != "Message to print" {
+			t.Errorf("handleDefinitions() parameter[0].Description = %q; want %q", task.Parameters[0].Description, "Message to print")
+		}
+		if task.Parameters[1].Name != "count" || task.Parameters[1].Type != "int" || !task.Parameters[1].Optional {
+			t.Errorf("handleDefinitions() parameter[1] = %+v; want optional int 'count'", task.Parameters[1])
+		}
+		if response.Tasks[1].Parameters == nil {
+			t.Error("handleDefinitions() parameters of task without parameters should be an empty list, not null")
+		}
+		if task.Disabled || !response.Tasks[1].Disabled {
+			t.Errorf("handleDefinitions() disabled = %v, %v; want false, true", task.Disabled, response.Tasks[1].Disabled)
+		}
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/definitions", nil)
+		w := httptest.NewRecorder()
+
+		handleDefinitions(w, req, config)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("handleDefinitions() status = %d; want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("wrong HTTP method", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/definitions?token="+apiToken(), nil)
+		w := httptest.NewRecorder()
+
+		handleDefinitions(w, req, config)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("handleDefinitions() status = %d; want %d", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestHandleStartTaskViewerURLFields(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	body := `{"task_name": "test-task"}`
+	newRequest := func(secret string) *http.Request {
+		claims := &Claims{
+			BodySHA1: computeBodyHashForToken(body),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/start", bytes.NewBufferString(body))
+		req.URL.RawQuery = "token=" + tokenString
+		return req
+	}
+
+	for _, omit := range []bool{false, true} {
+		config := &Config{
+			Server: ServerConfig{
+				TaskDir:                tmpDir,
+				OmitTokenizedViewerURL: omit,
+			},
+			Auth: AuthConfig{
+				Secret: "test-secret-key",
+			},
+			Tasks: []TaskConfig{
+				{Name: "test-task", Command: "echo hello"},
+			},
+		}
+		taskManager := NewTaskManager(config)
+
+		w := httptest.NewRecorder()
+		handleStartTask(w, newRequest(config.Auth.Secret), taskManager, config, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleStartTask() status = %d; want %d", w.Code, http.StatusOK)
+		}
+
+		var response StartTaskResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+		}
+
+		if response.ViewerURLBase == "" || response.ViewerToken == "" {
+			t.Fatalf("handleStartTask() viewer_url_base = %q, viewer_token = %q; want both set", response.ViewerURLBase, response.ViewerToken)
+		}
+		if containsString(response.ViewerURLBase, "token=") {
+			t.Errorf("handleStartTask() viewer_url_base = %q; must not contain the token", response.ViewerURLBase)
+		}
+		if !containsString(response.ViewerURLBase, response.TaskID) {
+			t.Errorf("handleStartTask() viewer_url_base = %q; want task_id %q", response.ViewerURLBase, response.TaskID)
+		}
+
+		if omit {
+			if response.ViewerURL != "" {
+				t.Errorf("handleStartTask() viewer_url = %q; want omitted", response.ViewerURL)
+			}
+		} else if response.ViewerURL != response.ViewerURLBase+"&token="+response.ViewerToken {
+			t.Errorf("handleStartTask() viewer_url = %q; want viewer_url_base + token", response.ViewerURL)
+		}
+	}
+
+	// An external front-end via server.viewer_url_template
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir:           tmpDir,
+			ViewerURLTemplate: "https://ui.example.com/task/{{task_id}}?token={{token}}",
+		},
+		Auth:  AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+	}
+	w := httptest.NewRecorder()
+	handleStartTask(w, newRequest(config.Auth.Secret), NewTaskManager(config), config, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleStartTask() with viewer_url_template status = %d; want %d", w.Code, http.StatusOK)
+	}
+	var response StartTaskResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+	}
+	if want := "https://ui.example.com/task/" + response.TaskID + "?token=" + response.ViewerToken; response.ViewerURL != want {
+		t.Errorf("handleStartTask() viewer_url = %q; want %q", response.ViewerURL, want)
+	}
+	if want := "https://ui.example.com/task/" + response.TaskID; response.ViewerURLBase != want {
+		t.Errorf("handleStartTask() viewer_url_base = %q; want %q", response.ViewerURLBase, want)
+	}
+}
+
+func TestHandleStartTaskClientCertificateAuth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	verifiedTLS := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{
+			{Subject: pkix.Name{CommonName: "deploy-bot"}},
+		}},
+	}
+
+	tests := []struct {
+		name           string
+		mtlsAPIAuth    bool
+		tlsState       *tls.ConnectionState
+		wantStatusCode int
+	}{
+		{name: "verified client certificate without JWT", mtlsAPIAuth: true, tlsState: verifiedTLS, wantStatusCode: http.StatusOK},
+		{name: "mTLS API auth disabled requires JWT", mtlsAPIAuth: false, tlsState: verifiedTLS, wantStatusCode: http.StatusUnauthorized},
+		{name: "no client certificate requires JWT", mtlsAPIAuth: true, tlsState: &tls.ConnectionState{}, wantStatusCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Server: ServerConfig{TaskDir: tmpDir, MTLSAPIAuth: tt.mtlsAPIAuth},
+				Auth:   AuthConfig{Secret: "test-secret-key"},
+				Tasks:  []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+			}
+			taskManager := NewTaskManager(config)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/start", bytes.NewBufferString(`{"task_name": "test-task"}`))
+			req.TLS = tt.tlsState
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskAPIHeaderSecret(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	body := `{"task_name": "test-task"}`
+	claims := &Claims{
+		BodySHA1: computeBodyHashForToken(body),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	validToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret-key"))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		headerSecret   string // auth.api_header_secret
+		header         string // X-API-Secret header (empty = not sent)
+		token          string // token query parameter (empty = not sent)
+		wantStatusCode int
+	}{
+		{name: "correct header", headerSecret: "shared-secret", header: "shared-secret", wantStatusCode: http.StatusOK},
+		{name: "incorrect header", headerSecret: "shared-secret", header: "wrong-secret", wantStatusCode: http.StatusUnauthorized},
+		{name: "missing header", headerSecret: "shared-secret", wantStatusCode: http.StatusUnauthorized},
+		{name: "header secret disabled", headerSecret: "", header: "shared-secret", wantStatusCode: http.StatusUnauthorized},
+		{name: "JWT takes precedence", headerSecret: "shared-secret", header: "wrong-secret", token: validToken, wantStatusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				Server: ServerConfig{TaskDir: tmpDir},
+				Auth:   AuthConfig{Secret: "test-secret-key", APIHeaderSecret: tt.headerSecret},
+				Tasks:  []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+			}
+			taskManager := NewTaskManager(config)
+
+			url := "/api/start"
+			if tt.token != "" {
+				url += "?token=" + tt.token
+			}
+			req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+			if tt.header != "" {
+				req.Header.Set(APISecretHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskLabel(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, LabelHeader: "X-Task-Label"},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		name           string
+		body           string
+		header         string
+		wantStatusCode int
+		wantLabel      string
+	}{
+		{name: "label from body", body: `{"task_name": "test-task", "label": "nightly-us-east"}`, wantStatusCode: http.StatusOK, wantLabel: "nightly-us-east"},
+		{name: "label from trusted header", body: `{"task_name": "test-task"}`, header: "gateway-run", wantStatusCode: http.StatusOK, wantLabel: "gateway-run"},
+		{name: "body label wins over header", body: `{"task_name": "test-task", "label": "from-body"}`, header: "from-header", wantStatusCode: http.StatusOK, wantLabel: "from-body"},
+		{name: "invalid label", body: `{"task_name": "test-task", "label": "bad;label"}`, wantStatusCode: http.StatusBadRequest},
+		{name: "invalid header label", body: `{"task_name": "test-task"}`, header: "<script>", wantStatusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &Claims{
+				BodySHA1: computeBodyHashForToken(tt.body),
+				RegisteredClaims: jwt.RegisteredClaims{
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+			if err != nil {
+				t.Fatalf("failed to create API token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/start?token="+token, bytes.NewBufferString(tt.body))
+			if tt.header != "" {
+				req.Header.Set("X-Task-Label", tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+			if tt.wantStatusCode != http.StatusOK {
+				return
+			}
+
+			var response StartTaskResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+			}
+			if response.Label != tt.wantLabel {
+				t.Errorf("handleStartTask() label = %q; want %q", response.Label, tt.wantLabel)
+			}
+			task, err := taskManager.GetTask(response.TaskID)
+			if err != nil {
+				t.Fatalf("GetTask() = %v", err)
+			}
+			if task.Label != tt.wantLabel {
+				t.Errorf("RunningTask.Label = %q; want %q", task.Label, tt.wantLabel)
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskParameterLimits(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, MaxParameters: 10, MaxParametersSize: 1024},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{{
+			Name:       "param-task",
+			Command:    "echo {{message}}",
+			Parameters: []ParameterConfig{{Name: "message", Type: "string"}},
+		}},
+	}
+	taskManager := NewTaskManager(config)
+
+	manyParams := map[string]interface{}{"message": "hello"}
+	for i := 0; i < 1000; i++ {
+		manyParams[fmt.Sprintf("unknown_%d", i)] = "x"
+	}
+
+	tests := []struct {
+		name           string
+		parameters     map[string]interface{}
+		wantStatusCode int
+		errContains    string
+	}{
+		{name: "within limits", parameters: map[string]interface{}{"message": "hello"}, wantStatusCode: http.StatusOK},
+		{name: "too many parameters", parameters: manyParams, wantStatusCode: http.StatusBadRequest, errContains: "too many parameters"},
+		{name: "parameters too large", parameters: map[string]interface{}{"message": strings.Repeat("a", 2048)}, wantStatusCode: http.StatusBadRequest, errContains: "parameters too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyBytes, err := json.Marshal(StartTaskRequest{TaskName: "param-task", Parameters: tt.parameters})
+			if err != nil {
+				t.Fatalf("json.Marshal() = %v", err)
+			}
+			body := string(bodyBytes)
+			claims := &Claims{
+				BodySHA1: computeBodyHashForToken(body),
+				RegisteredClaims: jwt.RegisteredClaims{
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+			if err != nil {
+				t.Fatalf("failed to create API token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/start?token="+token, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+			if tt.errContains != "" && !strings.Contains(w.Body.String(), tt.errContains) {
+				t.Errorf("handleStartTask() body = %s; want error containing %q", w.Body.String(), tt.errContains)
+			}
+		})
+	}
+}
+
+func TestHandleTaskStatus(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "quick-task", Command: "exit 7"},
+			{Name: "slow-task", Command: "sleep 5"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+
+	getStatus := func(taskID string) (int, TaskStatusResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/status?task_id="+taskID+"&token="+token, nil)
+		w := httptest.NewRecorder()
+		handleTaskStatus(w, req, taskManager, config)
+		var response TaskStatusResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("handleTaskStatus() response is not valid JSON: %v", err)
+			}
+		}
+		return w.Code, response
+	}
+
+	t.Run("running task has null exit code", func(t *testing.T) {
+		taskID, err := taskManager.StartTask("slow-task", nil)
+		if err != nil {
+			t.Fatalf("StartTask() = %v", err)
+		}
+		code, status := getStatus(taskID)
+		if code != http.StatusOK {
+			t.Fatalf("handleTaskStatus() status = %d; want %d", code, http.StatusOK)
+		}
+		if status.Completed || status.ExitCode != nil || status.State != TaskStateRunning {
+			t.Errorf("status = %+v; want running task without exit code", status)
+		}
+		if status.StartLatencyMs <= 0 {
+			t.Errorf("status start_latency_ms = %v; want > 0", status.StartLatencyMs)
+		}
+	})
+
+	t.Run("completed task reports exit code", func(t *testing.T) {
+		taskID, err := taskManager.StartTask("quick-task", nil)
+		if err != nil {
+			t.Fatalf("StartTask() = %v", err)
+		}
+		var status TaskStatusResponse
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			_, status = getStatus(taskID)
+			if status.Completed {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if !status.Completed {
+			t.Fatalf("status = %+v; want completed task", status)
+		}
+		if status.ExitCode == nil || *status.ExitCode != 7 {
+			t.Errorf("status exit code = %v; want 7", status.ExitCode)
+		}
+		if status.TaskName != "quick-task" {
+			t.Errorf("status task name = %q; want %q", status.TaskName, "quick-task")
+		}
+	})
+
+	t.Run("unknown task", func(t *testing.T) {
+		if code, _ := getStatus("550e8400-e29b-41d4-a716-446655440000"); code != http.StatusNotFound {
+			t.Errorf("handleTaskStatus() status = %d; want %d", code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("malformed task_id", func(t *testing.T) {
+		if code, _ := getStatus("not-a-task-id"); code != http.StatusBadRequest {
+			t.Errorf("handleTaskStatus() status = %d; want %d", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("viewer token rejected", func(t *testing.T) {
+		viewerToken, err := generateViewerToken("550e8400-e29b-41d4-a716-446655440000", config.Auth.Secret, time.Hour)
+		if err != nil {
+			t.Fatalf("generateViewerToken() = %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/status?token="+viewerToken, nil)
+		w := httptest.NewRecorder()
+		handleTaskStatus(w, req, taskManager, config)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("handleTaskStatus() status = %d; want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestHandleStartTaskFormEncoded(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{
+				Name:    "param-task",
+				Command: "echo {{filename}} {{count}}",
+				Parameters: []ParameterConfig{
+					{Name: "filename", Type: "string"},
+					{Name: "count", Type: "int", Optional: true},
+				},
+			},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	// Token bound to the canonical form (fields sorted by key)
+	formToken := func(canonical string) string {
+		claims := &Claims{
+			BodySHA1: computeSHA1Hex([]byte(canonical)),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		return tokenString
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		canonical      string // Body the token is bound to
+		contentType    string
+		wantStatusCode int
+		errContains    string
+	}{
+		{
+			name:           "form request",
+			body:           "task_name=param-task&param_filename=report.txt&param_count=3&label=nightly",
+			canonical:      "label=nightly&param_count=3&param_filename=report.txt&task_name=param-task",
+			contentType:    "application/x-www-form-urlencoded",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "form request with charset",
+			body:           "param_filename=report.txt&task_name=param-task",
+			canonical:      "param_filename=report.txt&task_name=param-task",
+			contentType:    "application/x-www-form-urlencoded; charset=utf-8",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "hash over different form",
+			body:           "task_name=param-task&param_filename=other.txt",
+			canonical:      "param_filename=report.txt&task_name=param-task",
+			contentType:    "application/x-www-form-urlencoded",
+			wantStatusCode: http.StatusUnauthorized,
+			errContains:    "request body does not match token",
+		},
+		{
+			name:           "duplicate field",
+			body:           "task_name=param-task&param_filename=a&param_filename=b",
+			canonical:      "param_filename=a&param_filename=b&task_name=param-task",
+			contentType:    "application/x-www-form-urlencoded",
+			wantStatusCode: http.StatusBadRequest,
+			errContains:    "must be provided exactly once",
+		},
+		{
+			name:           "unknown field",
+			body:           "task_name=param-task&filename=a",
+			canonical:      "filename=a&task_name=param-task",
+			contentType:    "application/x-www-form-urlencoded",
+			wantStatusCode: http.StatusBadRequest,
+			errContains:    "unknown field 'filename'",
+		},
+		{
+			name:           "invalid parameter value",
+			body:           "task_name=param-task&param_filename=a%2Fb",
+			canonical:      "param_filename=a%2Fb&task_name=param-task",
+			contentType:    "application/x-www-form-urlencoded",
+			wantStatusCode: http.StatusBadRequest,
+			errContains:    "contains invalid characters",
+		},
+		{
+			name:           "form body without form content type",
+			body:           "param_filename=report.txt&task_name=param-task",
+			canonical:      "param_filename=report.txt&task_name=param-task",
+			contentType:    "",
+			wantStatusCode: http.StatusBadRequest,
+			errContains:    "Invalid JSON format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/start?token="+formToken(tt.canonical), strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+			if tt.errContains != "" && !strings.Contains(w.Body.String(), tt.errContains) {
+				t.Errorf("handleStartTask() body = %s; want error containing %q", w.Body.String(), tt.errContains)
+			}
+			if tt.wantStatusCode != http.StatusOK {
+				return
+			}
+			var response StartTaskResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+			}
+			if response.TaskID == "" {
+				t.Error("handleStartTask() TaskID is empty")
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskDisabled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	disabled := false
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo hello", Enabled: &disabled}},
+	}
+	taskManager := NewTaskManager(config)
+
+	body := `{"task_name": "test-task"}`
+	claims := &Claims{
+		BodySHA1: computeBodyHashForToken(body),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/start?token="+token, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	handleStartTask(w, req, taskManager, config, nil)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, http.StatusForbidden, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "task disabled") {
+		t.Errorf("handleStartTask() body = %s; want error containing 'task disabled'", w.Body.String())
+	}
+	if tasks := taskManager.GetAllTasks(); len(tasks) != 0 {
+		t.Errorf("GetAllTasks() = %d tasks; want 0", len(tasks))
+	}
+}
+
+func TestHandleStartTaskDraining(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+	}
+	taskManager := NewTaskManager(config)
+	taskManager.StartDraining()
+
+	body := `{"task_name": "test-task"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/start", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	handleStartTask(w, req, taskManager, config, nil)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, http.StatusServiceUnavailable, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"code":"`+ErrCodeShuttingDown+`"`) {
+		t.Errorf("handleStartTask() body = %s; want code %q", w.Body.String(), ErrCodeShuttingDown)
+	}
+	if tasks := taskManager.GetAllTasks(); len(tasks) != 0 {
+		t.Errorf("GetAllTasks() = %d tasks; want 0", len(tasks))
+	}
+}
+
+func TestHandleStartTaskTaskDirFull(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Output of an earlier run filling the quota
+	if err := os.MkdirAll(filepath.Join(tmpDir, "earlier-run"), 0700); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "earlier-run", "stdout"), make([]byte, 2048), 0600); err != nil {
+		t.Fatalf("Failed to write output: %v", err)
+	}
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, MaxTaskDirBytes: 1024},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	body := `{"task_name": "test-task"}`
+	claims := &Claims{
+		BodySHA1: computeBodyHashForToken(body),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/start?token="+token, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+
+	handleStartTask(w, req, taskManager, config, nil)
+
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, http.StatusInsufficientStorage, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"code":"`+ErrCodeInsufficientStorage+`"`) {
+		t.Errorf("handleStartTask() body = %s; want code %q", w.Body.String(), ErrCodeInsufficientStorage)
+	}
+	if tasks := taskManager.GetAllTasks(); len(tasks) != 0 {
+		t.Errorf("GetAllTasks() = %d tasks; want 0", len(tasks))
+	}
+}
+
+func TestHandleStartTaskErrorCodes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	disabled := false
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "param-task", Command: "echo {{count}}", Parameters: []ParameterConfig{
+				{Name: "count", Type: "int"},
+			}},
+			{Name: "disabled-task", Command: "echo disabled", Enabled: &disabled},
+			{Name: "precheck-task", Command: "echo checked", PreCheck: "exit 1"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	signedToken := func(body string) string {
+		claims := &Claims{
+			BodySHA1: computeBodyHashForToken(body),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		token          string // Empty = token bound to body
+		wantStatusCode int
+		wantCode       string
+	}{
+		{name: "invalid token", body: `{"task_name": "param-task"}`, token: "invalid", wantStatusCode: http.StatusUnauthorized, wantCode: ErrCodeUnauthorized},
+		{name: "malformed JSON", body: `{"task_name": `, wantStatusCode: http.StatusBadRequest, wantCode: ErrCodeInvalidRequest},
+		{name: "unknown task", body: `{"task_name": "missing-task"}`, wantStatusCode: http.StatusNotFound, wantCode: ErrCodeTaskNotFound},
+		{name: "invalid parameter", body: `{"task_name": "param-task", "parameters": {"count": "abc"}}`, wantStatusCode: http.StatusBadRequest, wantCode: ErrCodeInvalidParameter},
+		{name: "disabled task", body: `{"task_name": "disabled-task"}`, wantStatusCode: http.StatusForbidden, wantCode: ErrCodeTaskDisabled},
+		{name: "pre_check failed", body: `{"task_name": "precheck-task"}`, wantStatusCode: http.StatusPreconditionFailed, wantCode: ErrCodePreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if token == "" {
+				token = signedToken(tt.body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/start?token="+token, bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+			}
+			if response.Code != tt.wantCode {
+				t.Errorf("handleStartTask() code = %q; want %q", response.Code, tt.wantCode)
+			}
+			if response.Error == "" {
+				t.Error("handleStartTask() error message is empty")
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskWait(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "quick-task", Command: "echo out; echo err >&2; exit 3"},
+			{Name: "noisy-task", Command: "head -c 70000 /dev/zero | tr '\\0' a"},
+			{Name: "slow-task", Command: "sleep 5"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	startTask := func(taskName, query string) (int, StartTaskResponse) {
+		body := `{"task_name": "` + taskName + `"}`
+		claims := &Claims{
+			BodySHA1: computeBodyHashForToken(body),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/start?token="+token+query, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		handleStartTask(w, req, taskManager, config, nil)
+
+		var response StartTaskResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+			}
+		}
+		return w.Code, response
+	}
+
+	t.Run("completed task returns exit code and output", func(t *testing.T) {
+		code, response := startTask("quick-task", "&wait=true&timeout=5")
+		if code != http.StatusOK {
+			t.Fatalf("handleStartTask() status = %d; want %d", code, http.StatusOK)
+		}
+		if !response.Completed || response.ExitCode == nil || *response.ExitCode != 3 {
+			t.Fatalf("response = %+v; want completed task with exit code 3", response)
+		}
+		if response.Stdout != "out\n" || response.Stderr != "err\n" || response.OutputTruncated {
+			t.Errorf("response output = %q/%q (truncated=%v); want %q/%q", response.Stdout, response.Stderr, response.OutputTruncated, "out\n", "err\n")
+		}
+		if response.TaskID == "" {
+			t.Error("response task_id is empty")
+		}
+	})
+
+	t.Run("output is cut to its tail", func(t *testing.T) {
+		_, response := startTask("noisy-task", "&wait=true&timeout=5")
+		if !response.Completed {
+			t.Fatalf("response = %+v; want completed task", response)
+		}
+		if len(response.Stdout) != waitOutputTailSize || !response.OutputTruncated {
+			t.Errorf("response stdout = %d bytes (truncated=%v); want %d bytes, truncated", len(response.Stdout), response.OutputTruncated, waitOutputTailSize)
+		}
+	})
+
+	t.Run("timeout returns task id for follow-up", func(t *testing.T) {
+		start := time.Now()
+		code, response := startTask("slow-task", "&wait=true&timeout=1")
+		if code != http.StatusOK {
+			t.Fatalf("handleStartTask() status = %d; want %d", code, http.StatusOK)
+		}
+		if elapsed := time.Since(start); elapsed < time.Second || elapsed > 4*time.Second {
+			t.Errorf("handleStartTask() returned after %v; want about 1s", elapsed)
+		}
+		if response.Completed || response.ExitCode != nil || response.TaskID == "" {
+			t.Errorf("response = %+v; want running task with task_id and without exit code", response)
+		}
+		if _, err := taskManager.GetTask(response.TaskID); err != nil {
+			t.Errorf("GetTask() = %v; want task to be still registered", err)
+		}
+	})
+
+	t.Run("without wait", func(t *testing.T) {
+		_, response := startTask("quick-task", "")
+		if response.Completed || response.ExitCode != nil {
+			t.Errorf("response = %+v; want no completion fields without wait", response)
+		}
+	})
+
+	for _, query := range []string{"&wait=true&timeout=0", "&wait=true&timeout=abc", "&wait=true&timeout=301", "&timeout=5"} {
+		t.Run("invalid "+query, func(t *testing.T) {
+			if code, _ := startTask("quick-task", query); code != http.StatusBadRequest {
+				t.Errorf("handleStartTask() status = %d; want %d", code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskAPIKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth: AuthConfig{
+			Secret: "test-secret-key",
+			APIKeys: []APIKeyConfig{
+				{ID: "ci", Secret: "ci-secret-key", Tasks: []string{"build"}},
+				{ID: "ops", Secret: "ops-secret-key", Tasks: []string{"build", "deploy"}},
+			},
+		},
+		Tasks: []TaskConfig{
+			{Name: "build", Command: "echo build"},
+			{Name: "deploy", Command: "echo deploy"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		name       string
+		kid        string
+		secret     string
+		taskName   string
+		wantStatus int
+	}{
+		{"key allows task", "ci", "ci-secret-key", "build", http.StatusOK},
+		{"key forbids task", "ci", "ci-secret-key", "deploy", http.StatusForbidden},
+		{"other key allows task", "ops", "ops-secret-key", "deploy", http.StatusOK},
+		{"shared secret allows all tasks", "", "test-secret-key", "deploy", http.StatusOK},
+		{"unknown key", "dev", "ci-secret-key", "build", http.StatusUnauthorized},
+		{"key secret without kid", "", "ci-secret-key", "build", http.StatusUnauthorized},
+		{"kid with wrong secret", "ci", "ops-secret-key", "build", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"task_name": "` + tt.taskName + `"}`
+			claims := &Claims{
+				BodySHA1: computeBodyHashForToken(body),
+				RegisteredClaims: jwt.RegisteredClaims{
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			if tt.kid != "" {
+				token.Header["kid"] = tt.kid
+			}
+			tokenString, err := token.SignedString([]byte(tt.secret))
+			if err != nil {
+				t.Fatalf("failed to create API token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/start?token="+tokenString, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusForbidden && !strings.Contains(w.Body.String(), `"code":"`+ErrCodeForbidden+`"`) {
+				t.Errorf("handleStartTask() body = %s; want code %q", w.Body.String(), ErrCodeForbidden)
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskAPIKeyParameters(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth: AuthConfig{
+			Secret: "test-secret-key",
+			APIKeys: []APIKeyConfig{
+				{ID: "ci", Secret: "ci-secret-key", Tasks: []string{"deploy"}, AllowedParameters: []string{"version"}},
+			},
+		},
+		Tasks: []TaskConfig{
+			{Name: "deploy", Command: "echo {{version}} {{environment}}", Parameters: []ParameterConfig{
+				{Name: "version", Type: "string", Optional: true},
+				{Name: "environment", Type: "string", Optional: true},
+			}},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		name       string
+		kid        string
+		secret     string
+		parameters string
+		wantStatus int
+	}{
+		{"no parameters", "ci", "ci-secret-key", `{}`, http.StatusOK},
+		{"allowed parameter", "ci", "ci-secret-key", `{"version": "1.2"}`, http.StatusOK},
+		{"disallowed parameter", "ci", "ci-secret-key", `{"version": "1.2", "environment": "prod"}`, http.StatusForbidden},
+		{"shared secret sets all parameters", "", "test-secret-key", `{"version": "1.2", "environment": "prod"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"task_name": "deploy", "parameters": ` + tt.parameters + `}`
+			claims := &Claims{
+				BodySHA1: computeBodyHashForToken(body),
+				RegisteredClaims: jwt.RegisteredClaims{
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			}
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+			if tt.kid != "" {
+				token.Header["kid"] = tt.kid
+			}
+			tokenString, err := token.SignedString([]byte(tt.secret))
+			if err != nil {
+				t.Fatalf("failed to create API token: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/start?token="+tokenString, bytes.NewBufferString(body))
+			w := httptest.NewRecorder()
+
+			handleStartTask(w, req, taskManager, config, nil)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusForbidden && !strings.Contains(w.Body.String(), "environment") {
+				t.Errorf("handleStartTask() body = %s; want disallowed parameter name", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlePing(t *testing.T) {
+	config := &Config{
+		Auth: AuthConfig{
+			Secret:  "test-secret-key",
+			APIKeys: []APIKeyConfig{{ID: "ci", Secret: "ci-secret-key", Tasks: []string{"test-task"}}},
+		},
+		Tasks: []TaskConfig{{Name: "test-task", Command: "echo hello"}},
+	}
+
+	keyToken := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	keyToken.Header["kid"] = "ci"
+	keyTokenString, err := keyToken.SignedString([]byte("ci-secret-key"))
+	if err != nil {
+		t.Fatalf("failed to create API key token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+		wantKeyID  string
+	}{
+		{"valid token", http.MethodGet, createTestToken(t, config.Auth.Secret, "", "", time.Hour), http.StatusOK, ""},
+		{"valid API key token", http.MethodGet, keyTokenString, http.StatusOK, "ci"},
+		{"missing token", http.MethodGet, "", http.StatusUnauthorized, ""},
+		{"wrong secret", http.MethodGet, createTestToken(t, "wrong-secret", "", "", time.Hour), http.StatusUnauthorized, ""},
+		{"expired token", http.MethodGet, createTestToken(t, config.Auth.Secret, "", "", -time.Hour), http.StatusUnauthorized, ""},
+		{"viewer token", http.MethodGet, createTestToken(t, config.Auth.Secret, "viewer", "", time.Hour), http.StatusUnauthorized, ""},
+		{"wrong method", http.MethodPost, createTestToken(t, config.Auth.Secret, "", "", time.Hour), http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/ping?token="+tt.token, nil)
+			w := httptest.NewRecorder()
+			handlePing(w, req, config)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handlePing() status = %d; want %d (body: %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var response PingResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("handlePing() response is not valid JSON: %v", err)
+			}
+			if !response.OK {
+				t.Error("handlePing() ok = false; want true")
+			}
+			if time.Since(response.ServerTime).Abs() > time.Minute {
+				t.Errorf("handlePing() server_time = %v; want current time", response.ServerTime)
+			}
+			if response.KeyID != tt.wantKeyID {
+				t.Errorf("handlePing() key_id = %q; want %q", response.KeyID, tt.wantKeyID)
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskWaitOutlivesWriteTimeout(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTimeout := 1
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir:         tmpDir,
+			WriteTimeout:    &writeTimeout,
+			ResponseHeaders: map[string]string{"X-Frame-Options": "DENY"},
+		},
+		Auth:  AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{{Name: "slow-task", Command: "sleep 2; echo done"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	// Serve with the production server settings and the response header middleware wrapping the writer
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleStartTask(w, r, taskManager, config, nil)
+	})
+	handler = ResponseHeadersMiddleware(handler, config.Server.ResponseHeaders, nil)
+	server := httptest.NewUnstartedServer(handler)
+	server.Config = newHTTPServer("", handler, config.Server)
+	server.Start()
+	defer server.Close()
+
+	body := `{"task_name": "slow-task"}`
+	claims := &Claims{
+		BodySHA1: computeBodyHashForToken(body),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+	resp, err := http.Post(server.URL+"/api/start?wait=true&timeout=10&token="+token, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("POST /api/start = %v; response was cut by the write timeout", err)
+	}
+	defer resp.Body.Close()
+
+	var response StartTaskResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("response is not valid JSON: %v", err)
+	}
+	if !response.Completed || response.Stdout != "done\n" {
+		t.Errorf("response = %+v; want completed task with output %q", response, "done\n")
+	}
+	if got := resp.Header.Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options = %q; want DENY", got)
+	}
+}
+
+func TestHandleStartTaskWaitCleansUp(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, OutputRetention: 1},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "quick-task", Command: "echo out"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	body := `{"task_name": "quick-task"}`
+	claims := &Claims{
+		BodySHA1: computeBodyHashForToken(body),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/start?wait=true&timeout=5&token="+token, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	handleStartTask(w, req, taskManager, config, nil)
+
+	var response StartTaskResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+	}
+	if !response.Completed || response.Stdout != "out\n" {
+		t.Fatalf("response = %+v; want completed task with output", response)
+	}
+
+	// No viewer ever connects, the task is removed once the retention period is over
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		_, taskErr := taskManager.GetTask(response.TaskID)
+		dirs, err := os.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatalf("ReadDir() = %v", err)
+		}
+		if taskErr != nil && len(dirs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task registered = %v, %d output directories left 3s after the wait; want none", taskErr == nil, len(dirs))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// archivedOutputFiles are the files of a task's output directory copied to its archive directory (if present)
+var archivedOutputFiles = []string{"stdout", "stderr", "stdout" + gzipSuffix, "stderr" + gzipSuffix, "cleanup"}
+
+// ArchiveMeta describes an archived task run, written as meta.json next to the archived output
+type ArchiveMeta struct {
+	TaskID    string    `json:"task_id"`
+	TaskName  string    `json:"task_name"`
+	Label     string    `json:"label,omitempty"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	ExitCode  int       `json:"exit_code"`
+
+	StepMarker string `json:"step_marker,omitempty"` // Prefix of the step marker lines in the archived stdout
+}
+
+// taskArchiveDir returns the directory a task run is archived to: <archive_dir>/<task_name>/<task_id>
+func taskArchiveDir(archiveDir, taskName, taskID string) string {
+	return filepath.Join(archiveDir, taskName, taskID)
+}
+
+// archiveTaskOutput copies the output of a finished task run into its archive directory, so it is
+// kept after the output directory is cleaned up
+func archiveTaskOutput(archiveDir string, task *RunningTask, exitCode int, endTime time.Time) (string, error) {
+	dir := taskArchiveDir(archiveDir, task.TaskName, task.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	for _, name := range archivedOutputFiles {
+		if err := copyFile(filepath.Join(task.OutputDir, name), filepath.Join(dir, name)); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+	}
+
+	meta, err := json.MarshalIndent(ArchiveMeta{
+		TaskID:    task.ID,
+		TaskName:  task.TaskName,
+		Label:     task.Label,
+		StartTime: task.StartTime.UTC(),
+		EndTime:   endTime.UTC(),
+		ExitCode:  exitCode,
+
+		StepMarker: task.StepMarker,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode archive metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), append(meta, '\n'), 0600); err != nil {
+		return "", fmt.Errorf("failed to write archive metadata: %w", err)
+	}
+	return dir, nil
+}
+
+// copyFile copies src to dst (0600). Returns an error satisfying os.IsNotExist if src doesn't exist.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// validateArchiveDirs creates the archive directories of all tasks (0700) if missing and
+// checks that they are writable by the current (exec) user
+func validateArchiveDirs(tasks []TaskConfig) error {
+	checked := make(map[string]bool)
+	for _, task := range tasks {
+		if task.ArchiveDir == "" || checked[task.ArchiveDir] {
+			continue
+		}
+		checked[task.ArchiveDir] = true
+
+		if err := os.MkdirAll(task.ArchiveDir, 0700); err != nil {
+			return fmt.Errorf("task '%s': cannot create archive directory %s: %w", task.Name, task.ArchiveDir, err)
+		}
+		probe, err := os.CreateTemp(task.ArchiveDir, ".write-test-*")
+		if err != nil {
+			return fmt.Errorf("task '%s': archive directory %s is not writable: %w", task.Name, task.ArchiveDir, err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	return nil
+}
+
+// GetTaskOrArchived returns a task like GetTask. If the task is no longer running, its run is
+// looked up in the archive directories of the configured tasks instead (see FindArchivedTask).
+func (tm *TaskManager) GetTaskOrArchived(taskID string) (*RunningTask, error) {
+	task, err := tm.GetTask(taskID)
+	if err == nil {
+		return task, nil
+	}
+	archived, archiveErr := tm.FindArchivedTask(taskID)
+	if archiveErr != nil {
+		return nil, err
+	}
+	return archived, nil
+}
+
+// FindArchivedTask reads the meta.json of an archived task run. The returned task is marked
+// Archived and completed, with the archive directory as OutputDir.
+func (tm *TaskManager) FindArchivedTask(taskID string) (*RunningTask, error) {
+	// The ID becomes part of the path, only accept IDs of the configured scheme
+	if !validateTaskID(taskID, tm.config.Server.TaskIDScheme) {
+		return nil, fmt.Errorf("invalid task ID format")
+	}
+
+	for _, taskConfig := range tm.config.Tasks {
+		if taskConfig.ArchiveDir == "" {
+			continue
+		}
+		dir := taskArchiveDir(taskConfig.ArchiveDir, taskConfig.Name, taskID)
+		data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+		if err != nil {
+			continue
+		}
+		var meta ArchiveMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("invalid archive metadata for task '%s': %w", taskID, err)
+		}
+		if meta.TaskID != taskID || meta.TaskName != taskConfig.Name {
+			return nil, fmt.Errorf("archive metadata doesn't match task '%s'", taskID)
+		}
+		return &RunningTask{
+			ID:          taskID,
+			TaskName:    meta.TaskName,
+			Label:       meta.Label,
+			StepMarker:  meta.StepMarker,
+			StartTime:   meta.StartTime,
+			OutputDir:   dir,
+			State:       TaskStateCompleted,
+			Completed:   true,
+			CompletedAt: meta.EndTime,
+			Exited:      true,
+			ExitCode:    meta.ExitCode,
+			Archived:    true,
+		}, nil
+	}
+	return nil, fmt.Errorf("task '%s' not found in archive", taskID)
+}
+
+// replayArchivedTask sends the archived output of a finished run to a viewer, stdout before stderr,
+// followed by the completion message. The output is static, nothing is tailed.
+// Returns false if the connection failed.
+func replayArchivedTask(safeConn *safeConn, handles *tailHandleGuard, task *RunningTask, binary bool, coalesce time.Duration, tailLines int, progressPrefix string) bool {
+	log.Printf("[WEBSOCKET] Replaying archived output: task_id=%s, dir=%s", task.ID, task.OutputDir)
+	sendConnectedMessage(safeConn, "WebSocket connected. Replaying archived output", 0)
+
+	counters := &streamCounters{}
+	if err := replayOutputFile(safeConn, handles, task.OutputDir, "stdout", binary, coalesce, tailLines, progressPrefix, streamStepMarker(task, "stdout"), &counters.stdout); err != nil {
+		return false
+	}
+	if err := replayOutputFile(safeConn, handles, task.OutputDir, "stderr", binary, coalesce, tailLines, progressPrefix, streamStepMarker(task, "stderr"), &counters.stderr); err != nil {
+		return false
+	}
+
+	msg := fmt.Sprintf("Process ended with exit code: %d", task.ExitCode)
+	sendCompletionMessage(safeConn, msg, 0, task.ExitCode, counters)
+	return true
+}
+
+// replayOutputFile sends an archived output stream, decompressing it if it was stored compressed.
+// Text output is sent line by line like tailFile (only the last tailLines lines if tailLines > 0),
+// binary output in chunks like tailFileBinary. A missing file is skipped.
+// The number of bytes consumed (including skipped ones) is stored in streamed.
+func replayOutputFile(safeConn *safeConn, handles *tailHandleGuard, outputDir, stream string, binary bool, coalesce time.Duration, tailLines int, progressPrefix, stepMarker string, streamed *atomic.Int64) error {
+	path := existingOutputFile(outputDir, stream)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	file, ok := openTailFile(handles, safeConn, path)
+	if !ok {
+		return fmt.Errorf("failed to open %s", path)
+	}
+	defer handles.Close(file)
+
+	var r io.Reader = file
+	var total int64
+	skipLines := 0
+	if strings.HasSuffix(path, gzipSuffix) {
+		gz, err := gzip.NewReader(bufio.NewReader(file))
+		if err != nil {
+			log.Printf("[TAIL] Failed to read gzip header: %s, error: %v", path, err)
+			return nil
+		}
+		defer gz.Close()
+		r = gz
+		if tailLines > 0 && !binary {
+			skipLines = max(0, countGzipLines(path)-tailLines)
+		}
+	} else if tailLines > 0 && !binary {
+		info, err := file.Stat()
+		if err != nil {
+			return nil
+		}
+		if total, err = lastLinesOffset(file, info.Size(), tailLines); err != nil {
+			return nil
+		}
+		if _, err := file.Seek(total, io.SeekStart); err != nil {
+			return nil
+		}
+		streamed.Store(total)
+	}
+
+	if binary {
+		frame := make([]byte, 1+binaryChunkSize)
+		frame[0] = binaryStreamTag(stream)
+		for {
+			n, err := io.ReadFull(r, frame[1:])
+			if n > 0 {
+				if err := safeConn.writeOutput(context.Background(), websocket.BinaryMessage, frame[:1+n]); err != nil {
+					return err
+				}
+				total += int64(n)
+				streamed.Store(total)
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					log.Printf("[TAIL] Failed to read file: %s, error: %v", path, err)
+				}
+				return nil
+			}
+		}
+	}
+
+	batcher := &lineBatcher{ctx: context.Background(), safeConn: safeConn, outputType: stream, window: coalesce, progressPrefix: progressPrefix, stepMarker: stepMarker}
+	reader := bufio.NewReader(r)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if skipLines > 0 {
+				// Skipped for the tail replay, a line ends at a newline or the end of the stream
+				if err != bufio.ErrBufferFull {
+					skipLines--
+				}
+			} else {
+				line := string(chunk)
+				if !strings.HasSuffix(line, "\n") && err != bufio.ErrBufferFull {
+					line += "\n" // Last line without newline, as sent by tailFile
+				}
+				if err := batcher.add(line); err != nil {
+					return err
+				}
+			}
+			total += int64(len(chunk))
+			streamed.Store(total)
+		}
+		if err == nil || err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != io.EOF {
+			log.Printf("[TAIL] Failed to read file: %s, error: %v", path, err)
+		}
+		return batcher.flush()
+	}
+}
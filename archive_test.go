@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func TestTaskManagerArchivesOutput(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archiveDir := filepath.Join(tmpDir, "archive")
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Tasks: []TaskConfig{
+			{Name: "archived-task", Command: "echo out; echo err >&2; exit 3", ArchiveDir: archiveDir},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	taskID, err := tm.StartTaskWithOptions("archived-task", nil, StartOptions{Label: "nightly"})
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v", err)
+	}
+	if _, ok := tm.WaitExitCode(taskID, 5*time.Second); !ok {
+		t.Fatal("TaskManager.WaitExitCode() ok = false; want task to finish")
+	}
+
+	// The output is archived right after the process has exited
+	dir := taskArchiveDir(archiveDir, "archived-task", taskID)
+	metaPath := filepath.Join(dir, "meta.json")
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(metaPath); err == nil {
+			break
+		}
+	}
+
+	files := map[string]string{"stdout": "out\n", "stderr": "err\n"}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("archived %s: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("archived %s = %q; want %q", name, got, want)
+		}
+	}
+
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		t.Fatalf("archived meta.json: %v", err)
+	}
+	var meta ArchiveMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		t.Fatalf("archived meta.json is not valid JSON: %v", err)
+	}
+	if meta.TaskID != taskID || meta.TaskName != "archived-task" || meta.Label != "nightly" || meta.ExitCode != 3 {
+		t.Errorf("archived meta = %+v; want task_id %s, task_name archived-task, label nightly, exit_code 3", meta, taskID)
+	}
+	if meta.EndTime.Before(meta.StartTime) {
+		t.Errorf("archived meta end_time %v before start_time %v", meta.EndTime, meta.StartTime)
+	}
+}
+
+func TestValidateArchiveDirs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	notADir := filepath.Join(tmpDir, "file")
+	if err := os.WriteFile(notADir, nil, 0600); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		archiveDir string
+		wantErr    bool
+	}{
+		{"no archive", "", false},
+		{"missing directory is created", filepath.Join(tmpDir, "new", "archive"), false},
+		{"path below a file", filepath.Join(notADir, "archive"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArchiveDirs([]TaskConfig{{Name: "test-task", ArchiveDir: tt.archiveDir}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateArchiveDirs() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.archiveDir != "" {
+				entries, err := os.ReadDir(tt.archiveDir)
+				if err != nil {
+					t.Fatalf("archive directory not created: %v", err)
+				}
+				if len(entries) != 0 {
+					t.Errorf("archive directory has %d entries after validation; want 0", len(entries))
+				}
+			}
+		})
+	}
+}
+
+// writeTestArchive archives a finished run with the given output files and returns its task ID
+func writeTestArchive(t *testing.T, archiveDir, taskName string, files map[string]string, exitCode int) string {
+	t.Helper()
+
+	outputDir, err := os.MkdirTemp("", "archive-output-*")
+	if err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	defer os.RemoveAll(outputDir)
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(outputDir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	task := &RunningTask{ID: uuid.New().String(), TaskName: taskName, Label: "nightly", StartTime: time.Now().Add(-time.Minute), OutputDir: outputDir}
+	if _, err := archiveTaskOutput(archiveDir, task, exitCode, time.Now()); err != nil {
+		t.Fatalf("archiveTaskOutput() = %v", err)
+	}
+	return task.ID
+}
+
+func TestGetTaskOrArchived(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archiveDir := filepath.Join(tmpDir, "archive")
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Tasks: []TaskConfig{
+			{Name: "plain-task", Command: "echo hello"},
+			{Name: "archived-task", Command: "echo out", ArchiveDir: archiveDir},
+		},
+	}
+	tm := NewTaskManager(config)
+	taskID := writeTestArchive(t, archiveDir, "archived-task", map[string]string{"stdout": "out\n"}, 3)
+
+	task, err := tm.GetTaskOrArchived(taskID)
+	if err != nil {
+		t.Fatalf("GetTaskOrArchived() = %v; want archived task", err)
+	}
+	if !task.Archived || task.TaskName != "archived-task" || task.Label != "nightly" || task.ExitCode != 3 || task.State != TaskStateCompleted {
+		t.Errorf("GetTaskOrArchived() = %+v; want archived completed run of archived-task with label nightly and exit code 3", task)
+	}
+	if want := taskArchiveDir(archiveDir, "archived-task", taskID); task.OutputDir != want {
+		t.Errorf("GetTaskOrArchived() OutputDir = %q; want %q", task.OutputDir, want)
+	}
+	if _, err := tm.GetTask(taskID); err == nil {
+		t.Error("GetTask() of archived run = nil; want error, only GetTaskOrArchived reads the archive")
+	}
+
+	// Runs of tasks no longer in the config, unknown and malformed IDs aren't found
+	config.Tasks = config.Tasks[:1]
+	if _, err := tm.GetTaskOrArchived(taskID); err == nil {
+		t.Error("GetTaskOrArchived() without archive_dir in config = nil; want error")
+	}
+	config.Tasks = []TaskConfig{{Name: "archived-task", ArchiveDir: archiveDir}}
+	for _, id := range []string{uuid.New().String(), "../archived-task", ""} {
+		if _, err := tm.GetTaskOrArchived(id); err == nil {
+			t.Errorf("GetTaskOrArchived(%q) = nil; want error", id)
+		}
+	}
+
+	// A running task takes precedence
+	live, err := tm.StartTask("archived-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	if task, err := tm.GetTaskOrArchived(live); err != nil || task.Archived {
+		t.Errorf("GetTaskOrArchived() of running task = %+v, %v; want live task", task, err)
+	}
+}
+
+func TestHandleWebSocketArchivedTask(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archiveDir := filepath.Join(tmpDir, "archive")
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "archived-task", Command: "true", ArchiveDir: archiveDir},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	taskID := writeTestArchive(t, archiveDir, "archived-task", map[string]string{"stdout": seqOutput(100), "stderr": "err\n"}, 3)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStdout string
+	}{
+		{"full output", "", seqOutput(100)},
+		{"last lines", "&tail=5", strings.TrimPrefix(seqOutput(100), seqOutput(95))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, cleanup := dialTestWebSocketQuery(t, taskManager, config, NewWebSocketManager(), taskID, tt.query)
+			defer cleanup()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			var stdout, stderr strings.Builder
+			var completion string
+			for completion == "" {
+				var msg struct {
+					Type        string `json:"type"`
+					Data        string `json:"data"`
+					Message     string `json:"message"`
+					StdoutBytes *int64 `json:"stdout_bytes"`
+				}
+				if err := conn.ReadJSON(&msg); err != nil {
+					t.Fatalf("ReadJSON() = %v before completion message", err)
+				}
+				switch {
+				case msg.Type == "stdout":
+					stdout.WriteString(msg.Data)
+				case msg.Type == "stderr":
+					stderr.WriteString(msg.Data)
+				case msg.Type == "system" && msg.StdoutBytes != nil:
+					completion = msg.Message
+					if *msg.StdoutBytes != int64(len(seqOutput(100))) {
+						t.Errorf("completion stdout_bytes = %d; want %d", *msg.StdoutBytes, len(seqOutput(100)))
+					}
+				}
+			}
+
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("stdout = %q; want %q", stdout.String(), tt.wantStdout)
+			}
+			if stderr.String() != "err\n" {
+				t.Errorf("stderr = %q; want %q", stderr.String(), "err\n")
+			}
+			if want := "Process ended with exit code: 3"; completion != want {
+				t.Errorf("completion message = %q; want %q", completion, want)
+			}
+
+			// The connection is closed after the replay, nothing is tailed
+			if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("ReadMessage() after replay = %v; want normal closure", err)
+			}
+		})
+	}
+}
+
+func TestHandleViewerArchivedTask(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	htmlDir := filepath.Join(tmpDir, "html")
+	if err := os.Mkdir(htmlDir, 0700); err != nil {
+		t.Fatalf("Failed to create HTML dir: %v", err)
+	}
+	viewerHTML := `<html><head><title>Viewer {{.Label}}</title></head><body>{{.TaskID}}</body></html>`
+	if err := os.WriteFile(filepath.Join(htmlDir, "viewer.html"), []byte(viewerHTML), 0644); err != nil {
+		t.Fatalf("Failed to create viewer.html: %v", err)
+	}
+	htmlCache, err := NewHTMLCache(htmlDir)
+	if err != nil {
+		t.Fatalf("Failed to create HTML cache: %v", err)
+	}
+
+	archiveDir := filepath.Join(tmpDir, "archive")
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "archived-task", Command: "true", ArchiveDir: archiveDir}},
+	}
+	taskManager := NewTaskManager(config)
+	taskID := writeTestArchive(t, archiveDir, "archived-task", map[string]string{"stdout": "out\n"}, 0)
+
+	for _, tt := range []struct {
+		taskID     string
+		wantStatus int
+	}{
+		{taskID, http.StatusOK},
+		{uuid.New().String(), http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/viewer", nil)
+		req.URL.RawQuery = "token=" + createTestToken(t, config.Auth.Secret, "viewer", tt.taskID, time.Hour) + "&task_id=" + tt.taskID
+		w := httptest.NewRecorder()
+		handleViewer(w, req, taskManager, config, htmlCache)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("handleViewer(%s) status = %d; want %d", tt.taskID, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestTaskManagerRemoveTaskWaitsForArchive(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "archive-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// stdout is large enough that copying it to the archive is still in progress when cleanup runs
+	archiveDir := filepath.Join(tmpDir, "archive")
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Tasks: []TaskConfig{
+			{Name: "large-task", Command: "head -c 50000000 /dev/zero; echo err >&2", ArchiveDir: archiveDir},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	taskID, err := tm.StartTask("large-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v", err)
+	}
+	select {
+	case <-tm.Exited(taskID):
+	case <-time.After(10 * time.Second):
+		t.Fatal("task did not exit")
+	}
+	tm.removeTask(taskID)
+
+	dir := taskArchiveDir(archiveDir, "large-task", taskID)
+	info, err := os.Stat(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatalf("archived stdout: %v", err)
+	}
+	if info.Size() != 50000000 {
+		t.Errorf("archived stdout size = %d; want 50000000", info.Size())
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "stderr")); err != nil || string(got) != "err\n" {
+		t.Errorf("archived stderr = %q, %v; want %q", got, err, "err\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "meta.json")); err != nil {
+		t.Errorf("archived meta.json: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxRequestIDLength = 128
+
+var requestIDRegex = regexp.MustCompile(`^[a-zA-Z0-9._:-]+$`)
+
+// AuditEntry is one line of the audit log, written for every successfully started task.
+// Parameter values are never recorded, only the names of the provided non-secret parameters.
+// Client fields are empty for scheduled runs.
+type AuditEntry struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Trigger      string    `json:"trigger"` // TriggerAPI or TriggerSchedule
+	ClientIP     string    `json:"client_ip,omitempty"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"` // X-Forwarded-For as sent by the client or proxy (unverified)
+	ClientCN     string    `json:"client_cn,omitempty"`     // Common name of the verified client certificate, if any
+	Subject      string    `json:"subject,omitempty"`       // "sub" claim of the API token, if any
+	RequestID    string    `json:"request_id,omitempty"`
+	TaskID       string    `json:"task_id"`
+	TaskName     string    `json:"task_name"`
+	Label        string    `json:"label,omitempty"`
+	Parameters   []string  `json:"parameters"`
+}
+
+// AuditLogger appends JSON lines to the audit file, independent of the general log.
+// Writes are serialized in-process and guarded by an exclusive flock, so several
+// processes may share one audit file.
+type AuditLogger struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewAuditLogger opens (or creates) the audit file in append-only mode with 0600 permissions.
+// It is called before dropping privileges, so the file may belong to root.
+func NewAuditLogger(path string) (*AuditLogger, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLogger{file: file}, nil
+}
+
+// Log writes an entry as a single JSON line. A nil logger (audit log disabled) does nothing.
+func (al *AuditLogger) Log(entry AuditEntry) error {
+	if al == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if err := syscall.Flock(int(al.file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	defer syscall.Flock(int(al.file.Fd()), syscall.LOCK_UN)
+
+	if _, err := al.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit file
+func (al *AuditLogger) Close() error {
+	if al == nil {
+		return nil
+	}
+	return al.file.Close()
+}
+
+// requestID returns the client-supplied X-Request-ID if it is well-formed, otherwise a new UUID
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= maxRequestIDLength && requestIDRegex.MatchString(id) {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// remoteIP returns the host part of the request's remote address (without port)
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parameterNames returns the sorted names of the provided parameters.
+// Parameters defined as secret are omitted entirely.
+func parameterNames(params map[string]interface{}, paramDefs []ParameterConfig) []string {
+	secret := make(map[string]bool)
+	for _, paramDef := range paramDefs {
+		if paramDef.Secret {
+			secret[paramDef.Name] = true
+		}
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if !secret[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
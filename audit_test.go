@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// readAuditEntries reads all JSON lines from an audit file
+func readAuditEntries(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("audit line %q is not valid JSON: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestHandleStartTaskAuditLog(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "audit-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	auditPath := filepath.Join(tmpDir, "audit.log")
+	auditLogger, err := NewAuditLogger(auditPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() = %v", err)
+	}
+	defer auditLogger.Close()
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{
+				Name:    "param-task",
+				Command: "echo {{filename}} {{api_key}}",
+				Parameters: []ParameterConfig{
+					{Name: "filename", Type: "string"},
+					{Name: "api_key", Type: "string", Optional: true, Secret: true},
+				},
+			},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	newRequest := func(body string) *http.Request {
+		claims := &Claims{
+			BodySHA1: computeBodyHashForToken(body),
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "deploy-bot",
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/start?token="+tokenString, bytes.NewBufferString(body))
+		req.RemoteAddr = "192.0.2.10:51234"
+		req.Header.Set("X-Request-ID", "req-42")
+		return req
+	}
+
+	// Failed start is not audited
+	w := httptest.NewRecorder()
+	handleStartTask(w, newRequest(`{"task_name": "param-task", "parameters": {"filename": "bad/value"}}`), taskManager, config, auditLogger)
+	if w.Code == http.StatusOK {
+		t.Fatalf("handleStartTask() with invalid parameter status = %d; want error", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleStartTask(w, newRequest(`{"task_name": "param-task", "parameters": {"filename": "plain-value", "api_key": "secret-value"}}`), taskManager, config, auditLogger)
+	if w.Code != http.StatusOK {
+		t.Fatalf("handleStartTask() status = %d; want %d (body: %s)", w.Code, http.StatusOK, w.Body.String())
+	}
+	var response StartTaskResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("handleStartTask() response is not valid JSON: %v", err)
+	}
+	if got := w.Header().Get("X-Request-ID"); got != "req-42" {
+		t.Errorf("X-Request-ID = %q; want %q", got, "req-42")
+	}
+
+	entries := readAuditEntries(t, auditPath)
+	if len(entries) != 1 {
+		t.Fatalf("audit log has %d entries; want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.TaskID != response.TaskID {
+		t.Errorf("audit task_id = %q; want %q", entry.TaskID, response.TaskID)
+	}
+	if entry.TaskName != "param-task" || entry.Trigger != TriggerAPI || entry.ClientIP != "192.0.2.10" || entry.RequestID != "req-42" {
+		t.Errorf("audit entry = %+v; want task name, API trigger, client IP and request ID", entry)
+	}
+	if entry.Subject != "deploy-bot" {
+		t.Errorf("audit subject = %q; want %q", entry.Subject, "deploy-bot")
+	}
+	if len(entry.Parameters) != 1 || entry.Parameters[0] != "filename" {
+		t.Errorf("audit parameters = %v; want [filename] without secret parameter", entry.Parameters)
+	}
+	if entry.Timestamp.IsZero() {
+		t.Error("audit timestamp is zero")
+	}
+
+	raw, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	for _, leaked := range []string{"plain-value", "secret-value", "api_key"} {
+		if strings.Contains(string(raw), leaked) {
+			t.Errorf("audit log = %s; must not contain %q", raw, leaked)
+		}
+	}
+}
+
+func TestAuditLoggerConcurrentWrites(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "audit-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	auditPath := filepath.Join(tmpDir, "audit.log")
+	auditLogger, err := NewAuditLogger(auditPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() = %v", err)
+	}
+	defer auditLogger.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := auditLogger.Log(AuditEntry{TaskID: fmt.Sprintf("task-%d", i), Parameters: []string{}}); err != nil {
+				t.Errorf("Log() = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if entries := readAuditEntries(t, auditPath); len(entries) != 50 {
+		t.Errorf("audit log has %d entries; want 50", len(entries))
+	}
+
+	info, err := os.Stat(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to stat audit log: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("audit log permissions = %o; want 600", perm)
+	}
+
+	var nilLogger *AuditLogger
+	if err := nilLogger.Log(AuditEntry{}); err != nil {
+		t.Errorf("nil AuditLogger.Log() = %v; want nil", err)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"client supplied", "abc-123", "abc-123"},
+		{"invalid characters", "abc 123\n", ""},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), ""},
+		{"missing", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/start", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+			got := requestID(req)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("requestID() = %q; want %q", got, tt.want)
+			}
+			if tt.want == "" && !validateTaskID(got, TaskIDSchemeUUID) {
+				t.Errorf("requestID() = %q; want generated UUID", got)
+			}
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,12 +18,122 @@ import (
 type Claims struct {
 	TaskID   string `json:"task_id"`
 	BodySHA1 string `json:"body_sha1,omitempty"`
+	KeyID    string `json:"-"` // ID of the API key that signed the token (from the "kid" header, empty = auth.secret)
 	jwt.RegisteredClaims
 }
 
 // validateJWT validates the JWT token from the request
 // expectedAudience: "" or empty string for API tokens, "viewer" for viewer tokens, nil to skip audience validation
 func validateJWT(r *http.Request, secret string, expectedAudience *string) (*Claims, error) {
+	return parseJWT(r, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, expectedAudience)
+}
+
+// validateAPIJWT validates an API token from the request. Tokens with a "kid" header are verified
+// with the secret of that API key (claims.KeyID is set), others with auth.secret.
+// With auth.require_subject, tokens must carry a non-empty "sub" claim.
+func validateAPIJWT(r *http.Request, auth AuthConfig) (*Claims, error) {
+	apiAudience := ""
+	claims, err := parseJWT(r, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return []byte(auth.Secret), nil
+		}
+		key := findAPIKey(auth.APIKeys, kid)
+		if key == nil {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+		return []byte(key.Secret), nil
+	}, &apiAudience)
+	if err != nil {
+		return nil, err
+	}
+	if auth.RequireSubject && strings.TrimSpace(claims.Subject) == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+// findAPIKey returns the API key with the given ID, or nil if there is none
+func findAPIKey(keys []APIKeyConfig, id string) *APIKeyConfig {
+	for i := range keys {
+		if keys[i].ID == id {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// allowsTask reports whether the API key may start the task
+func (k *APIKeyConfig) allowsTask(taskName string) bool {
+	for _, name := range k.Tasks {
+		if name == taskName {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsParameter reports whether the API key may set the parameter. Keys without
+// allowed_parameters may set all parameters.
+func (k *APIKeyConfig) allowsParameter(paramName string) bool {
+	if k.AllowedParameters == nil {
+		return true
+	}
+	for _, name := range k.AllowedParameters {
+		if name == paramName {
+			return true
+		}
+	}
+	return false
+}
+
+// disallowedParameters returns the sorted names of the provided parameters the API token may not set.
+// Tokens signed with auth.secret may set all parameters.
+func disallowedParameters(auth AuthConfig, claims *Claims, parameters map[string]interface{}) []string {
+	if claims == nil || claims.KeyID == "" {
+		return nil
+	}
+	key := findAPIKey(auth.APIKeys, claims.KeyID)
+	var names []string
+	for name := range parameters {
+		if key == nil || !key.allowsParameter(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tokenAllowsTask reports whether the API token may access the task. Tokens signed with auth.secret
+// may access all tasks, tokens of an API key only the key's tasks.
+func tokenAllowsTask(auth AuthConfig, claims *Claims, taskName string) bool {
+	if claims == nil || claims.KeyID == "" {
+		return true
+	}
+	key := findAPIKey(auth.APIKeys, claims.KeyID)
+	return key != nil && key.allowsTask(taskName)
+}
+
+// APISecretHeader carries the pre-shared secret of auth.api_header_secret
+const APISecretHeader = "X-API-Secret"
+
+// validateAPIHeaderSecret checks the pre-shared secret in the X-API-Secret header against auth.api_header_secret.
+// Compared in constant time, so the secret can't be guessed from response times.
+func validateAPIHeaderSecret(r *http.Request, secret string) error {
+	value := r.Header.Get(APISecretHeader)
+	if value == "" {
+		return fmt.Errorf("missing token parameter or %s header", APISecretHeader)
+	}
+	if subtle.ConstantTimeCompare([]byte(value), []byte(secret)) != 1 {
+		return fmt.Errorf("invalid %s header", APISecretHeader)
+	}
+	return nil
+}
+
+// parseJWT parses and validates the JWT token from the request, with keyFunc returning the HMAC secret
+func parseJWT(r *http.Request, keyFunc jwt.Keyfunc, expectedAudience *string) (*Claims, error) {
 	tokenStr := r.URL.Query().Get("token")
 	if tokenStr == "" {
 		return nil, errors.New("missing token parameter")
@@ -28,7 +143,7 @@ func validateJWT(r *http.Request, secret string, expectedAudience *string) (*Cla
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(secret), nil
+		return keyFunc(token)
 	})
 
 	if err != nil {
@@ -39,6 +154,7 @@ func validateJWT(r *http.Request, secret string, expectedAudience *string) (*Cla
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}
+	claims.KeyID, _ = token.Header["kid"].(string)
 
 	// Check expiration
 	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
@@ -79,3 +195,25 @@ func authMiddleware(handler http.HandlerFunc, secret string, expectedAudience *s
 	}
 }
 
+// buildClientAuthTLSConfig creates a TLS config that requires and verifies client certificates
+// signed by one of the CAs in the given PEM bundle
+func buildClientAuthTLSConfig(clientCAData []byte) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(clientCAData) {
+		return nil, errors.New("no valid CA certificates found in client CA file")
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// clientCertCommonName returns the common name of the verified client certificate,
+// or an empty string if the request has no verified client certificate
+func clientCertCommonName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
 	"time"
 
@@ -194,6 +206,44 @@ func TestValidateJWTAudience(t *testing.T) {
 	}
 }
 
+func TestValidateAPIJWTRequireSubject(t *testing.T) {
+	auth := AuthConfig{Secret: "test-secret", RequireSubject: true}
+
+	newToken := func(subject string) string {
+		claims := &Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   subject,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(auth.Secret))
+		if err != nil {
+			t.Fatalf("Failed to create test token: %v", err)
+		}
+		return tokenString
+	}
+
+	claims, err := validateAPIJWT(createRequestWithToken(newToken("deploy-bot")), auth)
+	if err != nil {
+		t.Fatalf("validateAPIJWT() with subject = %v; want nil", err)
+	}
+	if claims.Subject != "deploy-bot" {
+		t.Errorf("validateAPIJWT() subject = %q; want %q", claims.Subject, "deploy-bot")
+	}
+
+	for _, subject := range []string{"", "  "} {
+		if _, err := validateAPIJWT(createRequestWithToken(newToken(subject)), auth); err == nil {
+			t.Errorf("validateAPIJWT() with subject %q = nil; want error", subject)
+		}
+	}
+
+	// Off by default
+	auth.RequireSubject = false
+	if _, err := validateAPIJWT(createRequestWithToken(newToken("")), auth); err != nil {
+		t.Errorf("validateAPIJWT() without subject and without require_subject = %v; want nil", err)
+	}
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	secret := "test-secret"
 	apiAud := ""
@@ -234,6 +284,121 @@ func TestAuthMiddleware(t *testing.T) {
 
 // Helper functions
 
+func TestBuildClientAuthTLSConfig(t *testing.T) {
+	caPEM, clientCert := createTestClientCertificates(t, "test-client")
+
+	tlsConfig, err := buildClientAuthTLSConfig(caPEM)
+	if err != nil {
+		t.Fatalf("buildClientAuthTLSConfig() = %v; want nil", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("buildClientAuthTLSConfig() ClientAuth = %v; want RequireAndVerifyClientCert", tlsConfig.ClientAuth)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(clientCertCommonName(r)))
+	}))
+	server.TLS = tlsConfig
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // Expected handshake failures
+	server.StartTLS()
+	defer server.Close()
+
+	// Each client gets its own transport so certificates and connections are not shared
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = certs
+		return &http.Client{Transport: transport}
+	}
+
+	// Client with a certificate signed by the CA: common name is exposed to the handler
+	resp, err := newClient(clientCert).Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET with client certificate = %v; want nil", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "test-client" {
+		t.Errorf("clientCertCommonName() = %q; want %q", string(body), "test-client")
+	}
+
+	// Client without certificate is rejected during the handshake
+	if resp, err := newClient().Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("GET without client certificate succeeded; want handshake error")
+	}
+
+	// Client with a certificate from another CA is rejected
+	_, otherCert := createTestClientCertificates(t, "other-client")
+	if resp, err := newClient(otherCert).Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("GET with untrusted client certificate succeeded; want handshake error")
+	}
+}
+
+func TestBuildClientAuthTLSConfigInvalidCA(t *testing.T) {
+	if _, err := buildClientAuthTLSConfig([]byte("not a certificate")); err == nil {
+		t.Error("buildClientAuthTLSConfig() with invalid PEM = nil; want error")
+	}
+}
+
+func TestClientCertCommonNameWithoutTLS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/start", nil)
+	if cn := clientCertCommonName(req); cn != "" {
+		t.Errorf("clientCertCommonName() without TLS = %q; want empty", cn)
+	}
+}
+
+// createTestClientCertificates creates a self-signed CA (returned as PEM) and a client certificate signed by it
+func createTestClientCertificates(t *testing.T, commonName string) ([]byte, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create client certificate: %v", err)
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	clientCert := tls.Certificate{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}
+	return caPEM, clientCert
+}
+
 func createTestToken(t *testing.T, secret, audience, taskID string, expiration time.Duration) string {
 	t.Helper()
 	
@@ -308,3 +473,61 @@ func (m *mockResponseWriter) WriteHeader(statusCode int) {
 	m.statusCode = statusCode
 }
 
+
+func TestDisallowedParameters(t *testing.T) {
+	auth := AuthConfig{
+		Secret: "test-secret",
+		APIKeys: []APIKeyConfig{
+			{ID: "ci", Secret: "ci-secret", Tasks: []string{"deploy"}, AllowedParameters: []string{"version"}},
+			{ID: "ops", Secret: "ops-secret", Tasks: []string{"deploy"}},
+			{ID: "fixed", Secret: "fixed-secret", Tasks: []string{"deploy"}, AllowedParameters: []string{}},
+		},
+	}
+	params := map[string]interface{}{"version": "1.2", "environment": "prod", "debug": true}
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		want   []string
+	}{
+		{"no token (client certificate)", nil, nil},
+		{"shared secret", &Claims{}, nil},
+		{"key allows some parameters", &Claims{KeyID: "ci"}, []string{"debug", "environment"}},
+		{"key without allowed_parameters", &Claims{KeyID: "ops"}, nil},
+		{"key allows no parameters", &Claims{KeyID: "fixed"}, []string{"debug", "environment", "version"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := disallowedParameters(auth, tt.claims, params); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("disallowedParameters() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenAllowsTask(t *testing.T) {
+	auth := AuthConfig{
+		Secret:  "test-secret",
+		APIKeys: []APIKeyConfig{{ID: "ci", Secret: "ci-secret", Tasks: []string{"build", "test"}}},
+	}
+
+	tests := []struct {
+		name     string
+		claims   *Claims
+		taskName string
+		want     bool
+	}{
+		{"no token (client certificate)", nil, "deploy", true},
+		{"shared secret", &Claims{}, "deploy", true},
+		{"key allows task", &Claims{KeyID: "ci"}, "test", true},
+		{"key forbids task", &Claims{KeyID: "ci"}, "deploy", false},
+		{"unknown key", &Claims{KeyID: "dev"}, "build", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenAllowsTask(auth, tt.claims, tt.taskName); got != tt.want {
+				t.Errorf("tokenAllowsTask(%q) = %v; want %v", tt.taskName, got, tt.want)
+			}
+		})
+	}
+}
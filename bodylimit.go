@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// defaultMaxRequestSize is the body size limit of /api/start if max_request_size is not set
+const defaultMaxRequestSize = 10 * 1024 * 1024 // 10MB
+
+// MaxBodySizeMiddleware limits the request body of a route to limit bytes.
+// Requests announcing a larger body are rejected with 413 before the handler runs;
+// bodies without (or with a wrong) Content-Length fail with *http.MaxBytesError when read.
+func MaxBodySizeMiddleware(handler http.HandlerFunc, limit int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			log.Printf("[API] Request body too large from %s: %d bytes (max %d)", r.RemoteAddr, r.ContentLength, limit)
+			sendJSONError(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, fmt.Sprintf("Request body too large (max %d bytes)", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		handler(w, r)
+	}
+}
+
+// hasFileParameters reports whether a task declares parameters of type file
+func hasFileParameters(task *TaskConfig) bool {
+	if task == nil {
+		return false
+	}
+	for _, param := range task.Parameters {
+		if param.Type == "file" {
+			return true
+		}
+	}
+	return false
+}
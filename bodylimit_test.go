@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestMaxBodySizeMiddleware(t *testing.T) {
+	echoLength := func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendJSONError(w, http.StatusRequestEntityTooLarge, ErrCodeRequestTooLarge, "too large")
+			return
+		}
+		w.Write([]byte(strings.Repeat("x", len(data))))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/small", MaxBodySizeMiddleware(echoLength, 10))
+	mux.HandleFunc("/large", MaxBodySizeMiddleware(echoLength, 100))
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		chunked        bool // No Content-Length, the limit is enforced while reading
+		wantStatusCode int
+	}{
+		{name: "small route within limit", path: "/small", body: strings.Repeat("a", 10), wantStatusCode: http.StatusOK},
+		{name: "small route over limit", path: "/small", body: strings.Repeat("a", 50), wantStatusCode: http.StatusRequestEntityTooLarge},
+		{name: "large route with same body", path: "/large", body: strings.Repeat("a", 50), wantStatusCode: http.StatusOK},
+		{name: "large route over limit", path: "/large", body: strings.Repeat("a", 101), wantStatusCode: http.StatusRequestEntityTooLarge},
+		{name: "small route over limit without content length", path: "/small", body: strings.Repeat("a", 50), chunked: true, wantStatusCode: http.StatusRequestEntityTooLarge},
+		{name: "large route without content length", path: "/large", body: strings.Repeat("a", 50), chunked: true, wantStatusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatusCode {
+				t.Fatalf("status = %d; want %d", w.Code, tt.wantStatusCode)
+			}
+			if tt.wantStatusCode == http.StatusOK && w.Body.Len() != len(tt.body) {
+				t.Errorf("handler read %d bytes; want %d", w.Body.Len(), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestHandleStartTaskBodyLimits(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "api-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, MaxParametersSize: 4 * 1024 * 1024},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "text-task", Command: "echo {{message}}", Parameters: []ParameterConfig{
+				{Name: "message", Type: "string"},
+			}},
+			{Name: "file-task", Command: "wc -c {{input}}", Parameters: []ParameterConfig{
+				{Name: "input", Type: "file", MaxLength: 3 * 1024 * 1024},
+			}},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	handler := MaxBodySizeMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleStartTask(w, r, taskManager, config, nil)
+	}, 2*maxJSONSize)
+
+	startRequest := func(taskName, param string, size int) *http.Request {
+		body, err := json.Marshal(StartTaskRequest{
+			TaskName:   taskName,
+			Parameters: map[string]interface{}{param: strings.Repeat("a", size)},
+		})
+		if err != nil {
+			t.Fatalf("json.Marshal() = %v", err)
+		}
+		claims := &Claims{
+			BodySHA1: computeBodyHashForToken(string(body)),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+		}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+		if err != nil {
+			t.Fatalf("failed to create API token: %v", err)
+		}
+		return httptest.NewRequest(http.MethodPost, "/api/start?token="+token, bytes.NewReader(body))
+	}
+
+	tests := []struct {
+		name           string
+		req            *http.Request
+		wantStatusCode int
+	}{
+		{name: "text task within default limit", req: startRequest("text-task", "message", 1000), wantStatusCode: http.StatusOK},
+		{name: "text task over default limit", req: startRequest("text-task", "message", maxJSONSize+1), wantStatusCode: http.StatusRequestEntityTooLarge},
+		{name: "file task over default limit", req: startRequest("file-task", "input", maxJSONSize+1), wantStatusCode: http.StatusOK},
+		{name: "file task over route limit", req: startRequest("file-task", "input", 2*maxJSONSize+1), wantStatusCode: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, tt.req)
+			if w.Code != tt.wantStatusCode {
+				t.Errorf("handleStartTask() status = %d; want %d (body: %.200s)", w.Code, tt.wantStatusCode, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipResponseWriter gzip-encodes the response body.
+// Headers are only sent on the first write, so responses without a body are not encoded.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	statusCode  int
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(statusCode int) {
+	if gw.statusCode == 0 {
+		gw.statusCode = statusCode
+	}
+}
+
+func (gw *gzipResponseWriter) Write(data []byte) (int, error) {
+	if gw.gz == nil {
+		if gw.statusCode == 0 {
+			gw.statusCode = http.StatusOK
+		}
+		header := gw.ResponseWriter.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length") // Length of the uncompressed body
+		gw.ResponseWriter.WriteHeader(gw.statusCode)
+		gw.wroteHeader = true
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	return gw.gz.Write(data)
+}
+
+// Flush flushes the compressed data written so far to the client
+func (gw *gzipResponseWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController reaches its deadlines
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// finish completes the gzip stream, or sends the plain status if nothing was written
+func (gw *gzipResponseWriter) finish() {
+	if gw.gz != nil {
+		gw.gz.Close()
+		return
+	}
+	if gw.statusCode != 0 && !gw.wroteHeader {
+		gw.ResponseWriter.WriteHeader(gw.statusCode)
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows a gzip-encoded response
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		// "gzip;q=0" explicitly refuses gzip
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// GzipMiddleware gzip-encodes responses for clients sending "Accept-Encoding: gzip".
+// Must not be applied to WebSocket routes, the upgrade needs the unwrapped ResponseWriter.
+func GzipMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The response differs by Accept-Encoding, caches must not mix the variants
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			handler(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		handler(gw, r)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat("<p>task output</p>\n", 100)
+	handler := GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(body))
+	})
+
+	t.Run("gzip capable client", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/viewer", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip;q=0.8")
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("Content-Encoding = %q; want gzip", got)
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary = %q; want Accept-Encoding", got)
+		}
+		if w.Body.Len() >= len(body) {
+			t.Errorf("compressed body has %d bytes; want less than %d", w.Body.Len(), len(body))
+		}
+		gr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("gzip.NewReader() = %v", err)
+		}
+		decoded, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatalf("reading gzip body = %v", err)
+		}
+		if string(decoded) != body {
+			t.Errorf("decoded body does not match original")
+		}
+	})
+
+	for _, acceptEncoding := range []string{"", "deflate, br", "gzip;q=0"} {
+		t.Run("client accepting "+acceptEncoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/viewer", nil)
+			if acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if got := w.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q; want none", got)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q; want Accept-Encoding", got)
+			}
+			if w.Body.String() != body {
+				t.Errorf("body was modified for a client without gzip support")
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareStatusWithoutBody(t *testing.T) {
+	handler := GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/viewer", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q; want none for empty body", got)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("body = %q; want empty", w.Body.String())
+	}
+}
+
+func TestGzipMiddlewareErrorStatus(t *testing.T) {
+	handler := GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Error 404"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/viewer", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d; want %d", w.Code, http.StatusNotFound)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() = %v", err)
+	}
+	decoded, _ := io.ReadAll(gr)
+	if string(decoded) != "Error 404" {
+		t.Errorf("decoded body = %q; want %q", decoded, "Error 404")
+	}
+}
@@ -1,43 +1,196 @@
 package main
 
+import "regexp"
+
 // Config represents the application configuration
 type Config struct {
 	Server ServerConfig `toml:"server"`
 	Auth   AuthConfig   `toml:"auth"`
+	ACME   ACMEConfig   `toml:"acme"`
 	Tasks  []TaskConfig `toml:"tasks"`
 }
 
 // ServerConfig contains server settings
 type ServerConfig struct {
-	Port            int      `toml:"port"`
-	HTMLDir         string   `toml:"html_dir"`
-	TaskDir         string   `toml:"task_dir"`         // Path to task output directory
-	ExecUser        string   `toml:"exec_user"`        // User to run as (default: www-data)
-	AllowedOrigins  []string `toml:"allowed_origins"` // For WebSocket CORS
-	RateLimitRPM    int      `toml:"rate_limit_rpm"`  // Requests per minute per IP (0 = disabled)
-	MaxRequestSize  int64    `toml:"max_request_size"` // Max request body size in bytes (0 = default 10MB)
-	TLSKeyFile      string   `toml:"tls_key_file"`     // Path to TLS private key file
-	TLSCertFile     string   `toml:"tls_cert_file"`    // Path to TLS certificate file (fullchain)
+	Port           int      `toml:"port"`
+	BindAddress    string   `toml:"bind_address"` // Address to bind to (empty = all interfaces)
+	UnixSocket     string   `toml:"unix_socket"`  // Listen on this Unix socket path instead of TCP (empty = disabled)
+	HTMLDir        string   `toml:"html_dir"`
+	TaskDir        string   `toml:"task_dir"`         // Path to task output directory
+	ExecUser       string   `toml:"exec_user"`        // User to run as (default: www-data)
+	AllowedOrigins []string `toml:"allowed_origins"`  // For WebSocket CORS, exact or with wildcard ("https://*.example.com")
+	RateLimitRPM   int      `toml:"rate_limit_rpm"`   // Requests per minute per IP (0 = disabled)
+	MaxRequestSize int64    `toml:"max_request_size"` // Max body size of /api/start in bytes for requests with file parameters (0 = default 10MB), others are limited to 1MB
+	TLSKeyFile     string   `toml:"tls_key_file"`     // Path to TLS private key file
+	TLSCertFile    string   `toml:"tls_cert_file"`    // Path to TLS certificate file (fullchain)
+
+	DenyAllOriginsIfEmpty bool `toml:"deny_all_origins_if_empty"` // Treat an empty allowed_origins list as "deny all" instead of "allow all"
+	CheckAPIOrigin        bool `toml:"check_api_origin"`          // Also check Origin/Referer of /api/start against allowed_origins (CSRF protection)
+
+	// Rate limiting per subnet instead of per address (0 = per address)
+	RateLimitIPv4Prefix int `toml:"rate_limit_ipv4_prefix"` // Prefix length for IPv4 clients, e.g. 24 (0-32)
+	RateLimitIPv6Prefix int `toml:"rate_limit_ipv6_prefix"` // Prefix length for IPv6 clients, e.g. 64 (0-128)
+
+	// Separate rate limits per route in requests per minute per IP (nil = shared rate_limit_rpm, 0 = disabled)
+	RateLimitStartRPM  *int `toml:"rate_limit_start_rpm"`  // /api/start
+	RateLimitViewerRPM *int `toml:"rate_limit_viewer_rpm"` // /viewer
+	RateLimitWSRPM     *int `toml:"rate_limit_ws_rpm"`     // /ws
+
+	RateLimitMaxBuckets int `toml:"rate_limit_max_buckets"` // Maximum clients tracked per rate limiter, least recently used are evicted (0 = default 100000)
+
+	// Mutual TLS (requires tls_key_file and tls_cert_file)
+	TLSClientCAFile string `toml:"tls_client_ca_file"` // CA bundle for client certificates; when set, clients must present a valid certificate
+	MTLSAPIAuth     bool   `toml:"mtls_api_auth"`      // Accept a verified client certificate instead of a JWT for API requests
+
+	// Response header customization (e.g. for gateways)
+	ResponseHeaders      map[string]string `toml:"response_headers"`       // Headers added to every response
+	StripResponseHeaders []string          `toml:"strip_response_headers"` // Headers removed from every response
+
+	// HTTP server timeouts in seconds (nil = default, 0 = no timeout)
+	ReadTimeout  *int `toml:"read_timeout"`  // Default 15
+	WriteTimeout *int `toml:"write_timeout"` // Default 15; limits single HTTP responses, not WebSocket streams
+	IdleTimeout  *int `toml:"idle_timeout"`  // Default 60
+
+	ReadHeaderTimeout *int `toml:"read_header_timeout"` // Seconds to read the request headers, against slow-loris clients (nil = default 10, 0 = read_timeout)
+	MaxConnections    int  `toml:"max_connections"`     // Maximum concurrently served requests including WebSocket streams, more get 503 (0 = unlimited)
+
+	Protocols []string `toml:"protocols"` // Enabled protocols: "http1" (required), "http2" (over TLS), "h2c" (cleartext HTTP/2); empty = ["http1", "http2"]
+
+	OutputLayout string `toml:"output_layout"`  // Task output directory layout: "flat" (<task_dir>/<id>, default) or "date" (<task_dir>/<YYYY-MM-DD>/<id>)
+	TaskIDScheme string `toml:"task_id_scheme"` // Format of task IDs: "uuid" (default) or "short" (12 base62 characters)
+
+	AuditLog string `toml:"audit_log"` // Append a JSON line per started task to this file (empty = disabled)
+
+	Shell string `toml:"shell"` // Shell for the wrapper script and task commands (default /bin/bash)
+	Umask string `toml:"umask"` // Octal umask for task processes, e.g. "027" (empty = inherit the server's umask)
+
+	AllowedCommands []string `toml:"allowed_commands"` // Binaries task commands may run (names or absolute paths), checked after parameter substitution (empty = all)
+
+	StrictConfig bool `toml:"strict_config"` // Fail startup on unknown config keys (default: only warn)
+
+	// Periodic status snapshot for external monitoring
+	StatusFile     string `toml:"status_file"`     // Path for JSON snapshot of running tasks (empty = disabled)
+	StatusInterval int    `toml:"status_interval"` // Snapshot interval in seconds (0 = default 10)
+
+	// Guards against oversized parameter maps in /api/start
+	MaxParameters     int `toml:"max_parameters"`      // Maximum number of provided parameters (0 = default 100)
+	MaxParametersSize int `toml:"max_parameters_size"` // Maximum serialized size of all parameters in bytes (0 = default 65536)
+
+	OutputRetention int `toml:"output_retention"` // Seconds to keep output of completed tasks available to viewers (0 = remove right after completion)
+
+	HeartbeatInterval int `toml:"heartbeat_interval"` // Seconds between heartbeat messages to viewers of running tasks (0 = default 30)
+	StartupTimeout    int `toml:"startup_timeout"`    // Seconds after the task start until a wrapper that hasn't run the command yet marks the start as failed (0 = default 10)
+	DrainTimeout      int `toml:"drain_timeout"`      // Seconds to wait on shutdown for running tasks to finish, new starts get 503 (0 = shut down immediately)
+	StartRetries      int `toml:"start_retries"`      // Retries of task process starts failing with a transient error, with exponential backoff from 100ms (0 = no retries)
+	WSCoalesceMs      int `toml:"ws_coalesce_ms"`     // Window in milliseconds for joining output lines into one WebSocket message (0 = one message per line)
+	FileWaitTimeout   int `toml:"file_wait_timeout"`  // Seconds a viewer waits for an output file to be created (0 = default 60)
+	FilePollInterval  int `toml:"file_poll_interval"` // Milliseconds between checks for a missing output file (0 = default 1000)
+	MonitorWorkers    int `toml:"monitor_workers"`    // Goroutines sharing the monitoring of all viewed tasks (0 = default 1)
+	MaxTailHandles    int `toml:"max_tail_handles"`   // Maximum output files held open by viewers, one per viewed stream (0 = unlimited)
+	MaxTailers        int `toml:"max_tailers"`        // Maximum tail goroutines of all viewers, two per viewer, further viewers get 503 (0 = unlimited)
+
+	MaxTaskDirBytes int64 `toml:"max_task_dir_bytes"` // Reject new tasks with 507 while all task directories together use this many bytes (0 = unlimited)
+
+	WSMaxBytesPerSec int `toml:"ws_max_bytes_per_sec"` // Maximum task output in bytes per second sent to one viewer (0 = unlimited)
+
+	TerminationGracePeriod *int `toml:"termination_grace_period"` // Seconds between the termination signal and SIGKILL on timeout (nil = default 30, 0 = SIGKILL right away)
+
+	OmitTokenizedViewerURL bool   `toml:"omit_tokenized_viewer_url"` // Don't return viewer_url with embedded token from /api/start
+	ViewerURLTemplate      string `toml:"viewer_url_template"`       // Viewer URL returned by /api/start with {{task_id}} and {{token}} placeholders, e.g. for an external front-end (empty = built-in /viewer)
+	LabelHeader            string `toml:"label_header"`              // Trusted request header supplying a task label if the body has none (empty = disabled)
+
+	RootRedirect string `toml:"root_redirect"` // Redirect requests to / to this URL instead of serving index.html from html_dir (empty = disabled)
+
+	// Bounds for in-memory caches (idempotency keys, confirmation tokens, replayed token IDs)
+	CacheTTL        int `toml:"cache_ttl"`         // Maximum age of cache entries in seconds (0 = default 86400)
+	CacheMaxEntries int `toml:"cache_max_entries"` // Maximum number of entries per cache (0 = default 10000)
 }
 
 // AuthConfig contains authentication settings
 type AuthConfig struct {
-	Secret string `toml:"secret"`
+	Secret          string         `toml:"secret"`
+	APIKeys         []APIKeyConfig `toml:"api_keys"`          // Additional API token signing keys, each limited to a set of tasks
+	APIHeaderSecret string         `toml:"api_header_secret"` // Pre-shared secret accepted in the X-API-Secret header instead of a JWT for /api/start (empty = disabled)
+	RequireSubject  bool           `toml:"require_subject"`   // Reject API tokens without a "sub" claim identifying the caller
+}
+
+// APIKeyConfig is a per-client API key. Tokens signed with its secret carry its ID in the "kid"
+// header and may only start and view the listed tasks.
+type APIKeyConfig struct {
+	ID     string   `toml:"id"`     // Key ID, sent as "kid" in the JWT header
+	Secret string   `toml:"secret"` // HMAC secret for API tokens of this key
+	Tasks  []string `toml:"tasks"`  // Names of the tasks the key may access
+
+	AllowedParameters []string `toml:"allowed_parameters"` // Names of the parameters the key may set (not set = all, empty list = none)
+}
+
+// ACMEConfig contains settings for automatic certificates via ACME (e.g. Let's Encrypt)
+type ACMEConfig struct {
+	Domains     []string `toml:"domains"`      // Domains to obtain certificates for (empty = ACME disabled)
+	CacheDir    string   `toml:"cache_dir"`    // Directory for the account key and certificates (default: /var/lib/vsTaskViewer/acme)
+	Email       string   `toml:"email"`        // Contact email for expiry notices (optional)
+	HTTPAddress string   `toml:"http_address"` // Listen address for HTTP-01 challenges (default: :80)
 }
 
 // TaskConfig defines a task that can be executed
 type TaskConfig struct {
-	Name            string           `toml:"name"`
-	Command         string           `toml:"command"`
-	Description     string           `toml:"description"`
-	MaxExecutionTime int             `toml:"max_execution_time"` // Maximum execution time in seconds (0 = no limit)
-	Parameters      []ParameterConfig `toml:"parameters"`        // Parameter definitions for the task
+	Name                   string            `toml:"name"`
+	Command                string            `toml:"command"`
+	Steps                  []StepConfig      `toml:"steps"`                    // Commands run in order instead of command, each framed by step_start/step_end markers
+	ContinueOnStepFailure  bool              `toml:"continue_on_step_failure"` // Run the remaining steps after a failed step (default: stop at the first failure)
+	CleanupCommand         string            `toml:"cleanup_command"`          // Command run after the task command, regardless of success (output in "cleanup" file)
+	Description            string            `toml:"description"`
+	MaxExecutionTime       int               `toml:"max_execution_time"`       // Maximum execution time in seconds (0 = no limit)
+	MaxOpenFiles           int               `toml:"max_open_files"`           // Max open file descriptors (RLIMIT_NOFILE) for the task (0 = inherit)
+	Umask                  string            `toml:"umask"`                    // Octal umask for the task, overrides server.umask (empty = server.umask)
+	StallTimeout           int               `toml:"stall_timeout"`            // Seconds without wrapper heartbeat until viewers are told the task is stalled, detects a stopped or killed wrapper but not a hanging command (0 = disabled)
+	Parameters             []ParameterConfig `toml:"parameters"`               // Parameter definitions for the task
+	Enabled                *bool             `toml:"enabled"`                  // Whether the task can be started (nil = true), e.g. to block it during maintenance
+	Schedule               string            `toml:"schedule"`                 // Cron spec for scheduled runs with empty parameters (e.g. "0 3 * * *" or "@every 1h", empty = none)
+	BinaryOutput           bool              `toml:"binary_output"`            // Stream output as raw chunks in binary WebSocket messages instead of JSON lines
+	CompressOutput         bool              `toml:"compress_output"`          // Store stdout/stderr gzip-compressed (stdout.gz/stderr.gz), decompressed when streamed
+	TerminationSignal      string            `toml:"termination_signal"`       // Signal sent when max_execution_time is exceeded, before SIGKILL (e.g. "SIGINT", empty = SIGTERM)
+	TerminationGracePeriod *int              `toml:"termination_grace_period"` // Overrides server.termination_grace_period for this task
+	ArchiveDir             string            `toml:"archive_dir"`              // Absolute path; output and meta.json of finished runs are copied to <archive_dir>/<task_name>/<task_id> (empty = no archive)
+
+	SingleUseViewerToken bool `toml:"single_use_viewer_token"` // Viewer links can be opened only once (the page's WebSocket may follow within a short window)
+
+	AllowedOrigins []string `toml:"allowed_origins"` // Origins accepted for /ws connections to this task in addition to server.allowed_origins
+
+	PreCheck        string `toml:"pre_check"`         // Command run before each start, a non-zero exit rejects the start (may contain parameter placeholders)
+	PreCheckTimeout int    `toml:"pre_check_timeout"` // Seconds until the pre_check is killed and the start rejected (0 = default 10)
+
+	ForwardHeaders []string `toml:"forward_headers"` // Request headers passed to the task as HEADER_<NAME> environment variables
+
+	ProgressPrefix string `toml:"progress_prefix"` // Output lines starting with it are sent as progress messages, e.g. "PROGRESS:" (empty = disabled)
+
+	TerminateOnDisconnect bool `toml:"terminate_on_disconnect"` // Terminate the task shortly after its last viewer has disconnected
+}
+
+// IsEnabled reports whether the task may be started
+func (t *TaskConfig) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// StepConfig defines a step of a multi-step task
+type StepConfig struct {
+	Name    string `toml:"name"`    // Step name shown to viewers (letters, digits, '_', '.' and '-')
+	Command string `toml:"command"` // Command of the step, may contain parameter placeholders
 }
 
 // ParameterConfig defines a parameter for a task
 type ParameterConfig struct {
-	Name     string `toml:"name"`     // Parameter name
-	Type     string `toml:"type"`     // Parameter type: "int" or "string"
-	Optional bool   `toml:"optional"` // Whether the parameter is optional
-}
+	Name        string   `toml:"name"`        // Parameter name
+	Type        string   `toml:"type"`        // Parameter type: "int", "string" or "file"
+	Optional    bool     `toml:"optional"`    // Whether the parameter is optional
+	Description string   `toml:"description"` // Human-readable description (exposed via /api/definitions)
+	Min         *int     `toml:"min"`         // Minimum value for int parameters (nil = no lower bound)
+	Max         *int     `toml:"max"`         // Maximum value for int parameters (nil = no upper bound)
+	MaxLength   int      `toml:"max_length"`  // Maximum length for string parameters (0 = no limit), size in bytes for file parameters (0 = default 65536)
+	Pattern     string   `toml:"pattern"`     // Regular expression the whole string value must match (optional)
+	Secret      bool     `toml:"secret"`      // Value is redacted in error messages and logs and omitted from the audit log
+	Encoding    string   `toml:"encoding"`    // "base64": value is sent base64-encoded and never interpolated into the shell (string and file only)
+	Transform   []string `toml:"transform"`   // Transformations applied in order before validation: "trim", "lower", "upper" (string and int only)
 
+	pattern *regexp.Regexp // Compiled Pattern (set at config load)
+}
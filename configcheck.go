@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkOverrides holds the command line flags that override config values, applied as on startup
+type checkOverrides struct {
+	HTMLDir  string // -t
+	TaskDir  string // -d
+	ExecUser string // -u
+}
+
+// checkResult is the outcome of a single check of the -check report
+type checkResult struct {
+	Name   string
+	Detail string // Checked value, e.g. a path (empty = none)
+	Err    error
+}
+
+// runConfigCheck validates a config file with the checks done on startup, without binding ports,
+// dropping privileges or creating directories. Writes a report to w and returns the exit code
+// (0 = all checks passed).
+func runConfigCheck(w io.Writer, configPath string, overrides checkOverrides) int {
+	results := checkConfig(configPath, overrides)
+	failed := 0
+	for _, result := range results {
+		status := "OK  "
+		if result.Err != nil {
+			status = "FAIL"
+			failed++
+		}
+		line := fmt.Sprintf("%s %s", status, result.Name)
+		if result.Detail != "" {
+			line += " (" + result.Detail + ")"
+		}
+		if result.Err != nil {
+			line += ": " + result.Err.Error()
+		}
+		fmt.Fprintln(w, line)
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d checks failed\n", failed, len(results))
+		return 1
+	}
+	fmt.Fprintf(w, "Configuration OK (%d checks)\n", len(results))
+	return 0
+}
+
+// checkConfig runs the checks of runConfigCheck. The remaining checks are skipped if the config can't be loaded.
+func checkConfig(configPath string, overrides checkOverrides) []checkResult {
+	// loadConfig covers the syntax, all config values and the command placeholders
+	config, err := loadConfig(configPath)
+	results := []checkResult{{Name: "config", Detail: configPath, Err: err}}
+	if err != nil {
+		return results
+	}
+
+	htmlDir, err := checkHTMLDir(config.Server.HTMLDir, overrides.HTMLDir)
+	results = append(results, checkResult{Name: "templates directory", Detail: htmlDir, Err: err})
+
+	taskDir, err := checkTaskDir(config.Server.TaskDir, overrides.TaskDir)
+	results = append(results, checkResult{Name: "task directory", Detail: taskDir, Err: err})
+
+	execUser := config.Server.ExecUser
+	if overrides.ExecUser != "" {
+		execUser = overrides.ExecUser
+	} else if execUser == "" {
+		execUser = findExecUser()
+	}
+	// The exec user is only switched to when running as root
+	if os.Getuid() == 0 {
+		_, _, err = lookupUser(execUser)
+		results = append(results, checkResult{Name: "exec user", Detail: execUser, Err: err})
+	}
+
+	if config.Server.TLSKeyFile != "" && config.Server.TLSCertFile != "" {
+		_, err := newCertReloader(config.Server.TLSCertFile, config.Server.TLSKeyFile)
+		results = append(results, checkResult{Name: "TLS certificate", Detail: config.Server.TLSCertFile, Err: err})
+	}
+	if config.Server.TLSClientCAFile != "" {
+		clientCAData, err := os.ReadFile(config.Server.TLSClientCAFile)
+		if err == nil {
+			_, err = buildClientAuthTLSConfig(clientCAData)
+		}
+		results = append(results, checkResult{Name: "TLS client CA", Detail: config.Server.TLSClientCAFile, Err: err})
+	}
+
+	if config.Server.AuditLog != "" {
+		results = append(results, checkResult{Name: "audit log", Detail: config.Server.AuditLog, Err: checkParentDir(config.Server.AuditLog)})
+	}
+
+	checked := make(map[string]bool)
+	for _, task := range config.Tasks {
+		if task.ArchiveDir == "" || checked[task.ArchiveDir] {
+			continue
+		}
+		checked[task.ArchiveDir] = true
+		results = append(results, checkResult{Name: "archive directory of task '" + task.Name + "'", Detail: task.ArchiveDir, Err: checkDirOrParent(task.ArchiveDir)})
+	}
+
+	return results
+}
+
+// checkHTMLDir resolves the templates directory like on startup and checks that the templates can be loaded
+func checkHTMLDir(configured, override string) (string, error) {
+	htmlDir := configured
+	if override != "" {
+		htmlDir = override
+	}
+	if htmlDir == "" {
+		found, err := findTemplatesDir()
+		if err != nil {
+			return "", err
+		}
+		htmlDir = found
+	}
+	htmlDir, err := filepath.Abs(htmlDir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := NewHTMLCache(htmlDir); err != nil {
+		return htmlDir, err
+	}
+	return htmlDir, nil
+}
+
+// checkTaskDir resolves the task directory like on startup and checks that it exists as a directory
+// or can be created. Ownership and permissions are set and checked on startup.
+func checkTaskDir(configured, override string) (string, error) {
+	taskDir := configured
+	if override != "" {
+		taskDir = override
+	}
+	if taskDir == "" {
+		taskDir, _ = findTaskDir()
+	}
+	taskDir, err := filepath.Abs(taskDir)
+	if err != nil {
+		return "", err
+	}
+	return taskDir, checkDirOrParent(taskDir)
+}
+
+// checkDirOrParent checks that path is a directory, or doesn't exist yet but its parent directory does
+func checkDirOrParent(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return checkParentDir(path)
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s exists but is not a directory", path)
+	}
+	return nil
+}
+
+// checkParentDir checks that the parent directory of path exists
+func checkParentDir(path string) error {
+	parent := filepath.Dir(path)
+	info, err := os.Stat(parent)
+	if err != nil {
+		return fmt.Errorf("parent directory not accessible: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("parent %s is not a directory", parent)
+	}
+	return nil
+}
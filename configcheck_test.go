@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunConfigCheck(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "configcheck-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	htmlDir := filepath.Join(tmpDir, "html")
+	if err := os.Mkdir(htmlDir, 0755); err != nil {
+		t.Fatalf("Failed to create HTML dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(htmlDir, "viewer.html"), []byte("<html></html>"), 0644); err != nil {
+		t.Fatalf("Failed to write viewer.html: %v", err)
+	}
+	taskDir := filepath.Join(tmpDir, "tasks") // Doesn't exist yet, created on startup
+
+	baseConfig := `[server]
+html_dir = "` + htmlDir + `"
+task_dir = "` + taskDir + `"
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{msg}}"
+
+[[tasks.parameters]]
+name = "msg"
+type = "string"
+`
+
+	tests := []struct {
+		name         string
+		config       string
+		overrides    checkOverrides
+		wantCode     int
+		wantContains []string
+	}{
+		{
+			name:         "valid",
+			config:       baseConfig,
+			wantCode:     0,
+			wantContains: []string{"OK   config", "OK   templates directory (" + htmlDir + ")", "OK   task directory (" + taskDir + ")", "Configuration OK"},
+		},
+		{
+			name:         "undeclared placeholder",
+			config:       strings.Replace(baseConfig, "{{msg}}", "{{mgs}}", 1),
+			wantCode:     1,
+			wantContains: []string{"FAIL config", "undeclared parameter '{{mgs}}'", "1 of 1 checks failed"},
+		},
+		{
+			name:         "missing templates directory",
+			config:       baseConfig,
+			overrides:    checkOverrides{HTMLDir: filepath.Join(tmpDir, "missing")},
+			wantCode:     1,
+			wantContains: []string{"FAIL templates directory", "viewer.html", "OK   task directory"},
+		},
+		{
+			name:         "task directory parent missing",
+			config:       baseConfig,
+			overrides:    checkOverrides{TaskDir: filepath.Join(tmpDir, "missing", "tasks")},
+			wantCode:     1,
+			wantContains: []string{"FAIL task directory"},
+		},
+		{
+			name: "missing TLS files",
+			config: strings.Replace(baseConfig, "[auth]", `tls_key_file = "`+filepath.Join(tmpDir, "key.pem")+`"
+tls_cert_file = "`+filepath.Join(tmpDir, "cert.pem")+`"
+
+[auth]`, 1),
+			wantCode:     1,
+			wantContains: []string{"FAIL TLS certificate", "not accessible"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(tmpDir, "config.toml")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			var report bytes.Buffer
+			if code := runConfigCheck(&report, configPath, tt.overrides); code != tt.wantCode {
+				t.Errorf("runConfigCheck() = %d; want %d, report:\n%s", code, tt.wantCode, report.String())
+			}
+			for _, want := range tt.wantContains {
+				if !strings.Contains(report.String(), want) {
+					t.Errorf("runConfigCheck() report = %q; want it to contain %q", report.String(), want)
+				}
+			}
+		})
+	}
+
+	// The check must not create the task directory
+	if _, err := os.Stat(taskDir); !os.IsNotExist(err) {
+		t.Errorf("task directory exists after check (stat error: %v); want it untouched", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ConnectionLimitMiddleware serves at most limit requests concurrently (0 = unlimited) and rejects
+// further requests with 503. WebSocket connections hold their slot for as long as they stream, so
+// a few clients holding many slow connections can't exhaust the server's resources.
+func ConnectionLimitMiddleware(handler http.Handler, limit int) http.Handler {
+	if limit <= 0 {
+		return handler
+	}
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			handler.ServeHTTP(w, r)
+		default:
+			log.Printf("[API] Connection limit reached (%d), rejecting request from %s to %s", limit, r.RemoteAddr, r.URL.Path)
+			w.Header().Set("Retry-After", "1")
+			sendJSONError(w, http.StatusServiceUnavailable, ErrCodeTooManyConnections, fmt.Sprintf("Too many concurrent connections (max %d)", limit))
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConnectionLimitMiddleware(t *testing.T) {
+	const limit = 3
+	release := make(chan struct{})
+	started := make(chan struct{}, limit+1)
+	handler := ConnectionLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}), limit)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// Hold limit connections open in the handler
+	results := make(chan int, limit)
+	for i := 0; i < limit; i++ {
+		go func() {
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}()
+	}
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+
+	// The N+1th concurrent connection is refused
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("http.Get() = %v", err)
+	}
+	var errResp ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("request %d status = %d; want %d", limit+1, resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if errResp.Code != ErrCodeTooManyConnections {
+		t.Errorf("request %d error code = %q; want %q", limit+1, errResp.Code, ErrCodeTooManyConnections)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("rejected request has no Retry-After header")
+	}
+
+	close(release)
+	for i := 0; i < limit; i++ {
+		if status := <-results; status != http.StatusOK {
+			t.Errorf("held request status = %d; want %d", status, http.StatusOK)
+		}
+	}
+
+	// Slots are released once the requests have finished
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("http.Get() = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request after release status = %d; want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestConnectionLimitMiddlewareUnlimited(t *testing.T) {
+	inner := http.NotFoundHandler()
+	if handler := ConnectionLimitMiddleware(inner, 0); handler == nil {
+		t.Fatal("ConnectionLimitMiddleware(handler, 0) = nil; want handler")
+	}
+	rec := httptest.NewRecorder()
+	ConnectionLimitMiddleware(inner, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unlimited status = %d; want %d from the wrapped handler", rec.Code, http.StatusNotFound)
+	}
+}
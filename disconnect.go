@@ -0,0 +1,161 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// defaultDisconnectGrace is the time after the last viewer of a terminate_on_disconnect task has left
+// until the task is terminated, so that a viewer reloading the page can reconnect
+const defaultDisconnectGrace = 10 * time.Second
+
+// defaultUnviewedRetention is how long the output of a run that ended without viewers is kept if
+// output_retention is not set, so a viewer link opened right after a quick run still shows it
+const defaultUnviewedRetention = 60 * time.Second
+
+// addViewer records a WebSocket viewer of a task
+func (tm *TaskManager) addViewer(taskID string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if task, ok := tm.runningTasks[taskID]; ok {
+		task.viewers++
+	}
+}
+
+// removeViewer records that a viewer has left. If it was the last viewer of a running task with
+// terminate_on_disconnect, the task is terminated after the disconnect grace period unless a viewer
+// connects again in the meantime.
+func (tm *TaskManager) removeViewer(taskID string) {
+	tm.mu.Lock()
+	task, ok := tm.runningTasks[taskID]
+	if !ok {
+		tm.mu.Unlock()
+		return
+	}
+	task.viewers--
+	last := task.viewers == 0 && !task.Exited
+	ended := task.viewers == 0 && task.holds == 0 && task.Exited
+	taskName := task.TaskName
+	tm.mu.Unlock()
+
+	if ended {
+		// The last viewer left before its monitor handled the end of the task
+		tm.cleanupUnviewed(taskID)
+	}
+	if !last {
+		return
+	}
+	if taskConfig := findTaskConfig(tm.config.Tasks, taskName); taskConfig == nil || !taskConfig.TerminateOnDisconnect {
+		return
+	}
+	log.Printf("[TASK] Last viewer of task_id=%s disconnected, terminating in %v unless a viewer reconnects", taskID, tm.disconnectGrace)
+	time.AfterFunc(tm.disconnectGrace, func() {
+		tm.terminateUnwatched(taskID)
+	})
+}
+
+// holdTask keeps a task from being cleaned up while an /api/start request with wait=true reads its result
+func (tm *TaskManager) holdTask(taskID string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if task, ok := tm.runningTasks[taskID]; ok {
+		task.holds++
+	}
+}
+
+// releaseTask ends a hold of holdTask, a task that has ended meanwhile is handed to cleanupUnviewed
+func (tm *TaskManager) releaseTask(taskID string) {
+	tm.mu.Lock()
+	task, ok := tm.runningTasks[taskID]
+	if !ok {
+		tm.mu.Unlock()
+		return
+	}
+	task.holds--
+	ended := task.viewers == 0 && task.holds == 0 && task.Exited
+	tm.mu.Unlock()
+
+	if ended {
+		tm.cleanupUnviewed(taskID)
+	}
+}
+
+// cleanupUnviewed schedules the removal of a task whose process has exited while no viewer is
+// connected: after output_retention, or after the unviewed retention if output is not retained.
+// Runs with connected viewers are cleaned up by the viewers' monitor (finishViewer) instead.
+func (tm *TaskManager) cleanupUnviewed(taskID string) {
+	tm.mu.RLock()
+	task, ok := tm.runningTasks[taskID]
+	watched := ok && (task.viewers > 0 || task.holds > 0 || task.State != TaskStateRunning)
+	tm.mu.RUnlock()
+	if !ok || watched {
+		return
+	}
+	if tm.MarkCompleted(taskID) {
+		return
+	}
+
+	tm.mu.Lock()
+	if task.cleanupScheduled {
+		tm.mu.Unlock()
+		return
+	}
+	task.cleanupScheduled = true
+	tm.mu.Unlock()
+
+	log.Printf("[TASK] Task ended without viewers, removing output in %v: task_id=%s", tm.unviewedRetention, taskID)
+	time.AfterFunc(tm.unviewedRetention, func() {
+		// A viewer that connected in the meantime cleans up once it has shown the output
+		tm.mu.RLock()
+		watched := task.viewers > 0
+		tm.mu.RUnlock()
+		if !watched {
+			tm.removeTask(taskID)
+		}
+	})
+}
+
+// terminateUnwatched terminates a task that still has no viewers like a task exceeding its max execution
+// time: the termination signal first, SIGKILL if it is still running after the termination grace period
+func (tm *TaskManager) terminateUnwatched(taskID string) {
+	tm.mu.RLock()
+	task, ok := tm.runningTasks[taskID]
+	if !ok || task.viewers > 0 || task.Exited {
+		tm.mu.RUnlock()
+		return
+	}
+	outputDir := task.OutputDir
+	tm.mu.RUnlock()
+
+	pid := readPID(filepath.Join(outputDir, "pid"))
+	if pid == 0 {
+		return
+	}
+
+	// Check again, a viewer may have connected or the task ended while reading the PID
+	tm.mu.Lock()
+	if task.viewers > 0 || task.Exited || task.Terminated {
+		tm.mu.Unlock()
+		return
+	}
+	task.Terminated = true
+	taskConfig := findTaskConfig(tm.config.Tasks, task.TaskName)
+	sig := taskTerminationSignal(taskConfig)
+	grace := terminationGracePeriod(tm.config.Server, taskConfig)
+	if grace == 0 {
+		// No grace period, kill right away
+		task.Killed = true
+		tm.mu.Unlock()
+
+		log.Printf("[TASK] No viewers left, sending SIGKILL to PID=%d for task_id=%s (no grace period)", pid, taskID)
+		signalTask(pid, syscall.SIGKILL)
+		return
+	}
+	tm.mu.Unlock()
+
+	log.Printf("[TASK] No viewers left, sending %s to PID=%d for task_id=%s", signalName(sig), pid, taskID)
+	signalTask(pid, sig)
+	go killAfterGrace(tm, taskID, pid, grace, nil)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHandleWebSocketTerminateOnDisconnect(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "interactive-task", Command: "sleep 30", TerminateOnDisconnect: true},
+			{Name: "background-task", Command: "sleep 3"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	taskManager.disconnectGrace = 300 * time.Millisecond
+	wsManager := NewWebSocketManager()
+
+	// connect opens a viewer and waits for the connected message, sent once the viewer is counted
+	connect := func(taskID string) func() {
+		conn, cleanup := dialTestWebSocketQuery(t, taskManager, config, wsManager, taskID, "")
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err != nil {
+			cleanup()
+			t.Fatalf("ReadMessage() = %v; want connected message", err)
+		}
+		return cleanup
+	}
+	exitedWithin := func(taskID string, timeout time.Duration) bool {
+		select {
+		case <-taskManager.Exited(taskID):
+			return true
+		case <-time.After(timeout):
+			return false
+		}
+	}
+
+	taskID, err := taskManager.StartTask("interactive-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	// A viewer reconnecting within the grace period keeps the task running
+	disconnect := connect(taskID)
+	disconnect()
+	disconnect = connect(taskID)
+	if exitedWithin(taskID, time.Second) {
+		t.Fatal("task exited although a viewer reconnected within the grace period")
+	}
+
+	// The task is terminated once the last viewer has left
+	disconnect()
+	if !exitedWithin(taskID, 5*time.Second) {
+		t.Fatal("task still running 5s after the last viewer disconnected")
+	}
+	task, err := taskManager.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() = %v", err)
+	}
+	taskManager.mu.RLock()
+	terminated := task.Terminated
+	taskManager.mu.RUnlock()
+	if !terminated {
+		t.Error("task.Terminated = false; want true")
+	}
+
+	// Tasks without terminate_on_disconnect keep running without viewers
+	backgroundID, err := taskManager.StartTask("background-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	connect(backgroundID)()
+	if exitedWithin(backgroundID, time.Second) {
+		t.Error("task without terminate_on_disconnect exited after its viewer disconnected")
+	}
+}
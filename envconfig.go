@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// envOverride is a config value that can be set with an environment variable, e.g. for containers
+// where a secret is easier to inject than a mounted config file
+type envOverride struct {
+	name  string // Environment variable
+	key   string // Overridden config key
+	apply func(config *Config, value string) error
+}
+
+// envOverrides are applied over the config file and under the command line flags (flag > env > file)
+var envOverrides = []envOverride{
+	{"VSTASK_SECRET", "auth.secret", func(config *Config, value string) error {
+		config.Auth.Secret = value
+		return nil
+	}},
+	{"VSTASK_API_HEADER_SECRET", "auth.api_header_secret", func(config *Config, value string) error {
+		config.Auth.APIHeaderSecret = value
+		return nil
+	}},
+	{"VSTASK_PORT", "server.port", func(config *Config, value string) error {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("must be a port number between 1 and 65535")
+		}
+		config.Server.Port = port
+		return nil
+	}},
+	{"VSTASK_BIND_ADDRESS", "server.bind_address", func(config *Config, value string) error {
+		config.Server.BindAddress = value
+		return nil
+	}},
+	{"VSTASK_TASK_DIR", "server.task_dir", func(config *Config, value string) error {
+		config.Server.TaskDir = value
+		return nil
+	}},
+	{"VSTASK_HTML_DIR", "server.html_dir", func(config *Config, value string) error {
+		config.Server.HTMLDir = value
+		return nil
+	}},
+	{"VSTASK_EXEC_USER", "server.exec_user", func(config *Config, value string) error {
+		config.Server.ExecUser = value
+		return nil
+	}},
+}
+
+// applyEnvOverrides sets the config values of all non-empty override variables.
+// Values are not logged, they may be secrets.
+func applyEnvOverrides(config *Config, lookupEnv func(string) (string, bool)) error {
+	for _, override := range envOverrides {
+		value, ok := lookupEnv(override.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := override.apply(config, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", override.name, err)
+		}
+		log.Printf("Using %s from environment variable %s", override.key, override.name)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString(`[server]
+port = 8080
+task_dir = "/var/vsTaskViewer"
+
+[auth]
+secret = "file-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`)
+	tmpFile.Close()
+
+	t.Setenv("VSTASK_SECRET", "env-secret")
+	t.Setenv("VSTASK_PORT", "9090")
+	t.Setenv("VSTASK_TASK_DIR", "/srv/tasks")
+	t.Setenv("VSTASK_EXEC_USER", "")
+
+	config, err := loadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Auth.Secret != "env-secret" {
+		t.Errorf("Auth.Secret = %q; want %q", config.Auth.Secret, "env-secret")
+	}
+	if config.Server.Port != 9090 {
+		t.Errorf("Server.Port = %d; want 9090", config.Server.Port)
+	}
+	if config.Server.TaskDir != "/srv/tasks" {
+		t.Errorf("Server.TaskDir = %q; want %q", config.Server.TaskDir, "/srv/tasks")
+	}
+	// Empty variables don't override
+	if config.Server.ExecUser != "" {
+		t.Errorf("Server.ExecUser = %q; want empty", config.Server.ExecUser)
+	}
+}
+
+func TestLoadConfigEnvSecretWithoutFileSecret(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString(`[[tasks]]
+name = "test-task"
+command = "echo test"
+`)
+	tmpFile.Close()
+
+	if _, err := loadConfig(tmpFile.Name()); err == nil {
+		t.Fatal("loadConfig() without secret = nil; want error")
+	}
+
+	t.Setenv("VSTASK_SECRET", "env-secret")
+	config, err := loadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("loadConfig() with VSTASK_SECRET error = %v", err)
+	}
+	if config.Auth.Secret != "env-secret" {
+		t.Errorf("Auth.Secret = %q; want %q", config.Auth.Secret, "env-secret")
+	}
+}
+
+func TestApplyEnvOverridesInvalidPort(t *testing.T) {
+	for _, value := range []string{"http", "0", "70000"} {
+		env := map[string]string{"VSTASK_PORT": value}
+		lookup := func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		}
+		config := &Config{Server: ServerConfig{Port: 8080}}
+		err := applyEnvOverrides(config, lookup)
+		if err == nil || !strings.Contains(err.Error(), "VSTASK_PORT") {
+			t.Errorf("applyEnvOverrides(VSTASK_PORT=%q) = %v; want error naming VSTASK_PORT", value, err)
+		}
+		if config.Server.Port != 8080 {
+			t.Errorf("Server.Port = %d after invalid VSTASK_PORT; want 8080", config.Server.Port)
+		}
+	}
+}
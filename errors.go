@@ -6,5 +6,22 @@ var (
 	ErrEmptyTaskName   = errors.New("task name cannot be empty")
 	ErrTaskNameTooLong = errors.New("task name too long")
 	ErrInvalidTaskName = errors.New("task name contains invalid characters")
-)
+	ErrLabelTooLong    = errors.New("label too long")
+	ErrInvalidLabel    = errors.New("label contains invalid characters")
+	ErrTaskDisabled    = errors.New("task disabled")
+	ErrTaskNotFound    = errors.New("task not found in configuration")
+	ErrServerDraining  = errors.New("server is shutting down")
+	ErrTaskDirFull     = errors.New("task directory size limit reached")
+	ErrMissingTaskID   = errors.New("task_id is required")
+	ErrInvalidTaskID   = errors.New("invalid task_id format")
+	ErrTaskIDMismatch  = errors.New("task_id does not match the token's task_id claim")
 
+	ErrParameterValidation = errors.New("parameter validation failed")
+	ErrCommandNotAllowed   = errors.New("command not in server.allowed_commands")
+	ErrViewerTokenUsed     = errors.New("single-use viewer token already used")
+	ErrViewerTokenNoID     = errors.New("single-use viewer token has no jti claim")
+	ErrViewerTokenNoExpiry = errors.New("single-use viewer token has no exp claim")
+	ErrViewerTokensFull    = errors.New("too many unexpired single-use viewer tokens")
+	ErrPreCheckFailed      = errors.New("pre_check failed")
+	ErrUnsafeHeader        = errors.New("forwarded header contains unsafe characters")
+)
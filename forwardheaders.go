@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// forwardedHeaderPrefix is prepended to the environment variable of a forwarded request header
+const forwardedHeaderPrefix = "HEADER_"
+
+// forwardHeaderNameRegex matches header names allowed in forward_headers
+var forwardHeaderNameRegex = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// forwardedHeaderEnvName returns the environment variable holding a forwarded header,
+// e.g. "X-Trace-Id" becomes "HEADER_X_TRACE_ID"
+func forwardedHeaderEnvName(header string) string {
+	return forwardedHeaderPrefix + strings.ToUpper(strings.ReplaceAll(header, "-", "_"))
+}
+
+// validateForwardHeaders checks the forward_headers of a task for valid and unique header names
+func validateForwardHeaders(headers []string) error {
+	seen := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		if !forwardHeaderNameRegex.MatchString(header) {
+			return fmt.Errorf("invalid header name '%s'", header)
+		}
+		envName := forwardedHeaderEnvName(header)
+		if seen[envName] {
+			return fmt.Errorf("duplicate header '%s'", header)
+		}
+		seen[envName] = true
+	}
+	return nil
+}
+
+// forwardedHeaderEnv returns NAME=value environment entries for the configured headers present in
+// the request. Values must be valid UTF-8 without control characters, so they can't smuggle
+// line breaks or NUL bytes into the task's environment.
+func forwardedHeaderEnv(forward []string, header http.Header) ([]string, error) {
+	var env []string
+	for _, name := range forward {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if !isSafeHeaderValue(value) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsafeHeader, name)
+		}
+		env = append(env, forwardedHeaderEnvName(name)+"="+value)
+	}
+	return env, nil
+}
+
+// isSafeHeaderValue reports whether a header value is valid UTF-8 without control characters
+func isSafeHeaderValue(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestForwardedHeaderEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		forward []string
+		headers map[string][]string
+		want    []string
+		wantErr bool
+	}{
+		{name: "forwarded header", forward: []string{"X-Trace-Id"}, headers: map[string][]string{"X-Trace-Id": {"abc-123"}}, want: []string{"HEADER_X_TRACE_ID=abc-123"}},
+		{name: "missing header", forward: []string{"X-Trace-Id"}, headers: map[string][]string{}, want: nil},
+		{name: "not configured", forward: nil, headers: map[string][]string{"X-Trace-Id": {"abc"}}, want: nil},
+		{name: "multiple values", forward: []string{"X-Tag"}, headers: map[string][]string{"X-Tag": {"a", "b"}}, want: []string{"HEADER_X_TAG=a, b"}},
+		{name: "UTF-8 value", forward: []string{"X-User"}, headers: map[string][]string{"X-User": {"Jürgen"}}, want: []string{"HEADER_X_USER=Jürgen"}},
+		{name: "newline", forward: []string{"X-Trace-Id"}, headers: map[string][]string{"X-Trace-Id": {"abc\nFOO=bar"}}, wantErr: true},
+		{name: "NUL byte", forward: []string{"X-Trace-Id"}, headers: map[string][]string{"X-Trace-Id": {"abc\x00"}}, wantErr: true},
+		{name: "invalid UTF-8", forward: []string{"X-Trace-Id"}, headers: map[string][]string{"X-Trace-Id": {"abc\xff"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for name, values := range tt.headers {
+				for _, value := range values {
+					header.Add(name, value)
+				}
+			}
+			got, err := forwardedHeaderEnv(tt.forward, header)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsafeHeader) {
+					t.Errorf("forwardedHeaderEnv() error = %v; want %v", err, ErrUnsafeHeader)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("forwardedHeaderEnv() error = %v; want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forwardedHeaderEnv() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateForwardHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []string
+		wantErr bool
+	}{
+		{name: "valid", headers: []string{"X-Trace-Id", "X-Request-Id"}},
+		{name: "empty list", headers: nil},
+		{name: "empty name", headers: []string{""}, wantErr: true},
+		{name: "invalid character", headers: []string{"X_Trace"}, wantErr: true},
+		{name: "duplicate", headers: []string{"X-Trace-Id", "x-trace-id"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateForwardHeaders(tt.headers)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateForwardHeaders() error = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
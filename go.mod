@@ -7,6 +7,10 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.1
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.17.0
+	golang.org/x/net v0.17.0
+	golang.org/x/time v0.5.0
 )
 
-require golang.org/x/net v0.17.0 // indirect
+require golang.org/x/text v0.14.0 // indirect
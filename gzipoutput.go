@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// gzipSuffix is appended to the names of output files of tasks with compress_output
+const gzipSuffix = ".gz"
+
+// tailPollInterval is how often a tailer checks a file for new content at its end
+const tailPollInterval = 200 * time.Millisecond
+
+// outputFileName returns the name of an output file ("stdout" or "stderr") in the task's output directory
+func outputFileName(stream string, compressed bool) string {
+	if compressed {
+		return stream + gzipSuffix
+	}
+	return stream
+}
+
+// existingOutputFile returns the path of an output file in outputDir, the compressed one if it exists
+func existingOutputFile(outputDir, stream string) string {
+	compressed := filepath.Join(outputDir, outputFileName(stream, true))
+	if _, err := os.Stat(compressed); err == nil {
+		return compressed
+	}
+	return filepath.Join(outputDir, stream)
+}
+
+// followReader reads a file that is still being written. At the end of the file it calls idle
+// and waits for more data instead of returning io.EOF, until the context is cancelled.
+type followReader struct {
+	ctx  context.Context
+	file *os.File
+	idle func() error // Called each time the reader has caught up with the writer (may be nil)
+}
+
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.file.Read(p)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		if fr.idle != nil {
+			if err := fr.idle(); err != nil {
+				return 0, err
+			}
+		}
+		select {
+		case <-fr.ctx.Done():
+			return 0, fr.ctx.Err()
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
+
+// tailFileGzip tails a gzip-compressed output file while it is being written and sends the
+// decompressed lines over WebSocket, like tailFile. gzip writes compressed blocks, so output
+// arrives in bursts rather than line by line.
+// With tailLines > 0, only the last tailLines lines of the output written so far are replayed.
+// The number of decompressed bytes consumed (including skipped ones) is stored in streamed.
+func tailFileGzip(ctx context.Context, safeConn *safeConn, handles *tailHandleGuard, wait fileWait, filePath, outputType, taskID string, streamed *atomic.Int64, coalesce time.Duration, tailLines int, progressPrefix, stepMarker string) {
+	log.Printf("[TAIL] Starting to tail compressed file: %s (type=%s, task_id=%s)", filePath, outputType, taskID)
+	if !waitForOutputFile(ctx, safeConn, wait, filePath, outputType) {
+		return
+	}
+
+	// Compressed output can't be scanned backwards, count the lines written so far to know how many to skip
+	var skipLines int
+	if tailLines > 0 {
+		skipLines = max(0, countGzipLines(filePath)-tailLines)
+	}
+
+	file, ok := openTailFile(handles, safeConn, filePath)
+	if !ok {
+		return
+	}
+	defer handles.Close(file)
+
+	batcher := &lineBatcher{ctx: ctx, safeConn: safeConn, outputType: outputType, window: coalesce, progressPrefix: progressPrefix, stepMarker: stepMarker}
+	gz, err := gzip.NewReader(&followReader{ctx: ctx, file: file, idle: batcher.flush})
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[TAIL] Failed to read gzip header: %s, error: %v", filePath, err)
+		}
+		return
+	}
+	defer gz.Close()
+	// gzip writes a single member, don't wait for another one after its end
+	gz.Multistream(false)
+
+	reader := bufio.NewReader(gz)
+	var total int64
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 && (err == nil || err == bufio.ErrBufferFull || err == io.EOF) {
+			if skipLines > 0 {
+				// Skipped for the tail replay, a line ends at a newline or the end of the stream
+				if err != bufio.ErrBufferFull {
+					skipLines--
+				}
+			} else {
+				line := string(chunk)
+				if !strings.HasSuffix(line, "\n") && err != bufio.ErrBufferFull {
+					line += "\n" // Last line without newline, as sent by tailFile
+				}
+				if err := batcher.add(line); err != nil {
+					return
+				}
+			}
+			total += int64(len(chunk))
+			streamed.Store(total)
+		}
+		if err == nil || err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF {
+			// End of the compressed stream, the task has finished writing
+			batcher.flush()
+		} else if ctx.Err() == nil {
+			log.Printf("[TAIL] Failed to decompress file: %s, error: %v", filePath, err)
+		}
+		return
+	}
+}
+
+// countGzipLines returns the number of lines in the part of a gzip file that can be decompressed
+// right now. A final line without newline counts as a line.
+func countGzipLines(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0
+	}
+	defer gz.Close()
+
+	lines := 0
+	partial := false
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := gz.Read(buf)
+		if n > 0 {
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			partial = buf[n-1] != '\n'
+		}
+		if err != nil {
+			break
+		}
+	}
+	if partial {
+		lines++
+	}
+	return lines
+}
+
+// gzipUncompressedSize returns the uncompressed size (modulo 2^32) recorded in the trailer of a
+// complete gzip file. For a file still being written the value is meaningless.
+func gzipUncompressedSize(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var trailer [4]byte
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < int64(len(trailer)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if _, err := file.ReadAt(trailer[:], info.Size()-int64(len(trailer))); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(trailer[:]), nil
+}
+
+// readOutputTail returns at most the last maxBytes of an output stream, decompressing it if compressed
+func readOutputTail(outputDir, stream string, maxBytes int64) (string, bool) {
+	path := existingOutputFile(outputDir, stream)
+	if strings.HasSuffix(path, gzipSuffix) {
+		return readGzipFileTail(path, maxBytes)
+	}
+	return readFileTail(path, maxBytes)
+}
+
+// readGzipFileTail decompresses a gzip output file and returns at most the last maxBytes of it.
+// Reports whether the content was truncated. A partially written file yields what could be decompressed.
+func readGzipFileTail(path string, maxBytes int64) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return "", false
+	}
+	defer gz.Close()
+
+	// Keep a window of up to 2*maxBytes, so memory stays bounded for large outputs
+	var window []byte
+	truncated := false
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := gz.Read(buf)
+		window = append(window, buf[:n]...)
+		if int64(len(window)) > 2*maxBytes {
+			window = append(window[:0], window[int64(len(window))-maxBytes:]...)
+			truncated = true
+		}
+		if err != nil {
+			break
+		}
+	}
+	if int64(len(window)) > maxBytes {
+		window = window[int64(len(window))-maxBytes:]
+		truncated = true
+	}
+	return string(window), truncated
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seqOutput returns the output of `seq 1 n`
+func seqOutput(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&sb, "%d\n", i)
+	}
+	return sb.String()
+}
+
+func TestTaskManagerCompressOutput(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gzip-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			{Name: "compressed-task", Command: "seq 1 2000; echo oops >&2; exit 4", CompressOutput: true},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	taskID, err := tm.StartTask("compressed-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+	exitCode, ok := tm.WaitExitCode(taskID, 5*time.Second)
+	if !ok {
+		t.Fatal("TaskManager.WaitExitCode() ok = false; want task to finish")
+	}
+	if exitCode != 4 {
+		t.Errorf("exit code = %d; want 4 (exit code of the command, not of gzip)", exitCode)
+	}
+
+	outputDir := filepath.Join(tmpDir, taskID)
+	files := map[string]string{"stdout.gz": seqOutput(2000), "stderr.gz": "oops\n"}
+	for name, want := range files {
+		data, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+			t.Errorf("%s does not start with the gzip magic bytes", name)
+			continue
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("gzip.NewReader(%s) = %v", name, err)
+			continue
+		}
+		got, err := io.ReadAll(gz)
+		if err != nil {
+			t.Errorf("decompressing %s: %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("decompressed %s = %d bytes; want %d bytes", name, len(got), len(want))
+		}
+		if name == "stdout.gz" && len(data) >= len(want) {
+			t.Errorf("%s = %d bytes; want smaller than the %d uncompressed bytes", name, len(data), len(want))
+		}
+	}
+
+	for _, name := range []string{"stdout", "stderr", "stdout.pipe", "stderr.pipe"} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s exists in output directory of compressed task (err = %v)", name, err)
+		}
+	}
+
+	if size, err := gzipUncompressedSize(filepath.Join(outputDir, "stdout.gz")); err != nil || int(size) != len(seqOutput(2000)) {
+		t.Errorf("gzipUncompressedSize() = %d, %v; want %d", size, err, len(seqOutput(2000)))
+	}
+	if got, truncated := readOutputTail(outputDir, "stdout", 10); got != "1999\n2000\n" || !truncated {
+		t.Errorf("readOutputTail() = %q, %v; want %q, true", got, truncated, "1999\n2000\n")
+	}
+	if got, truncated := readOutputTail(outputDir, "stderr", 10); got != "oops\n" || truncated {
+		t.Errorf("readOutputTail() = %q, %v; want %q, false", got, truncated, "oops\n")
+	}
+}
+
+func TestBuildWrapperScriptCompressOutput(t *testing.T) {
+	outputDir := "/var/vsTaskViewer/550e8400-e29b-41d4-a716-446655440000"
+
+	script := buildWrapperScript(wrapperOptions{Command: "echo hello", OutputDir: outputDir})
+	if strings.Contains(script, "gzip") {
+		t.Errorf("buildWrapperScript() without compress_output = %q; want no gzip", script)
+	}
+
+	script = buildWrapperScript(wrapperOptions{Command: "echo hello", OutputDir: outputDir, CompressOutput: true})
+	for _, want := range []string{
+		"gzip -c < " + outputDir + "/stdout.pipe > " + outputDir + "/stdout.gz",
+		"gzip -c < " + outputDir + "/stderr.pipe > " + outputDir + "/stderr.gz",
+		"exec > " + outputDir + "/stdout.pipe 2> " + outputDir + "/stderr.pipe",
+		"wait\nexit $EXIT_CODE",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("buildWrapperScript() with compress_output = %q; want %q", script, want)
+		}
+	}
+}
+
+func TestHandleWebSocketCompressedOutput(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "gzip-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			// Output is written before and after the viewer connects
+			{Name: "compressed-task", Command: "seq 1 1000; sleep 0.5; seq 1001 3000; printf tail >&2", CompressOutput: true},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("compressed-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	received := map[string]*strings.Builder{"stdout": {}, "stderr": {}}
+	var stdoutBytes, stderrBytes int64
+	for {
+		var msg struct {
+			Type        string `json:"type"`
+			Data        string `json:"data"`
+			StdoutBytes *int64 `json:"stdout_bytes"`
+			StderrBytes *int64 `json:"stderr_bytes"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() = %v before completion message", err)
+		}
+		if msg.Type == "stdout" || msg.Type == "stderr" {
+			received[msg.Type].WriteString(msg.Data)
+			continue
+		}
+		if msg.Type == "system" && msg.StdoutBytes != nil {
+			stdoutBytes, stderrBytes = *msg.StdoutBytes, *msg.StderrBytes
+			break
+		}
+	}
+
+	want := seqOutput(3000)
+	if got := received["stdout"].String(); got != want {
+		t.Errorf("stdout = %d bytes; want %d bytes of seq output in order", len(got), len(want))
+	}
+	if got := received["stderr"].String(); got != "tail\n" {
+		t.Errorf("stderr = %q; want %q", got, "tail\n")
+	}
+	if stdoutBytes != int64(len(want)) || stderrBytes != 4 {
+		t.Errorf("completion stdout_bytes/stderr_bytes = %d/%d; want %d/4 (uncompressed)", stdoutBytes, stderrBytes, len(want))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// headerRewriter wraps a ResponseWriter and applies the configured header changes
+// right before the response headers are sent
+type headerRewriter struct {
+	http.ResponseWriter
+	add         map[string]string
+	strip       []string
+	wroteHeader bool
+}
+
+// apply adds and strips the configured headers
+func (hr *headerRewriter) apply() {
+	header := hr.ResponseWriter.Header()
+	for name, value := range hr.add {
+		header.Set(name, value)
+	}
+	for _, name := range hr.strip {
+		header.Del(name)
+	}
+}
+
+func (hr *headerRewriter) WriteHeader(statusCode int) {
+	if !hr.wroteHeader {
+		hr.apply()
+		hr.wroteHeader = true
+	}
+	hr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (hr *headerRewriter) Write(data []byte) (int, error) {
+	if !hr.wroteHeader {
+		hr.WriteHeader(http.StatusOK)
+	}
+	return hr.ResponseWriter.Write(data)
+}
+
+// Flush forwards to the underlying writer if it supports flushing
+func (hr *headerRewriter) Flush() {
+	if !hr.wroteHeader {
+		hr.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := hr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController reaches its deadlines
+func (hr *headerRewriter) Unwrap() http.ResponseWriter {
+	return hr.ResponseWriter
+}
+
+// Hijack forwards to the underlying writer (required for WebSocket upgrades)
+func (hr *headerRewriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := hr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// ResponseHeadersMiddleware adds and strips the configured response headers on all responses
+func ResponseHeadersMiddleware(handler http.Handler, add map[string]string, strip []string) http.Handler {
+	if len(add) == 0 && len(strip) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hr := &headerRewriter{ResponseWriter: w, add: add, strip: strip}
+		handler.ServeHTTP(hr, r)
+		// Handlers that write no body never trigger WriteHeader, apply changes before net/http sends headers
+		if !hr.wroteHeader {
+			hr.apply()
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseHeadersMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "test")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	add := map[string]string{"X-Served-By": "vsTaskViewer"}
+	strip := []string{"X-Powered-By"}
+
+	w := httptest.NewRecorder()
+	ResponseHeadersMiddleware(handler, add, strip).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	if got := w.Header().Get("X-Served-By"); got != "vsTaskViewer" {
+		t.Errorf("X-Served-By = %q; want %q", got, "vsTaskViewer")
+	}
+	if got := w.Header().Get("X-Powered-By"); got != "" {
+		t.Errorf("X-Powered-By = %q; want header to be stripped", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type = %q; want unrelated headers untouched", got)
+	}
+	if w.Body.String() != "OK" {
+		t.Errorf("body = %q; want %q", w.Body.String(), "OK")
+	}
+}
+
+func TestResponseHeadersMiddlewareWithoutBody(t *testing.T) {
+	// Handler that only sets headers and never writes triggers the post-handler path
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Powered-By", "test")
+	})
+
+	w := httptest.NewRecorder()
+	ResponseHeadersMiddleware(handler, map[string]string{"X-Served-By": "vsTaskViewer"}, []string{"X-Powered-By"}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := w.Header().Get("X-Served-By"); got != "vsTaskViewer" {
+		t.Errorf("X-Served-By = %q; want %q", got, "vsTaskViewer")
+	}
+	if got := w.Header().Get("X-Powered-By"); got != "" {
+		t.Errorf("X-Powered-By = %q; want header to be stripped", got)
+	}
+}
+
+func TestResponseHeadersMiddlewareDisabled(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	wrapped := ResponseHeadersMiddleware(handler, nil, nil)
+
+	w := httptest.NewRecorder()
+	wrapped.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if len(w.Header()) != 0 {
+		t.Errorf("headers = %v; want none", w.Header())
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
 // HTMLCache holds HTML files in memory
 type HTMLCache struct {
 	viewerHTML string
+	indexHTML  []byte         // Optional landing page served at / (nil = not present)
 	errorPages map[int][]byte // status code -> HTML content
 	mu         sync.RWMutex
 }
@@ -31,6 +34,14 @@ func NewHTMLCache(htmlDir string) (*HTMLCache, error) {
 	cache.viewerHTML = string(data)
 	log.Printf("[HTML] Loaded viewer.html from %s", htmlDir)
 
+	// Load the optional landing page
+	if data, err := os.ReadFile(filepath.Join(htmlDir, "index.html")); err == nil {
+		cache.indexHTML = data
+		log.Printf("[HTML] Loaded index.html from %s", htmlDir)
+	} else if !os.IsNotExist(err) {
+		log.Printf("[HTML] Warning: failed to read index.html: %v (/ will return 404)", err)
+	}
+
 	// Load error pages
 	errorCodes := []int{400, 401, 404, 405, 500}
 	for _, code := range errorCodes {
@@ -54,6 +65,13 @@ func (c *HTMLCache) GetViewerHTML() string {
 	return c.viewerHTML
 }
 
+// GetIndexHTML returns the landing page, or nil if html_dir has no index.html
+func (c *HTMLCache) GetIndexHTML() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.indexHTML
+}
+
 // GetErrorPage returns the error page HTML for the given status code
 func (c *HTMLCache) GetErrorPage(statusCode int) []byte {
 	c.mu.RLock()
@@ -87,3 +105,48 @@ func loadViewerHTML(htmlCache *HTMLCache) (string, error) {
 	return html, nil
 }
 
+// validateRootRedirect checks server.root_redirect: an absolute http(s) URL or a path on this
+// server other than / itself
+func validateRootRedirect(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(u.Path, "/") || u.Path == "/" {
+			return fmt.Errorf("must be an absolute http or https URL or a path other than /")
+		}
+		return nil
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL or a path other than /")
+	}
+	return nil
+}
+
+// handleRoot answers requests to / (and to unknown paths, which the mux routes here): / redirects
+// to server.root_redirect if configured, otherwise it serves index.html from the HTML cache.
+// Everything else gets the 404 error page instead of the mux's plain text response.
+func handleRoot(w http.ResponseWriter, r *http.Request, config *Config, htmlCache *HTMLCache) {
+	if r.URL.Path != "/" {
+		serveErrorHTML(w, http.StatusNotFound, htmlCache)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		serveErrorHTML(w, http.StatusMethodNotAllowed, htmlCache)
+		return
+	}
+
+	if config.Server.RootRedirect != "" {
+		http.Redirect(w, r, config.Server.RootRedirect, http.StatusFound)
+		return
+	}
+	index := htmlCache.GetIndexHTML()
+	if index == nil {
+		serveErrorHTML(w, http.StatusNotFound, htmlCache)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(index)
+}
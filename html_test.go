@@ -241,3 +241,85 @@ func TestServeErrorHTMLFallback(t *testing.T) {
 }
 
 
+
+func TestHandleRoot(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "html-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	indexHTML := `<html><body>Task Viewer</body></html>`
+	errorHTML := `<html><body>404 Not Found</body></html>`
+	files := map[string]string{"viewer.html": `<html><body>Viewer</body></html>`, "404.html": errorHTML}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	withoutIndex, err := NewHTMLCache(tmpDir)
+	if err != nil {
+		t.Fatalf("NewHTMLCache() = %v; want nil", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "index.html"), []byte(indexHTML), 0644); err != nil {
+		t.Fatalf("Failed to create index.html: %v", err)
+	}
+	withIndex, err := NewHTMLCache(tmpDir)
+	if err != nil {
+		t.Fatalf("NewHTMLCache() = %v; want nil", err)
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		redirect     string
+		cache        *HTMLCache
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+	}{
+		{"served index", "/", "", withIndex, http.StatusOK, indexHTML, ""},
+		{"redirect", "/", "https://portal.example.com/tasks", withIndex, http.StatusFound, "", "https://portal.example.com/tasks"},
+		{"redirect to path", "/", "/status", withoutIndex, http.StatusFound, "", "/status"},
+		{"neither configured", "/", "", withoutIndex, http.StatusNotFound, errorHTML, ""},
+		{"unknown path", "/favicon.ico", "", withIndex, http.StatusNotFound, errorHTML, ""},
+		{"unknown path with redirect", "/admin", "https://portal.example.com/tasks", withIndex, http.StatusNotFound, errorHTML, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Server: ServerConfig{RootRedirect: tt.redirect}}
+			w := httptest.NewRecorder()
+			handleRoot(w, httptest.NewRequest(http.MethodGet, tt.path, nil), config, tt.cache)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleRoot() status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("handleRoot() body = %q; want %q", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("handleRoot() Location = %q; want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestValidateRootRedirect(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{"https://portal.example.com/tasks", false},
+		{"http://portal.example.com", false},
+		{"/status", false},
+		{"/", true},
+		{"status", true},
+		{"ftp://portal.example.com", true},
+		{"https://", true},
+	}
+	for _, tt := range tests {
+		if err := validateRootRedirect(tt.target); (err != nil) != tt.wantErr {
+			t.Errorf("validateRootRedirect(%q) = %v; wantErr %v", tt.target, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// heartbeatFileName is the file in the output directory touched periodically by the wrapper of
+// tasks with stall_timeout. The heartbeat runs beside the command, its mtime only tells whether
+// the wrapper still gets scheduled (not stopped, killed or starved), not whether the command
+// makes progress: a command that hangs while the wrapper runs keeps the heartbeat going.
+const heartbeatFileName = "heartbeat"
+
+// startedFileName is the file in the output directory created by the wrapper right before it runs the
+// task command; a wrapper that hasn't got that far within the startup timeout has failed to start
+const startedFileName = "started"
+
+// wrapperHeartbeatInterval returns the interval in seconds at which the wrapper touches the heartbeat
+// file, a third of the stall timeout so a single delayed beat doesn't count as stall (at least 1s)
+func wrapperHeartbeatInterval(stallTimeout time.Duration) int {
+	return max(1, int(stallTimeout/time.Second)/3)
+}
+
+// taskStallTimeout returns the stall timeout of a task (0 = stall detection disabled)
+func taskStallTimeout(taskConfig *TaskConfig) time.Duration {
+	if taskConfig == nil {
+		return 0
+	}
+	return time.Duration(taskConfig.StallTimeout) * time.Second
+}
+
+// taskHeartbeatEvery returns the interval in seconds for the wrapper heartbeat of a task (0 = no heartbeat)
+func taskHeartbeatEvery(taskConfig *TaskConfig) int {
+	stallTimeout := taskStallTimeout(taskConfig)
+	if stallTimeout <= 0 {
+		return 0
+	}
+	return wrapperHeartbeatInterval(stallTimeout)
+}
+
+// lastHeartbeat returns the mtime of the heartbeat file, or fallback if it hasn't been written yet
+func lastHeartbeat(path string, fallback time.Time) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fallback
+	}
+	return info.ModTime()
+}
+
+// stallDetector tracks the heartbeats of a running task. A task is stalled once no heartbeat
+// has been seen for longer than the threshold; it is reported once per stall.
+type stallDetector struct {
+	threshold time.Duration
+	stalled   bool
+}
+
+// update checks the time of the last heartbeat against the threshold. Returns true only when the task
+// has just become stalled, and resets once heartbeats resume so a later stall is reported again.
+func (sd *stallDetector) update(lastBeat, now time.Time) bool {
+	if sd.threshold <= 0 {
+		return false
+	}
+	if now.Sub(lastBeat) <= sd.threshold {
+		sd.stalled = false
+		return false
+	}
+	if sd.stalled {
+		return false
+	}
+	sd.stalled = true
+	return true
+}
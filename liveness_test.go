@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrapperHeartbeatInterval(t *testing.T) {
+	tests := []struct {
+		stallTimeout time.Duration
+		want         int
+	}{
+		{1 * time.Second, 1},
+		{2 * time.Second, 1},
+		{30 * time.Second, 10},
+		{100 * time.Second, 33},
+	}
+	for _, tt := range tests {
+		if got := wrapperHeartbeatInterval(tt.stallTimeout); got != tt.want {
+			t.Errorf("wrapperHeartbeatInterval(%v) = %d; want %d", tt.stallTimeout, got, tt.want)
+		}
+	}
+}
+
+func TestStallDetector(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	// Each step checks the detector at now with the given last heartbeat
+	type step struct {
+		lastBeat, now int
+		want          bool
+	}
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		steps     []step
+	}{
+		{"heartbeats in time", 10 * time.Second, []step{{0, 5, false}, {5, 10, false}, {10, 20, false}}},
+		{"exactly at threshold is not stalled", 10 * time.Second, []step{{0, 10, false}}},
+		{"stall reported once", 10 * time.Second, []step{{0, 11, true}, {0, 12, false}, {0, 60, false}}},
+		{"stall reported again after resuming", 10 * time.Second, []step{{0, 11, true}, {12, 13, false}, {12, 23, true}}},
+		{"disabled", 0, []step{{0, 3600, false}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sd := &stallDetector{threshold: tt.threshold}
+			for i, s := range tt.steps {
+				if got := sd.update(at(s.lastBeat), at(s.now)); got != s.want {
+					t.Errorf("step %d: stallDetector.update(%ds, %ds) = %v; want %v", i, s.lastBeat, s.now, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLastHeartbeat(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "liveness-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, heartbeatFileName)
+	fallback := time.Now().Add(-time.Hour)
+	if got := lastHeartbeat(path, fallback); !got.Equal(fallback) {
+		t.Errorf("lastHeartbeat() without file = %v; want fallback %v", got, fallback)
+	}
+
+	beat := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("Failed to write heartbeat file: %v", err)
+	}
+	if err := os.Chtimes(path, beat, beat); err != nil {
+		t.Fatalf("Failed to set heartbeat mtime: %v", err)
+	}
+	if got := lastHeartbeat(path, fallback); !got.Equal(beat) {
+		t.Errorf("lastHeartbeat() = %v; want mtime %v", got, beat)
+	}
+}
+
+func TestBuildWrapperScriptHeartbeat(t *testing.T) {
+	outputDir := "/var/vsTaskViewer/550e8400-e29b-41d4-a716-446655440000"
+
+	script := buildWrapperScript(wrapperOptions{Command: "echo hello", OutputDir: outputDir})
+	if strings.Contains(script, heartbeatFileName) {
+		t.Errorf("buildWrapperScript() without stall_timeout = %q; want no heartbeat", script)
+	}
+
+	script = buildWrapperScript(wrapperOptions{Command: "echo hello", OutputDir: outputDir, HeartbeatEvery: 5})
+	loop := "touch " + outputDir + "/heartbeat; sleep 5"
+	if !strings.Contains(script, loop) {
+		t.Errorf("buildWrapperScript() with heartbeat = %q; want %q", script, loop)
+	}
+	if strings.Index(script, loop) > strings.Index(script, "bash -c") {
+		t.Error("buildWrapperScript() starts the heartbeat after running the command")
+	}
+	if strings.Index(script, "kill $HEARTBEAT_PID") < strings.Index(script, "EXIT_CODE=$?") {
+		t.Error("buildWrapperScript() doesn't stop the heartbeat after the command")
+	}
+}
+
+func TestTaskManagerStartTaskHeartbeat(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "liveness-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			{Name: "beating-task", Command: "sleep 2.5", StallTimeout: 1},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	taskID, err := tm.StartTask("beating-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+	path := filepath.Join(tmpDir, taskID, heartbeatFileName)
+
+	// The heartbeat is touched every second while the command runs
+	time.Sleep(500 * time.Millisecond)
+	first := lastHeartbeat(path, time.Time{})
+	if first.IsZero() {
+		t.Fatal("heartbeat file not written after task start")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if second := lastHeartbeat(path, time.Time{}); !second.After(first) {
+		t.Errorf("heartbeat mtime = %v after 1.5s; want later than %v", second, first)
+	}
+
+	if _, ok := tm.WaitExitCode(taskID, 5*time.Second); !ok {
+		t.Fatal("TaskManager.WaitExitCode() ok = false; want task to finish")
+	}
+
+	// No heartbeat after the command has ended
+	time.Sleep(100 * time.Millisecond)
+	stopped := lastHeartbeat(path, time.Time{})
+	time.Sleep(1500 * time.Millisecond)
+	if got := lastHeartbeat(path, time.Time{}); !got.Equal(stopped) {
+		t.Errorf("heartbeat mtime changed after the task finished (%v -> %v)", stopped, got)
+	}
+}
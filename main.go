@@ -2,19 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 var (
@@ -23,7 +29,9 @@ var (
 	taskDirFlag       = flag.String("d", "", "Path to task output directory (optional)")
 	execUserFlag      = flag.String("u", "", "User to run as (optional)")
 	port              = flag.Int("p", 8080, "Port to listen on")
+	bindAddressFlag   = flag.String("b", "", "Address to bind to (optional)")
 	showHelp          = flag.Bool("h", false, "Show help message")
+	checkConfigFlag   = flag.Bool("check", false, "Validate the configuration and exit")
 )
 
 const usage = `vsTaskViewer - Task execution viewer with WebSocket support
@@ -47,18 +55,37 @@ Options:
   -d string    Path to task output directory (optional)
                Search order:
                  1. Path specified with -d flag
-                 2. task_dir from config file
-                 3. /var/vsTaskViewer
+                 2. VSTASK_TASK_DIR environment variable
+                 3. task_dir from config file
+                 4. /var/vsTaskViewer
 
   -u string    User to run as (optional)
                Search order:
                  1. User specified with -u flag
-                 2. exec_user from config file
-                 3. www-data
+                 2. VSTASK_EXEC_USER environment variable
+                 3. exec_user from config file
+                 4. www-data
 
-  -p int       Port to listen on (default: 8080, can be overridden in config)
+  -p int       Port to listen on
+               Search order:
+                 1. Port specified with -p flag
+                 2. VSTASK_PORT environment variable
+                 3. port from config file
+                 4. 8080
+  -b string    Address to bind to (optional)
+               Search order:
+                 1. Address specified with -b flag
+                 2. VSTASK_BIND_ADDRESS environment variable
+                 3. bind_address from config file
+                 4. All interfaces
+  -check       Validate the configuration (config values, templates, task directory,
+               TLS files, ...) and exit with 0 if it is valid, without starting the server
   -h           Show this help message
 
+Environment variables (override the config file, flags override both):
+  VSTASK_SECRET, VSTASK_API_HEADER_SECRET, VSTASK_PORT, VSTASK_BIND_ADDRESS,
+  VSTASK_TASK_DIR, VSTASK_HTML_DIR, VSTASK_EXEC_USER
+
 Examples:
   vsTaskViewer
   vsTaskViewer -c /path/to/config.toml
@@ -66,8 +93,21 @@ Examples:
   vsTaskViewer -c /path/to/config.toml -d /var/vsTaskViewer
   vsTaskViewer -c /path/to/config.toml -u www-data
   vsTaskViewer -p 9090
+  vsTaskViewer -b 127.0.0.1 -p 9090
+  vsTaskViewer -check -c /path/to/config.toml
 `
 
+// isFlagSet reports whether a command line flag was given explicitly
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
 	flag.Parse()
 
@@ -84,6 +124,15 @@ func main() {
 	}
 	log.Printf("Using config file: %s", configPath)
 
+	// Only validate the configuration, without binding ports or dropping privileges
+	if *checkConfigFlag {
+		os.Exit(runConfigCheck(os.Stdout, configPath, checkOverrides{
+			HTMLDir:  *templatesPathFlag,
+			TaskDir:  *taskDirFlag,
+			ExecUser: *execUserFlag,
+		}))
+	}
+
 	// Load configuration
 	config, err := loadConfig(configPath)
 	if err != nil {
@@ -162,32 +211,58 @@ func main() {
 		log.Printf("Using exec user from config: %s", config.Server.ExecUser)
 	}
 
-	// Override port from config if specified
-	if config.Server.Port > 0 {
+	// Use the port from the config file or VSTASK_PORT unless -p is given
+	if config.Server.Port > 0 && !isFlagSet("p") {
 		*port = config.Server.Port
 	}
 
-	// Load TLS files early (before dropping privileges, as they may require elevated rights)
-	var tlsKeyData, tlsCertData []byte
+	// Override bind address if -b flag is set
+	if *bindAddressFlag != "" {
+		config.Server.BindAddress = *bindAddressFlag
+		log.Printf("Using bind address from -b flag: %s", config.Server.BindAddress)
+	}
+	listenAddr := listenAddress(config.Server.BindAddress, *port)
+
+	// Load TLS certificate early (before dropping privileges, as the files may require elevated rights).
+	// The certificate is reloaded automatically when the files change on disk.
+	var certLoader *certReloader
 	if config.Server.TLSKeyFile != "" && config.Server.TLSCertFile != "" {
-		// Validate TLS files exist
-		if _, err := os.Stat(config.Server.TLSKeyFile); os.IsNotExist(err) {
-			log.Fatalf("TLS key file not found: %s", config.Server.TLSKeyFile)
+		var err error
+		certLoader, err = newCertReloader(config.Server.TLSCertFile, config.Server.TLSKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS files: %v", err)
 		}
-		if _, err := os.Stat(config.Server.TLSCertFile); os.IsNotExist(err) {
-			log.Fatalf("TLS certificate file not found: %s", config.Server.TLSCertFile)
+		log.Printf("Loaded TLS files (key: %s, cert: %s)", config.Server.TLSKeyFile, config.Server.TLSCertFile)
+	}
+
+	// Load client CA for mutual TLS (before dropping privileges)
+	var clientTLSConfig *tls.Config
+	if config.Server.TLSClientCAFile != "" {
+		clientCAData, err := os.ReadFile(config.Server.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to read TLS client CA file: %v", err)
 		}
-		// Read TLS files into memory (before dropping privileges)
-		var err error
-		tlsKeyData, err = os.ReadFile(config.Server.TLSKeyFile)
+		clientTLSConfig, err = buildClientAuthTLSConfig(clientCAData)
 		if err != nil {
-			log.Fatalf("Failed to read TLS key file: %v", err)
+			log.Fatalf("Failed to load TLS client CA file %s: %v", config.Server.TLSClientCAFile, err)
+		}
+		log.Printf("Loaded TLS client CA file: %s (client certificates required)", config.Server.TLSClientCAFile)
+	}
+
+	// Set up ACME certificate management (before dropping privileges, as the cache directory
+	// must be handed to the exec user and the challenge port is usually privileged)
+	var acmeManager *autocert.Manager
+	var acmeListener net.Listener
+	if len(config.ACME.Domains) > 0 {
+		if err := prepareACMECacheDir(config.ACME.CacheDir, config.Server.ExecUser); err != nil {
+			log.Fatalf("ACME cache directory preparation failed: %v", err)
 		}
-		tlsCertData, err = os.ReadFile(config.Server.TLSCertFile)
+		acmeManager = newACMEManager(config.ACME)
+		acmeListener, err = net.Listen("tcp", config.ACME.HTTPAddress)
 		if err != nil {
-			log.Fatalf("Failed to read TLS certificate file: %v", err)
+			log.Fatalf("Failed to listen for ACME challenges on %s: %v", config.ACME.HTTPAddress, err)
 		}
-		log.Printf("Loaded TLS files (key: %s, cert: %s)", config.Server.TLSKeyFile, config.Server.TLSCertFile)
+		log.Printf("ACME enabled for %s (cache: %s)", strings.Join(config.ACME.Domains, ", "), config.ACME.CacheDir)
 	}
 
 	// Load HTML files early (before dropping privileges, as /etc/vsTaskViewer/html belongs to root)
@@ -202,6 +277,16 @@ func main() {
 		log.Fatalf("Task directory preparation failed: %v", err)
 	}
 
+	// Open audit log before dropping privileges, so the file may belong to root and stay unmodifiable for the exec user
+	var auditLogger *AuditLogger
+	if config.Server.AuditLog != "" {
+		auditLogger, err = NewAuditLogger(config.Server.AuditLog)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		log.Printf("Recording task starts in audit log %s", config.Server.AuditLog)
+	}
+
 	// Drop privileges to exec user (after loading TLS and HTML files and preparing task directory)
 	if err := dropPrivileges(config.Server.ExecUser); err != nil {
 		log.Fatalf("Failed to drop privileges: %v", err)
@@ -212,56 +297,140 @@ func main() {
 		log.Fatalf("Task directory validation failed: %v", err)
 	}
 
+	// Archive directories must be writable by the exec user
+	if err := validateArchiveDirs(config.Tasks); err != nil {
+		log.Fatalf("Archive directory validation failed: %v", err)
+	}
+
 	// Initialize task manager
 	taskManager := NewTaskManager(config)
 
+	// Start periodic status snapshots for external monitoring
+	var statusWriter *StatusWriter
+	if config.Server.StatusFile != "" {
+		interval := time.Duration(config.Server.StatusInterval) * time.Second
+		if interval == 0 {
+			interval = 10 * time.Second
+		}
+		statusWriter = NewStatusWriter(taskManager, config.Server.StatusFile, interval)
+		statusWriter.Start()
+		log.Printf("Writing status snapshots to %s every %v", config.Server.StatusFile, interval)
+	}
+
+	// Start scheduled task runs
+	scheduler, err := NewTaskScheduler(taskManager, config.Tasks, auditLogger)
+	if err != nil {
+		log.Fatalf("Failed to create task scheduler: %v", err)
+	}
+	if scheduler != nil {
+		scheduler.Start()
+	}
+
 	// Initialize WebSocket manager
 	wsManager := NewWebSocketManager()
 
 	// Create WebSocket upgrader with CORS settings
-	upgrader := createUpgrader(config.Server.AllowedOrigins)
+	upgrader := createUpgrader(config.Server.AllowedOrigins, config.Server.DenyAllOriginsIfEmpty)
 
 	// Initialize rate limiter
 	rateLimiter := NewRateLimiter(config.Server.RateLimitRPM)
+	rateLimiter.SetSubnetPrefixes(config.Server.RateLimitIPv4Prefix, config.Server.RateLimitIPv6Prefix)
+	rateLimiter.SetMaxBuckets(config.Server.RateLimitMaxBuckets)
+	startRateLimiter := routeRateLimiter(rateLimiter, config.Server.RateLimitStartRPM, config.Server)
+	viewerRateLimiter := routeRateLimiter(rateLimiter, config.Server.RateLimitViewerRPM, config.Server)
+	wsRateLimiter := routeRateLimiter(rateLimiter, config.Server.RateLimitWSRPM, config.Server)
 
 	// Setup HTTP server with request size limits
 	maxRequestSize := config.Server.MaxRequestSize
 	if maxRequestSize == 0 {
-		maxRequestSize = 10 * 1024 * 1024 // Default 10MB
+		maxRequestSize = defaultMaxRequestSize
 	}
 
 	mux := http.NewServeMux()
 
-	// API endpoint to start tasks (with rate limiting)
-	mux.HandleFunc("/api/start", RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		// Enforce request size limit
-		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
-		handleStartTask(w, r, taskManager, config)
-	}, rateLimiter))
-
-	// Viewer endpoint (with rate limiting)
-	mux.HandleFunc("/viewer", RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	// API endpoints send CORS headers for allowed_origins, outside the rate limiting so that
+	// rejected requests are readable by browser clients too
+	// API endpoint to start tasks (with rate limiting, optional origin check and request size limit)
+	mux.HandleFunc("/api/start", CORSMiddleware(RateLimitMiddleware(OriginCheckMiddleware(MaxBodySizeMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleStartTask(w, r, taskManager, config, auditLogger)
+	}, maxRequestSize), config.Server.AllowedOrigins, config.Server.CheckAPIOrigin), startRateLimiter), config.Server.AllowedOrigins))
+
+	// API endpoint to discover task definitions (with rate limiting)
+	mux.HandleFunc("/api/definitions", CORSMiddleware(RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleDefinitions(w, r, config)
+	}, rateLimiter), config.Server.AllowedOrigins))
+
+	// API endpoint describing task parameters as JSON Schema (with rate limiting)
+	mux.HandleFunc("/api/schema", CORSMiddleware(RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleSchema(w, r, config)
+	}, rateLimiter), config.Server.AllowedOrigins))
+
+	// API endpoint to query task state and exit code (with rate limiting)
+	mux.HandleFunc("/api/status", CORSMiddleware(RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleTaskStatus(w, r, taskManager, config)
+	}, rateLimiter), config.Server.AllowedOrigins))
+
+	// API endpoint to test API credentials (with rate limiting)
+	mux.HandleFunc("/api/ping", CORSMiddleware(RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlePing(w, r, config)
+	}, rateLimiter), config.Server.AllowedOrigins))
+
+	// Viewer endpoint (with rate limiting and gzip compression)
+	mux.HandleFunc("/viewer", RateLimitMiddleware(GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		handleViewer(w, r, taskManager, config, htmlCache)
-	}, rateLimiter))
+	}), viewerRateLimiter))
 
-	// WebSocket endpoint (with rate limiting)
+	// WebSocket endpoint (with rate limiting, never compressed as the connection is hijacked)
 	mux.HandleFunc("/ws", RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		handleWebSocket(w, r, taskManager, config, upgrader, wsManager)
-	}, rateLimiter))
+	}, wsRateLimiter))
 
 	// Health check endpoint (no rate limiting)
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	mux.HandleFunc("/health", handleHealth)
+
+	// Landing page or redirect at /, the 404 error page for unknown paths (with rate limiting and gzip compression)
+	mux.HandleFunc("/", RateLimitMiddleware(GzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleRoot(w, r, config, htmlCache)
+	}), rateLimiter))
+
+	// Rejected requests of the connection limit get the configured response headers too
+	handler := ConnectionLimitMiddleware(mux, config.Server.MaxConnections)
+	server := newHTTPServer(listenAddr, ResponseHeadersMiddleware(handler, config.Server.ResponseHeaders, config.Server.StripResponseHeaders), config.Server)
+	if certLoader != nil {
+		server.TLSConfig = buildServerTLSConfig(clientTLSConfig, certLoader.GetCertificate)
+	} else if acmeManager != nil {
+		server.TLSConfig = buildServerTLSConfig(clientTLSConfig, acmeManager.GetCertificate)
+		// Also answer TLS-ALPN-01 challenges on the HTTPS port
+		server.TLSConfig.NextProtos = append(server.TLSConfig.NextProtos, acme.ALPNProto)
+	}
+	configureProtocols(server, config.Server.Protocols)
+	log.Printf("Enabled protocols: %s", strings.Join(enabledProtocols(config.Server.Protocols), ", "))
+
+	// Serve HTTP-01 challenges (other requests are redirected to HTTPS)
+	var challengeServer *http.Server
+	if acmeManager != nil {
+		challengeServer = &http.Server{
+			Handler:        acmeManager.HTTPHandler(nil),
+			MaxHeaderBytes: 1 << 20,
+			ReadTimeout:    15 * time.Second,
+			WriteTimeout:   15 * time.Second,
+			IdleTimeout:    60 * time.Second,
+		}
+		go func() {
+			if err := challengeServer.Serve(acmeListener); err != nil && err != http.ErrServerClosed {
+				log.Printf("ACME challenge server failed: %v", err)
+			}
+		}()
+		log.Printf("Serving ACME challenges on %s", config.ACME.HTTPAddress)
+	}
 
-	server := &http.Server{
-		Addr:           fmt.Sprintf(":%d", *port),
-		Handler:        mux,
-		MaxHeaderBytes: 1 << 20, // 1MB max header size
-		ReadTimeout:    15 * time.Second,
-		WriteTimeout:   15 * time.Second,
-		IdleTimeout:    60 * time.Second,
+	// Listen on the Unix socket (as exec user, so the socket belongs to it)
+	var unixListener net.Listener
+	if config.Server.UnixSocket != "" {
+		unixListener, err = listenUnixSocket(config.Server.UnixSocket)
+		if err != nil {
+			log.Fatalf("Failed to listen on unix socket: %v", err)
+		}
 	}
 
 	// Graceful shutdown
@@ -272,9 +441,29 @@ func main() {
 
 		log.Println("Shutting down server...")
 
+		// Drain: refuse new starts (including scheduled runs) and let running tasks finish,
+		// viewers keep streaming meanwhile
+		if config.Server.DrainTimeout > 0 {
+			taskManager.StartDraining()
+			log.Printf("Draining: waiting up to %ds for running tasks to finish", config.Server.DrainTimeout)
+			if taskManager.WaitForRunningTasks(time.Duration(config.Server.DrainTimeout) * time.Second) {
+				log.Println("Draining complete, all tasks finished")
+			}
+		}
+
 		// Notify all WebSocket connections
 		wsManager.BroadcastShutdown("Server stopped, closing connection")
 
+		// Stop scheduled runs before cleaning up tasks
+		if scheduler != nil {
+			scheduler.Stop()
+		}
+
+		// Stop status snapshots
+		if statusWriter != nil {
+			statusWriter.Stop()
+		}
+
 		// Cleanup all task directories
 		taskManager.CleanupAllTasks()
 
@@ -285,45 +474,40 @@ func main() {
 		if err := server.Shutdown(ctx); err != nil {
 			log.Printf("Server shutdown error: %v", err)
 		}
+		if challengeServer != nil {
+			if err := challengeServer.Shutdown(ctx); err != nil {
+				log.Printf("ACME challenge server shutdown error: %v", err)
+			}
+		}
+		if err := auditLogger.Close(); err != nil {
+			log.Printf("Audit log close error: %v", err)
+		}
 
 		log.Println("Server shutdown complete")
 	}()
 
 	// Start server with or without TLS
-	if len(tlsKeyData) > 0 && len(tlsCertData) > 0 {
-		// Write TLS data to temporary files (as exec user)
-		tmpKeyFile, err := os.CreateTemp("", "vsTaskViewer-key-*.pem")
-		if err != nil {
-			log.Fatalf("Failed to create temporary TLS key file: %v", err)
-		}
-		defer os.Remove(tmpKeyFile.Name())
-		if _, err := tmpKeyFile.Write(tlsKeyData); err != nil {
-			tmpKeyFile.Close()
-			log.Fatalf("Failed to write temporary TLS key file: %v", err)
-		}
-		tmpKeyFile.Close()
-		os.Chmod(tmpKeyFile.Name(), 0600)
-
-		tmpCertFile, err := os.CreateTemp("", "vsTaskViewer-cert-*.pem")
-		if err != nil {
-			log.Fatalf("Failed to create temporary TLS cert file: %v", err)
-		}
-		defer os.Remove(tmpCertFile.Name())
-		if _, err := tmpCertFile.Write(tlsCertData); err != nil {
-			tmpCertFile.Close()
-			log.Fatalf("Failed to write temporary TLS cert file: %v", err)
+	if unixListener != nil {
+		log.Printf("Starting HTTP server on unix socket %s", config.Server.UnixSocket)
+		if err := server.Serve(unixListener); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
 		}
-		tmpCertFile.Close()
-		os.Chmod(tmpCertFile.Name(), 0644)
-
-		log.Printf("Starting HTTPS server on port %d", *port)
+	} else if certLoader != nil {
+		log.Printf("Starting HTTPS server on %s", listenAddr)
 		log.Printf("TLS key: %s", config.Server.TLSKeyFile)
 		log.Printf("TLS cert: %s", config.Server.TLSCertFile)
-		if err := server.ListenAndServeTLS(tmpCertFile.Name(), tmpKeyFile.Name()); err != nil && err != http.ErrServerClosed {
+		// Certificate is provided by TLSConfig.GetCertificate
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	} else if acmeManager != nil {
+		log.Printf("Starting HTTPS server on %s", listenAddr)
+		log.Printf("TLS certificates via ACME for: %s", strings.Join(config.ACME.Domains, ", "))
+		if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
 	} else {
-		log.Printf("Starting HTTP server on port %d", *port)
+		log.Printf("Starting HTTP server on %s", listenAddr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed: %v", err)
 		}
@@ -332,27 +516,281 @@ func main() {
 
 func loadConfig(path string) (*Config, error) {
 	var config Config
-	if _, err := toml.DecodeFile(path, &config); err != nil {
+	meta, err := toml.DecodeFile(path, &config)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode config file: %w", err)
 	}
 
+	// Report unknown keys (e.g. typos like rate_limit_rmp), which would otherwise be silently ignored
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		if config.Server.StrictConfig {
+			return nil, fmt.Errorf("unknown config keys: %s", strings.Join(keys, ", "))
+		}
+		log.Printf("Warning: ignoring unknown config keys: %s", strings.Join(keys, ", "))
+	}
+
+	// Environment variables override the file, they are validated like file values
+	if err := applyEnvOverrides(&config, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
 	// Validate config
 	if config.Auth.Secret == "" {
-		return nil, fmt.Errorf("auth.secret must be set in config")
+		return nil, fmt.Errorf("auth.secret must be set in config (or VSTASK_SECRET)")
 	}
 
 	if len(config.Tasks) == 0 {
 		return nil, fmt.Errorf("at least one task must be defined in config")
 	}
 
+	if config.Auth.APIHeaderSecret != "" && config.Auth.APIHeaderSecret == config.Auth.Secret {
+		return nil, fmt.Errorf("auth.api_header_secret must not reuse auth.secret")
+	}
+
+	// Validate API keys, their tasks must be configured
+	keyIDs := make(map[string]bool)
+	for i, key := range config.Auth.APIKeys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("auth.api_keys entry at index %d has no id", i)
+		}
+		if keyIDs[key.ID] {
+			return nil, fmt.Errorf("duplicate auth.api_keys id '%s'", key.ID)
+		}
+		keyIDs[key.ID] = true
+		if key.Secret == "" {
+			return nil, fmt.Errorf("auth.api_keys '%s' has no secret", key.ID)
+		}
+		if key.Secret == config.Auth.Secret {
+			return nil, fmt.Errorf("auth.api_keys '%s' must not reuse auth.secret", key.ID)
+		}
+		if len(key.Tasks) == 0 {
+			return nil, fmt.Errorf("auth.api_keys '%s' allows no tasks", key.ID)
+		}
+		for _, name := range key.Tasks {
+			if findTaskConfig(config.Tasks, name) == nil {
+				return nil, fmt.Errorf("auth.api_keys '%s' allows unknown task '%s'", key.ID, name)
+			}
+		}
+		for _, paramName := range key.AllowedParameters {
+			if !keyTasksDeclareParameter(config.Tasks, key.Tasks, paramName) {
+				return nil, fmt.Errorf("auth.api_keys '%s' allows parameter '%s' which none of its tasks declares", key.ID, paramName)
+			}
+		}
+	}
+
+	// Validate response header configuration
+	for name := range config.Server.ResponseHeaders {
+		if name == "" {
+			return nil, fmt.Errorf("server.response_headers contains an empty header name")
+		}
+	}
+	for _, name := range config.Server.StripResponseHeaders {
+		if name == "" {
+			return nil, fmt.Errorf("server.strip_response_headers contains an empty header name")
+		}
+	}
+
+	if config.Server.ReadTimeout != nil && *config.Server.ReadTimeout < 0 {
+		return nil, fmt.Errorf("server.read_timeout must not be negative")
+	}
+	if config.Server.WriteTimeout != nil && *config.Server.WriteTimeout < 0 {
+		return nil, fmt.Errorf("server.write_timeout must not be negative")
+	}
+	if config.Server.IdleTimeout != nil && *config.Server.IdleTimeout < 0 {
+		return nil, fmt.Errorf("server.idle_timeout must not be negative")
+	}
+	if config.Server.ReadHeaderTimeout != nil && *config.Server.ReadHeaderTimeout < 0 {
+		return nil, fmt.Errorf("server.read_header_timeout must not be negative")
+	}
+	if config.Server.MaxConnections < 0 {
+		return nil, fmt.Errorf("server.max_connections must not be negative")
+	}
+	if err := validateProtocols(enabledProtocols(config.Server.Protocols)); err != nil {
+		return nil, fmt.Errorf("invalid server.protocols: %w", err)
+	}
+
+	if config.Server.StatusInterval < 0 {
+		return nil, fmt.Errorf("server.status_interval must not be negative")
+	}
+
+	if config.Server.MaxParameters < 0 {
+		return nil, fmt.Errorf("server.max_parameters must not be negative")
+	}
+	if config.Server.MaxParametersSize < 0 {
+		return nil, fmt.Errorf("server.max_parameters_size must not be negative")
+	}
+
+	if config.Server.OutputRetention < 0 {
+		return nil, fmt.Errorf("server.output_retention must not be negative")
+	}
+
+	if config.Server.RateLimitIPv4Prefix < 0 || config.Server.RateLimitIPv4Prefix > 32 {
+		return nil, fmt.Errorf("server.rate_limit_ipv4_prefix must be between 0 and 32")
+	}
+	if config.Server.RateLimitIPv6Prefix < 0 || config.Server.RateLimitIPv6Prefix > 128 {
+		return nil, fmt.Errorf("server.rate_limit_ipv6_prefix must be between 0 and 128")
+	}
+	if config.Server.RateLimitMaxBuckets < 0 {
+		return nil, fmt.Errorf("server.rate_limit_max_buckets must not be negative")
+	}
+	for name, rpm := range map[string]*int{
+		"rate_limit_start_rpm":  config.Server.RateLimitStartRPM,
+		"rate_limit_viewer_rpm": config.Server.RateLimitViewerRPM,
+		"rate_limit_ws_rpm":     config.Server.RateLimitWSRPM,
+	} {
+		if rpm != nil && *rpm < 0 {
+			return nil, fmt.Errorf("server.%s must not be negative", name)
+		}
+	}
+
+	if config.Server.HeartbeatInterval < 0 {
+		return nil, fmt.Errorf("server.heartbeat_interval must not be negative")
+	}
+	if config.Server.StartupTimeout < 0 {
+		return nil, fmt.Errorf("server.startup_timeout must not be negative")
+	}
+	if config.Server.FileWaitTimeout < 0 {
+		return nil, fmt.Errorf("server.file_wait_timeout must not be negative")
+	}
+	if config.Server.FilePollInterval < 0 {
+		return nil, fmt.Errorf("server.file_poll_interval must not be negative")
+	}
+	if config.Server.MonitorWorkers < 0 || config.Server.MonitorWorkers > maxMonitorWorkers {
+		return nil, fmt.Errorf("server.monitor_workers must be between 0 and %d", maxMonitorWorkers)
+	}
+	if config.Server.MaxTailHandles < 0 {
+		return nil, fmt.Errorf("server.max_tail_handles must not be negative")
+	}
+	if config.Server.MaxTailers < 0 || (config.Server.MaxTailers > 0 && config.Server.MaxTailers < tailersPerViewer) {
+		return nil, fmt.Errorf("server.max_tailers must be 0 or at least %d", tailersPerViewer)
+	}
+	for _, entry := range config.Server.AllowedCommands {
+		if entry == "" || strings.ContainsAny(entry, " \t\n") {
+			return nil, fmt.Errorf("server.allowed_commands entries must be non-empty binary names or paths without whitespace, got %q", entry)
+		}
+	}
+	if config.Server.MaxTaskDirBytes < 0 {
+		return nil, fmt.Errorf("server.max_task_dir_bytes must not be negative")
+	}
+	if config.Server.WSMaxBytesPerSec < 0 {
+		return nil, fmt.Errorf("server.ws_max_bytes_per_sec must not be negative")
+	}
+	if config.Server.StartRetries < 0 || config.Server.StartRetries > maxStartRetries {
+		return nil, fmt.Errorf("server.start_retries must be between 0 and %d", maxStartRetries)
+	}
+	if config.Server.DrainTimeout < 0 {
+		return nil, fmt.Errorf("server.drain_timeout must not be negative")
+	}
+	if config.Server.TerminationGracePeriod != nil && *config.Server.TerminationGracePeriod < 0 {
+		return nil, fmt.Errorf("server.termination_grace_period must not be negative")
+	}
+
+	switch config.Server.OutputLayout {
+	case "", OutputLayoutFlat, OutputLayoutDate:
+	default:
+		return nil, fmt.Errorf("server.output_layout must be '%s' or '%s', got '%s'", OutputLayoutFlat, OutputLayoutDate, config.Server.OutputLayout)
+	}
+	switch config.Server.TaskIDScheme {
+	case "", TaskIDSchemeUUID, TaskIDSchemeShort:
+	default:
+		return nil, fmt.Errorf("server.task_id_scheme must be '%s' or '%s', got '%s'", TaskIDSchemeUUID, TaskIDSchemeShort, config.Server.TaskIDScheme)
+	}
+	if config.Server.ViewerURLTemplate != "" {
+		if err := validateViewerURLTemplate(config.Server.ViewerURLTemplate); err != nil {
+			return nil, fmt.Errorf("invalid server.viewer_url_template: %w", err)
+		}
+	}
+	if config.Server.RootRedirect != "" {
+		if err := validateRootRedirect(config.Server.RootRedirect); err != nil {
+			return nil, fmt.Errorf("invalid server.root_redirect: %w", err)
+		}
+	}
+
+	if config.Server.Shell == "" {
+		config.Server.Shell = defaultShell
+	}
+	if err := validateShell(config.Server.Shell); err != nil {
+		return nil, fmt.Errorf("invalid server.shell: %w", err)
+	}
+
+	if config.Server.Umask != "" {
+		if err := validateUmask(config.Server.Umask); err != nil {
+			return nil, fmt.Errorf("invalid server.umask: %w", err)
+		}
+	}
+
+	if config.Server.CacheTTL < 0 {
+		return nil, fmt.Errorf("server.cache_ttl must not be negative")
+	}
+	if config.Server.CacheMaxEntries < 0 {
+		return nil, fmt.Errorf("server.cache_max_entries must not be negative")
+	}
+
+	if config.Server.UnixSocket != "" && (config.Server.TLSKeyFile != "" || config.Server.TLSCertFile != "" || len(config.ACME.Domains) > 0) {
+		return nil, fmt.Errorf("server.unix_socket and TLS (tls_key_file/tls_cert_file or acme) are mutually exclusive")
+	}
+
+	// Validate ACME configuration
+	if len(config.ACME.Domains) > 0 {
+		if config.Server.TLSKeyFile != "" || config.Server.TLSCertFile != "" {
+			return nil, fmt.Errorf("acme and server.tls_key_file/tls_cert_file are mutually exclusive")
+		}
+		for _, domain := range config.ACME.Domains {
+			if domain == "" {
+				return nil, fmt.Errorf("acme.domains contains an empty domain")
+			}
+		}
+		if config.ACME.CacheDir == "" {
+			config.ACME.CacheDir = defaultACMECacheDir
+		}
+		if config.ACME.HTTPAddress == "" {
+			config.ACME.HTTPAddress = defaultACMEHTTPAddress
+		}
+	}
+
+	if config.Server.TLSClientCAFile != "" && (config.Server.TLSKeyFile == "" || config.Server.TLSCertFile == "") && len(config.ACME.Domains) == 0 {
+		return nil, fmt.Errorf("server.tls_client_ca_file requires tls_key_file and tls_cert_file or acme")
+	}
+	if hasProtocol(config.Server.Protocols, ProtocolH2C) && (config.Server.TLSKeyFile != "" || len(config.ACME.Domains) > 0) {
+		return nil, fmt.Errorf("server.protocols: %q is cleartext HTTP/2 and cannot be used with TLS (use %q)", ProtocolH2C, ProtocolHTTP2)
+	}
+	if config.Server.MTLSAPIAuth && config.Server.TLSClientCAFile == "" {
+		return nil, fmt.Errorf("server.mtls_api_auth requires tls_client_ca_file")
+	}
+
 	// Validate task configurations including parameters
 	for i, task := range config.Tasks {
 		if task.Name == "" {
 			return nil, fmt.Errorf("task at index %d has no name", i)
 		}
-		if task.Command == "" {
+		if task.Command == "" && len(task.Steps) == 0 {
 			return nil, fmt.Errorf("task '%s' has no command", task.Name)
 		}
+		if task.Command != "" && len(task.Steps) > 0 {
+			return nil, fmt.Errorf("task '%s' cannot have both command and steps", task.Name)
+		}
+		if err := validateSteps(task); err != nil {
+			return nil, err
+		}
+		if task.ContinueOnStepFailure && len(task.Steps) == 0 {
+			return nil, fmt.Errorf("task '%s' has continue_on_step_failure set, but no steps", task.Name)
+		}
+
+		if task.MaxOpenFiles < 0 || task.MaxOpenFiles > maxOpenFilesLimit {
+			return nil, fmt.Errorf("task '%s' has invalid max_open_files %d (must be between 0 and %d)", task.Name, task.MaxOpenFiles, maxOpenFilesLimit)
+		}
+		if task.Umask != "" {
+			if err := validateUmask(task.Umask); err != nil {
+				return nil, fmt.Errorf("task '%s' has invalid umask: %w", task.Name, err)
+			}
+		}
+		if task.StallTimeout < 0 {
+			return nil, fmt.Errorf("task '%s' has negative stall_timeout %d", task.Name, task.StallTimeout)
+		}
 
 		// Validate parameter definitions
 		paramNames := make(map[string]bool)
@@ -360,8 +798,61 @@ func loadConfig(path string) (*Config, error) {
 			if param.Name == "" {
 				return nil, fmt.Errorf("task '%s' has parameter at index %d with no name", task.Name, j)
 			}
-			if param.Type != "int" && param.Type != "string" {
-				return nil, fmt.Errorf("task '%s' parameter '%s' has invalid type '%s' (must be 'int' or 'string')", task.Name, param.Name, param.Type)
+			if param.Type != "int" && param.Type != "string" && param.Type != "file" {
+				return nil, fmt.Errorf("task '%s' parameter '%s' has invalid type '%s' (must be 'int', 'string' or 'file')", task.Name, param.Name, param.Type)
+			}
+			// File parameters are stored as param_<name> in the output directory
+			if param.Type == "file" && !taskNameRegex.MatchString(param.Name) {
+				return nil, fmt.Errorf("task '%s' file parameter '%s' has an invalid name (only [a-zA-Z0-9_-] allowed)", task.Name, param.Name)
+			}
+			// Validate encoding, decoded strings are passed in the environment variable PARAM_<NAME>
+			switch param.Encoding {
+			case "":
+			case "base64":
+				if param.Type != "string" && param.Type != "file" {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has encoding 'base64', which is only supported for type 'string' or 'file'", task.Name, param.Name)
+				}
+				if param.Type == "string" && !envParamRegex.MatchString(param.Name) {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has encoding 'base64' but its name is not a valid environment variable name", task.Name, param.Name)
+				}
+			default:
+				return nil, fmt.Errorf("task '%s' parameter '%s' has invalid encoding '%s' (must be empty or 'base64')", task.Name, param.Name, param.Encoding)
+			}
+			// Transformations apply to values that are validated and substituted as text
+			if len(param.Transform) > 0 {
+				if (param.Type != "string" && param.Type != "int") || param.Encoding != "" {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has transform set, which is only supported for type 'string' or 'int' without encoding", task.Name, param.Name)
+				}
+				for _, name := range param.Transform {
+					if _, ok := parameterTransforms[name]; !ok {
+						return nil, fmt.Errorf("task '%s' parameter '%s' has invalid transform '%s' (must be 'trim', 'lower' or 'upper')", task.Name, param.Name, name)
+					}
+				}
+			}
+			// Validate numeric bounds
+			if param.Min != nil || param.Max != nil {
+				if param.Type != "int" {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has min/max set, but bounds are only supported for type 'int'", task.Name, param.Name)
+				}
+				if param.Min != nil && param.Max != nil && *param.Min > *param.Max {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has min (%d) greater than max (%d)", task.Name, param.Name, *param.Min, *param.Max)
+				}
+			}
+			// Validate string constraints (max_length is the size limit in bytes for files) and compile pattern once
+			if param.MaxLength != 0 || param.Pattern != "" {
+				if param.Type != "string" && (param.Type != "file" || param.Pattern != "") {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has max_length/pattern set, but these are only supported for type 'string' (max_length also for 'file')", task.Name, param.Name)
+				}
+				if param.MaxLength < 0 {
+					return nil, fmt.Errorf("task '%s' parameter '%s' has negative max_length %d", task.Name, param.Name, param.MaxLength)
+				}
+				if param.Pattern != "" {
+					re, err := compileParameterPattern(param.Pattern)
+					if err != nil {
+						return nil, fmt.Errorf("task '%s' parameter '%s' has invalid pattern: %w", task.Name, param.Name, err)
+					}
+					config.Tasks[i].Parameters[j].pattern = re
+				}
 			}
 			// Check for duplicate parameter names
 			if paramNames[param.Name] {
@@ -369,6 +860,49 @@ func loadConfig(path string) (*Config, error) {
 			}
 			paramNames[param.Name] = true
 		}
+
+		// Placeholders must refer to declared parameters, unused parameters are likely typos
+		if err := checkCommandPlaceholders(task); err != nil {
+			return nil, err
+		}
+
+		if task.TerminationSignal != "" {
+			if _, err := parseSignal(task.TerminationSignal); err != nil {
+				return nil, fmt.Errorf("task '%s' has invalid termination_signal: %w", task.Name, err)
+			}
+		}
+		if task.ArchiveDir != "" && !filepath.IsAbs(task.ArchiveDir) {
+			return nil, fmt.Errorf("task '%s' archive_dir must be an absolute path", task.Name)
+		}
+		if task.CompressOutput {
+			if task.BinaryOutput {
+				return nil, fmt.Errorf("task '%s' cannot combine compress_output with binary_output", task.Name)
+			}
+			if _, err := exec.LookPath("gzip"); err != nil {
+				return nil, fmt.Errorf("task '%s' has compress_output set, but gzip was not found: %w", task.Name, err)
+			}
+		}
+		if task.TerminationGracePeriod != nil && *task.TerminationGracePeriod < 0 {
+			return nil, fmt.Errorf("task '%s' has negative termination_grace_period %d", task.Name, *task.TerminationGracePeriod)
+		}
+		if task.PreCheckTimeout < 0 {
+			return nil, fmt.Errorf("task '%s' has negative pre_check_timeout %d", task.Name, task.PreCheckTimeout)
+		}
+		if err := validateForwardHeaders(task.ForwardHeaders); err != nil {
+			return nil, fmt.Errorf("task '%s' has invalid forward_headers: %w", task.Name, err)
+		}
+
+		// Scheduled runs start with empty parameters, so all parameters must be optional
+		if task.Schedule != "" {
+			if _, err := parseSchedule(task.Schedule); err != nil {
+				return nil, fmt.Errorf("task '%s' has invalid schedule %q: %w", task.Name, task.Schedule, err)
+			}
+			for _, param := range task.Parameters {
+				if !param.Optional {
+					return nil, fmt.Errorf("task '%s' has a schedule but requires parameter '%s' (scheduled runs have no parameters)", task.Name, param.Name)
+				}
+			}
+		}
 	}
 
 	// Note: HTML directory validation is done in main() after path resolution
@@ -376,6 +910,90 @@ func loadConfig(path string) (*Config, error) {
 	return &config, nil
 }
 
+// keyTasksDeclareParameter reports whether one of the named tasks declares the parameter
+func keyTasksDeclareParameter(tasks []TaskConfig, taskNames []string, paramName string) bool {
+	for _, taskName := range taskNames {
+		task := findTaskConfig(tasks, taskName)
+		if task == nil {
+			continue
+		}
+		for _, param := range task.Parameters {
+			if param.Name == paramName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkCommandPlaceholders rejects placeholders in the command, steps, cleanup command or pre_check that don't refer
+// to a declared parameter and warns about declared parameters that are never referenced
+func checkCommandPlaceholders(task TaskConfig) error {
+	declared := make(map[string]bool, len(task.Parameters))
+	for _, param := range task.Parameters {
+		declared[param.Name] = true
+	}
+
+	used := make(map[string]bool)
+	commands := []string{task.Command, task.CleanupCommand, task.PreCheck}
+	for _, step := range task.Steps {
+		commands = append(commands, step.Command)
+	}
+	for _, command := range commands {
+		for _, name := range commandPlaceholders(command) {
+			if !declared[name] {
+				return fmt.Errorf("task '%s' references undeclared parameter '{{%s}}' in its command", task.Name, name)
+			}
+			used[name] = true
+		}
+	}
+
+	for _, param := range task.Parameters {
+		if used[param.Name] {
+			continue
+		}
+		if param.Optional {
+			log.Printf("Warning: task '%s' declares parameter '%s' which is not used in its command", task.Name, param.Name)
+		} else {
+			log.Printf("Warning: task '%s' requires parameter '%s' which is not used in its command", task.Name, param.Name)
+		}
+	}
+	return nil
+}
+
+// newHTTPServer creates the HTTP server with the configured timeouts
+func newHTTPServer(addr string, handler http.Handler, cfg ServerConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		MaxHeaderBytes:    1 << 20, // 1MB max header size
+		ReadTimeout:       timeoutOrDefault(cfg.ReadTimeout, 15*time.Second),
+		ReadHeaderTimeout: timeoutOrDefault(cfg.ReadHeaderTimeout, 10*time.Second),
+		WriteTimeout:      timeoutOrDefault(cfg.WriteTimeout, 15*time.Second),
+		IdleTimeout:       timeoutOrDefault(cfg.IdleTimeout, 60*time.Second),
+	}
+}
+
+// timeoutOrDefault converts a configured timeout in seconds, falling back to def if it is not set
+func timeoutOrDefault(seconds *int, def time.Duration) time.Duration {
+	if seconds == nil {
+		return def
+	}
+	return time.Duration(*seconds) * time.Second
+}
+
+// handleHealth handles the health check endpoint
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// listenAddress combines the bind address and port into the server listen address.
+// An empty bind address listens on all interfaces.
+func listenAddress(bindAddress string, port int) string {
+	return net.JoinHostPort(bindAddress, strconv.Itoa(port))
+}
+
 // getBinaryDir returns the directory where the binary is located
 func getBinaryDir() (string, error) {
 	execPath, err := os.Executable()
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"log"
+	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -124,6 +128,227 @@ type = "string"
 			wantErr:     true,
 			errContains: "duplicate parameter name",
 		},
+		{
+			name: "task with file parameter",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "wc -l {{input}}"
+
+[[tasks.parameters]]
+name = "input"
+type = "file"
+max_length = 1048576
+`,
+			wantErr: false,
+		},
+		{
+			name: "file parameter with pattern",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "wc -l {{input}}"
+
+[[tasks.parameters]]
+name = "input"
+type = "file"
+pattern = "[a-z]+"
+`,
+			wantErr:     true,
+			errContains: "only supported for type 'string'",
+		},
+		{
+			name: "file parameter with invalid name",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "wc -l {{input.txt}}"
+
+[[tasks.parameters]]
+name = "input.txt"
+type = "file"
+`,
+			wantErr:     true,
+			errContains: "file parameter 'input.txt' has an invalid name",
+		},
+		{
+			name: "base64 parameter with invalid encoding",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{msg}}"
+
+[[tasks.parameters]]
+name = "msg"
+type = "string"
+encoding = "hex"
+`,
+			wantErr:     true,
+			errContains: "invalid encoding 'hex'",
+		},
+		{
+			name: "parameter with unknown transform",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{msg}}"
+
+[[tasks.parameters]]
+name = "msg"
+type = "string"
+transform = ["trim", "reverse"]
+`,
+			wantErr:     true,
+			errContains: "invalid transform 'reverse'",
+		},
+		{
+			name: "base64 string parameter without valid env name",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{my-msg}}"
+
+[[tasks.parameters]]
+name = "my-msg"
+type = "string"
+encoding = "base64"
+`,
+			wantErr:     true,
+			errContains: "not a valid environment variable name",
+		},
+		{
+			name: "command references undeclared parameter",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{msg}} {{mgs}}"
+
+[[tasks.parameters]]
+name = "msg"
+type = "string"
+`,
+			wantErr:     true,
+			errContains: "undeclared parameter '{{mgs}}'",
+		},
+		{
+			name: "cleanup command references undeclared parameter",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+cleanup_command = "rm -f {{#tmp}}{{tmp}}{{/tmp}}"
+`,
+			wantErr:     true,
+			errContains: "undeclared parameter '{{tmp}}'",
+		},
+		{
+			name: "invalid schedule",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+schedule = "every hour"
+`,
+			wantErr:     true,
+			errContains: "invalid schedule",
+		},
+		{
+			name: "invalid termination signal",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+termination_signal = "SIGKILL"
+`,
+			wantErr:     true,
+			errContains: "invalid termination_signal",
+		},
+		{
+			name: "schedule with required parameter",
+			configContent: `[server]
+port = 8080
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{msg}}"
+schedule = "@hourly"
+
+[[tasks.parameters]]
+name = "msg"
+type = "string"
+`,
+			wantErr:     true,
+			errContains: "requires parameter 'msg'",
+		},
+		{
+			name: "invalid output layout",
+			configContent: `[server]
+port = 8080
+output_layout = "monthly"
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "server.output_layout must be",
+		},
 		{
 			name: "task with parameter without name",
 			configContent: `[server]
@@ -166,6 +391,219 @@ optional = true
 `,
 			wantErr: false,
 		},
+		{
+			name: "task with min greater than max",
+			configContent: `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "sleep {{timeout}}"
+
+[[tasks.parameters]]
+name = "timeout"
+type = "int"
+min = 10
+max = 5
+`,
+			wantErr:     true,
+			errContains: "greater than max",
+		},
+		{
+			name: "task with bounds on string parameter",
+			configContent: `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{name}}"
+
+[[tasks.parameters]]
+name = "name"
+type = "string"
+min = 1
+`,
+			wantErr:     true,
+			errContains: "only supported for type 'int'",
+		},
+		{
+			name: "valid config with parameter bounds",
+			configContent: `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "sleep {{timeout}}"
+
+[[tasks.parameters]]
+name = "timeout"
+type = "int"
+min = 1
+max = 3600
+`,
+			wantErr: false,
+		},
+		{
+			name: "unknown key in strict mode",
+			configContent: `[server]
+strict_config = true
+rate_limit_rmp = 60
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "server.rate_limit_rmp",
+		},
+		{
+			name: "task with invalid parameter pattern",
+			configContent: `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{name}}"
+
+[[tasks.parameters]]
+name = "name"
+type = "string"
+pattern = "[a-z"
+`,
+			wantErr:     true,
+			errContains: "invalid pattern",
+		},
+		{
+			name: "task with pattern on int parameter",
+			configContent: `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{count}}"
+
+[[tasks.parameters]]
+name = "count"
+type = "int"
+max_length = 3
+`,
+			wantErr:     true,
+			errContains: "only supported for type 'string'",
+		},
+		{
+			name: "task with negative max_open_files",
+			configContent: `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+max_open_files = -1
+`,
+			wantErr:     true,
+			errContains: "invalid max_open_files",
+		},
+		{
+			name: "acme combined with tls files",
+			configContent: `[server]
+tls_key_file = "/etc/ssl/private/key.pem"
+tls_cert_file = "/etc/ssl/certs/fullchain.pem"
+
+[auth]
+secret = "test-secret"
+
+[acme]
+domains = ["tasks.example.com"]
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "mutually exclusive",
+		},
+		{
+			name: "acme with empty domain",
+			configContent: `[auth]
+secret = "test-secret"
+
+[acme]
+domains = [""]
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "empty domain",
+		},
+		{
+			name: "negative write timeout",
+			configContent: `[server]
+write_timeout = -1
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "write_timeout must not be negative",
+		},
+		{
+			name: "unix socket combined with tls",
+			configContent: `[server]
+unix_socket = "/run/vsTaskViewer/vsTaskViewer.sock"
+tls_key_file = "/etc/ssl/private/key.pem"
+tls_cert_file = "/etc/ssl/certs/fullchain.pem"
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "mutually exclusive",
+		},
+		{
+			name: "protocols without http1",
+			configContent: `[server]
+protocols = ["http2"]
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "invalid server.protocols",
+		},
+		{
+			name: "h2c combined with tls",
+			configContent: `[server]
+protocols = ["http1", "h2c"]
+tls_key_file = "/etc/ssl/private/key.pem"
+tls_cert_file = "/etc/ssl/certs/fullchain.pem"
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`,
+			wantErr:     true,
+			errContains: "cannot be used with TLS",
+		},
 	}
 
 	for _, tt := range tests {
@@ -212,6 +650,251 @@ optional = true
 	}
 }
 
+func TestLoadConfigUnknownKeysWarning(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	configContent := `[server]
+rate_limit_rmp = 60
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	tmpFile.Close()
+
+	// Capture log output to verify the warning
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	config, err := loadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v, want no error in warn mode", err)
+	}
+	if config == nil {
+		t.Fatal("loadConfig() returned nil config")
+	}
+	if !strings.Contains(logBuf.String(), "server.rate_limit_rmp") {
+		t.Errorf("loadConfig() log = %q, want warning mentioning server.rate_limit_rmp", logBuf.String())
+	}
+}
+
+func TestLoadConfigUnusedParameterWarning(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	configContent := `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{#verbose}}-v{{/verbose}}"
+cleanup_command = "rm -f {{file}}"
+
+[[tasks.parameters]]
+name = "verbose"
+type = "string"
+optional = true
+
+[[tasks.parameters]]
+name = "file"
+type = "string"
+
+[[tasks.parameters]]
+name = "unused"
+type = "int"
+`
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	tmpFile.Close()
+
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	if _, err := loadConfig(tmpFile.Name()); err != nil {
+		t.Fatalf("loadConfig() error = %v, want unused parameters to be a warning only", err)
+	}
+	if !strings.Contains(logBuf.String(), "requires parameter 'unused' which is not used") {
+		t.Errorf("loadConfig() log = %q, want warning about unused parameter", logBuf.String())
+	}
+	for _, name := range []string{"'verbose'", "'file'"} {
+		if strings.Contains(logBuf.String(), name) {
+			t.Errorf("loadConfig() log = %q, want no warning for used parameter %s", logBuf.String(), name)
+		}
+	}
+}
+
+func TestCommandPlaceholders(t *testing.T) {
+	got := commandPlaceholders("cp {{src}} {{dst}}{{#force}} -f{{/force}} && ls {{src}} ${HOME}")
+	want := []string{"src", "dst", "force"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("commandPlaceholders() = %v; want %v", got, want)
+	}
+}
+
+func TestLoadConfigCompilesParameterPattern(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	configContent := `[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo {{env}}"
+
+[[tasks.parameters]]
+name = "env"
+type = "string"
+pattern = "prod|staging"
+`
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := loadConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v, want no error", err)
+	}
+	if config.Tasks[0].Parameters[0].pattern == nil {
+		t.Error("loadConfig() did not compile parameter pattern")
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		bindAddress string
+		want        string
+	}{
+		{name: "all interfaces", bindAddress: "", want: ":9090"},
+		{name: "loopback", bindAddress: "127.0.0.1", want: "127.0.0.1:9090"},
+		{name: "ipv6", bindAddress: "::1", want: "[::1]:9090"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+			if err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+			defer os.Remove(tmpFile.Name())
+
+			configContent := `[server]
+port = 9090
+bind_address = "` + tt.bindAddress + `"
+
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`
+			if _, err := tmpFile.WriteString(configContent); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			tmpFile.Close()
+
+			config, err := loadConfig(tmpFile.Name())
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v, want no error", err)
+			}
+			if got := listenAddress(config.Server.BindAddress, config.Server.Port); got != tt.want {
+				t.Errorf("listenAddress() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHTTPServerTimeouts(t *testing.T) {
+	tests := []struct {
+		name           string
+		server         string
+		wantRead       time.Duration
+		wantReadHeader time.Duration
+		wantWrite      time.Duration
+		wantIdle       time.Duration
+	}{
+		{
+			name:           "defaults",
+			server:         "",
+			wantRead:       15 * time.Second,
+			wantReadHeader: 10 * time.Second,
+			wantWrite:      15 * time.Second,
+			wantIdle:       60 * time.Second,
+		},
+		{
+			name:           "configured",
+			server:         "read_timeout = 30\nread_header_timeout = 5\nwrite_timeout = 0\nidle_timeout = 120\n",
+			wantRead:       30 * time.Second,
+			wantReadHeader: 5 * time.Second,
+			wantWrite:      0,
+			wantIdle:       120 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpFile, err := os.CreateTemp("", "test-config-*.toml")
+			if err != nil {
+				t.Fatalf("Failed to create temp file: %v", err)
+			}
+			defer os.Remove(tmpFile.Name())
+
+			configContent := "[server]\n" + tt.server + `
+[auth]
+secret = "test-secret"
+
+[[tasks]]
+name = "test-task"
+command = "echo test"
+`
+			if _, err := tmpFile.WriteString(configContent); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			tmpFile.Close()
+
+			config, err := loadConfig(tmpFile.Name())
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v, want no error", err)
+			}
+			server := newHTTPServer(":8080", http.NotFoundHandler(), config.Server)
+			if server.ReadTimeout != tt.wantRead {
+				t.Errorf("ReadTimeout = %v; want %v", server.ReadTimeout, tt.wantRead)
+			}
+			if server.ReadHeaderTimeout != tt.wantReadHeader {
+				t.Errorf("ReadHeaderTimeout = %v; want %v", server.ReadHeaderTimeout, tt.wantReadHeader)
+			}
+			if server.WriteTimeout != tt.wantWrite {
+				t.Errorf("WriteTimeout = %v; want %v", server.WriteTimeout, tt.wantWrite)
+			}
+			if server.IdleTimeout != tt.wantIdle {
+				t.Errorf("IdleTimeout = %v; want %v", server.IdleTimeout, tt.wantIdle)
+			}
+		})
+	}
+}
+
 func TestGetBinaryDir(t *testing.T) {
 	dir, err := getBinaryDir()
 	if err != nil {
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// monitorTickInterval is how often the shared monitor checks its viewers (PID wait, heartbeats, timeouts)
+const monitorTickInterval = 250 * time.Millisecond
+
+// livenessCheckInterval is how often the shared monitor polls the process of a task whose exit
+// hasn't been reported by cmd.Wait yet
+const livenessCheckInterval = time.Second
+
+// maxMonitorWorkers caps server.monitor_workers
+const maxMonitorWorkers = 64
+
+// taskViewer is a WebSocket connection watching a task, tracked by the shared task monitor.
+// The fields after the settings are only accessed by the monitor worker owning the viewer.
+type taskViewer struct {
+	ctx               context.Context
+	safeConn          *safeConn
+	taskID            string
+	outputDir         string
+	maxExecutionTime  time.Duration // 0 = no limit
+	counters          *streamCounters
+	startTime         time.Time     // Start of the task
+	heartbeatInterval time.Duration // Between heartbeat messages
+	startupTimeout    time.Duration // From startTime until a missing started file marks the start as failed
+	stallTimeout      time.Duration // 0 = stall detection disabled
+
+	started       bool      // Whether the wrapper has created the started file
+	pid           int       // 0 while waiting for the PID file
+	timeoutAt     time.Time // When the max execution time is exceeded (zero = no limit or already handled)
+	nextHeartbeat time.Time
+	stall         stallDetector
+}
+
+// monitoredTask is the per-task state shared by all viewers of a task
+type monitoredTask struct {
+	viewers       map[*taskViewer]bool
+	ended         chan struct{} // Closed once the task's process has ended, the shared completion signal
+	nextLiveness  time.Time
+	procStartTime uint64
+}
+
+// taskMonitor watches the processes of all viewed tasks in a fixed number of worker goroutines,
+// instead of one polling goroutine per WebSocket connection. Tasks are assigned to workers by ID,
+// so all viewers of a task are handled by the same worker.
+type taskMonitor struct {
+	taskManager *TaskManager
+	workers     []*monitorWorker
+}
+
+// monitorWorker runs one monitor loop for its share of the tasks. The loop only runs while it has viewers.
+type monitorWorker struct {
+	taskManager *TaskManager
+	mu          sync.Mutex
+	tasks       map[string]*monitoredTask
+	running     bool // Whether the loop goroutine is active (protected by mu)
+}
+
+// newTaskMonitor creates a task monitor with the given number of workers (at least 1)
+func newTaskMonitor(taskManager *TaskManager, workers int) *taskMonitor {
+	workers = max(1, workers)
+	tm := &taskMonitor{taskManager: taskManager, workers: make([]*monitorWorker, workers)}
+	for i := range tm.workers {
+		tm.workers[i] = &monitorWorker{taskManager: taskManager, tasks: make(map[string]*monitoredTask)}
+	}
+	return tm
+}
+
+// worker returns the worker responsible for a task
+func (m *taskMonitor) worker(taskID string) *monitorWorker {
+	h := fnv.New32a()
+	h.Write([]byte(taskID))
+	return m.workers[h.Sum32()%uint32(len(m.workers))]
+}
+
+// Watch starts monitoring a task for a viewer. The viewer is dropped when its context is cancelled.
+// Returns the task's completion signal, closed once the process has ended.
+func (m *taskMonitor) Watch(v *taskViewer) <-chan struct{} {
+	return m.worker(v.taskID).add(v)
+}
+
+// add registers a viewer and starts the worker loop if it isn't running
+func (w *monitorWorker) add(v *taskViewer) <-chan struct{} {
+	v.nextHeartbeat = time.Now().Add(v.heartbeatInterval)
+	v.stall.threshold = v.stallTimeout
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	task, ok := w.tasks[v.taskID]
+	if !ok {
+		task = &monitoredTask{viewers: make(map[*taskViewer]bool), ended: make(chan struct{})}
+		w.tasks[v.taskID] = task
+	}
+	task.viewers[v] = true
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	return task.ended
+}
+
+// run checks all viewers of the worker's tasks each tick, until no viewers are left
+func (w *monitorWorker) run() {
+	ticker := time.NewTicker(monitorTickInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		w.mu.Lock()
+		now := time.Now()
+		for taskID, task := range w.tasks {
+			w.checkTask(taskID, task, now)
+			if len(task.viewers) == 0 {
+				delete(w.tasks, taskID)
+			}
+		}
+		if len(w.tasks) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+	}
+}
+
+// checkTask handles the viewers of a task for one tick. Called with w.mu held; blocking work
+// (completion, startup failure) runs in separate goroutines.
+func (w *monitorWorker) checkTask(taskID string, task *monitoredTask, now time.Time) {
+	tm := w.taskManager
+
+	// Liveness is checked once per task for all of its viewers
+	ended := false
+	select {
+	case <-task.ended:
+		ended = true
+	case <-tm.Exited(taskID):
+		ended = true
+	default:
+		// A completed task's PID may already belong to another process, don't check it again
+		if tm.IsCompleted(taskID) {
+			ended = true
+		} else if pid := w.anyPID(task); pid > 0 && !now.Before(task.nextLiveness) {
+			task.nextLiveness = now.Add(livenessCheckInterval)
+			if task.procStartTime == 0 {
+				task.procStartTime = tm.ProcessStartTime(taskID)
+			}
+			ended = !isTaskProcessRunning(pid, task.procStartTime)
+		}
+	}
+	if ended {
+		select {
+		case <-task.ended:
+		default:
+			close(task.ended)
+		}
+	}
+
+	for v := range task.viewers {
+		if v.ctx.Err() != nil {
+			delete(task.viewers, v)
+			continue
+		}
+
+		// A wrapper that ended before running the command is reported through its exit code
+		if !v.started && !ended {
+			if _, err := os.Stat(filepath.Join(v.outputDir, startedFileName)); err != nil {
+				if !now.Before(v.startTime.Add(v.startupTimeout)) {
+					delete(task.viewers, v)
+					go handleStartupFailure(v.safeConn, tm, taskID, v.outputDir, v.startupTimeout)
+				}
+				continue
+			}
+			v.started = true
+		}
+
+		if v.pid == 0 {
+			if v.pid = readPID(filepath.Join(v.outputDir, "pid")); v.pid == 0 {
+				continue
+			}
+			log.Printf("[MONITOR] Monitoring process PID=%d for task_id=%s", v.pid, taskID)
+			if v.maxExecutionTime > 0 {
+				v.timeoutAt = now.Add(v.maxExecutionTime)
+				log.Printf("[MONITOR] Max execution time set to %v for task_id=%s", v.maxExecutionTime, taskID)
+			}
+		}
+
+		if ended {
+			delete(task.viewers, v)
+			go finishViewer(v, tm)
+			continue
+		}
+
+		// Send heartbeats independently of the WebSocket pings, which the browser doesn't expose.
+		// Messages are written outside the loop, so a slow client doesn't hold up other tasks.
+		if !now.Before(v.nextHeartbeat) {
+			v.nextHeartbeat = now.Add(v.heartbeatInterval)
+			go sendHeartbeat(v.safeConn, now.Sub(v.startTime), v.counters)
+		}
+
+		if !v.timeoutAt.IsZero() && !now.Before(v.timeoutAt) {
+			// Max execution time exceeded
+			v.timeoutAt = time.Time{}
+			go handleTimeout(v.safeConn, tm, taskID, v.pid)
+		}
+
+		if v.stallTimeout > 0 {
+			lastBeat := lastHeartbeat(filepath.Join(v.outputDir, heartbeatFileName), v.startTime)
+			if v.stall.update(lastBeat, now) {
+				log.Printf("[MONITOR] Task stalled: task_id=%s, pid=%d, last_heartbeat=%v", taskID, v.pid, lastBeat)
+				go sendSystemMessage(v.safeConn, "stalled", fmt.Sprintf("Task stalled: no heartbeat for more than %v", v.stallTimeout), v.pid)
+			}
+		}
+	}
+}
+
+// anyPID returns the PID of the task as seen by one of its viewers (0 = not known yet)
+func (w *monitorWorker) anyPID(task *monitoredTask) int {
+	for v := range task.viewers {
+		if v.pid > 0 {
+			return v.pid
+		}
+	}
+	return 0
+}
+
+// finishViewer sends the completion message to a viewer of an ended task, closes the connection
+// and cleans up the task unless its output is retained
+func finishViewer(v *taskViewer, taskManager *TaskManager) {
+	// Process has ended, either retain the output or refuse new viewers before cleanup starts
+	retained := taskManager.MarkCompleted(v.taskID)
+	if !retained {
+		taskManager.MarkFinishing(v.taskID)
+	}
+
+	// Prefer the exit status from cmd.Wait, the exitcode file is missing if the wrapper was killed
+	exitCode, ok := taskManager.WaitExitCode(v.taskID, time.Second)
+	if !ok {
+		exitCode = readExitCode(filepath.Join(v.outputDir, "exitcode"))
+	}
+
+	// Let the tailers stream the remaining output so the byte counts are final
+	waitForStreams(v.counters, existingOutputFile(v.outputDir, "stdout"), existingOutputFile(v.outputDir, "stderr"), 2*time.Second)
+
+	// Send completion message
+	msg := fmt.Sprintf("Process ended with exit code: %d", exitCode)
+	sendCompletionMessage(v.safeConn, msg, v.pid, exitCode, v.counters)
+	log.Printf("[MONITOR] Process ended: task_id=%s, pid=%d, exit_code=%d, stdout_bytes=%d, stderr_bytes=%d", v.taskID, v.pid, exitCode, v.counters.stdout.Load(), v.counters.stderr.Load())
+
+	// Wait a bit for final output to be written and message to be sent
+	time.Sleep(2 * time.Second)
+
+	if retained {
+		// Output is removed by the task manager once the retention window expires
+		v.safeConn.mu.Lock()
+		v.safeConn.conn.Close()
+		v.safeConn.mu.Unlock()
+		return
+	}
+
+	// Remove task from manager
+	taskManager.mu.Lock()
+	task := taskManager.runningTasks[v.taskID]
+	delete(taskManager.runningTasks, v.taskID)
+	taskManager.mu.Unlock()
+
+	// Close WebSocket connection (client should have closed it already, but close it here too)
+	v.safeConn.mu.Lock()
+	v.safeConn.conn.Close()
+	v.safeConn.mu.Unlock()
+
+	// Cleanup: remove task directory (after connection is closed)
+	time.Sleep(1 * time.Second)
+	if task != nil {
+		waitArchived(task)
+	}
+	if err := taskManager.removeOutputDir(v.outputDir); err != nil {
+		log.Printf("[MONITOR] Failed to cleanup directory %s: %v", v.outputDir, err)
+	} else {
+		log.Printf("[MONITOR] Cleaned up directory: %s", v.outputDir)
+	}
+}
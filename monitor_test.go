@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// countGoroutines returns the number of goroutines whose stack contains fn
+func countGoroutines(fn string) int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	count := 0
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, fn) {
+			count++
+		}
+	}
+	return count
+}
+
+// monitoredTaskCount returns the number of tasks tracked by all workers of the monitor
+func monitoredTaskCount(m *taskMonitor) int {
+	count := 0
+	for _, w := range m.workers {
+		w.mu.Lock()
+		count += len(w.tasks)
+		w.mu.Unlock()
+	}
+	return count
+}
+
+func TestTaskMonitorGoroutines(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "monitor-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "sleep-task", Command: "sleep 30"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	const numTasks = 10
+	taskIDs := make([]string, numTasks)
+	for i := range taskIDs {
+		taskID, err := taskManager.StartTask("sleep-task", nil)
+		if err != nil {
+			t.Fatalf("StartTask() = %v", err)
+		}
+		taskIDs[i] = taskID
+		t.Cleanup(func() {
+			if pid := readPID(filepath.Join(tmpDir, taskID, "pid")); pid > 0 {
+				syscall.Kill(-pid, syscall.SIGKILL)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, taskManager, config, createUpgrader(nil, false), NewWebSocketManager())
+	}))
+	defer server.Close()
+
+	before := runtime.NumGoroutine()
+	conns := make([]*websocket.Conn, 0, numTasks)
+	for _, taskID := range taskIDs {
+		token, err := generateViewerToken(taskID, config.Auth.Secret, time.Hour)
+		if err != nil {
+			t.Fatalf("generateViewerToken() = %v", err)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?task_id="+taskID+"&token="+token, nil)
+		if err != nil {
+			t.Fatalf("Dial() = %v", err)
+		}
+		conns = append(conns, conn)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for monitoredTaskCount(taskManager.monitor) < numTasks && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := monitoredTaskCount(taskManager.monitor); got != numTasks {
+		t.Fatalf("monitored tasks = %d; want %d", got, numTasks)
+	}
+
+	// All viewers are watched by a single monitor loop instead of one goroutine each
+	if got := countGoroutines("(*monitorWorker).run"); got != 1 {
+		t.Errorf("monitor goroutines with %d viewers = %d; want 1", numTasks, got)
+	}
+	// Per viewer: the handler, its reader and the stdout/stderr tailers
+	growth := runtime.NumGoroutine() - before
+	t.Logf("goroutines per viewer: %.1f", float64(growth)/numTasks)
+	if want := 4*numTasks + 1; growth > want {
+		t.Errorf("goroutine growth with %d viewers = %d; want at most %d", numTasks, growth, want)
+	}
+
+	// The loop ends once all viewers have disconnected
+	for _, conn := range conns {
+		conn.Close()
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for countGoroutines("(*monitorWorker).run") > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := countGoroutines("(*monitorWorker).run"); got != 0 {
+		t.Errorf("monitor goroutines after viewers disconnected = %d; want 0", got)
+	}
+}
+
+func TestTaskMonitorWorker(t *testing.T) {
+	m := newTaskMonitor(NewTaskManager(&Config{}), 4)
+	if len(m.workers) != 4 {
+		t.Fatalf("newTaskMonitor(4) workers = %d; want 4", len(m.workers))
+	}
+
+	used := make(map[*monitorWorker]bool)
+	for i := 0; i < 100; i++ {
+		taskID := uuid.New().String()
+		w := m.worker(taskID)
+		if m.worker(taskID) != w {
+			t.Fatalf("taskMonitor.worker(%s) is not stable", taskID)
+		}
+		used[w] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("100 tasks assigned to %d workers; want them spread over several", len(used))
+	}
+
+	if m := newTaskMonitor(NewTaskManager(&Config{}), 0); len(m.workers) != 1 {
+		t.Errorf("newTaskMonitor(0) workers = %d; want 1", len(m.workers))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// isOriginAllowed checks the Origin header value against the allowed origins.
+// Entries are exact origins ("https://example.com") or patterns with a wildcard subdomain
+// and/or port ("https://*.example.com", "http://localhost:*").
+// An empty list allows all origins, unless denyIfEmpty is set.
+func isOriginAllowed(origin string, allowedOrigins []string, denyIfEmpty bool) bool {
+	if len(allowedOrigins) == 0 {
+		return !denyIfEmpty
+	}
+	for _, allowed := range allowedOrigins {
+		if matchOrigin(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin reports whether origin matches a single allowed origin or pattern.
+// Scheme and host are compared case-insensitively. "*." only matches subdomains
+// (https://*.example.com does not match https://example.com), a pattern without port
+// only matches origins without port.
+func matchOrigin(origin, pattern string) bool {
+	if origin == "" {
+		return false
+	}
+	if strings.EqualFold(origin, pattern) {
+		return true
+	}
+	if !strings.Contains(pattern, "*") {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+		return false
+	}
+
+	scheme, hostPort, ok := strings.Cut(pattern, "://")
+	if !ok || !strings.EqualFold(u.Scheme, scheme) {
+		return false
+	}
+	patternHost, patternPort := hostPort, ""
+	if strings.Contains(hostPort, ":") {
+		patternHost, patternPort, err = net.SplitHostPort(hostPort)
+		if err != nil {
+			return false
+		}
+	}
+
+	if patternPort != "*" && u.Port() != patternPort {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	patternHost = strings.ToLower(patternHost)
+	if suffix, ok := strings.CutPrefix(patternHost, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix) && len(host) > len(suffix)+1
+	}
+	return host == patternHost
+}
+
+// withTaskOrigins returns the upgrader for a /ws connection to a task with its own allowed_origins:
+// those origins are accepted in addition to the ones passing the upgrader's (global) origin check
+func withTaskOrigins(upgrader websocket.Upgrader, taskOrigins []string) websocket.Upgrader {
+	if len(taskOrigins) == 0 {
+		return upgrader
+	}
+	check := upgrader.CheckOrigin
+	if check == nil {
+		check = isSameOrigin // The upgrader's default check
+	}
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		return isOriginAllowed(r.Header.Get("Origin"), taskOrigins, true) || check(r)
+	}
+	return upgrader
+}
+
+// isSameOrigin reports whether a request has no Origin header or one matching its Host,
+// the origin check of a websocket.Upgrader without CheckOrigin
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// requestOrigin returns the Origin header, falling back to the origin of the Referer header
+// (some browsers omit Origin on same-origin requests). Returns "" if neither is present.
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		return origin
+	}
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		return ""
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "null" // Never matches an allowed origin
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// OriginCheckMiddleware rejects cross-origin browser requests to state-changing endpoints (CSRF protection).
+// Requests whose Origin (or Referer) is not in allowedOrigins get 403. Requests without either header
+// are let through, as they don't come from a browser page (e.g. curl or backend clients).
+// The check is skipped if it is disabled or allowedOrigins is empty.
+func OriginCheckMiddleware(handler http.HandlerFunc, allowedOrigins []string, enabled bool) http.HandlerFunc {
+	if !enabled || len(allowedOrigins) == 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := requestOrigin(r)
+		if origin != "" && !isOriginAllowed(origin, allowedOrigins, false) {
+			log.Printf("[API] Rejected request from %s with disallowed origin %q", r.RemoteAddr, origin)
+			sendJSONError(w, http.StatusForbidden, ErrCodeForbidden, "Forbidden: origin not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// corsPreflightMaxAge is how long browsers may cache a CORS preflight response, in seconds
+const corsPreflightMaxAge = "600"
+
+// corsAllowedHeaders are the request headers browser clients may send to the JSON API,
+// including the header of auth.api_header_secret
+const corsAllowedHeaders = "Authorization, Content-Type, " + APISecretHeader
+
+// CORSMiddleware adds CORS headers for browser clients on origins in allowedOrigins (e.g. dashboards
+// on another origin calling the JSON API). Preflight requests (OPTIONS with Access-Control-Request-Method)
+// are answered directly, with the Authorization and Content-Type headers allowed. Requests from other
+// origins get no CORS headers, so the browser blocks the response.
+// CORS is disabled if allowedOrigins is empty.
+func CORSMiddleware(handler http.HandlerFunc, allowedOrigins []string) http.HandlerFunc {
+	if len(allowedOrigins) == 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			handler(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := isOriginAllowed(origin, allowedOrigins, false)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				log.Printf("[API] Rejected CORS preflight from %s with disallowed origin %q", r.RemoteAddr, origin)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsPreflightMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		pattern string
+		want    bool
+	}{
+		{"exact match", "https://example.com", "https://example.com", true},
+		{"exact match different case", "https://Example.com", "https://example.com", true},
+		{"exact match with port", "http://localhost:8080", "http://localhost:8080", true},
+		{"exact different port", "http://localhost:9090", "http://localhost:8080", false},
+		{"exact different scheme", "http://example.com", "https://example.com", false},
+		{"wildcard subdomain", "https://app.example.com", "https://*.example.com", true},
+		{"wildcard nested subdomain", "https://a.b.example.com", "https://*.example.com", true},
+		{"wildcard uppercase origin", "https://APP.Example.com", "https://*.example.com", true},
+		{"wildcard does not match apex", "https://example.com", "https://*.example.com", false},
+		{"wildcard suffix attack", "https://evilexample.com", "https://*.example.com", false},
+		{"wildcard other domain", "https://example.com.evil.com", "https://*.example.com", false},
+		{"wildcard wrong scheme", "http://app.example.com", "https://*.example.com", false},
+		{"wildcard rejects port", "https://app.example.com:8443", "https://*.example.com", false},
+		{"wildcard with port", "https://app.example.com:8443", "https://*.example.com:8443", true},
+		{"wildcard with other port", "https://app.example.com:9443", "https://*.example.com:8443", false},
+		{"wildcard port", "http://localhost:3000", "http://localhost:*", true},
+		{"wildcard port without port", "http://localhost", "http://localhost:*", true},
+		{"wildcard port other host", "http://127.0.0.1:3000", "http://localhost:*", false},
+		{"wildcard subdomain and port", "https://app.example.com:1234", "https://*.example.com:*", true},
+		{"origin with path", "https://app.example.com/x", "https://*.example.com", false},
+		{"empty origin", "", "https://*.example.com", false},
+		{"null origin", "null", "https://*.example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchOrigin(tt.origin, tt.pattern); got != tt.want {
+				t.Errorf("matchOrigin(%q, %q) = %v; want %v", tt.origin, tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsOriginAllowed(t *testing.T) {
+	allowed := []string{"https://example.com", "https://*.example.org"}
+
+	tests := []struct {
+		name           string
+		origin         string
+		allowedOrigins []string
+		denyIfEmpty    bool
+		want           bool
+	}{
+		{"empty list allows all", "https://evil.com", nil, false, true},
+		{"empty list denies all when configured", "https://evil.com", nil, true, false},
+		{"empty list denies missing origin when configured", "", nil, true, false},
+		{"exact entry", "https://example.com", allowed, false, true},
+		{"wildcard entry", "https://app.example.org", allowed, false, true},
+		{"no matching entry", "https://evil.com", allowed, false, false},
+		{"missing origin with list", "", allowed, false, false},
+		{"deny flag ignored for non-empty list", "https://example.com", allowed, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOriginAllowed(tt.origin, tt.allowedOrigins, tt.denyIfEmpty); got != tt.want {
+				t.Errorf("isOriginAllowed(%q, %v, %v) = %v; want %v", tt.origin, tt.allowedOrigins, tt.denyIfEmpty, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginCheckMiddleware(t *testing.T) {
+	allowed := []string{"https://app.example.com"}
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		origins    []string
+		origin     string
+		referer    string
+		wantStatus int
+	}{
+		{"enabled allowed origin", true, allowed, "https://app.example.com", "", http.StatusOK},
+		{"enabled disallowed origin", true, allowed, "https://evil.com", "", http.StatusForbidden},
+		{"enabled missing origin", true, allowed, "", "", http.StatusOK},
+		{"enabled allowed referer", true, allowed, "", "https://app.example.com/page?x=1", http.StatusOK},
+		{"enabled disallowed referer", true, allowed, "", "https://evil.com/page", http.StatusForbidden},
+		{"enabled origin takes precedence over referer", true, allowed, "https://evil.com", "https://app.example.com/", http.StatusForbidden},
+		{"enabled empty list", true, nil, "https://evil.com", "", http.StatusOK},
+		{"disabled disallowed origin", false, allowed, "https://evil.com", "", http.StatusOK},
+		{"disabled missing origin", false, allowed, "", "", http.StatusOK},
+		{"disabled allowed origin", false, allowed, "https://app.example.com", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := OriginCheckMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			}, tt.origins, tt.enabled)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/start", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.referer != "" {
+				req.Header.Set("Referer", tt.referer)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v; want %v", called, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	allowed := []string{"https://dashboard.example.com"}
+
+	tests := []struct {
+		name          string
+		origins       []string
+		method        string
+		origin        string
+		preflight     bool
+		wantStatus    int
+		wantAllowed   bool
+		wantCalled    bool
+		wantPreflight bool
+	}{
+		{"preflight allowed origin", allowed, http.MethodOptions, "https://dashboard.example.com", true, http.StatusNoContent, true, false, true},
+		{"preflight disallowed origin", allowed, http.MethodOptions, "https://evil.com", true, http.StatusForbidden, false, false, false},
+		{"simple GET allowed origin", allowed, http.MethodGet, "https://dashboard.example.com", false, http.StatusOK, true, true, false},
+		{"simple POST allowed origin", allowed, http.MethodPost, "https://dashboard.example.com", false, http.StatusOK, true, true, false},
+		{"simple POST disallowed origin", allowed, http.MethodPost, "https://evil.com", false, http.StatusOK, false, true, false},
+		{"same-origin request without Origin", allowed, http.MethodGet, "", false, http.StatusOK, false, true, false},
+		{"OPTIONS without preflight header", allowed, http.MethodOptions, "https://dashboard.example.com", false, http.StatusOK, true, true, false},
+		{"disabled with empty list", nil, http.MethodOptions, "https://dashboard.example.com", true, http.StatusOK, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := CORSMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			}, tt.origins)
+
+			req := httptest.NewRequest(tt.method, "/api/start", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v; want %v", called, tt.wantCalled)
+			}
+			gotOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.wantAllowed && gotOrigin != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q; want %q", gotOrigin, tt.origin)
+			}
+			if !tt.wantAllowed && gotOrigin != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q; want none", gotOrigin)
+			}
+			allowHeaders := w.Header().Get("Access-Control-Allow-Headers")
+			if tt.wantPreflight && allowHeaders != "Authorization, Content-Type, X-API-Secret" {
+				t.Errorf("Access-Control-Allow-Headers = %q; want %q", allowHeaders, "Authorization, Content-Type, X-API-Secret")
+			}
+			if !tt.wantPreflight && allowHeaders != "" {
+				t.Errorf("Access-Control-Allow-Headers = %q; want none", allowHeaders)
+			}
+		})
+	}
+}
+
+func TestCORSMiddlewareAPISecretPreflight(t *testing.T) {
+	handler := CORSMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called for preflight")
+	}, []string{"https://dashboard.example.com"})
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/start", nil)
+	req.Header.Set("Origin", "https://dashboard.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "content-type, x-api-secret")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d; want %d", w.Code, http.StatusNoContent)
+	}
+	// Browsers compare the requested headers case-insensitively against the allowed ones
+	allowed := make(map[string]bool)
+	for _, name := range strings.Split(w.Header().Get("Access-Control-Allow-Headers"), ",") {
+		allowed[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	for _, name := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+		if !allowed[strings.TrimSpace(name)] {
+			t.Errorf("Access-Control-Allow-Headers = %q; want %s allowed", w.Header().Get("Access-Control-Allow-Headers"), name)
+		}
+	}
+}
+
+func TestWithTaskOrigins(t *testing.T) {
+	base := createUpgrader([]string{"https://viewer.example.com"}, false)
+	if got := withTaskOrigins(base, nil); got.CheckOrigin == nil {
+		t.Fatal("withTaskOrigins(nil) removed CheckOrigin")
+	}
+
+	upgrader := withTaskOrigins(base, []string{"https://embed.example.com"})
+	defaultCheck := withTaskOrigins(websocket.Upgrader{}, []string{"https://embed.example.com"})
+	tests := []struct {
+		origin      string
+		want        bool
+		wantDefault bool // Upgrader without CheckOrigin (same-origin check)
+	}{
+		{"https://embed.example.com", true, true},
+		{"https://viewer.example.com", true, false},
+		{"http://localhost:8080", false, true}, // Same origin as the request
+		{"https://evil.example.com", false, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost:8080/ws", nil)
+		req.Header.Set("Origin", tt.origin)
+		if got := upgrader.CheckOrigin(req); got != tt.want {
+			t.Errorf("CheckOrigin(%q) = %v; want %v", tt.origin, got, tt.want)
+		}
+		if got := defaultCheck.CheckOrigin(req); got != tt.wantDefault {
+			t.Errorf("default CheckOrigin(%q) = %v; want %v", tt.origin, got, tt.wantDefault)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultPreCheckTimeout bounds a task's pre_check command if pre_check_timeout is not set.
+// /api/start waits for the precheck, so it stays below the default write timeout.
+const defaultPreCheckTimeout = 10 * time.Second
+
+// maxPreCheckOutput caps the precheck output included in the error of a rejected start
+const maxPreCheckOutput = 1024
+
+// preCheckTimeout returns the time limit of a task's pre_check command
+func preCheckTimeout(taskConfig *TaskConfig) time.Duration {
+	if taskConfig.PreCheckTimeout > 0 {
+		return time.Duration(taskConfig.PreCheckTimeout) * time.Second
+	}
+	return defaultPreCheckTimeout
+}
+
+// runPreCheck runs a task's pre_check command with the task shell and parameter environment in
+// the task's output directory and waits for it. A non-zero exit, a timeout or a failure to run
+// the command return an ErrPreCheckFailed error including the end of its combined output.
+func (tm *TaskManager) runPreCheck(command string, env []string, dir string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, tm.shell(), "-c", command)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	// Own process group, so that a timeout also kills the processes started by the command
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Don't wait for the output of processes that escaped the group
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: timed out after %v%s", ErrPreCheckFailed, timeout, preCheckOutput(output))
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w: exit code %d%s", ErrPreCheckFailed, processExitCode(exitErr.ProcessState), preCheckOutput(output))
+	}
+	return fmt.Errorf("%w: %v", ErrPreCheckFailed, err)
+}
+
+// preCheckOutput formats the end of a precheck's output for an error message ("" if empty)
+func preCheckOutput(output []byte) string {
+	text := strings.TrimSpace(string(output))
+	if text == "" {
+		return ""
+	}
+	if len(text) > maxPreCheckOutput {
+		text = "..." + text[len(text)-maxPreCheckOutput:]
+	}
+	return fmt.Sprintf(" (output: %s)", text)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ProgressMessage reports the progress of a task, parsed from an output line starting with the
+// task's progress_prefix
+type ProgressMessage struct {
+	Type    string  `json:"type"` // Always "progress"
+	Percent float64 `json:"percent"`
+}
+
+// taskProgressPrefix returns the progress_prefix of a task (empty = disabled, also for unknown tasks)
+func taskProgressPrefix(taskConfig *TaskConfig) string {
+	if taskConfig == nil {
+		return ""
+	}
+	return taskConfig.ProgressPrefix
+}
+
+// parseProgressLine parses an output line (without newline) starting with prefix, followed by a
+// percentage between 0 and 100 with an optional "%" (e.g. "PROGRESS: 42" or "PROGRESS: 42.5%").
+// Returns false if the line is regular output.
+func parseProgressLine(prefix, line string) (ProgressMessage, bool) {
+	if prefix == "" {
+		return ProgressMessage{}, false
+	}
+	rest, ok := strings.CutPrefix(line, prefix)
+	if !ok {
+		return ProgressMessage{}, false
+	}
+	value := strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(rest, "\r")), "%")
+	percent, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || !(percent >= 0 && percent <= 100) { // Also rejects NaN
+		return ProgressMessage{}, false
+	}
+	return ProgressMessage{Type: "progress", Percent: percent}, true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	tests := []struct {
+		prefix      string
+		line        string
+		wantOK      bool
+		wantPercent float64
+	}{
+		{"PROGRESS:", "PROGRESS: 42", true, 42},
+		{"PROGRESS:", "PROGRESS:42", true, 42},
+		{"PROGRESS:", "PROGRESS: 42.5%", true, 42.5},
+		{"PROGRESS:", "PROGRESS: 100 %", true, 100},
+		{"PROGRESS:", "PROGRESS: 0\r", true, 0},
+		{"PROGRESS:", "PROGRESS: 101", false, 0},
+		{"PROGRESS:", "PROGRESS: -1", false, 0},
+		{"PROGRESS:", "PROGRESS: NaN", false, 0},
+		{"PROGRESS:", "PROGRESS: half", false, 0},
+		{"PROGRESS:", "PROGRESS:", false, 0},
+		{"PROGRESS:", "regular output", false, 0},
+		{"PROGRESS:", "  PROGRESS: 42", false, 0},
+		{"", "PROGRESS: 42", false, 0},
+	}
+	for _, tt := range tests {
+		progress, ok := parseProgressLine(tt.prefix, tt.line)
+		if ok != tt.wantOK {
+			t.Errorf("parseProgressLine(%q, %q) ok = %v; want %v", tt.prefix, tt.line, ok, tt.wantOK)
+			continue
+		}
+		if ok && (progress.Type != "progress" || progress.Percent != tt.wantPercent) {
+			t.Errorf("parseProgressLine(%q, %q) = %+v; want progress %v", tt.prefix, tt.line, progress, tt.wantPercent)
+		}
+	}
+}
+
+func TestHandleWebSocketProgress(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{
+				Name:           "progress-task",
+				Command:        "echo starting; echo 'PROGRESS: 50'; echo 'PROGRESS: 100%' >&2; echo 'PROGRESS: done'",
+				ProgressPrefix: "PROGRESS:",
+			},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("progress-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	conn, cleanup := dialTestWebSocketQuery(t, taskManager, config, NewWebSocketManager(), taskID, "")
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	// Collect stdout and progress messages until the completion message, stderr is tailed concurrently
+	var stdout, progress []string
+	var completion SystemMessage
+	for completion.StdoutBytes == nil {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before completion message", err)
+		}
+		var msg struct {
+			Type    string   `json:"type"`
+			Data    string   `json:"data"`
+			Percent *float64 `json:"percent"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("json.Unmarshal(%q) = %v", data, err)
+		}
+		switch msg.Type {
+		case "stdout":
+			stdout = append(stdout, strings.TrimSuffix(msg.Data, "\n"))
+		case "stderr":
+			t.Errorf("progress line sent as stderr output: %q", msg.Data)
+		case "progress":
+			if msg.Percent == nil {
+				t.Fatalf("progress message %s has no percent", data)
+			}
+			progress = append(progress, strconv.FormatFloat(*msg.Percent, 'f', -1, 64))
+		case "system":
+			json.Unmarshal(data, &completion)
+		}
+	}
+
+	// Malformed progress lines stay regular output
+	if want := []string{"starting", "PROGRESS: done"}; strings.Join(stdout, "|") != strings.Join(want, "|") {
+		t.Errorf("stdout = %q; want %q", stdout, want)
+	}
+	if len(progress) != 2 || !strings.Contains(strings.Join(progress, "|"), "50") || !strings.Contains(strings.Join(progress, "|"), "100") {
+		t.Errorf("progress = %q; want 50 and 100", progress)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Protocols for server.protocols
+const (
+	ProtocolHTTP1 = "http1" // HTTP/1.1, required for WebSocket upgrades
+	ProtocolHTTP2 = "http2" // HTTP/2 over TLS, negotiated via ALPN
+	ProtocolH2C   = "h2c"   // HTTP/2 over cleartext TCP (prior knowledge or Upgrade: h2c)
+)
+
+// defaultProtocols is used if server.protocols is not set, matching the net/http defaults
+var defaultProtocols = []string{ProtocolHTTP1, ProtocolHTTP2}
+
+// validateProtocols checks server.protocols: known names without duplicates, including http1
+// as the WebSocket endpoint requires an HTTP/1.1 upgrade
+func validateProtocols(protocols []string) error {
+	seen := make(map[string]bool, len(protocols))
+	for _, protocol := range protocols {
+		switch protocol {
+		case ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C:
+		default:
+			return fmt.Errorf("unknown protocol %q (must be %q, %q or %q)", protocol, ProtocolHTTP1, ProtocolHTTP2, ProtocolH2C)
+		}
+		if seen[protocol] {
+			return fmt.Errorf("duplicate protocol %q", protocol)
+		}
+		seen[protocol] = true
+	}
+	if !seen[ProtocolHTTP1] {
+		return fmt.Errorf("%q is required for WebSocket connections", ProtocolHTTP1)
+	}
+	return nil
+}
+
+// hasProtocol reports whether a protocol is enabled, an empty list enables the defaultProtocols
+func hasProtocol(protocols []string, protocol string) bool {
+	for _, p := range enabledProtocols(protocols) {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledProtocols returns the configured protocols, or the defaultProtocols if none are configured
+func enabledProtocols(protocols []string) []string {
+	if len(protocols) == 0 {
+		return defaultProtocols
+	}
+	return protocols
+}
+
+// tlsNextProtos returns the ALPN protocols offered to TLS clients in order of preference
+func tlsNextProtos(protocols []string) []string {
+	if hasProtocol(protocols, ProtocolHTTP2) {
+		return []string{"h2", "http/1.1"}
+	}
+	return []string{"http/1.1"}
+}
+
+// configureProtocols applies server.protocols to the server. Must be called after the handler and
+// the TLS config have been set; other ALPN protocols already in the TLS config (e.g. for ACME) are kept.
+func configureProtocols(server *http.Server, protocols []string) {
+	if !hasProtocol(protocols, ProtocolHTTP2) {
+		// A non-nil, empty map keeps net/http from enabling HTTP/2 for TLS connections
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	if server.TLSConfig != nil {
+		server.TLSConfig.NextProtos = append(tlsNextProtos(protocols), server.TLSConfig.NextProtos...)
+	}
+	if hasProtocol(protocols, ProtocolH2C) {
+		// Requests without h2c preface or upgrade (including WebSocket upgrades) are passed through unchanged
+		server.Handler = h2c.NewHandler(server.Handler, &http2.Server{IdleTimeout: server.IdleTimeout})
+	}
+}
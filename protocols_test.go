@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
+)
+
+func TestValidateProtocols(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string
+		wantErr   string
+	}{
+		{"defaults", defaultProtocols, ""},
+		{"http1 only", []string{"http1"}, ""},
+		{"all", []string{"http1", "http2", "h2c"}, ""},
+		{"unknown", []string{"http1", "http3"}, "unknown protocol"},
+		{"duplicate", []string{"http1", "http1"}, "duplicate protocol"},
+		{"without http1", []string{"http2"}, "required for WebSocket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateProtocols(tt.protocols)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateProtocols(%v) = %v; want nil", tt.protocols, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateProtocols(%v) = %v; want error containing %q", tt.protocols, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSNextProtos(t *testing.T) {
+	tests := []struct {
+		protocols []string
+		want      []string
+	}{
+		{nil, []string{"h2", "http/1.1"}},
+		{[]string{"http1"}, []string{"http/1.1"}},
+		{[]string{"http1", "h2c"}, []string{"http/1.1"}},
+		{[]string{"http1", "http2"}, []string{"h2", "http/1.1"}},
+	}
+	for _, tt := range tests {
+		if got := tlsNextProtos(tt.protocols); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tlsNextProtos(%v) = %v; want %v", tt.protocols, got, tt.want)
+		}
+	}
+}
+
+func TestConfigureProtocolsKeepsNextProtos(t *testing.T) {
+	server := &http.Server{TLSConfig: &tls.Config{NextProtos: []string{"acme-tls/1"}}}
+	configureProtocols(server, nil)
+	want := []string{"h2", "http/1.1", "acme-tls/1"}
+	if !reflect.DeepEqual(server.TLSConfig.NextProtos, want) {
+		t.Errorf("NextProtos = %v; want %v", server.TLSConfig.NextProtos, want)
+	}
+}
+
+func TestConfigureProtocolsTLS(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "protocols-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	certPath := filepath.Join(tmpDir, "fullchain.pem")
+	keyPath := filepath.Join(tmpDir, "key.pem")
+	writeTestServerCertificate(t, certPath, keyPath, 1)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		protocols []string
+		wantProto string
+		wantALPN  string
+	}{
+		{"defaults", nil, "HTTP/2.0", "h2"},
+		{"http1 and http2", []string{"http1", "http2"}, "HTTP/2.0", "h2"},
+		{"http1 only", []string{"http1"}, "HTTP/1.1", "http/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+			server := newHTTPServer(listener.Addr().String(), handler, ServerConfig{Protocols: tt.protocols})
+			server.TLSConfig = buildServerTLSConfig(nil, func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil })
+			configureProtocols(server, tt.protocols)
+			go server.ServeTLS(listener, "", "")
+			defer server.Close()
+
+			// The client offers both h2 and http/1.1, the server picks from its configured set
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			}}
+			resp, err := client.Get("https://" + listener.Addr().String() + "/")
+			if err != nil {
+				t.Fatalf("GET = %v", err)
+			}
+			resp.Body.Close()
+			if resp.Proto != tt.wantProto {
+				t.Errorf("Proto = %q; want %q", resp.Proto, tt.wantProto)
+			}
+			if resp.TLS.NegotiatedProtocol != tt.wantALPN {
+				t.Errorf("NegotiatedProtocol = %q; want %q", resp.TLS.NegotiatedProtocol, tt.wantALPN)
+			}
+		})
+	}
+}
+
+func TestConfigureProtocolsH2C(t *testing.T) {
+	upgrader := createUpgrader(nil, false)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(websocket.TextMessage, []byte(r.Proto))
+			conn.Close()
+			return
+		}
+		w.Write([]byte(r.Proto))
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.Config = newHTTPServer("", handler, ServerConfig{})
+	configureProtocols(server.Config, []string{"http1", "h2c"})
+	server.Start()
+	defer server.Close()
+
+	// HTTP/2 with prior knowledge over cleartext TCP
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get(server.URL + "/")
+	if err != nil {
+		t.Fatalf("GET = %v", err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("Proto = %q; want %q", resp.Proto, "HTTP/2.0")
+	}
+
+	// WebSocket upgrades are still served over HTTP/1.1
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial = %v", err)
+	}
+	defer conn.Close()
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() = %v", err)
+	}
+	if string(message) != "HTTP/1.1" {
+		t.Errorf("WebSocket request Proto = %q; want %q", message, "HTTP/1.1")
+	}
+}
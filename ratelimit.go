@@ -1,23 +1,34 @@
 package main
 
 import (
+	"container/list"
 	"net/http"
+	"net/netip"
+	"strings"
 	"sync"
 	"time"
 )
 
+// defaultRateLimitMaxBuckets bounds the tracked clients if server.rate_limit_max_buckets is not set
+const defaultRateLimitMaxBuckets = 100000
+
 // RateLimiter implements a simple token bucket rate limiter per IP
 type RateLimiter struct {
 	requestsPerMinute int
 	buckets           map[string]*bucket
+	lru               *list.List // Bucket keys, most recently used first
+	maxBuckets        int        // Least recently used buckets are evicted beyond this number
 	mu                sync.Mutex
 	cleanupInterval   time.Duration
 	lastCleanup       time.Time
+	ipv4Prefix        int // Bucket IPv4 clients by this prefix length (0 = per address)
+	ipv6Prefix        int // Bucket IPv6 clients by this prefix length (0 = per address)
 }
 
 type bucket struct {
 	tokens     int
 	lastRefill time.Time
+	elem       *list.Element // Position in RateLimiter.lru
 }
 
 // NewRateLimiter creates a new rate limiter
@@ -25,6 +36,8 @@ func NewRateLimiter(requestsPerMinute int) *RateLimiter {
 	rl := &RateLimiter{
 		requestsPerMinute: requestsPerMinute,
 		buckets:           make(map[string]*bucket),
+		lru:               list.New(),
+		maxBuckets:        defaultRateLimitMaxBuckets,
 		cleanupInterval:   5 * time.Minute,
 		lastCleanup:       time.Now(),
 	}
@@ -35,6 +48,42 @@ func NewRateLimiter(requestsPerMinute int) *RateLimiter {
 	return rl
 }
 
+// SetSubnetPrefixes makes clients within the same IPv4/IPv6 subnet share a bucket,
+// e.g. 64 for IPv6, where a single client can rotate through the addresses of its /64.
+// A prefix length of 0 keeps one bucket per address.
+func (rl *RateLimiter) SetSubnetPrefixes(ipv4Prefix, ipv6Prefix int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.ipv4Prefix = ipv4Prefix
+	rl.ipv6Prefix = ipv6Prefix
+}
+
+// SetMaxBuckets bounds the number of tracked clients (buckets), so that requests from many
+// different addresses can't grow the limiter without bound between cleanups. When the limit is
+// reached, the least recently used bucket is evicted; that client starts over with a full bucket.
+// 0 keeps the default.
+func (rl *RateLimiter) SetMaxBuckets(maxBuckets int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if maxBuckets == 0 {
+		maxBuckets = defaultRateLimitMaxBuckets
+	}
+	rl.maxBuckets = maxBuckets
+	for len(rl.buckets) > rl.maxBuckets {
+		rl.evictOldest()
+	}
+}
+
+// evictOldest removes the least recently used bucket (caller must hold mu)
+func (rl *RateLimiter) evictOldest() {
+	oldest := rl.lru.Back()
+	if oldest == nil {
+		return
+	}
+	rl.lru.Remove(oldest)
+	delete(rl.buckets, oldest.Value.(string))
+}
+
 // cleanup removes old buckets periodically
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(rl.cleanupInterval)
@@ -46,6 +95,7 @@ func (rl *RateLimiter) cleanup() {
 		for ip, b := range rl.buckets {
 			// Remove buckets older than 10 minutes
 			if now.Sub(b.lastRefill) > 10*time.Minute {
+				rl.lru.Remove(b.elem)
 				delete(rl.buckets, ip)
 			}
 		}
@@ -88,24 +138,60 @@ func (rl *RateLimiter) getIP(r *http.Request) string {
 	return ip
 }
 
+// bucketKey returns the bucket of a client IP as returned by getIP. With subnet prefixes
+// configured, the address is masked to its subnet; unparseable values are used as is.
+func (rl *RateLimiter) bucketKey(ip string) string {
+	if rl.ipv4Prefix == 0 && rl.ipv6Prefix == 0 {
+		return ip
+	}
+	// X-Forwarded-For may contain a list, the first entry is the client
+	first, _, _ := strings.Cut(ip, ",")
+	addr, err := netip.ParseAddr(strings.Trim(strings.TrimSpace(first), "[]"))
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+
+	bits := rl.ipv6Prefix
+	if addr.Is4() {
+		bits = rl.ipv4Prefix
+	}
+	if bits == 0 {
+		return addr.String()
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
 // Allow checks if a request should be allowed
 func (rl *RateLimiter) Allow(r *http.Request) bool {
 	if rl.requestsPerMinute <= 0 {
 		return true // Rate limiting disabled
 	}
 	
-	ip := rl.getIP(r)
 	now := time.Now()
 	
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 	
+	ip := rl.bucketKey(rl.getIP(r))
+	
 	b, exists := rl.buckets[ip]
-	if !exists {
+	if exists {
+		rl.lru.MoveToFront(b.elem)
+	} else {
+		// Make room by evicting the least recently used client
+		for len(rl.buckets) >= rl.maxBuckets {
+			rl.evictOldest()
+		}
 		// Create new bucket with full tokens
 		b = &bucket{
 			tokens:     rl.requestsPerMinute,
 			lastRefill: now,
+			elem:       rl.lru.PushFront(ip),
 		}
 		rl.buckets[ip] = b
 	}
@@ -137,13 +223,23 @@ func (rl *RateLimiter) Allow(r *http.Request) bool {
 	return false
 }
 
+// routeRateLimiter returns the rate limiter of a route: a separate limiter with its own budget if
+// routeRPM is set, otherwise the limiter shared by all routes without their own limit
+func routeRateLimiter(shared *RateLimiter, routeRPM *int, cfg ServerConfig) *RateLimiter {
+	if routeRPM == nil {
+		return shared
+	}
+	rl := NewRateLimiter(*routeRPM)
+	rl.SetSubnetPrefixes(cfg.RateLimitIPv4Prefix, cfg.RateLimitIPv6Prefix)
+	rl.SetMaxBuckets(cfg.RateLimitMaxBuckets)
+	return rl
+}
+
 // RateLimitMiddleware wraps a handler with rate limiting
 func RateLimitMiddleware(handler http.HandlerFunc, limiter *RateLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !limiter.Allow(r) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
-			w.Write([]byte(`{"error":"Rate limit exceeded"}`))
+			sendJSONError(w, http.StatusTooManyRequests, ErrCodeRateLimited, "Rate limit exceeded")
 			return
 		}
 		handler(w, r)
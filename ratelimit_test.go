@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -157,6 +158,57 @@ func TestRateLimiterMultipleIPs(t *testing.T) {
 	}
 }
 
+func TestRateLimiterSubnetPrefixes(t *testing.T) {
+	rl := NewRateLimiter(5)
+	rl.SetSubnetPrefixes(24, 64)
+
+	sameSubnet := createTestRequest("[2001:db8:1:2::1]:8080")
+	sameSubnetOther := createTestRequest("[2001:db8:1:2:ffff::42]:8080")
+	otherSubnet := createTestRequest("[2001:db8:1:3::1]:8080")
+
+	// Two addresses in the same /64 share one bucket
+	for i := 0; i < 5; i++ {
+		req := sameSubnet
+		if i%2 == 1 {
+			req = sameSubnetOther
+		}
+		if !rl.Allow(req) {
+			t.Errorf("RateLimiter.Allow() same /64 request %d = false; want true", i+1)
+		}
+	}
+	if rl.Allow(sameSubnetOther) {
+		t.Error("RateLimiter.Allow() second address in exhausted /64 = true; want false")
+	}
+
+	// A different /64 has its own bucket
+	if !rl.Allow(otherSubnet) {
+		t.Error("RateLimiter.Allow() address in different /64 = false; want true")
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"[2001:db8:1:2::1]", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff::42", "2001:db8:1:2::/64"},
+		{"192.168.1.77", "192.168.1.0/24"},
+		{"::ffff:192.168.1.77", "192.168.1.0/24"},
+		{"10.0.0.1, 172.16.0.1", "10.0.0.0/24"},
+		{"not-an-ip", "not-an-ip"},
+	}
+	for _, tt := range tests {
+		if got := rl.bucketKey(tt.ip); got != tt.want {
+			t.Errorf("RateLimiter.bucketKey(%q) = %q; want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	// Prefix 0 keeps per-address buckets for that address family
+	rl.SetSubnetPrefixes(0, 64)
+	if got, want := rl.bucketKey("192.168.1.77"), "192.168.1.77"; got != want {
+		t.Errorf("RateLimiter.bucketKey() with IPv4 prefix 0 = %q; want %q", got, want)
+	}
+}
+
 func TestRateLimiterGetIP(t *testing.T) {
 	rl := NewRateLimiter(10)
 	
@@ -254,8 +306,8 @@ func TestRateLimitMiddleware(t *testing.T) {
 	
 	// Check response body
 	bodyStr := string(w.body)
-	if bodyStr != `{"error":"Rate limit exceeded"}` {
-		t.Errorf("RateLimitMiddleware() body = %q; want %q", bodyStr, `{"error":"Rate limit exceeded"}`)
+	if bodyStr != `{"error":"Rate limit exceeded","code":"rate_limited"}`+"\n" {
+		t.Errorf("RateLimitMiddleware() body = %q; want %q", bodyStr, `{"error":"Rate limit exceeded","code":"rate_limited"}`)
 	}
 }
 
@@ -313,3 +365,108 @@ func createTestRequest(remoteAddr string) *http.Request {
 	}
 }
 
+
+func TestRouteRateLimiter(t *testing.T) {
+	startRPM, wsRPM := 2, 0
+	cfg := ServerConfig{
+		RateLimitRPM:        100,
+		RateLimitStartRPM:   &startRPM,
+		RateLimitWSRPM:      &wsRPM,
+		RateLimitIPv4Prefix: 24,
+	}
+	shared := NewRateLimiter(cfg.RateLimitRPM)
+	start := routeRateLimiter(shared, cfg.RateLimitStartRPM, cfg)
+	viewer := routeRateLimiter(shared, cfg.RateLimitViewerRPM, cfg)
+	ws := routeRateLimiter(shared, cfg.RateLimitWSRPM, cfg)
+
+	if viewer != shared {
+		t.Error("routeRateLimiter() without route limit doesn't return the shared limiter")
+	}
+	if start == shared || ws == shared {
+		t.Error("routeRateLimiter() with route limit returns the shared limiter")
+	}
+	if start.ipv4Prefix != 24 {
+		t.Errorf("routeRateLimiter() ipv4Prefix = %d; want 24", start.ipv4Prefix)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	startHandler := RateLimitMiddleware(ok, start)
+	viewerHandler := RateLimitMiddleware(ok, viewer)
+	wsHandler := RateLimitMiddleware(ok, ws)
+	request := func(handler http.HandlerFunc) int {
+		w := httptest.NewRecorder()
+		handler(w, createTestRequest("192.168.1.1:8080"))
+		return w.Code
+	}
+
+	// Exhaust the start limit
+	for i := 0; i < startRPM; i++ {
+		if got := request(startHandler); got != http.StatusOK {
+			t.Fatalf("start request %d status = %d; want %d", i+1, got, http.StatusOK)
+		}
+	}
+	if got := request(startHandler); got != http.StatusTooManyRequests {
+		t.Errorf("start request over limit status = %d; want %d", got, http.StatusTooManyRequests)
+	}
+
+	// Viewer requests have their own budget and are not blocked
+	for i := 0; i < 10; i++ {
+		if got := request(viewerHandler); got != http.StatusOK {
+			t.Fatalf("viewer request %d after exhausted start limit status = %d; want %d", i+1, got, http.StatusOK)
+		}
+	}
+	// A route limit of 0 disables rate limiting for the route
+	for i := 0; i < 200; i++ {
+		if got := request(wsHandler); got != http.StatusOK {
+			t.Fatalf("ws request %d with disabled limit status = %d; want %d", i+1, got, http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	rl := NewRateLimiter(1)
+	rl.SetMaxBuckets(3)
+
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+		if !rl.Allow(createTestRequest(ip + ":8080")) {
+			t.Errorf("RateLimiter.Allow() %s first request = false; want true", ip)
+		}
+	}
+	// Using the oldest bucket again makes 192.168.1.2 the least recently used one
+	if rl.Allow(createTestRequest("192.168.1.1:8080")) {
+		t.Error("RateLimiter.Allow() 192.168.1.1 after limit = true; want false")
+	}
+
+	// Inserting beyond the cap evicts the least recently used buckets
+	if !rl.Allow(createTestRequest("192.168.1.4:8080")) {
+		t.Error("RateLimiter.Allow() 192.168.1.4 first request = false; want true")
+	}
+	if len(rl.buckets) != 3 || rl.lru.Len() != 3 {
+		t.Fatalf("buckets = %d (lru %d) after insert beyond cap; want 3", len(rl.buckets), rl.lru.Len())
+	}
+	if _, ok := rl.buckets["192.168.1.2"]; ok {
+		t.Error("bucket of least recently used 192.168.1.2 still tracked; want evicted")
+	}
+	for _, ip := range []string{"192.168.1.1", "192.168.1.3", "192.168.1.4"} {
+		if _, ok := rl.buckets[ip]; !ok {
+			t.Errorf("bucket of %s evicted; want kept", ip)
+		}
+	}
+
+	// An evicted client starts over with a full bucket, evicting the next oldest
+	if !rl.Allow(createTestRequest("192.168.1.2:8080")) {
+		t.Error("RateLimiter.Allow() evicted 192.168.1.2 = false; want true")
+	}
+	if _, ok := rl.buckets["192.168.1.3"]; ok {
+		t.Error("bucket of 192.168.1.3 still tracked; want evicted")
+	}
+
+	// Lowering the cap evicts right away
+	rl.SetMaxBuckets(1)
+	if len(rl.buckets) != 1 {
+		t.Errorf("buckets after SetMaxBuckets(1) = %d; want 1", len(rl.buckets))
+	}
+	if _, ok := rl.buckets["192.168.1.2"]; !ok {
+		t.Error("most recently used bucket evicted by SetMaxBuckets(1); want kept")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Audit log triggers
+const (
+	TriggerAPI      = "api"      // Task was started via /api/start
+	TriggerSchedule = "schedule" // Task was started by the scheduler
+)
+
+// TaskScheduler starts tasks with a configured schedule (cron spec) without an external cron.
+// Scheduled runs use empty parameters and are skipped while a previous run of the same task
+// is still running.
+type TaskScheduler struct {
+	cron        *cron.Cron
+	taskManager *TaskManager
+	auditLogger *AuditLogger
+	mu          sync.Mutex // Serializes the running check and the start of scheduled runs
+}
+
+// parseSchedule parses a standard 5-field cron spec or a descriptor such as "@hourly" or "@every 10m".
+// A "CRON_TZ=<zone>" prefix selects the time zone, otherwise local time is used.
+func parseSchedule(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// NewTaskScheduler creates a scheduler for all tasks with a schedule. Returns nil if no task has one.
+func NewTaskScheduler(taskManager *TaskManager, tasks []TaskConfig, auditLogger *AuditLogger) (*TaskScheduler, error) {
+	ts := &TaskScheduler{
+		cron:        cron.New(),
+		taskManager: taskManager,
+		auditLogger: auditLogger,
+	}
+
+	scheduled := 0
+	for _, task := range tasks {
+		if task.Schedule == "" {
+			continue
+		}
+		schedule, err := parseSchedule(task.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("task '%s': invalid schedule %q: %w", task.Name, task.Schedule, err)
+		}
+		taskName := task.Name
+		ts.cron.Schedule(schedule, cron.FuncJob(func() {
+			ts.runScheduled(taskName)
+		}))
+		scheduled++
+		log.Printf("[SCHEDULE] Task '%s' scheduled: %s", task.Name, task.Schedule)
+	}
+
+	if scheduled == 0 {
+		return nil, nil
+	}
+	return ts, nil
+}
+
+// Start starts the scheduler in the background
+func (ts *TaskScheduler) Start() {
+	ts.cron.Start()
+}
+
+// Stop stops the scheduler and waits for scheduled starts in progress
+func (ts *TaskScheduler) Stop() {
+	<-ts.cron.Stop().Done()
+}
+
+// runScheduled starts one scheduled run of a task and records it in the audit log
+func (ts *TaskScheduler) runScheduled(taskName string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.taskManager.IsRunning(taskName) {
+		log.Printf("[SCHEDULE] Skipping task '%s', previous run is still running", taskName)
+		return
+	}
+
+	taskID, err := ts.taskManager.StartTask(taskName, map[string]interface{}{})
+	if err != nil {
+		log.Printf("[SCHEDULE] Failed to start task '%s': %v", taskName, err)
+		return
+	}
+	log.Printf("[SCHEDULE] Task created: task_id=%s, task_name=%s", taskID, taskName)
+
+	if err := ts.auditLogger.Log(AuditEntry{
+		Timestamp:  time.Now().UTC(),
+		Trigger:    TriggerSchedule,
+		TaskID:     taskID,
+		TaskName:   taskName,
+		Parameters: []string{},
+	}); err != nil {
+		log.Printf("[AUDIT] Failed to record start of task %s: %v", taskID, err)
+	}
+}
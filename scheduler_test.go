@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"0 3 * * *", false},
+		{"*/5 * * * 1-5", false},
+		{"@hourly", false},
+		{"@every 10m", false},
+		{"CRON_TZ=Europe/Berlin 0 3 * * *", false},
+		{"", true},
+		{"every hour", true},
+		{"0 0 3 * * *", true}, // Seconds field is not supported
+		{"61 * * * *", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := parseSchedule(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSchedule(%q) error = %v; wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTaskSchedulerWithoutSchedules(t *testing.T) {
+	tasks := []TaskConfig{{Name: "test-task", Command: "echo test"}}
+	ts, err := NewTaskScheduler(NewTaskManager(&Config{Tasks: tasks}), tasks, nil)
+	if err != nil || ts != nil {
+		t.Errorf("NewTaskScheduler() = %v, %v; want nil, nil", ts, err)
+	}
+
+	tasks[0].Schedule = "invalid"
+	if _, err := NewTaskScheduler(NewTaskManager(&Config{Tasks: tasks}), tasks, nil); err == nil {
+		t.Error("NewTaskScheduler() with invalid schedule = nil; want error")
+	}
+}
+
+func TestTaskSchedulerStartsTask(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "scheduler-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	auditPath := filepath.Join(tmpDir, "audit.log")
+	auditLogger, err := NewAuditLogger(auditPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() = %v", err)
+	}
+	defer auditLogger.Close()
+
+	taskDir := filepath.Join(tmpDir, "tasks")
+	config := &Config{
+		Server: ServerConfig{TaskDir: taskDir},
+		Tasks: []TaskConfig{
+			// Runs longer than the schedule interval, so the next runs are skipped
+			{Name: "scheduled-task", Command: "sleep 3", Schedule: "@every 1s"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	defer taskManager.CleanupAllTasks()
+
+	ts, err := NewTaskScheduler(taskManager, config.Tasks, auditLogger)
+	if err != nil || ts == nil {
+		t.Fatalf("NewTaskScheduler() = %v, %v; want scheduler", ts, err)
+	}
+	ts.Start()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(taskManager.GetAllTasks()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !taskManager.IsRunning("scheduled-task") {
+		ts.Stop()
+		t.Fatal("scheduled task was not started within 5s")
+	}
+
+	// The next firings happen while the first run is still running
+	time.Sleep(1500 * time.Millisecond)
+	ts.Stop()
+
+	if tasks := taskManager.GetAllTasks(); len(tasks) != 1 {
+		t.Errorf("GetAllTasks() = %d tasks; want 1 (overlapping runs must be skipped)", len(tasks))
+	}
+
+	entries := readAuditEntries(t, auditPath)
+	if len(entries) != 1 {
+		t.Fatalf("audit log has %d entries; want 1", len(entries))
+	}
+	if entries[0].Trigger != TriggerSchedule || entries[0].TaskName != "scheduled-task" || entries[0].ClientIP != "" {
+		t.Errorf("audit entry = %+v; want scheduled run of scheduled-task without client", entries[0])
+	}
+}
+
+func TestTaskSchedulerCleansUpFinishedRuns(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "scheduler-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	auditPath := filepath.Join(tmpDir, "audit.log")
+	auditLogger, err := NewAuditLogger(auditPath)
+	if err != nil {
+		t.Fatalf("NewAuditLogger() = %v", err)
+	}
+	defer auditLogger.Close()
+
+	taskDir := filepath.Join(tmpDir, "tasks")
+	config := &Config{
+		Server: ServerConfig{TaskDir: taskDir},
+		Tasks: []TaskConfig{
+			{Name: "scheduled-task", Command: "echo done", Schedule: "@every 1s"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	taskManager.unviewedRetention = 200 * time.Millisecond
+
+	ts, err := NewTaskScheduler(taskManager, config.Tasks, auditLogger)
+	if err != nil || ts == nil {
+		t.Fatalf("NewTaskScheduler() = %v, %v; want scheduler", ts, err)
+	}
+	ts.Start()
+
+	// Two runs back to back, nobody watches them
+	deadline := time.Now().Add(5 * time.Second)
+	for len(readAuditEntries(t, auditPath)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	ts.Stop()
+	if entries := readAuditEntries(t, auditPath); len(entries) < 2 {
+		t.Fatalf("audit log has %d entries after 5s; want 2 scheduled runs", len(entries))
+	}
+
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		tasks := taskManager.GetAllTasks()
+		dirs, err := os.ReadDir(taskDir)
+		if err != nil {
+			t.Fatalf("ReadDir() = %v", err)
+		}
+		if len(tasks) == 0 && len(dirs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tasks and %d output directories left after the runs ended; want none", len(tasks), len(dirs))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// jsonSchemaDialect is the JSON Schema version of the documents served by /api/schema
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the subset of JSON Schema used to describe task parameters.
+// Patterns use Go (RE2) syntax, which matches ECMAScript for the usual character classes and quantifiers.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Maximum              *int                   `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	AllOf                []*JSONSchema          `json:"allOf,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	WriteOnly            bool                   `json:"writeOnly,omitempty"`
+}
+
+// buildParametersSchema describes the "parameters" object of a start request for the task,
+// using the same constraints as validateParameterValue
+func buildParametersSchema(task *TaskConfig) *JSONSchema {
+	noAdditional := false
+	schema := &JSONSchema{
+		Schema:               jsonSchemaDialect,
+		Title:                fmt.Sprintf("Parameters of task '%s'", task.Name),
+		Description:          task.Description,
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema, len(task.Parameters)),
+		AdditionalProperties: &noAdditional, // Unknown parameters are rejected
+	}
+	for _, param := range task.Parameters {
+		schema.Properties[param.Name] = buildParameterSchema(param)
+		if !param.Optional {
+			schema.Required = append(schema.Required, param.Name)
+		}
+	}
+	return schema
+}
+
+// buildParameterSchema describes a single parameter value
+func buildParameterSchema(param ParameterConfig) *JSONSchema {
+	schema := &JSONSchema{
+		Description: param.Description,
+		WriteOnly:   param.Secret,
+	}
+
+	// Encoded values are validated after decoding, which JSON Schema can't express
+	if param.Encoding == "base64" {
+		schema.Type = "string"
+		schema.ContentEncoding = "base64"
+		return schema
+	}
+
+	switch param.Type {
+	case "int":
+		// Only digits are accepted, so values can't be negative
+		schema.Type = "integer"
+		minimum := 0
+		if param.Min != nil && *param.Min > minimum {
+			minimum = *param.Min
+		}
+		schema.Minimum = &minimum
+		schema.Maximum = param.Max
+	case "file":
+		schema.Type = "string"
+		maxSize := param.MaxLength
+		if maxSize == 0 {
+			maxSize = defaultMaxFileParameterSize
+		}
+		schema.MaxLength = &maxSize
+	default:
+		schema.Type = "string"
+		minLength := 1
+		schema.MinLength = &minLength
+		if param.MaxLength > 0 {
+			maxLength := param.MaxLength
+			schema.MaxLength = &maxLength
+		}
+		schema.Pattern = stringParamRegex.String()
+		if param.Pattern != "" {
+			// The configured pattern must match the whole value, in addition to the allowed characters
+			schema.AllOf = []*JSONSchema{{Pattern: `^(?:` + param.Pattern + `)$`}}
+		}
+	}
+	return schema
+}
+
+// handleSchema returns a JSON Schema for the parameters of a task, so front-ends can validate input
+// before submitting it
+func handleSchema(w http.ResponseWriter, r *http.Request, config *Config) {
+	log.Printf("[API] Schema request from %s", r.RemoteAddr)
+
+	// Authenticate request - API tokens should have no audience or empty audience
+	claims, err := validateAPIJWT(r, config.Auth)
+	if err != nil {
+		log.Printf("[API] Authentication failed: %v", err)
+		sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		sendJSONError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Method not allowed. Use GET.")
+		return
+	}
+
+	taskName := r.URL.Query().Get("task_name")
+	if err := validateTaskName(taskName); err != nil {
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid task_name: %v", err))
+		return
+	}
+	task := findTaskConfig(config.Tasks, taskName)
+	if task == nil {
+		sendJSONError(w, http.StatusNotFound, ErrCodeTaskNotFound, fmt.Sprintf("Task '%s' not found", taskName))
+		return
+	}
+	if !tokenAllowsTask(config.Auth, claims, taskName) {
+		sendJSONError(w, http.StatusForbidden, ErrCodeForbidden, fmt.Sprintf("Forbidden: API key is not allowed to access task '%s'", taskName))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(buildParametersSchema(task))
+}
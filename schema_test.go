@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestHandleSchema(t *testing.T) {
+	minCount, maxCount := 1, 100
+	config := &Config{
+		Auth: AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{
+				Name:    "param-task",
+				Command: "echo {{message}} {{count}} {{blob}}",
+				Parameters: []ParameterConfig{
+					{Name: "message", Type: "string", Description: "Message to print", MaxLength: 20, Pattern: "[a-z]+"},
+					{Name: "count", Type: "int", Optional: true, Min: &minCount, Max: &maxCount},
+					{Name: "blob", Type: "file", Optional: true, Encoding: "base64", Secret: true},
+				},
+			},
+		},
+	}
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Auth.Secret))
+	if err != nil {
+		t.Fatalf("failed to create API token: %v", err)
+	}
+
+	t.Run("describes parameters", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/schema?task_name=param-task&token="+token, nil)
+		w := httptest.NewRecorder()
+
+		handleSchema(w, req, config)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("handleSchema() status = %d; want %d (body: %s)", w.Code, http.StatusOK, w.Body.String())
+		}
+		var schema JSONSchema
+		if err := json.Unmarshal(w.Body.Bytes(), &schema); err != nil {
+			t.Fatalf("handleSchema() response is not valid JSON: %v", err)
+		}
+
+		if schema.Schema != jsonSchemaDialect || schema.Type != "object" {
+			t.Errorf("schema $schema/type = %q/%q; want %q/%q", schema.Schema, schema.Type, jsonSchemaDialect, "object")
+		}
+		if schema.AdditionalProperties == nil || *schema.AdditionalProperties {
+			t.Errorf("schema additionalProperties = %v; want false", schema.AdditionalProperties)
+		}
+		if want := []string{"message"}; !reflect.DeepEqual(schema.Required, want) {
+			t.Errorf("schema required = %v; want %v", schema.Required, want)
+		}
+
+		count := schema.Properties["count"]
+		if count == nil {
+			t.Fatal("schema has no property 'count'")
+		}
+		if count.Type != "integer" {
+			t.Errorf("count type = %q; want %q", count.Type, "integer")
+		}
+		if count.Minimum == nil || *count.Minimum != minCount {
+			t.Errorf("count minimum = %v; want %d", count.Minimum, minCount)
+		}
+		if count.Maximum == nil || *count.Maximum != maxCount {
+			t.Errorf("count maximum = %v; want %d", count.Maximum, maxCount)
+		}
+
+		message := schema.Properties["message"]
+		if message == nil {
+			t.Fatal("schema has no property 'message'")
+		}
+		if message.MaxLength == nil || *message.MaxLength != 20 {
+			t.Errorf("message maxLength = %v; want 20", message.MaxLength)
+		}
+		if message.Pattern != stringParamRegex.String() {
+			t.Errorf("message pattern = %q; want %q", message.Pattern, stringParamRegex.String())
+		}
+		if len(message.AllOf) != 1 || message.AllOf[0].Pattern != "^(?:[a-z]+)$" {
+			t.Errorf("message allOf = %+v; want configured pattern anchored", message.AllOf)
+		}
+
+		blob := schema.Properties["blob"]
+		if blob == nil {
+			t.Fatal("schema has no property 'blob'")
+		}
+		if blob.ContentEncoding != "base64" || !blob.WriteOnly {
+			t.Errorf("blob contentEncoding/writeOnly = %q/%v; want %q/true", blob.ContentEncoding, blob.WriteOnly, "base64")
+		}
+	})
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{"missing token", "?task_name=param-task", http.StatusUnauthorized},
+		{"missing task name", "?token=" + token, http.StatusBadRequest},
+		{"unknown task", "?task_name=other-task&token=" + token, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/schema"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handleSchema(w, req, config)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleSchema() status = %d; want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
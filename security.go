@@ -1,9 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -12,14 +18,26 @@ import (
 )
 
 const (
-	maxJSONSize       = 1024 * 1024 // 1MB max JSON request size
-	maxTaskNameLength = 100
+	maxJSONSize        = 1024 * 1024 // 1MB max request size for tasks without file parameters
+	maxTaskNameLength  = 100
+	maxTaskLabelLength = 64
+	maxOpenFilesLimit  = 1 << 20 // Upper bound for per-task max_open_files
+	shortTaskIDLength  = 12      // Characters of a short task ID, 12 * log2(62) ≈ 71 bits of entropy
+
+	defaultMaxFileParameterSize = 64 * 1024 // Default size limit for the content of a "file" parameter
+
+	defaultMaxParameters     = 100       // Default cap on the number of provided parameters
+	defaultMaxParametersSize = 64 * 1024 // Default cap on the serialized size of all parameters in bytes
 )
 
 var (
 	taskNameRegex    = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	taskLabelRegex   = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9 _.:-]*$`)
 	intParamRegex    = regexp.MustCompile(`^[0-9]+$`)
 	stringParamRegex = regexp.MustCompile(`^[-a-zA-Z0-9_:,\.]+$`)
+	shellPathRegex   = regexp.MustCompile(`^/[-a-zA-Z0-9_./+]+$`)
+	envParamRegex    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	shortTaskIDRegex = regexp.MustCompile(`^[0-9A-Za-z]{12}$`)
 )
 
 // validateTaskName validates a task name
@@ -36,13 +54,145 @@ func validateTaskName(name string) error {
 	return nil
 }
 
-// validateTaskID validates a task ID (must be UUID)
-func validateTaskID(taskID string) bool {
+// validateTaskLabel validates a client-supplied task label
+func validateTaskLabel(label string) error {
+	if len(label) > maxTaskLabelLength {
+		return ErrLabelTooLong
+	}
+	if !taskLabelRegex.MatchString(label) {
+		return ErrInvalidLabel
+	}
+	return nil
+}
+
+// validateTaskID validates a task ID in the given scheme (TaskIDSchemeShort, otherwise UUID)
+func validateTaskID(taskID, scheme string) bool {
+	if scheme == TaskIDSchemeShort {
+		return shortTaskIDRegex.MatchString(taskID)
+	}
 	_, err := uuid.Parse(taskID)
 	return err == nil
 }
 
-// escapeBashCommand escapes a command for safe use in bash script
+// normalizeTaskID validates a task ID in the given scheme and returns it in canonical form:
+// UUIDs are accepted in any form uuid.Parse understands (e.g. upper case) and returned lower case with hyphens
+func normalizeTaskID(taskID, scheme string) (string, bool) {
+	if scheme == TaskIDSchemeShort {
+		return taskID, shortTaskIDRegex.MatchString(taskID)
+	}
+	id, err := uuid.Parse(taskID)
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+// requestTaskID returns the task ID a request refers to: the task_id query parameter, or else the
+// task ID claim of its token. A token bound to a task ID only grants access to that task.
+// Fails with ErrMissingTaskID, ErrInvalidTaskID or ErrTaskIDMismatch before any task lookup.
+func requestTaskID(r *http.Request, claims *Claims, scheme string) (string, error) {
+	var claimed string
+	if claims != nil {
+		claimed = claims.TaskID
+	}
+	taskID := r.URL.Query().Get("task_id")
+	if taskID == "" {
+		taskID = claimed
+	}
+	if taskID == "" {
+		return "", ErrMissingTaskID
+	}
+	normalized, ok := normalizeTaskID(taskID, scheme)
+	if !ok {
+		return "", ErrInvalidTaskID
+	}
+	if claimed != "" {
+		if normalizedClaim, ok := normalizeTaskID(claimed, scheme); !ok || normalizedClaim != normalized {
+			return "", ErrTaskIDMismatch
+		}
+	}
+	return normalized, nil
+}
+
+// newShortTaskID returns shortTaskIDLength random base62 characters (about 71 bits of entropy).
+// Random bytes are drawn from crypto/rand; bytes that would bias the distribution are rejected.
+func newShortTaskID() (string, error) {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	const limit = 256 - 256%len(alphabet) // Largest multiple of 62 below 256
+
+	id := make([]byte, 0, shortTaskIDLength)
+	buf := make([]byte, 2*shortTaskIDLength)
+	for len(id) < shortTaskIDLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(id) < shortTaskIDLength {
+				id = append(id, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(id), nil
+}
+
+// validateShell validates the configured shell: an absolute path without special characters
+// (it is written unquoted into the shebang) pointing to an executable file
+func validateShell(path string) error {
+	if !shellPathRegex.MatchString(path) {
+		return fmt.Errorf("shell must be an absolute path containing only alphanumeric characters, '.', '_', '-', '+' and '/'")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("shell not found: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("shell '%s' is not an executable file", path)
+	}
+	return nil
+}
+
+// umaskRegex matches an octal umask with up to three digits, optionally with a leading zero
+var umaskRegex = regexp.MustCompile(`^0?[0-7]{1,3}$`)
+
+// validateUmask validates an octal umask (e.g. "027" or "0022"), it is written unquoted into the wrapper script
+func validateUmask(value string) error {
+	if !umaskRegex.MatchString(value) {
+		return fmt.Errorf("umask must be an octal value between 000 and 0777, got %q", value)
+	}
+	return nil
+}
+
+// envAssignmentRegex matches a leading variable assignment of a shell command (e.g. "LANG=C")
+var envAssignmentRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// commandBinary returns the binary a shell command runs: its first token, skipping leading
+// variable assignments (empty if there is none)
+func commandBinary(command string) string {
+	for _, token := range strings.Fields(command) {
+		if !envAssignmentRegex.MatchString(token) {
+			return token
+		}
+	}
+	return ""
+}
+
+// checkAllowedCommand checks the binary of a command against server.allowed_commands (empty = all allowed).
+// Entries with a '/' must match the binary's path exactly, other entries match its base name.
+func checkAllowedCommand(command string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	binary := commandBinary(command)
+	for _, entry := range allowed {
+		if entry == binary || (!strings.Contains(entry, "/") && entry == filepath.Base(binary)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: '%s'", ErrCommandNotAllowed, binary)
+}
+
+// escapeBashCommand escapes a command for safe use in the wrapper script.
+// Single-quote quoting is POSIX, so the result is valid for sh as well as bash.
 // This prevents command injection even if config is compromised
 func escapeBashCommand(cmd string) string {
 	// Replace single quotes with '\''
@@ -51,31 +201,93 @@ func escapeBashCommand(cmd string) string {
 	return "'" + escaped + "'"
 }
 
-// decodeJSONRequest safely decodes JSON with size limit
+// decodeJSONRequest safely decodes JSON with size limit.
+// Numbers in untyped fields (e.g. parameter values) are decoded as json.Number, so large
+// integers keep their full precision instead of being rounded to float64.
 func decodeJSONRequest(r io.Reader, v interface{}, maxSize int64) error {
 	limitedReader := io.LimitReader(r, maxSize)
 	decoder := json.NewDecoder(limitedReader)
+	decoder.UseNumber()
 	return decoder.Decode(v)
 }
 
-// validateParameterValue validates a parameter value based on its type
+// checkParameterLimits rejects parameter maps with too many entries or too large a serialized size.
+// It runs before the per-parameter validation, so oversized requests are rejected cheaply.
+// A limit of 0 uses the default.
+func checkParameterLimits(params map[string]interface{}, maxCount, maxSize int) error {
+	if maxCount == 0 {
+		maxCount = defaultMaxParameters
+	}
+	if maxSize == 0 {
+		maxSize = defaultMaxParametersSize
+	}
+	if len(params) > maxCount {
+		return fmt.Errorf("too many parameters: %d provided, at most %d allowed", len(params), maxCount)
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("parameters cannot be serialized: %w", err)
+	}
+	if len(data) > maxSize {
+		return fmt.Errorf("parameters too large: %d bytes, at most %d allowed", len(data), maxSize)
+	}
+	return nil
+}
+
+// redactedValue replaces the values of secret parameters in error messages and logs
+const redactedValue = "***"
+
+// redactValue returns the value for display, or redactedValue for secret parameters
+func redactValue(paramDef ParameterConfig, value string) string {
+	if paramDef.Secret {
+		return redactedValue
+	}
+	return value
+}
+
+// validateParameterValue validates a parameter value based on its definition
 // Returns the validated value as a string and an error if validation fails
-func validateParameterValue(paramName, paramType string, value interface{}) (string, error) {
+func validateParameterValue(paramDef ParameterConfig, value interface{}) (string, error) {
+	paramName := paramDef.Name
+	paramType := paramDef.Type
+
+	// Encoded values are decoded and never interpolated into the shell
+	if paramDef.Encoding == "base64" {
+		return validateBase64Parameter(paramDef, value)
+	}
+
+	// File contents are free text, they are written to a file instead of being substituted
+	if paramType == "file" {
+		return validateFileParameter(paramDef, value)
+	}
+
 	// Convert value to string for validation
 	var valueStr string
 	switch v := value.(type) {
 	case string:
 		valueStr = v
+	case json.Number:
+		// Non-int parameters get the number exactly as sent, int parameters must fit into an int64
+		if paramType != "int" {
+			valueStr = v.String()
+			break
+		}
+		if n, err := v.Int64(); err == nil {
+			valueStr = strconv.FormatInt(n, 10)
+			break
+		}
+		f, err := v.Float64()
+		if err != nil || (f == math.Trunc(f) && (f < math.MinInt64 || f >= math.MaxInt64)) {
+			return "", fmt.Errorf("parameter '%s' is out of range: %s", paramName, redactValue(paramDef, v.String()))
+		}
+		if valueStr, err = formatFloatParameter(paramDef, f); err != nil {
+			return "", err
+		}
 	case float64:
-		// JSON numbers are decoded as float64
-		if paramType == "int" {
-			// Check if it's a whole number
-			if v != float64(int64(v)) {
-				return "", fmt.Errorf("parameter '%s' must be an integer, got float: %v", paramName, v)
-			}
-			valueStr = strconv.FormatInt(int64(v), 10)
-		} else {
-			valueStr = strconv.FormatFloat(v, 'f', -1, 64)
+		// JSON numbers decoded without UseNumber are float64
+		var err error
+		if valueStr, err = formatFloatParameter(paramDef, v); err != nil {
+			return "", err
 		}
 	case int:
 		if paramType == "int" {
@@ -93,19 +305,150 @@ func validateParameterValue(paramName, paramType string, value interface{}) (str
 		return "", fmt.Errorf("parameter '%s' has unsupported type: %T", paramName, v)
 	}
 
+	// Normalize the value before it is checked
+	valueStr = applyParameterTransforms(paramDef.Transform, valueStr)
+
 	// Validate based on type
 	switch paramType {
 	case "int":
 		if !intParamRegex.MatchString(valueStr) {
-			return "", fmt.Errorf("parameter '%s' (type int) contains invalid characters. Only digits 0-9 are allowed, got: %s", paramName, valueStr)
+			return "", fmt.Errorf("parameter '%s' (type int) contains invalid characters. Only digits 0-9 are allowed, got: %s", paramName, redactValue(paramDef, valueStr))
+		}
+		if err := checkIntBounds(paramDef, valueStr); err != nil {
+			return "", err
 		}
 		return valueStr, nil
 	case "string":
 		if !stringParamRegex.MatchString(valueStr) {
-			return "", fmt.Errorf("parameter '%s' (type string) contains invalid characters. Only [-a-zA-Z0-9_:,.] are allowed, got: %s", paramName, valueStr)
+			return "", fmt.Errorf("parameter '%s' (type string) contains invalid characters. Only [-a-zA-Z0-9_:,.] are allowed, got: %s", paramName, redactValue(paramDef, valueStr))
+		}
+		if err := checkStringConstraints(paramDef, valueStr); err != nil {
+			return "", err
 		}
 		return valueStr, nil
 	default:
-		return "", fmt.Errorf("parameter '%s' has unknown type: %s (must be 'int' or 'string')", paramName, paramType)
+		return "", fmt.Errorf("parameter '%s' has unknown type: %s (must be 'int', 'string' or 'file')", paramName, paramType)
+	}
+}
+
+// formatFloatParameter converts a float64 parameter value to a string, int parameters must be whole numbers
+func formatFloatParameter(paramDef ParameterConfig, v float64) (string, error) {
+	if paramDef.Type != "int" {
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	// Check if it's a whole number
+	if v != float64(int64(v)) {
+		return "", fmt.Errorf("parameter '%s' must be an integer, got float: %s", paramDef.Name, redactValue(paramDef, strconv.FormatFloat(v, 'f', -1, 64)))
+	}
+	return strconv.FormatInt(int64(v), 10), nil
+}
+
+// parameterTransforms is the fixed set of transformations a parameter may apply to its value before validation
+var parameterTransforms = map[string]func(string) string{
+	"trim":  strings.TrimSpace,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// applyParameterTransforms applies the configured transformations in order (names are validated at config load)
+func applyParameterTransforms(transforms []string, value string) string {
+	for _, name := range transforms {
+		if transform, ok := parameterTransforms[name]; ok {
+			value = transform(value)
+		}
 	}
+	return value
+}
+
+// validateFileParameter validates the content of a "file" parameter against its size limit
+// (max_length in bytes, 0 = defaultMaxFileParameterSize). The content itself is not restricted.
+func validateFileParameter(paramDef ParameterConfig, value interface{}) (string, error) {
+	content, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter '%s' (type file) must be a string, got %T", paramDef.Name, value)
+	}
+	maxSize := paramDef.MaxLength
+	if maxSize == 0 {
+		maxSize = defaultMaxFileParameterSize
+	}
+	if len(content) > maxSize {
+		return "", fmt.Errorf("parameter '%s' (type file) exceeds maximum size of %d bytes", paramDef.Name, maxSize)
+	}
+	return content, nil
+}
+
+// validateBase64Parameter decodes a base64-encoded parameter value (standard encoding with padding).
+// Decoded file contents are checked against the file size limit. Decoded strings are passed to the
+// command in an environment variable, so they are only checked against max_length and pattern
+// and must not contain NUL bytes.
+func validateBase64Parameter(paramDef ParameterConfig, value interface{}) (string, error) {
+	encoded, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter '%s' must be a base64-encoded string, got %T", paramDef.Name, value)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("parameter '%s' is not valid base64", paramDef.Name)
+	}
+	if paramDef.Type == "file" {
+		return validateFileParameter(paramDef, string(decoded))
+	}
+	if paramDef.Type != "string" {
+		return "", fmt.Errorf("parameter '%s' of type '%s' does not support base64 encoding", paramDef.Name, paramDef.Type)
+	}
+	if strings.IndexByte(string(decoded), 0) >= 0 {
+		return "", fmt.Errorf("parameter '%s' must not contain NUL bytes", paramDef.Name)
+	}
+	if err := checkStringConstraints(paramDef, string(decoded)); err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// checkIntBounds checks an int parameter value (digits only) against the optional min/max bounds
+func checkIntBounds(paramDef ParameterConfig, valueStr string) error {
+	if paramDef.Min == nil && paramDef.Max == nil {
+		return nil
+	}
+	n, err := strconv.ParseInt(valueStr, 10, 64)
+	if err != nil {
+		// Digit strings only fail to parse when they overflow int64
+		return fmt.Errorf("parameter '%s' is out of range: %s", paramDef.Name, redactValue(paramDef, valueStr))
+	}
+	if paramDef.Min != nil && n < int64(*paramDef.Min) {
+		return fmt.Errorf("parameter '%s' must be at least %d, got: %s", paramDef.Name, *paramDef.Min, redactValue(paramDef, valueStr))
+	}
+	if paramDef.Max != nil && n > int64(*paramDef.Max) {
+		return fmt.Errorf("parameter '%s' must be at most %d, got: %s", paramDef.Name, *paramDef.Max, redactValue(paramDef, valueStr))
+	}
+	return nil
+}
+
+// compileParameterPattern compiles a parameter pattern anchored to match the whole value
+func compileParameterPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// checkStringConstraints checks a string parameter value against the optional max_length and pattern.
+// These only tighten validation, the base stringParamRegex has already been applied.
+func checkStringConstraints(paramDef ParameterConfig, valueStr string) error {
+	if paramDef.MaxLength > 0 && len(valueStr) > paramDef.MaxLength {
+		return fmt.Errorf("parameter '%s' exceeds maximum length of %d characters", paramDef.Name, paramDef.MaxLength)
+	}
+	if paramDef.Pattern == "" {
+		return nil
+	}
+	re := paramDef.pattern
+	if re == nil {
+		// Config not loaded via loadConfig, compile on demand
+		var err error
+		re, err = compileParameterPattern(paramDef.Pattern)
+		if err != nil {
+			return fmt.Errorf("parameter '%s' has invalid pattern: %w", paramDef.Name, err)
+		}
+	}
+	if !re.MatchString(valueStr) {
+		return fmt.Errorf("parameter '%s' does not match the required pattern, got: %s", paramDef.Name, redactValue(paramDef, valueStr))
+	}
+	return nil
 }
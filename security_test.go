@@ -2,6 +2,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -125,7 +133,7 @@ func TestValidateTaskID(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := validateTaskID(tt.input)
+			got := validateTaskID(tt.input, TaskIDSchemeUUID)
 			if got != tt.want {
 				t.Errorf("validateTaskID(%q) = %v, want %v", tt.input, got, tt.want)
 			}
@@ -224,6 +232,61 @@ func TestValidateParameterValue(t *testing.T) {
 			want:      "789",
 			wantErr:   false,
 		},
+		{
+			name:      "valid int as json.Number above 2^53",
+			paramName: "timestamp",
+			paramType: "int",
+			value:     json.Number("9007199254740993"),
+			want:      "9007199254740993",
+			wantErr:   false,
+		},
+		{
+			name:      "valid int as json.Number (whole float)",
+			paramName: "timeout",
+			paramType: "int",
+			value:     json.Number("5.0"),
+			want:      "5",
+			wantErr:   false,
+		},
+		{
+			name:      "invalid int as json.Number (fraction)",
+			paramName: "timeout",
+			paramType: "int",
+			value:     json.Number("1.5"),
+			wantErr:   true,
+		},
+		{
+			name:      "valid string as json.Number above 2^53",
+			paramName: "id",
+			paramType: "string",
+			value:     json.Number("9007199254740993"),
+			want:      "9007199254740993",
+			wantErr:   false,
+		},
+		{
+			name:      "valid string as json.Number above 2^64",
+			paramName: "id",
+			paramType: "string",
+			value:     json.Number("12345678901234567890"),
+			want:      "12345678901234567890",
+			wantErr:   false,
+		},
+		{
+			name:      "invalid int as json.Number above int64",
+			paramName: "timestamp",
+			paramType: "int",
+			value:     json.Number("12345678901234567890"),
+			wantErr:   true,
+			errMsg:    "out of range",
+		},
+		{
+			name:      "invalid int as json.Number below int64",
+			paramName: "timestamp",
+			paramType: "int",
+			value:     json.Number("-1e30"),
+			wantErr:   true,
+			errMsg:    "out of range",
+		},
 		{
 			name:      "valid int zero",
 			paramName: "count",
@@ -387,7 +450,7 @@ func TestValidateParameterValue(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := validateParameterValue(tt.paramName, tt.paramType, tt.value)
+			got, err := validateParameterValue(ParameterConfig{Name: tt.paramName, Type: tt.paramType}, tt.value)
 			if tt.wantErr {
 				if err == nil {
 					t.Errorf("validateParameterValue(%q, %q, %v) = %q, nil; want error", tt.paramName, tt.paramType, tt.value, got)
@@ -511,9 +574,26 @@ func TestDecodeJSONRequestWithStruct(t *testing.T) {
 		t.Errorf("req.Parameters[\"key\"] = %v; want %q", req.Parameters["key"], "value")
 	}
 	
-	// JSON numbers are decoded as float64
-	if req.Parameters["num"] != float64(42) {
-		t.Errorf("req.Parameters[\"num\"] = %v; want %v", req.Parameters["num"], float64(42))
+	// JSON numbers are decoded as json.Number
+	if req.Parameters["num"] != json.Number("42") {
+		t.Errorf("req.Parameters[\"num\"] = %#v; want %#v", req.Parameters["num"], json.Number("42"))
+	}
+}
+
+func TestDecodeJSONRequestLargeInteger(t *testing.T) {
+	// 2^53 + 1 can't be represented as float64
+	jsonStr := `{"task_name": "my-task", "parameters": {"timestamp": 9007199254740993}}`
+
+	var req StartTaskRequest
+	if err := decodeJSONRequest(bytes.NewReader([]byte(jsonStr)), &req, maxJSONSize); err != nil {
+		t.Fatalf("decodeJSONRequest() = %v; want nil", err)
+	}
+	got, err := validateParameterValue(ParameterConfig{Name: "timestamp", Type: "int"}, req.Parameters["timestamp"])
+	if err != nil {
+		t.Fatalf("validateParameterValue() = %v; want nil", err)
+	}
+	if got != "9007199254740993" {
+		t.Errorf("validateParameterValue() = %q; want %q", got, "9007199254740993")
 	}
 }
 
@@ -584,3 +664,507 @@ func TestDecodeJSONRequestLimitReader(t *testing.T) {
 	}
 }
 
+
+func TestValidateParameterValueBounds(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	paramDef := ParameterConfig{Name: "timeout", Type: "int", Min: intPtr(1), Max: intPtr(3600)}
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+		errMsg  string
+	}{
+		{name: "below min", value: float64(0), wantErr: true, errMsg: "must be at least 1"},
+		{name: "above max", value: "3601", wantErr: true, errMsg: "must be at most 3600"},
+		{name: "overflowing value", value: "99999999999999999999999", wantErr: true, errMsg: "out of range"},
+		{name: "at min", value: float64(1), want: "1"},
+		{name: "in range", value: "300", want: "300"},
+		{name: "at max", value: 3600, want: "3600"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateParameterValue(paramDef, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateParameterValue(%v) = %q, nil; want error", tt.value, got)
+				} else if !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateParameterValue(%v) error = %v, want error containing %q", tt.value, err, tt.errMsg)
+				}
+			} else if err != nil || got != tt.want {
+				t.Errorf("validateParameterValue(%v) = %q, %v; want %q, nil", tt.value, got, err, tt.want)
+			}
+		})
+	}
+
+	// Only a lower bound configured
+	minOnly := ParameterConfig{Name: "count", Type: "int", Min: intPtr(5)}
+	if _, err := validateParameterValue(minOnly, "1000000"); err != nil {
+		t.Errorf("validateParameterValue() with min only = %v; want nil", err)
+	}
+}
+
+func TestValidateParameterValueStringConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		paramDef ParameterConfig
+		value    interface{}
+		wantErr  bool
+		errMsg   string
+	}{
+		{
+			name:     "pattern match",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Pattern: "prod|staging"},
+			value:    "staging",
+		},
+		{
+			name:     "pattern mismatch",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Pattern: "prod|staging"},
+			value:    "dev",
+			wantErr:  true,
+			errMsg:   "does not match the required pattern",
+		},
+		{
+			name:     "pattern must match whole value",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Pattern: "prod"},
+			value:    "production",
+			wantErr:  true,
+			errMsg:   "does not match the required pattern",
+		},
+		{
+			name:     "base regex still applies when pattern allows more",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Pattern: ".*"},
+			value:    "a;b",
+			wantErr:  true,
+			errMsg:   "contains invalid characters",
+		},
+		{
+			name:     "within max length",
+			paramDef: ParameterConfig{Name: "name", Type: "string", MaxLength: 5},
+			value:    "abcde",
+		},
+		{
+			name:     "exceeds max length",
+			paramDef: ParameterConfig{Name: "name", Type: "string", MaxLength: 5},
+			value:    "abcdef",
+			wantErr:  true,
+			errMsg:   "exceeds maximum length of 5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateParameterValue(tt.paramDef, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateParameterValue(%v) = %q, nil; want error", tt.value, got)
+				} else if !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateParameterValue(%v) error = %v, want error containing %q", tt.value, err, tt.errMsg)
+				}
+			} else if err != nil {
+				t.Errorf("validateParameterValue(%v) = %v; want nil", tt.value, err)
+			}
+		})
+	}
+}
+
+func TestValidateParameterValueTransform(t *testing.T) {
+	tests := []struct {
+		name     string
+		paramDef ParameterConfig
+		value    interface{}
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "surrounding spaces rejected without transform",
+			paramDef: ParameterConfig{Name: "env", Type: "string"},
+			value:    "  staging ",
+			wantErr:  true,
+		},
+		{
+			name:     "trim",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Transform: []string{"trim"}},
+			value:    "  staging ",
+			want:     "staging",
+		},
+		{
+			name:     "trim before pattern",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Pattern: "prod|staging", Transform: []string{"trim", "lower"}},
+			value:    " Staging\t",
+			want:     "staging",
+		},
+		{
+			name:     "upper",
+			paramDef: ParameterConfig{Name: "region", Type: "string", Transform: []string{"upper"}},
+			value:    "eu-west",
+			want:     "EU-WEST",
+		},
+		{
+			name:     "trim int",
+			paramDef: ParameterConfig{Name: "timeout", Type: "int", Transform: []string{"trim"}},
+			value:    " 30 ",
+			want:     "30",
+		},
+		{
+			name:     "inner spaces still rejected",
+			paramDef: ParameterConfig{Name: "env", Type: "string", Transform: []string{"trim"}},
+			value:    " a b ",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateParameterValue(tt.paramDef, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateParameterValue(%q) = %q, nil; want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateParameterValue(%q) = %v; want nil", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("validateParameterValue(%q) = %q; want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTaskLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   string
+		wantErr error
+	}{
+		{name: "simple label", label: "nightly-us-east"},
+		{name: "label with spaces and dots", label: "Release 1.2.3: hotfix"},
+		{name: "max length", label: strings.Repeat("a", maxTaskLabelLength)},
+		{name: "too long", label: strings.Repeat("a", maxTaskLabelLength+1), wantErr: ErrLabelTooLong},
+		{name: "leading space", label: " nightly", wantErr: ErrInvalidLabel},
+		{name: "shell characters", label: "nightly;rm", wantErr: ErrInvalidLabel},
+		{name: "html characters", label: "<script>", wantErr: ErrInvalidLabel},
+		{name: "newline", label: "a\nb", wantErr: ErrInvalidLabel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTaskLabel(tt.label); err != tt.wantErr {
+				t.Errorf("validateTaskLabel(%q) = %v; want %v", tt.label, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckParameterLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   map[string]interface{}
+		maxCount int
+		maxSize  int
+		wantErr  bool
+	}{
+		{name: "nil parameters", params: nil, wantErr: false},
+		{name: "within defaults", params: map[string]interface{}{"a": "b", "n": 1}, wantErr: false},
+		{name: "at count limit", params: map[string]interface{}{"a": 1, "b": 2}, maxCount: 2, wantErr: false},
+		{name: "over count limit", params: map[string]interface{}{"a": 1, "b": 2, "c": 3}, maxCount: 2, wantErr: true},
+		{name: "over size limit", params: map[string]interface{}{"a": strings.Repeat("x", 100)}, maxSize: 50, wantErr: true},
+		{name: "over default size limit", params: map[string]interface{}{"a": strings.Repeat("x", defaultMaxParametersSize)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkParameterLimits(tt.params, tt.maxCount, tt.maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkParameterLimits() error = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateShell(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shell-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	notExecutable := filepath.Join(tmpDir, "not-executable")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"default shell", defaultShell, false},
+		{"sh", "/bin/sh", false},
+		{"relative path", "bash", true},
+		{"missing", filepath.Join(tmpDir, "missing"), true},
+		{"directory", tmpDir, true},
+		{"not executable", notExecutable, true},
+		{"with space", "/bin/ba sh", true},
+		{"with arguments", "/bin/bash -e", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateShell(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateShell(%q) error = %v; wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateParameterValueSecretRedaction(t *testing.T) {
+	min, max := 1, 10
+	tests := []struct {
+		name     string
+		paramDef ParameterConfig
+		value    interface{}
+	}{
+		{"invalid string characters", ParameterConfig{Name: "token", Type: "string", Secret: true}, "s3cr3t;value"},
+		{"invalid int characters", ParameterConfig{Name: "pin", Type: "int", Secret: true}, "12ab34"},
+		{"float for int", ParameterConfig{Name: "pin", Type: "int", Secret: true}, 1234.5},
+		{"below min", ParameterConfig{Name: "pin", Type: "int", Min: &min, Max: &max, Secret: true}, "0"},
+		{"above max", ParameterConfig{Name: "pin", Type: "int", Min: &min, Max: &max, Secret: true}, "98765"},
+		{"pattern mismatch", ParameterConfig{Name: "token", Type: "string", Pattern: "tok-.*", Secret: true}, "s3cr3tvalue"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateParameterValue(tt.paramDef, tt.value)
+			if err == nil {
+				t.Fatalf("validateParameterValue(%q) = nil; want error", tt.paramDef.Name)
+			}
+			secret := fmt.Sprint(tt.value)
+			if strings.Contains(err.Error(), secret) {
+				t.Errorf("validateParameterValue() error = %q; must not contain secret value %q", err, secret)
+			}
+			if !strings.Contains(err.Error(), redactedValue) {
+				t.Errorf("validateParameterValue() error = %q; want redacted value %q", err, redactedValue)
+			}
+		})
+	}
+
+	// Non-secret parameters still report the offending value
+	_, err := validateParameterValue(ParameterConfig{Name: "name", Type: "string"}, "bad;value")
+	if err == nil || !strings.Contains(err.Error(), "bad;value") {
+		t.Errorf("validateParameterValue() error = %v; want error containing the value", err)
+	}
+}
+
+func TestValidateParameterValueFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		paramDef ParameterConfig
+		value    interface{}
+		wantErr  bool
+		errMsg   string
+	}{
+		{"free text content", ParameterConfig{Name: "input", Type: "file"}, "line 1; $(rm -rf /)\n'quoted' \"text\"\n", false, ""},
+		{"empty content", ParameterConfig{Name: "input", Type: "file"}, "", false, ""},
+		{"within max size", ParameterConfig{Name: "input", Type: "file", MaxLength: 10}, "0123456789", false, ""},
+		{"exceeds max size", ParameterConfig{Name: "input", Type: "file", MaxLength: 10}, "0123456789a", true, "exceeds maximum size of 10 bytes"},
+		{"exceeds default size", ParameterConfig{Name: "input", Type: "file"}, strings.Repeat("x", defaultMaxFileParameterSize+1), true, "exceeds maximum size"},
+		{"non-string value", ParameterConfig{Name: "input", Type: "file"}, float64(42), true, "must be a string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateParameterValue(tt.paramDef, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateParameterValue() = %q, nil; want error", got)
+				} else if !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateParameterValue() error = %v, want error containing %q", err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateParameterValue() = %v; want nil", err)
+			}
+			if got != tt.value {
+				t.Errorf("validateParameterValue() = %q; want content unchanged", got)
+			}
+		})
+	}
+}
+
+func TestValidateParameterValueBase64(t *testing.T) {
+	tests := []struct {
+		name     string
+		paramDef ParameterConfig
+		value    interface{}
+		want     string
+		wantErr  bool
+		errMsg   string
+	}{
+		{"decodes string", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64"}, "aGVsbG8gd29ybGQgLyAnOyQoaWQpJw==", "hello world / ';$(id)'", false, ""},
+		{"empty string", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64"}, "", "", false, ""},
+		{"decodes file", ParameterConfig{Name: "doc", Type: "file", Encoding: "base64"}, "bGluZSAxCmxpbmUgMgo=", "line 1\nline 2\n", false, ""},
+		{"malformed characters", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64"}, "not base64!", "", true, "not valid base64"},
+		{"missing padding", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64"}, "aGVsbG8", "", true, "not valid base64"},
+		{"non-string value", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64"}, float64(1), "", true, "must be a base64-encoded string"},
+		{"NUL byte", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64"}, "YQBi", "", true, "NUL bytes"},
+		{"decoded exceeds max length", ParameterConfig{Name: "msg", Type: "string", Encoding: "base64", MaxLength: 4}, "aGVsbG8=", "", true, "exceeds maximum length"},
+		{"decoded file exceeds max size", ParameterConfig{Name: "doc", Type: "file", Encoding: "base64", MaxLength: 4}, "aGVsbG8=", "", true, "exceeds maximum size"},
+		{"int not supported", ParameterConfig{Name: "n", Type: "int", Encoding: "base64"}, "MTI=", "", true, "does not support base64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateParameterValue(tt.paramDef, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateParameterValue(%v) = %q, nil; want error", tt.value, got)
+				} else if !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateParameterValue(%v) error = %v, want error containing %q", tt.value, err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateParameterValue(%v) = %v; want nil", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("validateParameterValue(%v) = %q; want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTaskIDShort(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"valid short ID", "7fKq2ZrT9mXa", true},
+		{"too short", "7fKq2ZrT9mX", false},
+		{"too long", "7fKq2ZrT9mXab", false},
+		{"invalid character", "7fKq2ZrT9m-a", false},
+		{"path traversal", "../../etc/pa", false},
+		{"UUID", "550e8400-e29b-41d4-a716-446655440000", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateTaskID(tt.input, TaskIDSchemeShort); got != tt.want {
+				t.Errorf("validateTaskID(%q, short) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewShortTaskID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := newShortTaskID()
+		if err != nil {
+			t.Fatalf("newShortTaskID() error = %v", err)
+		}
+		if !validateTaskID(id, TaskIDSchemeShort) {
+			t.Fatalf("newShortTaskID() = %q; not a valid short task ID", id)
+		}
+		if seen[id] {
+			t.Fatalf("newShortTaskID() returned %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestValidateUmask(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"027", false},
+		{"0022", false},
+		{"0777", false},
+		{"7", false},
+		{"", true},
+		{"0800", true},
+		{"00022", true},
+		{"u=rwx", true},
+		{"022; rm -rf /", true},
+	}
+	for _, tt := range tests {
+		err := validateUmask(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateUmask(%q) = %v; want error %v", tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckAllowedCommand(t *testing.T) {
+	allowed := []string{"echo", "/usr/bin/rsync"}
+	tests := []struct {
+		command string
+		allowed []string
+		wantErr bool
+	}{
+		{"echo hello", allowed, false},
+		{"/bin/echo hello", allowed, false},
+		{"LANG=C echo hello", allowed, false},
+		{"  echo  ", allowed, false},
+		{"/usr/bin/rsync -a src dst", allowed, false},
+		{"rsync -a src dst", allowed, true},
+		{"/tmp/rsync -a src dst", allowed, true},
+		{"rm -rf /tmp/x", allowed, true},
+		{"echoo hello", allowed, true},
+		{"rm -rf /tmp/x", nil, false},
+	}
+	for _, tt := range tests {
+		err := checkAllowedCommand(tt.command, tt.allowed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkAllowedCommand(%q, %v) = %v; want error %v", tt.command, tt.allowed, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrCommandNotAllowed) {
+			t.Errorf("checkAllowedCommand(%q) = %v; want %v", tt.command, err, ErrCommandNotAllowed)
+		}
+	}
+}
+
+func TestRequestTaskID(t *testing.T) {
+	const id = "550e8400-e29b-41d4-a716-446655440000"
+	tests := []struct {
+		name    string
+		query   string
+		claim   string
+		scheme  string
+		want    string
+		wantErr error
+	}{
+		{name: "query", query: id, want: id},
+		{name: "claim", claim: id, want: id},
+		{name: "query matching claim", query: strings.ToUpper(id), claim: id, want: id},
+		{name: "query not matching claim", query: id, claim: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", wantErr: ErrTaskIDMismatch},
+		{name: "upper case UUID is normalized", query: strings.ToUpper(id), want: id},
+		{name: "UUID in braces is normalized", query: "{" + id + "}", want: id},
+		{name: "missing", wantErr: ErrMissingTaskID},
+		{name: "malformed query", query: "not-a-task-id", wantErr: ErrInvalidTaskID},
+		{name: "malformed claim", claim: "../../etc", wantErr: ErrInvalidTaskID},
+		{name: "short ID", query: "aB3dE5gH7jK9", scheme: TaskIDSchemeShort, want: "aB3dE5gH7jK9"},
+		{name: "UUID in short scheme", query: id, scheme: TaskIDSchemeShort, wantErr: ErrInvalidTaskID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws?task_id="+url.QueryEscape(tt.query), nil)
+			got, err := requestTaskID(r, &Claims{TaskID: tt.claim}, tt.scheme)
+			if err != tt.wantErr {
+				t.Fatalf("requestTaskID() error = %v; want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("requestTaskID() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
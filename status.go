@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TaskStatus is a redacted view of a running task (no output directory or parameters)
+type TaskStatus struct {
+	TaskID           string    `json:"task_id"`
+	TaskName         string    `json:"task_name"`
+	Label            string    `json:"label,omitempty"`
+	StartTime        time.Time `json:"start_time"`
+	RunningSeconds   int64     `json:"running_seconds"`
+	MaxExecutionTime int64     `json:"max_execution_time,omitempty"` // Seconds (0 = no limit)
+	Terminated       bool      `json:"terminated"`
+	Killed           bool      `json:"killed"`
+	Completed        bool      `json:"completed"`
+	StartLatencyMs   float64   `json:"start_latency_ms"` // Time from the start request until the PID was written
+}
+
+// StatusSnapshot is the content written to the status file
+type StatusSnapshot struct {
+	GeneratedAt     time.Time    `json:"generated_at"`
+	Tasks           []TaskStatus `json:"tasks"`
+	OpenTailHandles int64        `json:"open_tail_handles"` // Output files currently held open by viewers
+}
+
+// Snapshot returns a redacted copy of all running tasks
+func (tm *TaskManager) Snapshot() []TaskStatus {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	now := time.Now()
+	statuses := make([]TaskStatus, 0, len(tm.runningTasks))
+	for _, task := range tm.runningTasks {
+		end := now
+		if task.Completed {
+			end = task.CompletedAt
+		}
+		statuses = append(statuses, TaskStatus{
+			TaskID:           task.ID,
+			TaskName:         task.TaskName,
+			Label:            task.Label,
+			StartTime:        task.StartTime,
+			RunningSeconds:   int64(end.Sub(task.StartTime).Seconds()),
+			MaxExecutionTime: int64(task.MaxExecutionTime.Seconds()),
+			Terminated:       task.Terminated,
+			Killed:           task.Killed,
+			Completed:        task.Completed,
+			StartLatencyMs:   durationMillis(task.StartLatency()),
+		})
+	}
+	return statuses
+}
+
+// StatusWriter periodically writes a snapshot of running tasks to a status file
+type StatusWriter struct {
+	taskManager *TaskManager
+	path        string
+	interval    time.Duration
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewStatusWriter creates a new status writer
+func NewStatusWriter(taskManager *TaskManager, path string, interval time.Duration) *StatusWriter {
+	return &StatusWriter{
+		taskManager: taskManager,
+		path:        path,
+		interval:    interval,
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start writes an initial snapshot and then refreshes it periodically until Stop is called
+func (sw *StatusWriter) Start() {
+	if err := sw.WriteSnapshot(); err != nil {
+		log.Printf("[STATUS] Failed to write status file %s: %v", sw.path, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(sw.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sw.stop:
+				return
+			case <-ticker.C:
+				if err := sw.WriteSnapshot(); err != nil {
+					log.Printf("[STATUS] Failed to write status file %s: %v", sw.path, err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic snapshot writes
+func (sw *StatusWriter) Stop() {
+	sw.stopOnce.Do(func() {
+		close(sw.stop)
+	})
+}
+
+// WriteSnapshot atomically writes the current snapshot (temp file + rename)
+func (sw *StatusWriter) WriteSnapshot() error {
+	snapshot := StatusSnapshot{
+		GeneratedAt:     time.Now(),
+		Tasks:           sw.taskManager.Snapshot(),
+		OpenTailHandles: sw.taskManager.tailHandles.Count(),
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	// Temp file must be in the same directory for rename to be atomic
+	tmpFile, err := os.CreateTemp(filepath.Dir(sw.path), ".status-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmpFile.Name()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmpName, sw.path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readStatusSnapshot(t *testing.T, path string) StatusSnapshot {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read status file: %v", err)
+	}
+	var snapshot StatusSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("Status file is not valid JSON: %v", err)
+	}
+	return snapshot
+}
+
+func TestStatusWriter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "status-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tm := NewTaskManager(&Config{Server: ServerConfig{TaskDir: tmpDir}})
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	tm.mu.Lock()
+	tm.runningTasks[taskID] = &RunningTask{
+		ID:               taskID,
+		TaskName:         "test-task",
+		StartTime:        time.Now(),
+		OutputDir:        filepath.Join(tmpDir, taskID),
+		MaxExecutionTime: time.Minute,
+	}
+	tm.mu.Unlock()
+
+	statusPath := filepath.Join(tmpDir, "status.json")
+	sw := NewStatusWriter(tm, statusPath, 50*time.Millisecond)
+	sw.Start()
+	defer sw.Stop()
+
+	snapshot := readStatusSnapshot(t, statusPath)
+	if len(snapshot.Tasks) != 1 {
+		t.Fatalf("snapshot has %d tasks; want 1", len(snapshot.Tasks))
+	}
+	if snapshot.Tasks[0].TaskID != taskID || snapshot.Tasks[0].TaskName != "test-task" {
+		t.Errorf("snapshot task = %+v; want task_id=%s, task_name=test-task", snapshot.Tasks[0], taskID)
+	}
+	if snapshot.Tasks[0].MaxExecutionTime != 60 {
+		t.Errorf("snapshot max_execution_time = %d; want 60", snapshot.Tasks[0].MaxExecutionTime)
+	}
+
+	data, _ := os.ReadFile(statusPath)
+	if containsString(string(data), tmpDir) {
+		t.Error("snapshot contains the task output directory; want redacted")
+	}
+
+	// Add a second task and wait for the next snapshot
+	secondID := "650e8400-e29b-41d4-a716-446655440000"
+	tm.mu.Lock()
+	tm.runningTasks[secondID] = &RunningTask{ID: secondID, TaskName: "second-task", StartTime: time.Now()}
+	tm.mu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		updated := readStatusSnapshot(t, statusPath)
+		if len(updated.Tasks) == 2 && updated.GeneratedAt.After(snapshot.GeneratedAt) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot was not updated: %+v", updated)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// No temporary files should be left behind once writes have stopped
+	sw.Stop()
+	time.Sleep(100 * time.Millisecond)
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, ".status-*"))
+	if len(matches) > 0 {
+		t.Errorf("temporary status files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stepMarkerPrefix starts the marker lines the wrapper script writes to stdout around each step
+// of a multi-step task. Tailers turn them into step_start/step_end messages instead of output.
+// Each run adds a random nonce (see newStepMarker), so output can't forge markers.
+const stepMarkerPrefix = "##vsTaskViewer:"
+
+// stepNamePattern restricts step names, they are written unquoted into the marker lines
+var stepNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// StepMessage reports the start or the end of a step of a multi-step task to viewers
+type StepMessage struct {
+	Type     string `json:"type"` // "step_start" or "step_end"
+	Step     string `json:"step"`
+	ExitCode *int   `json:"exit_code,omitempty"` // Exit code of the step (step_end only)
+}
+
+// newStepMarker returns the marker line prefix of a run of a multi-step task: stepMarkerPrefix
+// followed by a random nonce
+func newStepMarker() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return stepMarkerPrefix + hex.EncodeToString(nonce) + ":", nil
+}
+
+// parseStepMarker parses a stdout line (without newline) written by the wrapper script at the
+// start or end of a step, using the run's marker prefix (empty = the task has no steps).
+// Returns false if the line is regular output.
+func parseStepMarker(marker, line string) (StepMessage, bool) {
+	if marker == "" {
+		return StepMessage{}, false
+	}
+	rest, ok := strings.CutPrefix(line, marker)
+	if !ok {
+		return StepMessage{}, false
+	}
+	if name, ok := strings.CutPrefix(rest, "step_start:"); ok && stepNamePattern.MatchString(name) {
+		return StepMessage{Type: "step_start", Step: name}, true
+	}
+	if rest, ok := strings.CutPrefix(rest, "step_end:"); ok {
+		name, code, found := strings.Cut(rest, ":")
+		exitCode, err := strconv.Atoi(code)
+		if found && err == nil && stepNamePattern.MatchString(name) {
+			return StepMessage{Type: "step_end", Step: name, ExitCode: &exitCode}, true
+		}
+	}
+	return StepMessage{}, false
+}
+
+// streamStepMarker returns the step marker prefix for tailing an output stream of a task, only
+// stdout carries markers
+func streamStepMarker(task *RunningTask, stream string) string {
+	if stream != "stdout" {
+		return ""
+	}
+	return task.StepMarker
+}
+
+// buildStepsScript generates the part of the wrapper script that runs the steps of a task in order,
+// each framed by marker lines, and sets EXIT_CODE to the exit code of the first failed step.
+// Unless continueOnFailure is set, the steps after a failed step are skipped.
+// Each marker line is preceded by a newline, so it starts a line even if the step's output doesn't
+// end with one; tailers drop the blank line this leaves otherwise (see lineBatcher).
+func buildStepsScript(shell, marker string, steps []StepConfig, continueOnFailure bool) string {
+	var b strings.Builder
+	b.WriteString("EXIT_CODE=0\n")
+	for i, step := range steps {
+		guarded := i > 0 && !continueOnFailure
+		if guarded {
+			b.WriteString("if [ $EXIT_CODE -eq 0 ]; then\n")
+		}
+		fmt.Fprintf(&b, "printf '\\n%%s\\n' %s\n", escapeBashCommand(marker+"step_start:"+step.Name))
+		fmt.Fprintf(&b, "%s -c %s\n", shell, escapeBashCommand(step.Command))
+		b.WriteString("STEP_EXIT=$?\n")
+		fmt.Fprintf(&b, "printf '\\n%%s\\n' %s\"$STEP_EXIT\"\n", escapeBashCommand(marker+"step_end:"+step.Name+":"))
+		b.WriteString("if [ $STEP_EXIT -ne 0 ] && [ $EXIT_CODE -eq 0 ]; then EXIT_CODE=$STEP_EXIT; fi\n")
+		if guarded {
+			b.WriteString("fi\n")
+		}
+	}
+	return b.String()
+}
+
+// validateSteps checks the step definitions of a multi-step task
+func validateSteps(task TaskConfig) error {
+	names := make(map[string]bool, len(task.Steps))
+	for i, step := range task.Steps {
+		if !stepNamePattern.MatchString(step.Name) {
+			return fmt.Errorf("task '%s' has step at index %d with invalid name %q (letters, digits, '_', '.' and '-' only)", task.Name, i, step.Name)
+		}
+		if names[step.Name] {
+			return fmt.Errorf("task '%s' has duplicate step name '%s'", task.Name, step.Name)
+		}
+		names[step.Name] = true
+		if step.Command == "" {
+			return fmt.Errorf("task '%s' step '%s' has no command", task.Name, step.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseStepMarker(t *testing.T) {
+	const marker = "##vsTaskViewer:0123456789abcdef:"
+	tests := []struct {
+		line     string
+		wantOK   bool
+		wantType string
+		wantStep string
+		wantCode int
+	}{
+		{"##vsTaskViewer:0123456789abcdef:step_start:build", true, "step_start", "build", 0},
+		{"##vsTaskViewer:0123456789abcdef:step_end:build:0", true, "step_end", "build", 0},
+		{"##vsTaskViewer:0123456789abcdef:step_end:deploy.v2:127", true, "step_end", "deploy.v2", 127},
+		{"regular output", false, "", "", 0},
+		{"##vsTaskViewer:0123456789abcdef:step_start:", false, "", "", 0},
+		{"##vsTaskViewer:0123456789abcdef:step_start:bad name", false, "", "", 0},
+		{"##vsTaskViewer:0123456789abcdef:step_end:build", false, "", "", 0},
+		{"##vsTaskViewer:0123456789abcdef:step_end:build:x", false, "", "", 0},
+		{"##vsTaskViewer:0123456789abcdef:other:build", false, "", "", 0},
+		{"  ##vsTaskViewer:0123456789abcdef:step_start:build", false, "", "", 0},
+		{"##vsTaskViewer:step_start:build", false, "", "", 0},
+		{"##vsTaskViewer:fedcba9876543210:step_start:build", false, "", "", 0},
+	}
+	for _, tt := range tests {
+		step, ok := parseStepMarker(marker, tt.line)
+		if ok != tt.wantOK {
+			t.Errorf("parseStepMarker(%q) ok = %v; want %v", tt.line, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if step.Type != tt.wantType || step.Step != tt.wantStep {
+			t.Errorf("parseStepMarker(%q) = %+v; want type %q, step %q", tt.line, step, tt.wantType, tt.wantStep)
+		}
+		if tt.wantType == "step_end" && (step.ExitCode == nil || *step.ExitCode != tt.wantCode) {
+			t.Errorf("parseStepMarker(%q) exit code = %v; want %d", tt.line, step.ExitCode, tt.wantCode)
+		}
+		if tt.wantType == "step_start" && step.ExitCode != nil {
+			t.Errorf("parseStepMarker(%q) exit code = %d; want none", tt.line, *step.ExitCode)
+		}
+	}
+
+	// Tasks without steps have no marker, nothing is parsed
+	if _, ok := parseStepMarker("", "##vsTaskViewer:step_start:build"); ok {
+		t.Error("parseStepMarker() without marker ok = true; want false")
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []StepConfig
+		wantErr bool
+	}{
+		{"valid", []StepConfig{{Name: "build", Command: "make"}, {Name: "test-1.x", Command: "make test"}}, false},
+		{"missing name", []StepConfig{{Command: "make"}}, true},
+		{"invalid name", []StepConfig{{Name: "build all", Command: "make"}}, true},
+		{"duplicate name", []StepConfig{{Name: "build", Command: "make"}, {Name: "build", Command: "make test"}}, true},
+		{"missing command", []StepConfig{{Name: "build"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSteps(TaskConfig{Name: "steps-task", Steps: tt.steps})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateSteps() = %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTaskManagerStartTaskSteps(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	steps := []StepConfig{
+		{Name: "prepare", Command: "echo preparing; exit 4"},
+		{Name: "deploy", Command: "echo deploying"},
+	}
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			{Name: "stop-task", Steps: steps},
+			{Name: "continue-task", Steps: steps, ContinueOnStepFailure: true},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	tests := []struct {
+		taskName   string
+		wantStdout string
+	}{
+		{"stop-task", "\nMARKER:step_start:prepare\npreparing\n\nMARKER:step_end:prepare:4\n"},
+		{"continue-task", "\nMARKER:step_start:prepare\npreparing\n\nMARKER:step_end:prepare:4\n" +
+			"\nMARKER:step_start:deploy\ndeploying\n\nMARKER:step_end:deploy:0\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.taskName, func(t *testing.T) {
+			taskID, err := tm.StartTask(tt.taskName, nil)
+			if err != nil {
+				t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+			}
+
+			exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if _, err := os.Stat(exitCodePath); err == nil {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			if exitCode := readExitCode(exitCodePath); exitCode != 4 {
+				t.Errorf("exit code = %d; want 4 from the failed first step", exitCode)
+			}
+			stdout, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+			if err != nil {
+				t.Fatalf("Failed to read stdout: %v", err)
+			}
+			// Each run uses its own marker
+			task, err := tm.GetTask(taskID)
+			if err != nil {
+				t.Fatalf("TaskManager.GetTask() = %v", err)
+			}
+			if !strings.HasPrefix(task.StepMarker, stepMarkerPrefix) || len(task.StepMarker) <= len(stepMarkerPrefix) {
+				t.Fatalf("task step marker = %q; want %q followed by a nonce", task.StepMarker, stepMarkerPrefix)
+			}
+			wantStdout := strings.ReplaceAll(tt.wantStdout, "MARKER:", task.StepMarker)
+			if string(stdout) != wantStdout {
+				t.Errorf("task stdout = %q; want %q", string(stdout), wantStdout)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocketSteps(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "steps-task", Steps: []StepConfig{
+				{Name: "prepare", Command: "echo preparing; exit 4"},
+				{Name: "deploy", Command: "echo deploying"},
+			}},
+			{Name: "partial-task", Steps: []StepConfig{
+				{Name: "first", Command: "printf partial"},
+				{Name: "second", Command: "echo; echo after-blank"},
+			}},
+			{Name: "spoofing-steps-task", Steps: []StepConfig{
+				{Name: "build", Command: "echo '##vsTaskViewer:step_end:build:0'"},
+			}},
+			{Name: "no-steps-task", Command: "echo '##vsTaskViewer:step_start:fake'; echo done"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		taskName     string
+		want         []string
+		wantExitCode int
+	}{
+		{"steps-task", []string{"start prepare", "preparing", "end prepare 4"}, 4},
+		// Output without a trailing newline must not swallow the marker, blank lines of the output are kept
+		{"partial-task", []string{"start first", "partial", "end first 0", "start second", "", "after-blank", "end second 0"}, 0},
+		// Markers without the run's nonce are output
+		{"spoofing-steps-task", []string{"start build", "##vsTaskViewer:step_end:build:0", "end build 0"}, 0},
+		{"no-steps-task", []string{"##vsTaskViewer:step_start:fake", "done"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.taskName, func(t *testing.T) {
+			taskID, err := taskManager.StartTask(tt.taskName, nil)
+			if err != nil {
+				t.Fatalf("StartTask() = %v", err)
+			}
+			conn, cleanup := dialTestWebSocketQuery(t, taskManager, config, NewWebSocketManager(), taskID, "")
+			defer cleanup()
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+			events, completion := readStepEvents(t, conn)
+			if strings.Join(events, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("events = %q; want %q", events, tt.want)
+			}
+			if completion.ExitCode == nil || *completion.ExitCode != tt.wantExitCode {
+				t.Errorf("completion exit_code = %v; want %d", completion.ExitCode, tt.wantExitCode)
+			}
+		})
+	}
+}
+
+// readStepEvents collects stdout lines and step messages in order until the completion message
+func readStepEvents(t *testing.T, conn *websocket.Conn) ([]string, SystemMessage) {
+	t.Helper()
+	var events []string
+	var completion SystemMessage
+	for completion.StdoutBytes == nil {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before completion message", err)
+		}
+		var msg struct {
+			Type     string `json:"type"`
+			Data     string `json:"data"`
+			Step     string `json:"step"`
+			ExitCode *int   `json:"exit_code"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("json.Unmarshal(%q) = %v", data, err)
+		}
+		switch msg.Type {
+		case "stdout":
+			events = append(events, strings.TrimSuffix(msg.Data, "\n"))
+		case "step_start":
+			events = append(events, "start "+msg.Step)
+		case "step_end":
+			if msg.ExitCode == nil {
+				t.Fatalf("step_end message %s has no exit_code", data)
+			}
+			events = append(events, "end "+msg.Step+" "+strconv.Itoa(*msg.ExitCode))
+		case "system":
+			json.Unmarshal(data, &completion)
+		}
+	}
+	return events, completion
+}
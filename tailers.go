@@ -0,0 +1,38 @@
+package main
+
+import "sync/atomic"
+
+// tailersPerViewer is the number of tail goroutines of a viewer, one per output stream
+const tailersPerViewer = 2
+
+// tailerLimiter is a server-wide semaphore for the tail goroutines of all viewers, so that
+// thousands of viewers can't exhaust the memory of the server. WebSocket connections reserve
+// their tailers before the upgrade and are rejected with 503 if the limit is reached.
+type tailerLimiter struct {
+	limit  int64        // Maximum number of active tailers (0 = unlimited)
+	active atomic.Int64 // Currently reserved tailers
+}
+
+// newTailerLimiter creates a limiter allowing at most limit active tailers (0 = unlimited)
+func newTailerLimiter(limit int) *tailerLimiter {
+	return &tailerLimiter{limit: int64(limit)}
+}
+
+// Acquire reserves n tailers. Returns false without reserving any if the limit would be exceeded.
+func (l *tailerLimiter) Acquire(n int) bool {
+	if active := l.active.Add(int64(n)); l.limit > 0 && active > l.limit {
+		l.active.Add(-int64(n))
+		return false
+	}
+	return true
+}
+
+// Release frees n tailers reserved with Acquire
+func (l *tailerLimiter) Release(n int) {
+	l.active.Add(-int64(n))
+}
+
+// Count returns the number of currently reserved tailers
+func (l *tailerLimiter) Count() int64 {
+	return l.active.Load()
+}
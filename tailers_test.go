@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTailerLimiter(t *testing.T) {
+	limiter := newTailerLimiter(4)
+	if !limiter.Acquire(2) || !limiter.Acquire(2) {
+		t.Fatal("Acquire() = false below the limit")
+	}
+	if limiter.Acquire(2) {
+		t.Error("Acquire() = true above the limit")
+	}
+	if got := limiter.Count(); got != 4 {
+		t.Errorf("Count() = %d; want 4 (failed Acquire must not reserve)", got)
+	}
+	limiter.Release(2)
+	if !limiter.Acquire(2) {
+		t.Error("Acquire() = false after Release()")
+	}
+
+	unlimited := newTailerLimiter(0)
+	for i := 0; i < 100; i++ {
+		if !unlimited.Acquire(2) {
+			t.Fatal("Acquire() = false without limit")
+		}
+	}
+}
+
+func TestHandleWebSocketMaxTailers(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, MaxTailers: 4},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "long-task", Command: "sleep 10"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	wsManager := NewWebSocketManager()
+
+	taskID, err := taskManager.StartTask("long-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, taskManager, config, createUpgrader(nil, false), wsManager)
+	}))
+	defer server.Close()
+	token, err := generateViewerToken(taskID, config.Auth.Secret, time.Hour)
+	if err != nil {
+		t.Fatalf("generateViewerToken() = %v", err)
+	}
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?task_id=" + taskID + "&token=" + token
+
+	// Many concurrent viewers, only two fit into four tailers
+	const attempts = 20
+	var (
+		mu       sync.Mutex
+		conns    []*websocket.Conn
+		rejected int
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if resp != nil && resp.StatusCode == http.StatusServiceUnavailable {
+					rejected++
+				} else {
+					t.Errorf("Dial() = %v; want success or 503", err)
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	if len(conns) != 2 || rejected != attempts-2 {
+		t.Fatalf("%d viewers connected, %d rejected; want 2 connected, %d rejected", len(conns), rejected, attempts-2)
+	}
+	if got := taskManager.tailers.Count(); got != 4 {
+		t.Errorf("tailers.Count() = %d; want 4", got)
+	}
+
+	// A disconnecting viewer frees its tailers for the next one
+	conns[0].Close()
+	conns = conns[1:]
+	deadline := time.Now().Add(5 * time.Second)
+	for taskManager.tailers.Count() > 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("tailers.Count() = %d 5s after a viewer disconnected; want 2", taskManager.tailers.Count())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial() after a viewer disconnected = %v; want success", err)
+	}
+	conns = append(conns, conn)
+}
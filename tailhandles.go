@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// errTooManyTailHandles is returned when server.max_tail_handles output files are already open
+var errTooManyTailHandles = errors.New("too many open output files")
+
+// tailHandleGuard counts the output files held open by the tailers of all viewers (one per viewed
+// stream) and optionally caps them, so that many viewers can't exhaust the file descriptors of the server
+type tailHandleGuard struct {
+	limit  int64        // Maximum number of open handles (0 = unlimited)
+	open   atomic.Int64 // Currently open handles
+	opened atomic.Int64 // Total number of opened handles
+}
+
+// newTailHandleGuard creates a guard allowing at most limit open handles (0 = unlimited)
+func newTailHandleGuard(limit int) *tailHandleGuard {
+	return &tailHandleGuard{limit: int64(limit)}
+}
+
+// Open opens an output file for tailing. Fails with errTooManyTailHandles if the limit is reached.
+// The file must be released with Close.
+func (g *tailHandleGuard) Open(path string) (*os.File, error) {
+	if n := g.open.Add(1); g.limit > 0 && n > g.limit {
+		g.open.Add(-1)
+		return nil, errTooManyTailHandles
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		g.open.Add(-1)
+		return nil, err
+	}
+	g.opened.Add(1)
+	return file, nil
+}
+
+// Close closes a file opened with Open
+func (g *tailHandleGuard) Close(file *os.File) {
+	file.Close()
+	g.open.Add(-1)
+}
+
+// Count returns the number of currently open handles
+func (g *tailHandleGuard) Count() int64 {
+	return g.open.Load()
+}
+
+// Opened returns the total number of handles opened so far
+func (g *tailHandleGuard) Opened() int64 {
+	return g.opened.Load()
+}
+
+// openTailFile opens an output file for a tailer and tells the viewer if the server is out of handles
+func openTailFile(handles *tailHandleGuard, safeConn *safeConn, filePath string) (*os.File, bool) {
+	file, err := handles.Open(filePath)
+	if err != nil {
+		log.Printf("[TAIL] Failed to open file: %s, error: %v", filePath, err)
+		if errors.Is(err, errTooManyTailHandles) {
+			sendSystemMessage(safeConn, "error", "Too many viewers, output can't be streamed right now. Please try again later.", 0)
+		}
+		return nil, false
+	}
+	return file, true
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTailHandleGuard(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tailhandles-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "stdout")
+	if err := os.WriteFile(path, []byte("hello\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	g := newTailHandleGuard(2)
+	first, err := g.Open(path)
+	if err != nil {
+		t.Fatalf("Open() = %v; want nil", err)
+	}
+	second, err := g.Open(path)
+	if err != nil {
+		t.Fatalf("Open() = %v; want nil", err)
+	}
+	if _, err := g.Open(path); !errors.Is(err, errTooManyTailHandles) {
+		t.Errorf("Open() at limit = %v; want %v", err, errTooManyTailHandles)
+	}
+	if got := g.Count(); got != 2 {
+		t.Errorf("Count() = %d; want 2", got)
+	}
+
+	g.Close(first)
+	if _, err := g.Open(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("Open() of missing file = nil; want error")
+	}
+	if got := g.Count(); got != 1 {
+		t.Errorf("Count() after failed open = %d; want 1", got)
+	}
+	third, err := g.Open(path)
+	if err != nil {
+		t.Fatalf("Open() after Close() = %v; want nil", err)
+	}
+	g.Close(second)
+	g.Close(third)
+	if got := g.Count(); got != 0 {
+		t.Errorf("Count() after closing all = %d; want 0", got)
+	}
+	if got := g.Opened(); got != 3 {
+		t.Errorf("Opened() = %d; want 3", got)
+	}
+
+	unlimited := newTailHandleGuard(0)
+	for i := 0; i < 10; i++ {
+		file, err := unlimited.Open(path)
+		if err != nil {
+			t.Fatalf("Open() without limit = %v; want nil", err)
+		}
+		defer unlimited.Close(file)
+	}
+}
+
+func TestTailFileKeepsHandleOpen(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tailhandles-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "stdout")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	handles := newTailHandleGuard(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var streamed atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tailFile(ctx, newTestSafeConn(t), handles, newFileWait(ServerConfig{}), path, "stdout", "test-task", &streamed, 0, 0, "", "")
+	}()
+
+	// Append output over several poll cycles, each write is picked up on a later tick
+	var size int64
+	for i := 0; i < 5; i++ {
+		n, err := fmt.Fprintf(file, "line %d\n", i)
+		if err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		size += int64(n)
+		deadline := time.Now().Add(5 * time.Second)
+		for streamed.Load() < size && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if got := streamed.Load(); got != size {
+			t.Fatalf("streamed after write %d = %d; want %d", i, got, size)
+		}
+	}
+
+	if got := handles.Opened(); got != 1 {
+		t.Errorf("tailFile() opened the file %d times over 5 poll cycles; want 1", got)
+	}
+	if got := handles.Count(); got != 1 {
+		t.Errorf("open handles while tailing = %d; want 1", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tailFile() didn't return after the context was cancelled")
+	}
+	if got := handles.Count(); got != 0 {
+		t.Errorf("open handles after tailFile() returned = %d; want 0", got)
+	}
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,13 +18,53 @@ import (
 	"github.com/google/uuid"
 )
 
+// Output directory layouts (server.output_layout)
+const (
+	OutputLayoutFlat = "flat" // <task_dir>/<task_id>
+	OutputLayoutDate = "date" // <task_dir>/<YYYY-MM-DD>/<task_id>, date of the task start in UTC
+)
+
+// Task ID schemes (server.task_id_scheme)
+const (
+	TaskIDSchemeUUID  = "uuid"  // Random UUID (version 4), e.g. 550e8400-e29b-41d4-a716-446655440000
+	TaskIDSchemeShort = "short" // shortTaskIDLength random base62 characters, e.g. 7fKq2ZrT9mXa
+)
+
+// defaultShell is used for the wrapper script and task commands if server.shell is not set
+const defaultShell = "/bin/bash"
+
 // TaskManager manages task execution
 type TaskManager struct {
 	config       *Config
 	runningTasks map[string]*RunningTask
+	draining     bool // No new tasks are started while draining (protected by mu)
 	mu           sync.RWMutex
+
+	monitor     *taskMonitor     // Watches the processes of tasks with connected viewers
+	tailHandles *tailHandleGuard // Output files held open by the tailers of all viewers
+	tailers     *tailerLimiter   // Tail goroutines of all viewers, reserved before the WebSocket upgrade
+	taskDirSize *taskDirUsage    // Size of the task directory for server.max_task_dir_bytes (nil = unlimited)
+	viewerUses  *viewerTokenUses // Consumed single-use viewer tokens (nil = no task with single_use_viewer_token)
+
+	startProcess func(*exec.Cmd) error               // Starts a task process, replaced in tests to inject failures
+	generateID   func(scheme string) (string, error) // Generates random task IDs, replaced in tests to inject collisions
+
+	disconnectGrace   time.Duration // Delay before terminating a terminate_on_disconnect task without viewers
+	unviewedRetention time.Duration // Delay before removing a run that ended without viewers, if output_retention is not set
 }
 
+// startRetryBaseDelay is the delay before the first retry of a failed process start, doubled for each further retry
+const startRetryBaseDelay = 100 * time.Millisecond
+
+// maxStartRetries caps server.start_retries, the last retry is delayed by 100ms * 2^(n-1)
+const maxStartRetries = 10
+
+// maxTaskIDAttempts bounds the number of task IDs generated for a start when IDs are already in use
+const maxTaskIDAttempts = 10
+
+// drainPollInterval is how often WaitForRunningTasks checks for running processes
+const drainPollInterval = 200 * time.Millisecond
+
 // RunningTask represents a currently running task
 type RunningTask struct {
 	ID               string
@@ -31,121 +74,227 @@ type RunningTask struct {
 	MaxExecutionTime time.Duration // Maximum execution time (0 = no limit)
 	Terminated       bool          // Whether SIGTERM has been sent
 	Killed           bool          // Whether SIGKILL has been sent
+	State            string        // TaskStateRunning, TaskStateFinishing or TaskStateCompleted (protected by TaskManager.mu)
+	Label            string        // Optional client-supplied label to tell concurrent runs apart
+	StepMarker       string        // Prefix of the step marker lines in stdout (empty = task has no steps)
+	Completed        bool          // Whether the process has ended and the output is retained (protected by TaskManager.mu)
+	CompletedAt      time.Time     // When the task was marked completed
+	ProcessStartTime uint64        // Start time of the wrapper process from /proc (0 = unknown), detects PID reuse
+	Exited           bool          // Whether cmd.Wait has returned (protected by TaskManager.mu)
+	ExitCode         int           // Exit status reported by cmd.Wait, 128+signal if killed (protected by TaskManager.mu)
+	RequestedAt      time.Time     // When StartTask was called
+	PIDWrittenAt     time.Time     // When the process was started and its PID written
+	Archived         bool          // Run read from the archive after the task was removed, OutputDir is its archive directory
+
+	exited           chan struct{} // Closed once Exited and ExitCode are set
+	archived         chan struct{} // Closed once the output has been archived (or archive_dir is unset), cleanup waits for it
+	viewers          int           // Connected WebSocket viewers (protected by TaskManager.mu)
+	holds            int           // /api/start requests waiting for the task to end (protected by TaskManager.mu)
+	cleanupScheduled bool          // Removal of an unviewed run is scheduled (protected by TaskManager.mu)
+}
+
+// StartOptions holds optional settings for starting a task
+type StartOptions struct {
+	Label   string      // Client-supplied label (validated with validateTaskLabel)
+	Headers http.Header // Request headers, the task's forward_headers are passed as environment variables
 }
 
+// Task states
+const (
+	TaskStateRunning   = "running"   // Process is running, viewers may attach
+	TaskStateFinishing = "finishing" // Process has ended, cleanup is in progress and new viewers are refused
+	TaskStateCompleted = "completed" // Process has ended, output is retained and viewers may still attach
+	TaskStateFailed    = "failed"    // Wrapper never ran the command within the startup timeout, the task is removed
+)
+
 // NewTaskManager creates a new task manager
 func NewTaskManager(config *Config) *TaskManager {
-	return &TaskManager{
+	tm := &TaskManager{
 		config:       config,
 		runningTasks: make(map[string]*RunningTask),
+		startProcess: (*exec.Cmd).Start,
+		generateID:   generateTaskID,
+
+		disconnectGrace:   defaultDisconnectGrace,
+		unviewedRetention: defaultUnviewedRetention,
+	}
+	tm.monitor = newTaskMonitor(tm, config.Server.MonitorWorkers)
+	tm.tailHandles = newTailHandleGuard(config.Server.MaxTailHandles)
+	tm.tailers = newTailerLimiter(config.Server.MaxTailers)
+	if config.Server.MaxTaskDirBytes > 0 {
+		tm.taskDirSize = newTaskDirUsage(config.Server.TaskDir, taskDirUsageRefreshInterval)
 	}
+	for _, task := range config.Tasks {
+		if task.SingleUseViewerToken {
+			maxEntries := config.Server.CacheMaxEntries
+			if maxEntries == 0 {
+				maxEntries = defaultCacheMaxEntries
+			}
+			tm.viewerUses = newViewerTokenUses(maxEntries, singleUseWebSocketWindow)
+			break
+		}
+	}
+	return tm
 }
 
 // StartTask starts a predefined task as a background process
 func (tm *TaskManager) StartTask(taskName string, parameters map[string]interface{}) (string, error) {
+	return tm.StartTaskWithOptions(taskName, parameters, StartOptions{})
+}
+
+// StartTaskWithOptions starts a predefined task as a background process with optional settings
+func (tm *TaskManager) StartTaskWithOptions(taskName string, parameters map[string]interface{}, opts StartOptions) (string, error) {
+	requestedAt := time.Now()
+
+	if tm.IsDraining() {
+		return "", ErrServerDraining
+	}
+
 	// Validate task name
 	if err := validateTaskName(taskName); err != nil {
 		return "", fmt.Errorf("invalid task name: %w", err)
 	}
 
-	// Find task in config
-	var taskConfig *TaskConfig
-	for i := range tm.config.Tasks {
-		if tm.config.Tasks[i].Name == taskName {
-			taskConfig = &tm.config.Tasks[i]
-			break
+	// Validate label
+	if opts.Label != "" {
+		if err := validateTaskLabel(opts.Label); err != nil {
+			return "", fmt.Errorf("invalid label: %w", err)
 		}
 	}
 
+	// Find task in config
+	taskConfig := findTaskConfig(tm.config.Tasks, taskName)
 	if taskConfig == nil {
-		return "", fmt.Errorf("task '%s' not found in configuration", taskName)
+		if suggestion := suggestTaskName(tm.config.Tasks, taskName); suggestion != "" {
+			return "", fmt.Errorf("%w: '%s' (did you mean '%s'?)", ErrTaskNotFound, taskName, suggestion)
+		}
+		return "", fmt.Errorf("%w: '%s'", ErrTaskNotFound, taskName)
+	}
+	if !taskConfig.IsEnabled() {
+		return "", fmt.Errorf("task '%s': %w", taskName, ErrTaskDisabled)
 	}
 
 	// Validate and process parameters
 	validatedParams, err := validateAndProcessParameters(taskConfig.Parameters, parameters)
 	if err != nil {
-		return "", fmt.Errorf("parameter validation failed: %w", err)
+		return "", fmt.Errorf("%w: %w", ErrParameterValidation, err)
 	}
 
-	// Substitute parameters in command
-	command := substituteParameters(taskConfig.Command, validatedParams)
+	// Forward allowed request headers as environment variables
+	headerEnv, err := forwardedHeaderEnv(taskConfig.ForwardHeaders, opts.Headers)
+	if err != nil {
+		return "", fmt.Errorf("task '%s': %w", taskName, err)
+	}
+
+	// Refuse new output while the task directory is over its size limit
+	if tm.taskDirSize != nil {
+		if size := tm.taskDirSize.Size(); size >= tm.config.Server.MaxTaskDirBytes {
+			log.Printf("[TASK] Task directory uses %d bytes (max %d), rejecting start of task '%s'", size, tm.config.Server.MaxTaskDirBytes, taskName)
+			return "", fmt.Errorf("%w (%d of %d bytes used)", ErrTaskDirFull, size, tm.config.Server.MaxTaskDirBytes)
+		}
+	}
 
-	// Generate unique task ID
-	taskID := uuid.New().String()
+	// Generate unique task ID and create its output directory with restrictive permissions (0700)
+	startTime := time.Now()
+	taskID, outputDir, err := tm.newTaskID(startTime)
+	if err != nil {
+		return "", err
+	}
 
-	// Create output directory with restrictive permissions (0700)
-	outputDir := filepath.Join(tm.config.Server.TaskDir, taskID)
-	if err := os.MkdirAll(outputDir, 0700); err != nil {
-		return "", fmt.Errorf("failed to create output directory: %w", err)
+	// Write contents of file parameters, their path is substituted instead of the content
+	if err := writeFileParameters(taskConfig.Parameters, validatedParams, outputDir); err != nil {
+		os.RemoveAll(outputDir)
+		return "", err
 	}
 
-	stdoutPath := filepath.Join(outputDir, "stdout")
-	stderrPath := filepath.Join(outputDir, "stderr")
+	// Pass decoded base64 strings as environment variables, their placeholders reference the variable
+	paramEnv := append(encodedParameterEnv(taskConfig.Parameters, validatedParams), headerEnv...)
+
+	// Substitute parameters in command (and cleanup command, if any)
+	command := substituteParameters(taskConfig.Command, validatedParams)
+	steps := make([]StepConfig, len(taskConfig.Steps))
+	for i, step := range taskConfig.Steps {
+		steps[i] = StepConfig{Name: step.Name, Command: substituteParameters(step.Command, validatedParams)}
+	}
+	var stepMarker string
+	if len(steps) > 0 {
+		if stepMarker, err = newStepMarker(); err != nil {
+			os.RemoveAll(outputDir)
+			return "", fmt.Errorf("failed to generate step marker: %w", err)
+		}
+	}
+	var cleanupCommand string
+	if taskConfig.CleanupCommand != "" {
+		cleanupCommand = substituteParameters(taskConfig.CleanupCommand, validatedParams)
+	}
+	var preCheck string
+	if taskConfig.PreCheck != "" {
+		preCheck = substituteParameters(taskConfig.PreCheck, validatedParams)
+	}
+
+	// Defense in depth: only run binaries from server.allowed_commands
+	if err := tm.checkAllowedCommands(command, steps, cleanupCommand, preCheck); err != nil {
+		os.RemoveAll(outputDir)
+		log.Printf("[TASK] Refusing to start task '%s': %v", taskName, err)
+		return "", fmt.Errorf("task '%s': %w", taskName, err)
+	}
+
+	// Only start if the task's precondition holds
+	if preCheck != "" {
+		if err := tm.runPreCheck(preCheck, paramEnv, outputDir, preCheckTimeout(taskConfig)); err != nil {
+			os.RemoveAll(outputDir)
+			log.Printf("[TASK] Not starting task '%s': %v", taskName, err)
+			return "", fmt.Errorf("task '%s': %w", taskName, err)
+		}
+	}
 
 	// Create wrapper script that redirects output to files
 	// Write PID to file, capture exit code, and use unbuffered output
-	// Escape command to prevent injection even if config is compromised
 	pidPath := filepath.Join(outputDir, "pid")
-	exitCodePath := filepath.Join(outputDir, "exitcode")
-	escapedCommand := escapeBashCommand(command)
-	escapedOutputDir := escapeBashCommand(outputDir)
-	wrapperScript := fmt.Sprintf(`#!/bin/bash
-set +e
-echo $$ > %s
-cd %s
-exec > %s 2> %s
-bash -c %s
-EXIT_CODE=$?
-echo $EXIT_CODE > %s
-exit $EXIT_CODE
-`, pidPath, escapedOutputDir, stdoutPath, stderrPath, escapedCommand, exitCodePath)
+	wrapperScript := buildWrapperScript(wrapperOptions{
+		Command:        command,
+		Steps:          steps,
+		StepMarker:     stepMarker,
+		ContinueOnStep: taskConfig.ContinueOnStepFailure,
+		CleanupCommand: cleanupCommand,
+		CompressOutput: taskConfig.CompressOutput,
+		HeartbeatEvery: taskHeartbeatEvery(taskConfig),
+		OutputDir:      outputDir,
+		MaxOpenFiles:   taskConfig.MaxOpenFiles,
+		Umask:          tm.umask(taskConfig),
+		Shell:          tm.shell(),
+	})
 
 	scriptPath := filepath.Join(outputDir, "run.sh")
 	// Use 0700 permissions (owner only) instead of 0755
 	if err := os.WriteFile(scriptPath, []byte(wrapperScript), 0700); err != nil {
+		os.RemoveAll(outputDir)
 		return "", fmt.Errorf("failed to create wrapper script: %w", err)
 	}
 
-	// Start task process directly (replaces `at` command)
+	// Start task process directly (replaces `at` command), retrying transient failures
 	// This works without elevated privileges
-	cmd := exec.Command("bash", scriptPath)
-
-	// Set up process attributes for background execution
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setsid: true, // Create new session to detach from terminal
-	}
-
-	// Redirect stdin to /dev/null to detach from terminal
-	stdinFile, err := os.OpenFile("/dev/null", os.O_RDONLY, 0)
+	cmd, err := tm.startTaskProcess(scriptPath, paramEnv)
 	if err != nil {
-		return "", fmt.Errorf("failed to open /dev/null: %w", err)
-	}
-	cmd.Stdin = stdinFile
-
-	// Start the process
-	if err := cmd.Start(); err != nil {
-		stdinFile.Close()
+		os.RemoveAll(outputDir)
 		log.Printf("[TASK] Failed to start task process: %v", err)
 		return "", fmt.Errorf("failed to start task process: %w", err)
 	}
-	// Close stdin file after process has started (command has its own fd)
-	stdinFile.Close()
 
 	// Write PID immediately (the script will also write it, but this ensures it's there)
 	pid := cmd.Process.Pid
 	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(pid)), 0600); err != nil {
 		log.Printf("[TASK] Warning: failed to write PID file: %v", err)
 	}
+	pidWrittenAt := time.Now()
 
-	// Don't wait for the process - let it run in background
-	// The process will write its own PID and exit code when done
-	go func() {
-		// Wait for process to complete (in background goroutine)
-		// This prevents zombie processes
-		cmd.Wait()
-	}()
+	// Remember the start time to tell the process apart from a later process with the same PID
+	procStartTime, err := processStartTime(pid)
+	if err != nil {
+		log.Printf("[TASK] Warning: failed to read process start time of PID %d: %v", pid, err)
+	}
 
-	log.Printf("[TASK] Task started: task_id=%s, task_name=%s, pid=%d, script=%s", taskID, taskName, pid, scriptPath)
+	log.Printf("[TASK] Task started: task_id=%s, task_name=%s, label=%q, pid=%d, script=%s, start_latency=%v", taskID, taskName, opts.Label, pid, scriptPath, pidWrittenAt.Sub(requestedAt))
 
 	// Calculate max execution time
 	var maxExecTime time.Duration
@@ -154,8 +303,7 @@ exit $EXIT_CODE
 	}
 
 	// Register running task
-	tm.mu.Lock()
-	tm.runningTasks[taskID] = &RunningTask{
+	task := &RunningTask{
 		ID:               taskID,
 		TaskName:         taskName,
 		StartTime:        time.Now(),
@@ -163,16 +311,353 @@ exit $EXIT_CODE
 		MaxExecutionTime: maxExecTime,
 		Terminated:       false,
 		Killed:           false,
+		State:            TaskStateRunning,
+		Label:            opts.Label,
+		StepMarker:       stepMarker,
+		ProcessStartTime: procStartTime,
+		RequestedAt:      requestedAt,
+		PIDWrittenAt:     pidWrittenAt,
+		exited:           make(chan struct{}),
+		archived:         make(chan struct{}),
 	}
+	tm.mu.Lock()
+	tm.runningTasks[taskID] = task
 	tm.mu.Unlock()
 
+	// Don't wait for the process - let it run in background.
+	// Waiting in a goroutine prevents zombie processes and yields the authoritative exit status,
+	// which is also available if the wrapper was killed before writing the exitcode file.
+	go func() {
+		cmd.Wait()
+		exitCode := processExitCode(cmd.ProcessState)
+
+		tm.mu.Lock()
+		task.Exited = true
+		task.ExitCode = exitCode
+		tm.mu.Unlock()
+		close(task.exited)
+		log.Printf("[TASK] Process exited: task_id=%s, pid=%d, exit_code=%d", taskID, pid, exitCode)
+
+		// Archive the output before viewers or the retention window clean up the output directory
+		if taskConfig.ArchiveDir != "" {
+			if dir, err := archiveTaskOutput(taskConfig.ArchiveDir, task, exitCode, time.Now()); err != nil {
+				log.Printf("[TASK] Failed to archive output of task_id=%s: %v", taskID, err)
+			} else {
+				log.Printf("[TASK] Archived output of task_id=%s to %s", taskID, dir)
+			}
+		}
+		close(task.archived)
+
+		// Runs nobody watches (scheduled runs, viewer links never opened) are cleaned up here,
+		// runs with connected viewers by the viewers' monitor
+		tm.cleanupUnviewed(taskID)
+	}()
+
 	return taskID, nil
 }
 
+// startTaskProcess starts the wrapper script in a new session. Transient failures (e.g. temporary
+// resource exhaustion) are retried up to server.start_retries times with exponential backoff.
+func (tm *TaskManager) startTaskProcess(scriptPath string, env []string) (*exec.Cmd, error) {
+	retries := tm.config.Server.StartRetries
+	delay := startRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		// A Cmd can only be started once, so each attempt gets a new one
+		cmd := exec.Command(tm.shell(), scriptPath)
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+
+		// Set up process attributes for background execution
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setsid: true, // Create new session to detach from terminal
+		}
+
+		// Redirect stdin to /dev/null to detach from terminal
+		stdinFile, err := os.OpenFile("/dev/null", os.O_RDONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open /dev/null: %w", err)
+		}
+		cmd.Stdin = stdinFile
+
+		err = tm.startProcess(cmd)
+		// Close stdin file after process has started (command has its own fd)
+		stdinFile.Close()
+		if err == nil {
+			return cmd, nil
+		}
+		if attempt >= retries || !isTransientStartError(err) {
+			return nil, err
+		}
+		log.Printf("[TASK] Failed to start task process (attempt %d of %d), retrying in %v: %v", attempt+1, retries+1, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientStartError reports whether a failed process start may succeed when retried.
+// Other errors (e.g. missing or non-executable shell) are permanent.
+func isTransientStartError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ETXTBSY)
+}
+
+// StartLatency returns the time between the start request and the process writing its PID
+// (validation, output directory setup and fork/exec), 0 if unknown
+func (t *RunningTask) StartLatency() time.Duration {
+	if t.RequestedAt.IsZero() || t.PIDWrittenAt.IsZero() {
+		return 0
+	}
+	return t.PIDWrittenAt.Sub(t.RequestedAt)
+}
+
+// processExitCode converts a process state into a shell-style exit code (128+signal if killed)
+func processExitCode(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// taskOutputDir returns the output directory of a task according to the configured layout
+func taskOutputDir(taskDir, layout, taskID string, startTime time.Time) string {
+	if layout == OutputLayoutDate {
+		return filepath.Join(taskDir, startTime.UTC().Format("2006-01-02"), taskID)
+	}
+	return filepath.Join(taskDir, taskID)
+}
+
+// removeOutputDir removes a task's output directory and, for the date layout, the date
+// directory once it is empty
+func (tm *TaskManager) removeOutputDir(outputDir string) error {
+	if err := os.RemoveAll(outputDir); err != nil {
+		return err
+	}
+	if parent := filepath.Dir(outputDir); parent != filepath.Clean(tm.config.Server.TaskDir) {
+		// Fails while other tasks of the same day still have output, which is fine
+		os.Remove(parent)
+	}
+	return nil
+}
+
+// checkAllowedCommands checks the substituted task command, step commands, cleanup command and
+// pre_check against server.allowed_commands
+func (tm *TaskManager) checkAllowedCommands(command string, steps []StepConfig, cleanupCommand, preCheck string) error {
+	allowed := tm.config.Server.AllowedCommands
+	commands := []string{command, cleanupCommand, preCheck}
+	for _, step := range steps {
+		commands = append(commands, step.Command)
+	}
+	for _, cmd := range commands {
+		if cmd == "" {
+			continue
+		}
+		if err := checkAllowedCommand(cmd, allowed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findTaskConfig returns the configuration of the named task, or nil if it is not defined
+func findTaskConfig(tasks []TaskConfig, taskName string) *TaskConfig {
+	for i := range tasks {
+		if tasks[i].Name == taskName {
+			return &tasks[i]
+		}
+	}
+	return nil
+}
+
+// maxSuggestionDistance is the largest edit distance at which a configured task name is suggested for an unknown one
+const maxSuggestionDistance = 3
+
+// suggestTaskName returns the configured task name closest to an unknown name (case-insensitive
+// Levenshtein distance), or "" if none is close enough. The distance must also be less than half the
+// length of the configured name, so short names don't match arbitrary input.
+func suggestTaskName(tasks []TaskConfig, taskName string) string {
+	name := strings.ToLower(taskName)
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, task := range tasks {
+		distance := levenshtein(name, strings.ToLower(task.Name))
+		if distance < bestDistance && distance*2 < len(task.Name) {
+			best, bestDistance = task.Name, distance
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance (insertions, deletions, substitutions) between two strings, by byte
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// wrapperOptions holds the settings used to generate a task's wrapper script
+type wrapperOptions struct {
+	Command        string       // Command with parameters already substituted
+	Steps          []StepConfig // Steps with parameters already substituted, run instead of Command if set
+	StepMarker     string       // Prefix of the step marker lines (see newStepMarker)
+	ContinueOnStep bool         // Run the remaining steps after a failed step
+	CleanupCommand string       // Command run on exit of the wrapper, regardless of success (empty = none)
+	CompressOutput bool         // Write stdout/stderr gzip-compressed to stdout.gz/stderr.gz
+	HeartbeatEvery int          // Interval in seconds at which the heartbeat file is touched while the command runs (0 = none)
+	OutputDir      string       // Task output directory
+	MaxOpenFiles   int          // RLIMIT_NOFILE for the task (0 = inherit)
+	Umask          string       // Octal umask for the task commands (empty = inherit)
+	Shell          string       // Shell for the shebang and for running the commands (empty = defaultShell)
+}
+
+// shell returns the configured shell or defaultShell
+func (tm *TaskManager) shell() string {
+	if tm.config.Server.Shell != "" {
+		return tm.config.Server.Shell
+	}
+	return defaultShell
+}
+
+// umask returns the umask for a task: its own, or else server.umask (empty = inherit)
+func (tm *TaskManager) umask(taskConfig *TaskConfig) string {
+	if taskConfig.Umask != "" {
+		return taskConfig.Umask
+	}
+	return tm.config.Server.Umask
+}
+
+// buildWrapperScript generates the wrapper script that runs the task command (or its steps),
+// redirects its output to files and records PID and exit code. Right before the command runs,
+// the started file marks the start as successful.
+// The command is escaped to prevent injection even if config is compromised.
+// A cleanup command runs in an EXIT trap after the exit code has been recorded,
+// with its output written to a separate "cleanup" file.
+func buildWrapperScript(opts wrapperOptions) string {
+	pidPath := filepath.Join(opts.OutputDir, "pid")
+	stdoutPath := filepath.Join(opts.OutputDir, "stdout")
+	stderrPath := filepath.Join(opts.OutputDir, "stderr")
+	exitCodePath := filepath.Join(opts.OutputDir, "exitcode")
+	cleanupPath := filepath.Join(opts.OutputDir, "cleanup")
+	startedPath := filepath.Join(opts.OutputDir, startedFileName)
+	escapedOutputDir := escapeBashCommand(opts.OutputDir)
+	shell := opts.Shell
+	if shell == "" {
+		shell = defaultShell
+	}
+
+	var cleanup strings.Builder
+	if opts.CleanupCommand != "" {
+		fmt.Fprintf(&cleanup, "run_cleanup() {\n\t%s -c %s > %s 2>&1\n}\ntrap run_cleanup EXIT\n", shell, escapeBashCommand(opts.CleanupCommand), cleanupPath)
+	}
+
+	var limits strings.Builder
+	if opts.MaxOpenFiles > 0 {
+		// Fail the task (instead of running it unrestricted) if the limit cannot be applied
+		fmt.Fprintf(&limits, "ulimit -n %d || { echo 126 > %s; exit 126; }\n", opts.MaxOpenFiles, exitCodePath)
+	}
+	if opts.Umask != "" {
+		// Set after the output redirection, so the output files stay readable for the server
+		fmt.Fprintf(&limits, "umask %s\n", opts.Umask)
+	}
+
+	redirect := fmt.Sprintf("exec > %s 2> %s\n", stdoutPath, stderrPath)
+	var finish string
+	if opts.CompressOutput {
+		// Output goes through FIFOs into gzip. gzip ignores termination signals sent to the process group
+		// on timeout, so it still completes the file once the command has ended; the wrapper waits for it.
+		stdoutPipe := filepath.Join(opts.OutputDir, "stdout.pipe")
+		stderrPipe := filepath.Join(opts.OutputDir, "stderr.pipe")
+		redirect = fmt.Sprintf(`mkfifo -m 600 %[1]s %[2]s || { echo 126 > %[5]s; exit 126; }
+( trap '' TERM INT HUP; exec gzip -c < %[1]s > %[3]s ) &
+( trap '' TERM INT HUP; exec gzip -c < %[2]s > %[4]s ) &
+exec > %[1]s 2> %[2]s
+rm -f %[1]s %[2]s
+`, stdoutPipe, stderrPipe, stdoutPath+gzipSuffix, stderrPath+gzipSuffix, exitCodePath)
+		finish = "exec > /dev/null 2>&1\nwait\n"
+	}
+
+	var heartbeat, stopHeartbeat string
+	if opts.HeartbeatEvery > 0 {
+		// Touch the heartbeat file in the background while the command runs; the loop also ends if the wrapper is gone
+		heartbeat = fmt.Sprintf("( while kill -0 $$ 2>/dev/null; do touch %s; sleep %d; done ) > /dev/null 2>&1 &\nHEARTBEAT_PID=$!\n",
+			filepath.Join(opts.OutputDir, heartbeatFileName), opts.HeartbeatEvery)
+		stopHeartbeat = "kill $HEARTBEAT_PID 2>/dev/null\n"
+	}
+
+	run := fmt.Sprintf("%s -c %s\nEXIT_CODE=$?\n", shell, escapeBashCommand(opts.Command))
+	if len(opts.Steps) > 0 {
+		run = buildStepsScript(shell, opts.StepMarker, opts.Steps, opts.ContinueOnStep)
+	}
+
+	return fmt.Sprintf(`#!%s
+set +e
+echo $$ > %s
+cd %s
+%s%s%s%stouch %s
+%s%secho $EXIT_CODE > %s
+%sexit $EXIT_CODE
+`, shell, pidPath, escapedOutputDir, cleanup.String(), redirect, limits.String(), heartbeat, startedPath, run, stopHeartbeat, exitCodePath, finish)
+}
+
+// generateTaskID returns a random task ID in the given scheme
+func generateTaskID(scheme string) (string, error) {
+	if scheme == TaskIDSchemeShort {
+		return newShortTaskID()
+	}
+	return uuid.New().String(), nil
+}
+
+// newTaskID generates an ID for a new task in the configured scheme that is not used by a task in
+// memory and creates its output directory (for a task started at startTime) with permissions 0700.
+// The directory is claimed with a single mkdir, so concurrent starts can't end up with the same ID.
+// Collisions are practically impossible, but cheap to rule out; a bounded number of new IDs is tried.
+func (tm *TaskManager) newTaskID(startTime time.Time) (taskID, outputDir string, err error) {
+	for attempt := 0; attempt < maxTaskIDAttempts; attempt++ {
+		taskID, err = tm.generateID(tm.config.Server.TaskIDScheme)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate task ID: %w", err)
+		}
+		tm.mu.RLock()
+		_, exists := tm.runningTasks[taskID]
+		tm.mu.RUnlock()
+		if !exists {
+			outputDir = taskOutputDir(tm.config.Server.TaskDir, tm.config.Server.OutputLayout, taskID, startTime)
+			if err := os.MkdirAll(filepath.Dir(outputDir), 0700); err != nil {
+				return "", "", fmt.Errorf("failed to create output directory: %w", err)
+			}
+			err := os.Mkdir(outputDir, 0700)
+			if err == nil {
+				return taskID, outputDir, nil
+			}
+			if !os.IsExist(err) {
+				return "", "", fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+		log.Printf("[TASK] Generated task ID %s is already in use, generating a new one", taskID)
+	}
+	return "", "", fmt.Errorf("failed to generate task ID: no unused task ID after %d attempts", maxTaskIDAttempts)
+}
+
 // GetTask returns information about a running task
 func (tm *TaskManager) GetTask(taskID string) (*RunningTask, error) {
-	// Validate task ID format (must be UUID)
-	if !validateTaskID(taskID) {
+	// Validate task ID format (must match the configured scheme)
+	if !validateTaskID(taskID, tm.config.Server.TaskIDScheme) {
 		return nil, fmt.Errorf("invalid task ID format")
 	}
 
@@ -187,6 +672,194 @@ func (tm *TaskManager) GetTask(taskID string) (*RunningTask, error) {
 	return task, nil
 }
 
+// MarkFinishing marks a task as finishing so that no new viewers attach while its output is cleaned up
+func (tm *TaskManager) MarkFinishing(taskID string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if task, ok := tm.runningTasks[taskID]; ok {
+		task.State = TaskStateFinishing
+	}
+}
+
+// IsFinishing reports whether the task has ended and its cleanup has started
+func (tm *TaskManager) IsFinishing(taskID string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	task, ok := tm.runningTasks[taskID]
+	return ok && task.State == TaskStateFinishing
+}
+
+// MarkCompleted marks a task whose process has ended as completed and schedules the removal
+// of its output after the configured retention window. Returns false if output retention is
+// disabled, in which case the caller cleans up immediately.
+func (tm *TaskManager) MarkCompleted(taskID string) bool {
+	retention := time.Duration(tm.config.Server.OutputRetention) * time.Second
+	if retention <= 0 {
+		return false
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	task, ok := tm.runningTasks[taskID]
+	if !ok || task.Completed {
+		// Already removed or scheduled for removal (e.g. by another viewer's monitor)
+		return true
+	}
+	task.Completed = true
+	task.CompletedAt = time.Now()
+	task.State = TaskStateCompleted
+	time.AfterFunc(retention, func() {
+		tm.removeTask(taskID)
+	})
+	log.Printf("[TASK] Task completed, retaining output for %v: task_id=%s", retention, taskID)
+	return true
+}
+
+// IsCompleted reports whether the task's process has ended and its output is retained
+func (tm *TaskManager) IsCompleted(taskID string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	task, ok := tm.runningTasks[taskID]
+	return ok && task.Completed
+}
+
+// Exited returns a channel that is closed once the task's process has exited,
+// or nil if the task is unknown or was not started by this task manager
+func (tm *TaskManager) Exited(taskID string) <-chan struct{} {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	task, ok := tm.runningTasks[taskID]
+	if !ok || task.exited == nil {
+		return nil
+	}
+	return task.exited
+}
+
+// ProcessStartTime returns the recorded start time of the task's process (0 = unknown)
+func (tm *TaskManager) ProcessStartTime(taskID string) uint64 {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	if task, ok := tm.runningTasks[taskID]; ok {
+		return task.ProcessStartTime
+	}
+	return 0
+}
+
+// WaitExitCode waits up to timeout for the task's process to exit and returns the exit status
+// reported by cmd.Wait. ok is false if the status is not (yet) known.
+func (tm *TaskManager) WaitExitCode(taskID string, timeout time.Duration) (exitCode int, ok bool) {
+	if exited := tm.Exited(taskID); exited != nil {
+		select {
+		case <-exited:
+		case <-time.After(timeout):
+		}
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	task, found := tm.runningTasks[taskID]
+	if !found || !task.Exited {
+		return 0, false
+	}
+	return task.ExitCode, true
+}
+
+// removeTask unregisters a task and removes its output directory
+func (tm *TaskManager) removeTask(taskID string) {
+	tm.mu.Lock()
+	task, ok := tm.runningTasks[taskID]
+	delete(tm.runningTasks, taskID)
+	tm.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	waitArchived(task)
+	if err := tm.removeOutputDir(task.OutputDir); err != nil {
+		log.Printf("[TASK] Failed to cleanup directory %s (task_id=%s): %v", task.OutputDir, taskID, err)
+	} else {
+		log.Printf("[TASK] Retention expired, cleaned up directory: %s (task_id=%s)", task.OutputDir, taskID)
+	}
+}
+
+// waitArchived blocks until the task's output has been archived, removing the output directory
+// earlier would truncate the archive
+func waitArchived(task *RunningTask) {
+	if task.archived != nil {
+		<-task.archived
+	}
+}
+
+// IsRunning reports whether a process of the named task is still running
+func (tm *TaskManager) IsRunning(taskName string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	for _, task := range tm.runningTasks {
+		if task.TaskName == taskName && !task.Exited {
+			return true
+		}
+	}
+	return false
+}
+
+// StartDraining stops the task manager from starting new tasks (for graceful shutdown)
+func (tm *TaskManager) StartDraining() {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.draining = true
+}
+
+// IsDraining reports whether StartDraining has been called
+func (tm *TaskManager) IsDraining() bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	return tm.draining
+}
+
+// runningCount returns the number of tasks whose process has not exited yet
+func (tm *TaskManager) runningCount() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	count := 0
+	for _, task := range tm.runningTasks {
+		if !task.Exited {
+			count++
+		}
+	}
+	return count
+}
+
+// WaitForRunningTasks waits up to timeout for all task processes to exit.
+// Returns false if processes are still running when the timeout expires.
+func (tm *TaskManager) WaitForRunningTasks(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		count := tm.runningCount()
+		if count == 0 {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			log.Printf("[TASK] Drain timeout expired with %d task(s) still running", count)
+			return false
+		}
+		<-ticker.C
+	}
+}
+
 // GetAllTasks returns all running tasks (for cleanup on shutdown)
 func (tm *TaskManager) GetAllTasks() []*RunningTask {
 	tm.mu.RLock()
@@ -206,7 +879,7 @@ func (tm *TaskManager) CleanupAllTasks() {
 
 	log.Printf("[TASK] Cleaning up %d task directories", len(tm.runningTasks))
 	for taskID, task := range tm.runningTasks {
-		if err := os.RemoveAll(task.OutputDir); err != nil {
+		if err := tm.removeOutputDir(task.OutputDir); err != nil {
 			log.Printf("[TASK] Failed to cleanup directory %s (task_id=%s): %v", task.OutputDir, taskID, err)
 		} else {
 			log.Printf("[TASK] Cleaned up directory: %s (task_id=%s)", task.OutputDir, taskID)
@@ -248,7 +921,7 @@ func validateAndProcessParameters(paramDefs []ParameterConfig, providedParams ma
 		}
 
 		// Validate the parameter value
-		validatedValue, err := validateParameterValue(paramDef.Name, paramDef.Type, value)
+		validatedValue, err := validateParameterValue(paramDef, value)
 		if err != nil {
 			return nil, err
 		}
@@ -273,13 +946,118 @@ func validateAndProcessParameters(paramDefs []ParameterConfig, providedParams ma
 	return validated, nil
 }
 
+// writeFileParameters writes the content of each provided "file" parameter to "param_<name>"
+// in the output directory (0600) and replaces the parameter value with the file's path
+func writeFileParameters(paramDefs []ParameterConfig, validated map[string]string, outputDir string) error {
+	for _, paramDef := range paramDefs {
+		if paramDef.Type != "file" {
+			continue
+		}
+		content, ok := validated[paramDef.Name]
+		if !ok {
+			continue
+		}
+		// Parameter name becomes part of the file name
+		if !taskNameRegex.MatchString(paramDef.Name) {
+			return fmt.Errorf("file parameter '%s' has an invalid name", paramDef.Name)
+		}
+		path := filepath.Join(outputDir, "param_"+paramDef.Name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return fmt.Errorf("failed to write file parameter '%s': %w", paramDef.Name, err)
+		}
+		validated[paramDef.Name] = path
+	}
+	return nil
+}
+
+// parameterEnvName returns the environment variable holding a decoded base64 parameter
+func parameterEnvName(paramName string) string {
+	return "PARAM_" + strings.ToUpper(paramName)
+}
+
+// encodedParameterEnv returns NAME=value environment entries for the provided base64 string parameters
+// and replaces their values with a quoted reference to the variable ("$PARAM_NAME"), so the decoded
+// value never becomes part of the shell command. Empty values stay empty for conditional sections.
+func encodedParameterEnv(paramDefs []ParameterConfig, validated map[string]string) []string {
+	var env []string
+	for _, paramDef := range paramDefs {
+		if paramDef.Encoding != "base64" || paramDef.Type != "string" {
+			continue
+		}
+		value, ok := validated[paramDef.Name]
+		if !ok {
+			continue
+		}
+		envName := parameterEnvName(paramDef.Name)
+		env = append(env, envName+"="+value)
+		if value != "" {
+			validated[paramDef.Name] = `"$` + envName + `"`
+		}
+	}
+	return env
+}
+
 // substituteParameters substitutes parameter placeholders in the command
 // Placeholder format: {{param_name}}
+// Conditional sections {{#param_name}}...{{/param_name}} are only kept if the parameter has a non-empty value
 func substituteParameters(command string, parameters map[string]string) string {
-	result := command
+	result := expandConditionalSections(command, parameters)
 	for paramName, paramValue := range parameters {
 		placeholder := fmt.Sprintf("{{%s}}", paramName)
 		result = strings.ReplaceAll(result, placeholder, paramValue)
 	}
 	return result
 }
+
+// placeholderRegex matches {{name}} placeholders and {{#name}}/{{/name}} conditional section tags
+var placeholderRegex = regexp.MustCompile(`\{\{[#/]?([^{}]*)\}\}`)
+
+// commandPlaceholders returns the parameter names referenced in a command, in order of first use
+func commandPlaceholders(command string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, match := range placeholderRegex.FindAllStringSubmatch(command, -1) {
+		if name := match[1]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// expandConditionalSections resolves {{#param}}...{{/param}} blocks (no nesting).
+// A block's content is kept if the parameter has a non-empty value and dropped otherwise.
+// Unterminated blocks are left unchanged.
+func expandConditionalSections(command string, parameters map[string]string) string {
+	var result strings.Builder
+	rest := command
+	for {
+		start := strings.Index(rest, "{{#")
+		if start < 0 {
+			break
+		}
+		nameEnd := strings.Index(rest[start+3:], "}}")
+		if nameEnd < 0 {
+			break
+		}
+		name := rest[start+3 : start+3+nameEnd]
+		openTag := "{{#" + name + "}}"
+		closeTag := "{{/" + name + "}}"
+		contentStart := start + len(openTag)
+		end := strings.Index(rest[contentStart:], closeTag)
+		if end < 0 {
+			// No matching end tag, keep the text as is
+			result.WriteString(rest[:contentStart])
+			rest = rest[contentStart:]
+			continue
+		}
+
+		result.WriteString(rest[:start])
+		if parameters[name] != "" {
+			result.WriteString(rest[contentStart : contentStart+end])
+		}
+		rest = rest[contentStart+end+len(closeTag):]
+	}
+	result.WriteString(rest)
+	return result.String()
+}
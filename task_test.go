@@ -1,8 +1,15 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -197,6 +204,36 @@ func TestSubstituteParameters(t *testing.T) {
 			parameters: map[string]string{"value": ""},
 			want:       "echo ",
 		},
+		{
+			name:       "conditional section with parameter present",
+			command:    "run{{#verbose}} --verbose={{verbose}}{{/verbose}} {{file}}",
+			parameters: map[string]string{"verbose": "2", "file": "data.txt"},
+			want:       "run --verbose=2 data.txt",
+		},
+		{
+			name:       "conditional section with parameter absent",
+			command:    "run{{#verbose}} --verbose={{verbose}}{{/verbose}} {{file}}",
+			parameters: map[string]string{"file": "data.txt"},
+			want:       "run data.txt",
+		},
+		{
+			name:       "conditional section with empty parameter",
+			command:    "run{{#verbose}} --verbose={{verbose}}{{/verbose}}",
+			parameters: map[string]string{"verbose": ""},
+			want:       "run",
+		},
+		{
+			name:       "multiple conditional sections",
+			command:    "run{{#a}} -a {{a}}{{/a}}{{#b}} -b {{b}}{{/b}}",
+			parameters: map[string]string{"b": "x"},
+			want:       "run -b x",
+		},
+		{
+			name:       "unterminated conditional section",
+			command:    "run{{#a}} -a {{a}}",
+			parameters: map[string]string{"a": "x"},
+			want:       "run{{#a}} -a x",
+		},
 	}
 
 	for _, tt := range tests {
@@ -293,6 +330,59 @@ func TestTaskManagerGetTask(t *testing.T) {
 	}
 }
 
+func TestTaskManagerTaskIDScheme(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		scheme    string
+		otherID   string // Valid ID of the other scheme, rejected by GetTask
+		wantLen   int
+		wantShort bool
+	}{
+		{"", "7fKq2ZrT9mXa", 36, false},
+		{TaskIDSchemeUUID, "7fKq2ZrT9mXa", 36, false},
+		{TaskIDSchemeShort, "550e8400-e29b-41d4-a716-446655440000", shortTaskIDLength, true},
+	}
+
+	for _, tt := range tests {
+		t.Run("scheme "+tt.scheme, func(t *testing.T) {
+			config := &Config{
+				Server: ServerConfig{TaskDir: tmpDir, TaskIDScheme: tt.scheme},
+				Tasks:  []TaskConfig{{Name: "test-task", Command: "echo test"}},
+			}
+			tm := NewTaskManager(config)
+
+			taskID, err := tm.StartTask("test-task", nil)
+			if err != nil {
+				t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+			}
+			if len(taskID) != tt.wantLen || shortTaskIDRegex.MatchString(taskID) != tt.wantShort {
+				t.Errorf("TaskManager.StartTask() task ID = %q; want %d characters in scheme %q", taskID, tt.wantLen, tt.scheme)
+			}
+
+			task, err := tm.GetTask(taskID)
+			if err != nil {
+				t.Fatalf("TaskManager.GetTask(%q) = %v; want nil", taskID, err)
+			}
+			if task.ID != taskID || task.OutputDir != filepath.Join(tmpDir, taskID) {
+				t.Errorf("TaskManager.GetTask() = ID %q, OutputDir %q; want ID %q in %s", task.ID, task.OutputDir, taskID, tmpDir)
+			}
+			if _, err := os.Stat(task.OutputDir); err != nil {
+				t.Errorf("output directory of task %q: %v", taskID, err)
+			}
+
+			if _, err := tm.GetTask(tt.otherID); err == nil || !strings.Contains(err.Error(), "invalid task ID format") {
+				t.Errorf("TaskManager.GetTask(%q) = %v; want invalid task ID format", tt.otherID, err)
+			}
+			tm.WaitExitCode(taskID, 5*time.Second)
+		})
+	}
+}
+
 func TestTaskManagerGetAllTasks(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "task-test-*")
 	if err != nil {
@@ -362,6 +452,272 @@ func TestTaskManagerStartTaskValidation(t *testing.T) {
 	}
 }
 
+func TestSuggestTaskName(t *testing.T) {
+	tasks := []TaskConfig{{Name: "deploy"}, {Name: "backup-database"}, {Name: "ls"}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"deploj", "deploy"},
+		{"Deploy", "deploy"},
+		{"depoly", "deploy"},
+		{"backup-databse", "backup-database"},
+		{"backup_database", "backup-database"},
+		{"cleanup", ""},
+		{"dep", ""},
+		{"xs", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestTaskName(tasks, tt.name); got != tt.want {
+				t.Errorf("suggestTaskName(%q) = %q; want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"deploy", "deploy", 0},
+		{"deploy", "deploj", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d; want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestTaskManagerStartTaskSuggestion(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks:  []TaskConfig{{Name: "deploy", Command: "echo deploy"}},
+	}
+	tm := NewTaskManager(config)
+
+	_, err = tm.StartTask("deplyo", nil)
+	if !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("TaskManager.StartTask() = %v; want %v", err, ErrTaskNotFound)
+	}
+	if !strings.Contains(err.Error(), "did you mean 'deploy'?") {
+		t.Errorf("TaskManager.StartTask() error = %q; want suggestion for 'deploy'", err)
+	}
+
+	_, err = tm.StartTask("something-else", nil)
+	if !errors.Is(err, ErrTaskNotFound) {
+		t.Fatalf("TaskManager.StartTask() = %v; want %v", err, ErrTaskNotFound)
+	}
+	if strings.Contains(err.Error(), "did you mean") {
+		t.Errorf("TaskManager.StartTask() error = %q; want no suggestion", err)
+	}
+}
+
+func TestTaskManagerStartRetries(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tests := []struct {
+		name       string
+		retries    int
+		failures   int   // Number of starts failing before the real start is used
+		startErr   error // Error of the failing starts
+		wantErr    bool
+		wantStarts int
+	}{
+		{"transient failure recovers", 3, 2, syscall.EAGAIN, false, 3},
+		{"retries exhausted", 2, 5, syscall.EAGAIN, true, 3},
+		{"permanent failure not retried", 3, 1, &os.PathError{Op: "fork/exec", Path: "/bin/missing", Err: syscall.ENOENT}, true, 1},
+		{"retries disabled", 0, 1, syscall.ENOMEM, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskDir := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "-"))
+			config := &Config{
+				Server: ServerConfig{TaskDir: taskDir, StartRetries: tt.retries},
+				Tasks:  []TaskConfig{{Name: "test-task", Command: "echo test"}},
+			}
+			tm := NewTaskManager(config)
+			starts := 0
+			tm.startProcess = func(cmd *exec.Cmd) error {
+				starts++
+				if starts <= tt.failures {
+					return tt.startErr
+				}
+				return cmd.Start()
+			}
+
+			taskID, err := tm.StartTask("test-task", nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TaskManager.StartTask() error = %v; wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, tt.startErr) {
+				t.Errorf("TaskManager.StartTask() error = %v; want wrapping %v", err, tt.startErr)
+			}
+			if err != nil {
+				// The output directory of a task that never started is removed
+				if entries, _ := os.ReadDir(taskDir); len(entries) != 0 {
+					t.Errorf("task_dir has %d entries after failed start; want 0", len(entries))
+				}
+			}
+			if starts != tt.wantStarts {
+				t.Errorf("process starts = %d; want %d", starts, tt.wantStarts)
+			}
+			if err == nil {
+				if exitCode, ok := tm.WaitExitCode(taskID, 5*time.Second); !ok || exitCode != 0 {
+					t.Errorf("TaskManager.WaitExitCode() = %d, %v; want 0, true", exitCode, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestTaskManagerStartLatency(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo test"}},
+	}
+	tm := NewTaskManager(config)
+
+	before := time.Now()
+	taskID, err := tm.StartTask("test-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	task, err := tm.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() = %v", err)
+	}
+
+	if task.RequestedAt.Before(before) || task.PIDWrittenAt.Before(task.RequestedAt) {
+		t.Errorf("RequestedAt = %v, PIDWrittenAt = %v; want before <= RequestedAt <= PIDWrittenAt", task.RequestedAt, task.PIDWrittenAt)
+	}
+	if latency := task.StartLatency(); latency <= 0 || latency > time.Since(before) {
+		t.Errorf("StartLatency() = %v; want between 0 and %v", latency, time.Since(before))
+	}
+	if latency := (&RunningTask{}).StartLatency(); latency != 0 {
+		t.Errorf("StartLatency() without timestamps = %v; want 0", latency)
+	}
+}
+
+func TestTaskManagerStartTaskDisabled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	enabled, disabled := true, false
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{Name: "disabled-task", Command: "echo test", Enabled: &disabled},
+			{Name: "enabled-task", Command: "echo test", Enabled: &enabled},
+		},
+	}
+
+	tm := NewTaskManager(config)
+
+	_, err = tm.StartTask("disabled-task", map[string]interface{}{})
+	if !errors.Is(err, ErrTaskDisabled) {
+		t.Fatalf("TaskManager.StartTask() with disabled task = %v; want %v", err, ErrTaskDisabled)
+	}
+	if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+		t.Errorf("TaskManager.StartTask() with disabled task created %d entries in task dir; want 0", len(entries))
+	}
+
+	if _, err := tm.StartTask("enabled-task", map[string]interface{}{}); err != nil {
+		t.Errorf("TaskManager.StartTask() with enabled task = %v; want nil", err)
+	}
+}
+
+func TestTaskManagerDraining(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			{Name: "short-task", Command: "sleep 0.5"},
+			{Name: "long-task", Command: "sleep 30"},
+		},
+	}
+
+	t.Run("running task finishes within grace", func(t *testing.T) {
+		tm := NewTaskManager(config)
+		if _, err := tm.StartTask("short-task", nil); err != nil {
+			t.Fatalf("TaskManager.StartTask() = %v", err)
+		}
+
+		tm.StartDraining()
+		if !tm.IsDraining() {
+			t.Fatal("TaskManager.IsDraining() = false; want true")
+		}
+		if _, err := tm.StartTask("short-task", nil); !errors.Is(err, ErrServerDraining) {
+			t.Errorf("TaskManager.StartTask() while draining = %v; want %v", err, ErrServerDraining)
+		}
+		if !tm.WaitForRunningTasks(10 * time.Second) {
+			t.Errorf("TaskManager.WaitForRunningTasks() = false; want true")
+		}
+	})
+
+	t.Run("grace expires with task still running", func(t *testing.T) {
+		tm := NewTaskManager(config)
+		taskID, err := tm.StartTask("long-task", nil)
+		if err != nil {
+			t.Fatalf("TaskManager.StartTask() = %v", err)
+		}
+		defer func() {
+			if task, err := tm.GetTask(taskID); err == nil {
+				// The wrapper runs in its own session, kill its whole process group
+				if pid := readPID(filepath.Join(task.OutputDir, "pid")); pid > 0 {
+					syscall.Kill(-pid, syscall.SIGKILL)
+				}
+			}
+		}()
+
+		tm.StartDraining()
+		start := time.Now()
+		if tm.WaitForRunningTasks(300 * time.Millisecond) {
+			t.Errorf("TaskManager.WaitForRunningTasks() = true; want false")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("TaskManager.WaitForRunningTasks() took %v; want about the grace period", elapsed)
+		}
+	})
+}
+
 func TestTaskManagerStartTaskParameterValidation(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "task-test-*")
 	if err != nil {
@@ -404,17 +760,1011 @@ func TestTaskManagerStartTaskParameterValidation(t *testing.T) {
 	}
 }
 
-// Helper functions
+func TestBuildWrapperScript(t *testing.T) {
+	outputDir := "/var/vsTaskViewer/550e8400-e29b-41d4-a716-446655440000"
 
-func mapsEqual(a, b map[string]string) bool {
-	if len(a) != len(b) {
-		return false
+	script := buildWrapperScript(wrapperOptions{Command: "echo hello", OutputDir: outputDir})
+	if !strings.Contains(script, "bash -c 'echo hello'") {
+		t.Errorf("buildWrapperScript() = %q; want escaped command", script)
 	}
-	for k, v := range a {
-		if b[k] != v {
-			return false
+	if strings.Contains(script, "ulimit -n") {
+		t.Errorf("buildWrapperScript() without max_open_files = %q; want no ulimit", script)
+	}
+	started := strings.Index(script, "touch "+outputDir+"/started")
+	if started < 0 || started > strings.Index(script, "bash -c") {
+		t.Errorf("buildWrapperScript() = %q; want started file created right before the command", script)
+	}
+
+	script = buildWrapperScript(wrapperOptions{Command: "echo hello", OutputDir: outputDir, MaxOpenFiles: 256})
+	if !strings.Contains(script, "ulimit -n 256") {
+		t.Errorf("buildWrapperScript() with max_open_files = %q; want ulimit -n 256", script)
+	}
+	if strings.Index(script, "ulimit -n 256") > strings.Index(script, "bash -c") {
+		t.Error("buildWrapperScript() applies ulimit after running the command")
+	}
+
+	script = buildWrapperScript(wrapperOptions{Command: "echo hello", CleanupCommand: "rm -f '/tmp/x'", OutputDir: outputDir})
+	if !strings.Contains(script, `bash -c 'rm -f '\''/tmp/x'\''' > `) {
+		t.Errorf("buildWrapperScript() with cleanup command = %q; want escaped cleanup command", script)
+	}
+	if !strings.Contains(script, "trap run_cleanup EXIT") {
+		t.Errorf("buildWrapperScript() with cleanup command = %q; want EXIT trap", script)
+	}
+}
+
+func TestTaskManagerStartTaskMaxOpenFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{Name: "nofile-task", Command: "ulimit -n", MaxOpenFiles: 64},
+		},
+	}
+
+	tm := NewTaskManager(config)
+	taskID, err := tm.StartTask("nofile-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+
+	exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(exitCodePath); err == nil {
+			break
 		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "64" {
+		t.Errorf("task stdout = %q; want nofile limit 64", string(output))
+	}
+}
+
+func TestTaskManagerStartTaskUmask(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+			Umask:   "027",
+		},
+		Tasks: []TaskConfig{
+			{Name: "server-umask-task", Command: "touch created"},
+			{Name: "task-umask-task", Command: "touch created", Umask: "0077"},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	tests := []struct {
+		taskName string
+		wantMode os.FileMode
+	}{
+		{"server-umask-task", 0640},
+		{"task-umask-task", 0600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.taskName, func(t *testing.T) {
+			taskID, err := tm.StartTask(tt.taskName, nil)
+			if err != nil {
+				t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+			}
+
+			exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				if _, err := os.Stat(exitCodePath); err == nil {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			info, err := os.Stat(filepath.Join(tmpDir, taskID, "created"))
+			if err != nil {
+				t.Fatalf("Failed to stat file created by the task: %v", err)
+			}
+			if mode := info.Mode().Perm(); mode != tt.wantMode {
+				t.Errorf("created file mode = %o; want %o", mode, tt.wantMode)
+			}
+		})
 	}
-	return true
 }
 
+func TestTaskManagerStartTaskAllowedCommands(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir:         tmpDir,
+			AllowedCommands: []string{"echo"},
+		},
+		Tasks: []TaskConfig{
+			{Name: "allowed-task", Command: "echo hello"},
+			{Name: "disallowed-task", Command: "touch {{name}}", Parameters: []ParameterConfig{
+				{Name: "name", Type: "string"},
+			}},
+			{Name: "disallowed-step-task", Steps: []StepConfig{
+				{Name: "build", Command: "echo build"},
+				{Name: "deploy", Command: "touch deployed"},
+			}},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	taskID, err := tm.StartTask("allowed-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask(allowed-task) = %v; want nil", err)
+	}
+	tm.WaitExitCode(taskID, 5*time.Second)
+
+	for _, tt := range []struct {
+		taskName   string
+		parameters map[string]interface{}
+	}{
+		{"disallowed-task", map[string]interface{}{"name": "created"}},
+		{"disallowed-step-task", nil},
+	} {
+		if _, err := tm.StartTask(tt.taskName, tt.parameters); !errors.Is(err, ErrCommandNotAllowed) {
+			t.Errorf("TaskManager.StartTask(%s) = %v; want %v", tt.taskName, err, ErrCommandNotAllowed)
+		}
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read task dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("task dir has %d entries; want 1 (no output directory for rejected starts)", len(entries))
+	}
+}
+
+func TestTaskManagerStartTaskMaxTaskDirBytes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir:         tmpDir,
+			MaxTaskDirBytes: 4096,
+		},
+		Tasks: []TaskConfig{{Name: "fill-task", Command: "head -c 8192 /dev/zero"}},
+	}
+	tm := NewTaskManager(config)
+
+	// The task directory is empty, the first run may write past the limit
+	taskID, err := tm.StartTask("fill-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+	if _, ok := tm.WaitExitCode(taskID, 5*time.Second); !ok {
+		t.Fatalf("task did not finish")
+	}
+
+	// Measure the filled directory instead of waiting for the background refresh
+	tm.taskDirSize.refresh()
+	if size := tm.taskDirSize.Size(); size < 8192 {
+		t.Fatalf("taskDirUsage.Size() = %d; want >= 8192", size)
+	}
+
+	_, err = tm.StartTask("fill-task", nil)
+	if !errors.Is(err, ErrTaskDirFull) {
+		t.Fatalf("TaskManager.StartTask() = %v; want %v", err, ErrTaskDirFull)
+	}
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read task dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("task dir has %d entries; want 1 (no output directory for the rejected start)", len(entries))
+	}
+}
+
+// Helper functions
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+
+func TestTaskManagerStartTaskCleanupCommand(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	artifact := filepath.Join(tmpDir, "artifact")
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{
+				Name:           "cleanup-task",
+				Command:        "touch " + artifact + "; echo task output; exit 3",
+				CleanupCommand: "rm -f " + artifact + "; echo cleanup done; echo cleanup error >&2",
+			},
+		},
+	}
+
+	tm := NewTaskManager(config)
+	taskID, err := tm.StartTask("cleanup-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+
+	// The cleanup output is written last, once the wrapper exits
+	cleanupPath := filepath.Join(tmpDir, taskID, "cleanup")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(cleanupPath); err == nil && strings.Contains(string(data), "cleanup error") {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cleanupOutput, err := os.ReadFile(cleanupPath)
+	if err != nil {
+		t.Fatalf("Failed to read cleanup output: %v", err)
+	}
+	if string(cleanupOutput) != "cleanup done\ncleanup error\n" {
+		t.Errorf("cleanup output = %q; want stdout and stderr of the cleanup command", string(cleanupOutput))
+	}
+	if _, err := os.Stat(artifact); !os.IsNotExist(err) {
+		t.Errorf("artifact still exists after cleanup (stat error: %v)", err)
+	}
+
+	stdout, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if string(stdout) != "task output\n" {
+		t.Errorf("task stdout = %q; want only the task output", string(stdout))
+	}
+	if exitCode := readExitCode(filepath.Join(tmpDir, taskID, "exitcode")); exitCode != 3 {
+		t.Errorf("exit code = %d; want 3 (not changed by the cleanup command)", exitCode)
+	}
+}
+
+func TestTaskManagerMarkCompletedRetention(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	outputDir := filepath.Join(tmpDir, taskID)
+	if err := os.MkdirAll(outputDir, 0700); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+
+	tm := NewTaskManager(&Config{Server: ServerConfig{TaskDir: tmpDir, OutputRetention: 1}})
+	tm.runningTasks[taskID] = &RunningTask{ID: taskID, OutputDir: outputDir, State: TaskStateRunning}
+
+	if !tm.MarkCompleted(taskID) {
+		t.Fatal("MarkCompleted() = false; want true with retention enabled")
+	}
+	if !tm.MarkCompleted(taskID) {
+		t.Error("MarkCompleted() second call = false; want true")
+	}
+
+	// Completed tasks stay available during the retention window
+	task, err := tm.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() during retention = %v; want task", err)
+	}
+	if !task.Completed || task.State != TaskStateCompleted {
+		t.Errorf("task Completed = %v, State = %q; want completed", task.Completed, task.State)
+	}
+	if !tm.IsCompleted(taskID) || tm.IsFinishing(taskID) {
+		t.Errorf("IsCompleted() = %v, IsFinishing() = %v; want true, false", tm.IsCompleted(taskID), tm.IsFinishing(taskID))
+	}
+	if _, err := os.Stat(outputDir); err != nil {
+		t.Errorf("output directory removed during retention: %v", err)
+	}
+
+	// Removed once the retention window has expired
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := tm.GetTask(taskID); err != nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := tm.GetTask(taskID); err == nil {
+		t.Error("GetTask() after retention = task; want not found")
+	}
+	if _, err := os.Stat(outputDir); !os.IsNotExist(err) {
+		t.Errorf("output directory still exists after retention (stat error: %v)", err)
+	}
+}
+
+func TestTaskManagerMarkCompletedWithoutRetention(t *testing.T) {
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	tm := NewTaskManager(&Config{})
+	tm.runningTasks[taskID] = &RunningTask{ID: taskID, State: TaskStateRunning}
+
+	if tm.MarkCompleted(taskID) {
+		t.Error("MarkCompleted() = true; want false with retention disabled")
+	}
+	if tm.IsCompleted(taskID) {
+		t.Error("IsCompleted() = true; want false with retention disabled")
+	}
+}
+
+func TestTaskManagerStartTaskShell(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Shell that records its invocation and delegates to sh
+	marker := filepath.Join(tmpDir, "shell-used")
+	shellPath := filepath.Join(tmpDir, "test-shell")
+	shellScript := "#!/bin/sh\necho invoked >> " + marker + "\nexec /bin/sh \"$@\"\n"
+	if err := os.WriteFile(shellPath, []byte(shellScript), 0700); err != nil {
+		t.Fatalf("Failed to write test shell: %v", err)
+	}
+	if err := validateShell(shellPath); err != nil {
+		t.Fatalf("validateShell(%q) = %v; want nil", shellPath, err)
+	}
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+			Shell:   shellPath,
+		},
+		Tasks: []TaskConfig{
+			{Name: "shell-task", Command: "echo 'quoted output'"},
+		},
+	}
+
+	tm := NewTaskManager(config)
+	taskID, err := tm.StartTask("shell-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+
+	exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(exitCodePath); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "quoted output" {
+		t.Errorf("task stdout = %q; want %q", string(output), "quoted output")
+	}
+
+	// Invoked once for the wrapper script and once for the command
+	invocations, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("configured shell was not used: %v", err)
+	}
+	if n := strings.Count(string(invocations), "invoked"); n != 2 {
+		t.Errorf("configured shell invoked %d times; want 2", n)
+	}
+
+	script, err := os.ReadFile(filepath.Join(tmpDir, taskID, "run.sh"))
+	if err != nil {
+		t.Fatalf("Failed to read wrapper script: %v", err)
+	}
+	if !strings.HasPrefix(string(script), "#!"+shellPath+"\n") {
+		t.Errorf("wrapper script shebang = %q; want %q", strings.SplitN(string(script), "\n", 2)[0], "#!"+shellPath)
+	}
+}
+
+func TestTaskManagerStartTaskSecretParameter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{
+				Name:    "secret-task",
+				Command: "echo {{password}}",
+				Parameters: []ParameterConfig{
+					{Name: "password", Type: "string", Secret: true},
+				},
+			},
+		},
+	}
+
+	tm := NewTaskManager(config)
+
+	_, err = tm.StartTask("secret-task", map[string]interface{}{"password": "hunter2;rm"})
+	if err == nil {
+		t.Fatal("TaskManager.StartTask() with invalid secret = nil; want error")
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("TaskManager.StartTask() error = %q; must not contain the secret value", err)
+	}
+
+	// Substitution uses the real value
+	taskID, err := tm.StartTask("secret-task", map[string]interface{}{"password": "hunter2"})
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+	exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(exitCodePath); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	output, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if strings.TrimSpace(string(output)) != "hunter2" {
+		t.Errorf("task stdout = %q; want %q", string(output), "hunter2")
+	}
+}
+
+func TestTaskManagerStartTaskFileParameter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{
+				Name:    "file-task",
+				Command: "echo {{input}}; cat {{input}}",
+				Parameters: []ParameterConfig{
+					{Name: "input", Type: "file", MaxLength: 64},
+				},
+			},
+		},
+	}
+
+	tm := NewTaskManager(config)
+
+	content := "first line; $(touch pwned)\nsecond 'line'\n"
+	taskID, err := tm.StartTask("file-task", map[string]interface{}{"input": content})
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+
+	paramPath := filepath.Join(tmpDir, taskID, "param_input")
+	info, err := os.Stat(paramPath)
+	if err != nil {
+		t.Fatalf("file parameter was not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("file parameter permissions = %o; want 600", perm)
+	}
+
+	exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(exitCodePath); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if want := paramPath + "\n" + content; string(output) != want {
+		t.Errorf("task stdout = %q; want path followed by content %q", string(output), want)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, taskID, "pwned")); err == nil {
+		t.Error("file parameter content was executed")
+	}
+
+	// Oversized content is rejected before anything is written
+	entries, _ := os.ReadDir(tmpDir)
+	_, err = tm.StartTask("file-task", map[string]interface{}{"input": strings.Repeat("x", 65)})
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum size") {
+		t.Errorf("TaskManager.StartTask() with oversized file = %v; want size error", err)
+	}
+	if after, _ := os.ReadDir(tmpDir); len(after) != len(entries) {
+		t.Errorf("task directory has %d entries after rejected start; want %d", len(after), len(entries))
+	}
+}
+
+func TestTaskManagerStartTaskBase64Parameter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{
+				Name:    "base64-task",
+				Command: "printf '%s\\n' {{message}}; echo \"env=$PARAM_MESSAGE\"",
+				Parameters: []ParameterConfig{
+					{Name: "message", Type: "string", Encoding: "base64"},
+				},
+			},
+		},
+	}
+
+	tm := NewTaskManager(config)
+
+	message := "it's a /path with spaces; $(touch pwned) `touch pwned`"
+	taskID, err := tm.StartTask("base64-task", map[string]interface{}{
+		"message": base64.StdEncoding.EncodeToString([]byte(message)),
+	})
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+	}
+
+	exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(exitCodePath); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if want := message + "\nenv=" + message + "\n"; string(output) != want {
+		t.Errorf("task stdout = %q; want %q", string(output), want)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, taskID, "pwned")); err == nil {
+		t.Error("decoded parameter was executed by the shell")
+	}
+
+	script, err := os.ReadFile(filepath.Join(tmpDir, taskID, "run.sh"))
+	if err != nil {
+		t.Fatalf("Failed to read wrapper script: %v", err)
+	}
+	if strings.Contains(string(script), "pwned") {
+		t.Errorf("wrapper script contains the decoded value: %s", script)
+	}
+
+	_, err = tm.StartTask("base64-task", map[string]interface{}{"message": "%%%"})
+	if err == nil || !strings.Contains(err.Error(), "not valid base64") {
+		t.Errorf("TaskManager.StartTask() with malformed base64 = %v; want base64 error", err)
+	}
+}
+
+func TestTaskManagerStartTaskForwardHeaders(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{
+				Name:           "header-task",
+				Command:        "echo \"trace=$HEADER_X_TRACE_ID\"; echo \"agent=${HEADER_USER_AGENT-unset}\"",
+				ForwardHeaders: []string{"X-Trace-Id"},
+			},
+		},
+	}
+
+	tm := NewTaskManager(config)
+
+	headers := http.Header{}
+	headers.Set("X-Trace-Id", "abc-123 $(touch pwned)")
+	headers.Set("User-Agent", "not-forwarded")
+	taskID, err := tm.StartTaskWithOptions("header-task", nil, StartOptions{Headers: headers})
+	if err != nil {
+		t.Fatalf("TaskManager.StartTaskWithOptions() = %v; want nil", err)
+	}
+
+	exitCodePath := filepath.Join(tmpDir, taskID, "exitcode")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(exitCodePath); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	output, err := os.ReadFile(filepath.Join(tmpDir, taskID, "stdout"))
+	if err != nil {
+		t.Fatalf("Failed to read stdout: %v", err)
+	}
+	if want := "trace=abc-123 $(touch pwned)\nagent=unset\n"; string(output) != want {
+		t.Errorf("task stdout = %q; want %q", string(output), want)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, taskID, "pwned")); err == nil {
+		t.Error("forwarded header was executed by the shell")
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read task directory: %v", err)
+	}
+	unsafe := http.Header{}
+	unsafe.Set("X-Trace-Id", "abc\x00def")
+	_, err = tm.StartTaskWithOptions("header-task", nil, StartOptions{Headers: unsafe})
+	if !errors.Is(err, ErrUnsafeHeader) {
+		t.Errorf("TaskManager.StartTaskWithOptions() with unsafe header = %v; want %v", err, ErrUnsafeHeader)
+	}
+	if after, _ := os.ReadDir(tmpDir); len(after) != len(entries) {
+		t.Errorf("task directory has %d entries after rejected start; want %d", len(after), len(entries))
+	}
+}
+
+func TestTaskManagerWaitExitCode(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{
+			TaskDir: tmpDir,
+		},
+		Tasks: []TaskConfig{
+			{Name: "failing-task", Command: "echo failing; exit 42"},
+			// Kills the wrapper script, so it never writes the exitcode file
+			{Name: "killed-task", Command: "kill -9 $PPID; sleep 5"},
+		},
+	}
+
+	tm := NewTaskManager(config)
+
+	tests := []struct {
+		taskName     string
+		wantExitCode int
+	}{
+		{"failing-task", 42},
+		{"killed-task", 128 + 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.taskName, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				taskID, err := tm.StartTask(tt.taskName, nil)
+				if err != nil {
+					t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+				}
+				exitCode, ok := tm.WaitExitCode(taskID, 5*time.Second)
+				if !ok {
+					t.Fatalf("TaskManager.WaitExitCode() ok = false; want exit status")
+				}
+				if exitCode != tt.wantExitCode {
+					t.Errorf("TaskManager.WaitExitCode() = %d; want %d", exitCode, tt.wantExitCode)
+				}
+			}
+		})
+	}
+
+	if _, ok := tm.WaitExitCode("550e8400-e29b-41d4-a716-446655440000", 10*time.Millisecond); ok {
+		t.Error("TaskManager.WaitExitCode() for unknown task ok = true; want false")
+	}
+}
+
+func TestTaskOutputDir(t *testing.T) {
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	// 23:30 in UTC-2 is already the next day in UTC
+	startTime := time.Date(2024, 1, 1, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*60*60))
+
+	tests := []struct {
+		name   string
+		layout string
+		want   string
+	}{
+		{"default", "", "/var/vsTaskViewer/" + taskID},
+		{"flat", OutputLayoutFlat, "/var/vsTaskViewer/" + taskID},
+		{"date", OutputLayoutDate, "/var/vsTaskViewer/2024-01-02/" + taskID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := taskOutputDir("/var/vsTaskViewer", tt.layout, taskID, startTime); got != tt.want {
+				t.Errorf("taskOutputDir(%q) = %q; want %q", tt.layout, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskManagerOutputLayout(t *testing.T) {
+	for _, layout := range []string{OutputLayoutFlat, OutputLayoutDate} {
+		t.Run(layout, func(t *testing.T) {
+			tmpDir, err := os.MkdirTemp("", "task-test-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			config := &Config{
+				Server: ServerConfig{
+					TaskDir:      tmpDir,
+					OutputLayout: layout,
+				},
+				Tasks: []TaskConfig{
+					{Name: "test-task", Command: "echo hello"},
+				},
+			}
+			tm := NewTaskManager(config)
+
+			taskIDs := make([]string, 2)
+			for i := range taskIDs {
+				taskIDs[i], err = tm.StartTask("test-task", nil)
+				if err != nil {
+					t.Fatalf("TaskManager.StartTask() = %v; want nil", err)
+				}
+			}
+
+			task, err := tm.GetTask(taskIDs[0])
+			if err != nil {
+				t.Fatalf("TaskManager.GetTask() = %v", err)
+			}
+			want := filepath.Join(tmpDir, taskIDs[0])
+			if layout == OutputLayoutDate {
+				want = filepath.Join(tmpDir, task.StartTime.UTC().Format("2006-01-02"), taskIDs[0])
+			}
+			if task.OutputDir != want {
+				t.Errorf("OutputDir = %q; want %q", task.OutputDir, want)
+			}
+			if _, err := os.Stat(filepath.Join(want, "run.sh")); err != nil {
+				t.Errorf("wrapper script not created in %s: %v", want, err)
+			}
+
+			// The date directory is removed with the last task of the day
+			for _, taskID := range taskIDs {
+				tm.WaitExitCode(taskID, 5*time.Second)
+				tm.removeTask(taskID)
+			}
+			entries, err := os.ReadDir(tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to read task dir: %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("task dir has %d entries after cleanup; want 0", len(entries))
+			}
+			if _, err := os.Stat(tmpDir); err != nil {
+				t.Errorf("task dir itself was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestTaskManagerStartTaskDuplicateID(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo test"}},
+	}
+	tm := NewTaskManager(config)
+
+	// The first ID belongs to a task in memory, the second to an existing output directory
+	runningID := "11111111-1111-1111-1111-111111111111"
+	leftoverID := "22222222-2222-2222-2222-222222222222"
+	freshID := "33333333-3333-3333-3333-333333333333"
+	tm.runningTasks[runningID] = &RunningTask{ID: runningID, TaskName: "test-task"}
+	if err := os.Mkdir(filepath.Join(tmpDir, leftoverID), 0700); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	ids := []string{runningID, leftoverID, freshID}
+	tm.generateID = func(string) (string, error) {
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+
+	taskID, err := tm.StartTask("test-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() error = %v", err)
+	}
+	if taskID != freshID {
+		t.Errorf("TaskManager.StartTask() = %q; want %q", taskID, freshID)
+	}
+	tm.WaitExitCode(taskID, 5*time.Second)
+
+	// Without an unused ID the start fails instead of retrying forever
+	generated := 0
+	tm.generateID = func(string) (string, error) {
+		generated++
+		return runningID, nil
+	}
+	if _, err := tm.StartTask("test-task", nil); err == nil {
+		t.Error("TaskManager.StartTask() with only used IDs = nil error; want error")
+	}
+	if generated != maxTaskIDAttempts {
+		t.Errorf("generated IDs = %d; want %d", generated, maxTaskIDAttempts)
+	}
+}
+
+func TestTaskManagerStartTaskConcurrentDuplicateID(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks:  []TaskConfig{{Name: "test-task", Command: "echo test"}},
+	}
+	tm := NewTaskManager(config)
+
+	// All starts get the same first ID at the same time, only one of them may claim it
+	const starts = 20
+	sharedID := "11111111-1111-1111-1111-111111111111"
+	var mu sync.Mutex
+	var allGenerated sync.WaitGroup
+	allGenerated.Add(starts)
+	calls := 0
+	tm.generateID = func(string) (string, error) {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		if call <= starts {
+			allGenerated.Done()
+			allGenerated.Wait()
+			return sharedID, nil
+		}
+		return generateTaskID("")
+	}
+
+	taskIDs := make([]string, starts)
+	var started sync.WaitGroup
+	for i := range taskIDs {
+		started.Add(1)
+		go func(i int) {
+			defer started.Done()
+			taskID, err := tm.StartTask("test-task", nil)
+			if err != nil {
+				t.Errorf("TaskManager.StartTask() error = %v", err)
+			}
+			taskIDs[i] = taskID
+		}(i)
+	}
+	started.Wait()
+
+	seen := make(map[string]bool, starts)
+	for _, taskID := range taskIDs {
+		if seen[taskID] {
+			t.Errorf("concurrent starts got task ID %q more than once; want different IDs", taskID)
+		}
+		seen[taskID] = true
+	}
+	for _, taskID := range taskIDs {
+		tm.WaitExitCode(taskID, 5*time.Second)
+	}
+}
+
+func TestTaskManagerStartTaskPreCheck(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "task-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockFile := filepath.Join(tmpDir, "maintenance.lock")
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			{Name: "locked-task", Command: "echo test", PreCheck: "if [ -e " + lockFile + " ]; then echo 'maintenance in progress'; exit 3; fi"},
+			{Name: "param-task", Command: "echo test", PreCheck: "test {{count}} -lt 10", Parameters: []ParameterConfig{{Name: "count", Type: "int"}}},
+			{Name: "slow-task", Command: "echo test", PreCheck: "sleep 30", PreCheckTimeout: 1},
+		},
+	}
+	tm := NewTaskManager(config)
+
+	// Passing precheck starts the task
+	taskID, err := tm.StartTask("locked-task", nil)
+	if err != nil {
+		t.Fatalf("TaskManager.StartTask() with passing pre_check = %v", err)
+	}
+	if exitCode, ok := tm.WaitExitCode(taskID, 5*time.Second); !ok || exitCode != 0 {
+		t.Errorf("TaskManager.WaitExitCode() = %d, %v; want 0, true", exitCode, ok)
+	}
+
+	// Failing precheck rejects the start with its exit code and output
+	if err := os.WriteFile(lockFile, nil, 0600); err != nil {
+		t.Fatalf("Failed to create lock file: %v", err)
+	}
+	_, err = tm.StartTask("locked-task", nil)
+	if !errors.Is(err, ErrPreCheckFailed) {
+		t.Fatalf("TaskManager.StartTask() with failing pre_check = %v; want %v", err, ErrPreCheckFailed)
+	}
+	for _, want := range []string{"exit code 3", "maintenance in progress"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("TaskManager.StartTask() error = %q; want it to contain %q", err, want)
+		}
+	}
+
+	// Parameters are substituted in the precheck
+	if _, err := tm.StartTask("param-task", map[string]interface{}{"count": 5}); err != nil {
+		t.Errorf("TaskManager.StartTask(count=5) = %v; want nil", err)
+	}
+	if _, err := tm.StartTask("param-task", map[string]interface{}{"count": 50}); !errors.Is(err, ErrPreCheckFailed) {
+		t.Errorf("TaskManager.StartTask(count=50) = %v; want %v", err, ErrPreCheckFailed)
+	}
+
+	// A hanging precheck is killed after its timeout
+	start := time.Now()
+	_, err = tm.StartTask("slow-task", nil)
+	if !errors.Is(err, ErrPreCheckFailed) || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("TaskManager.StartTask() with hanging pre_check = %v; want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hanging pre_check rejected after %v; want about 1s", elapsed)
+	}
+
+	// Rejected starts leave no output directory behind
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to read task dir: %v", err)
+	}
+	dirs := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs++
+		}
+	}
+	if dirs != 2 {
+		t.Errorf("output directories = %d; want 2 (only the started tasks)", dirs)
+	}
+}
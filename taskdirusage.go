@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// taskDirUsageRefreshInterval is how long the measured size of the task directory is reused
+// before it is measured again in the background
+const taskDirUsageRefreshInterval = 10 * time.Second
+
+// taskDirUsage tracks the total size of the task directory for server.max_task_dir_bytes.
+// Walking all task directories is too expensive for every start, so the size is cached and
+// refreshed in the background once it is older than the refresh interval.
+type taskDirUsage struct {
+	dir      string
+	interval time.Duration
+
+	mu         sync.Mutex
+	size       int64
+	measuredAt time.Time // Zero until the first measurement
+	refreshing bool      // A background refresh is running
+}
+
+// newTaskDirUsage creates the size accounting for a task directory
+func newTaskDirUsage(dir string, interval time.Duration) *taskDirUsage {
+	return &taskDirUsage{dir: dir, interval: interval}
+}
+
+// Size returns the cached size of the task directory in bytes. The first call measures the
+// directory, later calls return the cached size and start a refresh if it is stale.
+func (u *taskDirUsage) Size() int64 {
+	u.mu.Lock()
+	if u.measuredAt.IsZero() {
+		u.mu.Unlock()
+		size := dirSize(u.dir)
+		u.mu.Lock()
+		u.size = size
+		u.measuredAt = time.Now()
+		u.mu.Unlock()
+		return size
+	}
+	defer u.mu.Unlock()
+	if !u.refreshing && time.Since(u.measuredAt) >= u.interval {
+		u.refreshing = true
+		go u.refresh()
+	}
+	return u.size
+}
+
+// refresh measures the task directory and replaces the cached size
+func (u *taskDirUsage) refresh() {
+	size := dirSize(u.dir)
+	u.mu.Lock()
+	u.size = size
+	u.measuredAt = time.Now()
+	u.refreshing = false
+	u.mu.Unlock()
+}
+
+// dirSize returns the total size of the regular files below dir. Files removed during the walk
+// (e.g. by the cleanup of finished tasks) are skipped.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		// Vanished or unreadable entries don't count, the rest of the tree is still measured
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
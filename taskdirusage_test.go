@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirSize(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "taskdirusage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "task-1"), 0700); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "task-1", "stdout"), make([]byte, 100), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "task-1", "stderr"), make([]byte, 20), 0600)
+	os.WriteFile(filepath.Join(tmpDir, "other"), make([]byte, 3), 0600)
+	// Symlinks are not followed
+	os.Symlink(filepath.Join(tmpDir, "task-1", "stdout"), filepath.Join(tmpDir, "link"))
+
+	if size := dirSize(tmpDir); size != 123 {
+		t.Errorf("dirSize() = %d; want 123", size)
+	}
+	if size := dirSize(filepath.Join(tmpDir, "missing")); size != 0 {
+		t.Errorf("dirSize(missing) = %d; want 0", size)
+	}
+}
+
+func TestTaskDirUsageRefresh(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "taskdirusage-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, "a"), make([]byte, 10), 0600)
+
+	usage := newTaskDirUsage(tmpDir, time.Hour)
+	if size := usage.Size(); size != 10 {
+		t.Fatalf("Size() = %d; want 10", size)
+	}
+
+	// The cached size is used until it is older than the refresh interval
+	os.WriteFile(filepath.Join(tmpDir, "b"), make([]byte, 20), 0600)
+	if size := usage.Size(); size != 10 {
+		t.Errorf("Size() = %d; want cached 10", size)
+	}
+
+	// A stale size starts a refresh in the background
+	usage.interval = 0
+	deadline := time.Now().Add(2 * time.Second)
+	for usage.Size() != 30 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size := usage.Size(); size != 30 {
+		t.Errorf("Size() = %d; want 30 after refresh", size)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// outputThrottle limits the output bytes per second sent to one viewer (server.ws_max_bytes_per_sec),
+// so a task producing output faster than a slow viewer can read it doesn't fill the server's write buffers
+type outputThrottle struct {
+	limiter *rate.Limiter
+}
+
+// newOutputThrottle creates a throttle for bytesPerSec, or returns nil (unlimited) for 0.
+// Up to one second of output can be sent at once.
+func newOutputThrottle(bytesPerSec int) *outputThrottle {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &outputThrottle{limiter: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec)}
+}
+
+// wait blocks until n bytes may be sent or the context ends. Messages larger than the burst
+// are paid for in several steps. A nil throttle never blocks.
+func (t *outputThrottle) wait(ctx context.Context, n int) error {
+	if t == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, t.limiter.Burst())
+		if err := t.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewOutputThrottle(t *testing.T) {
+	if throttle := newOutputThrottle(0); throttle != nil {
+		t.Errorf("newOutputThrottle(0) = %v; want nil", throttle)
+	}
+	var throttle *outputThrottle
+	if err := throttle.wait(context.Background(), 1<<20); err != nil {
+		t.Errorf("nil throttle wait() = %v; want nil", err)
+	}
+}
+
+func TestOutputThrottleContextCancel(t *testing.T) {
+	throttle := newOutputThrottle(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if err := throttle.wait(ctx, 1000); err == nil {
+		t.Error("wait() for 100 seconds of output = nil; want context error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("wait() returned after %v; want shortly after cancellation", elapsed)
+	}
+}
+
+func TestTailFileThrottled(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "throttle-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// 40 lines of 100 bytes, each sent as its own message
+	line := strings.Repeat("x", 99) + "\n"
+	path := filepath.Join(tmpDir, "stdout")
+	if err := os.WriteFile(path, []byte(strings.Repeat(line, 40)), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	msg, err := json.Marshal(WebSocketMessage{Type: "stdout", Data: line})
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	total := 40 * len(msg)
+
+	const bytesPerSec = 2000
+	safeConn := newTestSafeConn(t)
+	safeConn.throttle = newOutputThrottle(bytesPerSec)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var streamed atomic.Int64
+	start := time.Now()
+	go tailFile(ctx, safeConn, newTailHandleGuard(0), newFileWait(ServerConfig{}), path, "stdout", "test-task", &streamed, 0, 0, "", "")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for streamed.Load() < int64(40*len(line)) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	if streamed.Load() != int64(40*len(line)) {
+		t.Fatalf("streamed = %d; want %d", streamed.Load(), 40*len(line))
+	}
+
+	// The first second of output is sent at once, the rest at the configured rate
+	minElapsed := time.Duration(float64(total-bytesPerSec) / bytesPerSec * float64(time.Second))
+	if elapsed < minElapsed-50*time.Millisecond {
+		t.Errorf("%d bytes sent in %v; want at least %v at %d bytes/s", total, elapsed, minElapsed, bytesPerSec)
+	}
+
+	// A tailer waiting for the throttle stops when the viewer disconnects
+	slow := newTestSafeConn(t)
+	slow.throttle = newOutputThrottle(100)
+	slowCtx, slowCancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tailFile(slowCtx, slow, newTailHandleGuard(0), newFileWait(ServerConfig{}), path, "stdout", "test-task", new(atomic.Int64), 0, 0, "", "")
+	}()
+	time.Sleep(100 * time.Millisecond)
+	slowCancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("tailFile() didn't return after the context was cancelled while throttled")
+	}
+}
@@ -1,12 +1,121 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"os"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// terminationSignals are the signals allowed as termination_signal (SIGKILL is always the final escalation)
+var terminationSignals = map[string]syscall.Signal{
+	"SIGTERM": syscall.SIGTERM,
+	"SIGINT":  syscall.SIGINT,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// parseSignal parses a termination signal name such as "SIGINT" or "int" (case-insensitive, "SIG" prefix optional)
+func parseSignal(name string) (syscall.Signal, error) {
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(upper, "SIG") {
+		upper = "SIG" + upper
+	}
+	sig, ok := terminationSignals[upper]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal %q (allowed: SIGTERM, SIGINT, SIGHUP, SIGQUIT, SIGUSR1, SIGUSR2)", name)
+	}
+	return sig, nil
+}
+
+// signalName returns the name of a termination signal, e.g. "SIGTERM"
+func signalName(sig syscall.Signal) string {
+	for name, s := range terminationSignals {
+		if s == sig {
+			return name
+		}
+	}
+	return sig.String()
+}
+
+// exitSignals names the signals that commonly kill tasks besides the termination signals
+var exitSignals = map[syscall.Signal]string{
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGALRM: "SIGALRM",
+}
+
+// exitSignal returns the name of the signal that killed a task with a shell-style exit code
+// (128+signal, as reported by bash and processExitCode), or "" for a normal exit
+func exitSignal(exitCode int) string {
+	const maxSignal = 64
+	if exitCode <= 128 || exitCode > 128+maxSignal {
+		return ""
+	}
+	sig := syscall.Signal(exitCode - 128)
+	if name, ok := exitSignals[sig]; ok {
+		return name
+	}
+	return signalName(sig)
+}
+
+// taskTerminationSignal returns the signal sent to a task on timeout (default SIGTERM)
+func taskTerminationSignal(taskConfig *TaskConfig) syscall.Signal {
+	if taskConfig == nil || taskConfig.TerminationSignal == "" {
+		return syscall.SIGTERM
+	}
+	sig, err := parseSignal(taskConfig.TerminationSignal)
+	if err != nil {
+		// Validated at load, fall back to the default
+		return syscall.SIGTERM
+	}
+	return sig
+}
+
+// signalTask sends sig to the task's process group, so that children spawned by the command are
+// signaled as well. The wrapper is started with Setsid, which makes its PID (as written to the pid file)
+// the session and process group leader. If pid doesn't lead its own group, only the process is signaled.
+// If the wrapper has already exited, its group may live on in children, which are signaled as well.
+// PIDs <= 0 are refused, kill(0) and kill(-1) would signal the server's own process group or all processes.
+func signalTask(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid PID %d", pid)
+	}
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid == pid {
+		return syscall.Kill(-pid, sig)
+	}
+	log.Printf("[TIMEOUT] PID=%d is not a process group leader, signaling the process only", pid)
+	return syscall.Kill(pid, sig)
+}
+
+// processGroupRunning reports whether any process of the process group led by pid is still running
+func processGroupRunning(pid int) bool {
+	return syscall.Kill(-pid, 0) == nil
+}
+
+// defaultTerminationGracePeriod is the time between the termination signal and SIGKILL if not configured
+const defaultTerminationGracePeriod = 30 * time.Second
+
+// terminationGracePeriod returns the time to wait after the termination signal before sending SIGKILL.
+// The task setting takes precedence over the server setting; 0 means SIGKILL right away.
+func terminationGracePeriod(server ServerConfig, taskConfig *TaskConfig) time.Duration {
+	if taskConfig != nil && taskConfig.TerminationGracePeriod != nil {
+		return time.Duration(*taskConfig.TerminationGracePeriod) * time.Second
+	}
+	if server.TerminationGracePeriod != nil {
+		return time.Duration(*server.TerminationGracePeriod) * time.Second
+	}
+	return defaultTerminationGracePeriod
+}
+
 // handleTimeout handles when a task exceeds its maximum execution time
 func handleTimeout(safeConn *safeConn, taskManager *TaskManager, taskID string, pid int) {
 	log.Printf("[TIMEOUT] Max execution time exceeded for task_id=%s, pid=%d", taskID, pid)
@@ -24,7 +133,7 @@ func handleTimeout(safeConn *safeConn, taskManager *TaskManager, taskID string,
 		// Already sent SIGTERM, check if we should send SIGKILL
 		if !task.Killed {
 			// Check if process is still running
-			if isProcessRunning(pid) {
+			if isTaskProcessRunning(pid, task.ProcessStartTime) {
 				// Process still running after SIGTERM, send SIGKILL
 				task.Killed = true
 				taskManager.mu.Unlock()
@@ -32,10 +141,7 @@ func handleTimeout(safeConn *safeConn, taskManager *TaskManager, taskID string,
 				sendSystemMessage(safeConn, "timeout", "Process exceeded maximum execution time. Sending SIGKILL...", pid)
 				log.Printf("[TIMEOUT] Sending SIGKILL to PID=%d for task_id=%s", pid, taskID)
 
-				process, err := os.FindProcess(pid)
-				if err == nil {
-					process.Signal(syscall.SIGKILL)
-				}
+				signalTask(pid, syscall.SIGKILL)
 			} else {
 				taskManager.mu.Unlock()
 			}
@@ -45,45 +151,62 @@ func handleTimeout(safeConn *safeConn, taskManager *TaskManager, taskID string,
 		return
 	}
 
-	// Mark as terminated and send SIGTERM
+	// Mark as terminated and send the termination signal (SIGTERM unless configured otherwise)
 	task.Terminated = true
-	taskManager.mu.Unlock()
+	taskConfig := findTaskConfig(taskManager.config.Tasks, task.TaskName)
+	sig := taskTerminationSignal(taskConfig)
+	grace := terminationGracePeriod(taskManager.config.Server, taskConfig)
+	if grace == 0 {
+		// No grace period, kill right away
+		task.Killed = true
+		taskManager.mu.Unlock()
 
-	// Send SIGTERM
-	sendSystemMessage(safeConn, "timeout", "Process exceeded maximum execution time. Sending SIGTERM (graceful shutdown)...", pid)
-	log.Printf("[TIMEOUT] Sending SIGTERM to PID=%d for task_id=%s", pid, taskID)
+		sendSystemMessage(safeConn, "timeout", "Process exceeded maximum execution time. Sending SIGKILL...", pid)
+		log.Printf("[TIMEOUT] Sending SIGKILL to PID=%d for task_id=%s (no grace period)", pid, taskID)
 
-	process, err := os.FindProcess(pid)
-	if err == nil {
-		process.Signal(syscall.SIGTERM)
+		signalTask(pid, syscall.SIGKILL)
+		return
 	}
+	taskManager.mu.Unlock()
 
-	// Start a goroutine to check after 30 seconds if process is still running
-	go func() {
-		time.Sleep(30 * time.Second)
+	// Send termination signal
+	sendSystemMessage(safeConn, "timeout", fmt.Sprintf("Process exceeded maximum execution time. Sending %s (graceful shutdown)...", signalName(sig)), pid)
+	log.Printf("[TIMEOUT] Sending %s to PID=%d for task_id=%s", signalName(sig), pid, taskID)
 
-		taskManager.mu.Lock()
-		task, exists := taskManager.runningTasks[taskID]
-		if !exists {
-			taskManager.mu.Unlock()
-			return
-		}
+	signalTask(pid, sig)
 
-		if !task.Killed && isProcessRunning(pid) {
-			// Process still running after 30 seconds, send SIGKILL
-			task.Killed = true
-			taskManager.mu.Unlock()
+	// Start a goroutine to check after the grace period if the process (group) is still running
+	go killAfterGrace(taskManager, taskID, pid, grace, func() {
+		sendSystemMessage(safeConn, "timeout", fmt.Sprintf("Process did not terminate after %s. Sending SIGKILL...", signalName(sig)), pid)
+	})
+}
 
-			sendSystemMessage(safeConn, "timeout", "Process did not terminate after SIGTERM. Sending SIGKILL...", pid)
-			log.Printf("[TIMEOUT] Sending SIGKILL to PID=%d for task_id=%s (after 30s grace period)", pid, taskID)
+// killAfterGrace sends SIGKILL to a task whose process (group) is still running after the grace period
+// following the termination signal. notify (optional) is called before SIGKILL is sent.
+func killAfterGrace(taskManager *TaskManager, taskID string, pid int, grace time.Duration, notify func()) {
+	time.Sleep(grace)
 
-			process, err := os.FindProcess(pid)
-			if err == nil {
-				process.Signal(syscall.SIGKILL)
-			}
-		} else {
-			taskManager.mu.Unlock()
+	taskManager.mu.Lock()
+	task, exists := taskManager.runningTasks[taskID]
+	if !exists {
+		taskManager.mu.Unlock()
+		return
+	}
+
+	// The wrapper may have exited while children that ignore the signal keep its process group alive
+	if !task.Killed && (isTaskProcessRunning(pid, task.ProcessStartTime) || processGroupRunning(pid)) {
+		// Process still running after the grace period, send SIGKILL
+		task.Killed = true
+		taskManager.mu.Unlock()
+
+		if notify != nil {
+			notify()
 		}
-	}()
+		log.Printf("[TIMEOUT] Sending SIGKILL to PID=%d for task_id=%s (after %v grace period)", pid, taskID, grace)
+
+		signalTask(pid, syscall.SIGKILL)
+	} else {
+		taskManager.mu.Unlock()
+	}
 }
 
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 func TestHandleTimeout(t *testing.T) {
@@ -250,3 +259,274 @@ func TestHandleTimeoutNonExistentTask(t *testing.T) {
 	taskManager.mu.Unlock()
 }
 
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"SIGTERM", syscall.SIGTERM, false},
+		{"SIGINT", syscall.SIGINT, false},
+		{"sighup", syscall.SIGHUP, false},
+		{"USR1", syscall.SIGUSR1, false},
+		{" SIGQUIT ", syscall.SIGQUIT, false},
+		{"SIGKILL", 0, true},
+		{"SIGFOO", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSignal(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSignal(%q) error = %v; wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSignal(%q) = %v; want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	if got := taskTerminationSignal(&TaskConfig{}); got != syscall.SIGTERM {
+		t.Errorf("taskTerminationSignal() without termination_signal = %v; want %v", got, syscall.SIGTERM)
+	}
+	if got := signalName(syscall.SIGINT); got != "SIGINT" {
+		t.Errorf("signalName(SIGINT) = %q; want %q", got, "SIGINT")
+	}
+}
+
+func TestExitSignal(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		want     string
+	}{
+		{0, ""},
+		{1, ""},
+		{128, ""},
+		{130, "SIGINT"},
+		{137, "SIGKILL"},
+		{139, "SIGSEGV"},
+		{143, "SIGTERM"},
+		{255, ""},
+		{-1, ""},
+	}
+	for _, tt := range tests {
+		if got := exitSignal(tt.exitCode); got != tt.want {
+			t.Errorf("exitSignal(%d) = %q; want %q", tt.exitCode, got, tt.want)
+		}
+	}
+}
+
+func TestHandleTimeoutTerminationSignal(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "timeout-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			{Name: "hup-task", Command: "sleep 30", TerminationSignal: "SIGHUP"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	taskID, err := taskManager.StartTask("hup-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	task, err := taskManager.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() = %v", err)
+	}
+	pid := readPID(filepath.Join(task.OutputDir, "pid"))
+	// The wrapper runs in its own session, kill its whole process group when done
+	defer syscall.Kill(-pid, syscall.SIGKILL)
+
+	handleTimeout(newTestSafeConn(t), taskManager, taskID, pid)
+
+	exitCode, ok := taskManager.WaitExitCode(taskID, 10*time.Second)
+	if !ok {
+		t.Fatal("WaitExitCode() ok = false; want process to exit after the termination signal")
+	}
+	if want := 128 + int(syscall.SIGHUP); exitCode != want {
+		t.Errorf("exit code = %d; want %d (terminated by SIGHUP)", exitCode, want)
+	}
+}
+
+func TestSignalTaskInvalidPID(t *testing.T) {
+	// Signal 0 only checks permissions, nothing is signaled if the guard fails
+	for _, pid := range []int{0, -1} {
+		if err := signalTask(pid, syscall.Signal(0)); err == nil {
+			t.Errorf("signalTask(%d) = nil; want error", pid)
+		}
+	}
+}
+
+func TestHandleTimeoutSignalsProcessGroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process states are read from /proc")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "timeout-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks: []TaskConfig{
+			// The background child is not signaled if only the wrapper PID is
+			{Name: "spawning-task", Command: "sleep 30 & echo $! > child.pid; wait"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	taskID, pid, childPID := startSpawningTask(t, taskManager, "spawning-task")
+	if pgid, err := syscall.Getpgid(pid); err != nil || pgid != pid {
+		t.Fatalf("Getpgid(%d) = %d, %v; want wrapper to lead its process group", pid, pgid, err)
+	}
+
+	handleTimeout(newTestSafeConn(t), taskManager, taskID, pid)
+
+	if _, ok := taskManager.WaitExitCode(taskID, 10*time.Second); !ok {
+		t.Fatal("WaitExitCode() ok = false; want wrapper to exit after SIGTERM")
+	}
+	for deadline := time.Now().Add(5 * time.Second); processAlive(childPID); {
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d still running after timeout termination; want process group terminated", childPID)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestHandleTimeoutGracePeriod(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process states are read from /proc")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "timeout-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	zero, short, long := 0, 1, 60
+	// The command and its child ignore SIGTERM, only SIGKILL ends them
+	command := "trap '' TERM; sleep 30 & echo $! > child.pid; wait"
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, TerminationGracePeriod: &long},
+		Tasks: []TaskConfig{
+			{Name: "zero-grace", Command: command, TerminationGracePeriod: &zero},
+			{Name: "short-grace", Command: command, TerminationGracePeriod: &short},
+			{Name: "server-grace", Command: command},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		taskName   string
+		wantKilled bool // Whether SIGKILL is sent within killWait
+		killWait   time.Duration
+	}{
+		{"zero-grace", true, 500 * time.Millisecond},
+		{"short-grace", true, 3 * time.Second},
+		{"server-grace", false, 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.taskName, func(t *testing.T) {
+			taskID, pid, childPID := startSpawningTask(t, taskManager, tt.taskName)
+
+			handleTimeout(newTestSafeConn(t), taskManager, taskID, pid)
+
+			deadline := time.Now().Add(tt.killWait)
+			for processAlive(childPID) && time.Now().Before(deadline) {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if alive := processAlive(childPID); alive == tt.wantKilled {
+				t.Errorf("child process alive after %v = %v; want %v", tt.killWait, alive, !tt.wantKilled)
+			}
+
+			task, err := taskManager.GetTask(taskID)
+			if err != nil {
+				t.Fatalf("GetTask() = %v", err)
+			}
+			taskManager.mu.RLock()
+			killed := task.Killed
+			taskManager.mu.RUnlock()
+			if killed != tt.wantKilled {
+				t.Errorf("task.Killed = %v; want %v", killed, tt.wantKilled)
+			}
+		})
+	}
+
+	if got := terminationGracePeriod(ServerConfig{}, &TaskConfig{}); got != defaultTerminationGracePeriod {
+		t.Errorf("terminationGracePeriod() without settings = %v; want %v", got, defaultTerminationGracePeriod)
+	}
+}
+
+// startSpawningTask starts a task whose command writes the PID of a background child to child.pid
+// and returns the task ID, the wrapper PID and the child PID. The process group is killed on cleanup.
+func startSpawningTask(t *testing.T, taskManager *TaskManager, taskName string) (taskID string, pid, childPID int) {
+	t.Helper()
+
+	taskID, err := taskManager.StartTask(taskName, nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	task, err := taskManager.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() = %v", err)
+	}
+	pid = readPID(filepath.Join(task.OutputDir, "pid"))
+	t.Cleanup(func() { syscall.Kill(-pid, syscall.SIGKILL) })
+
+	// Wait for the command to report its child
+	for deadline := time.Now().Add(5 * time.Second); childPID == 0 && time.Now().Before(deadline); {
+		childPID = readPID(filepath.Join(task.OutputDir, "child.pid"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	if childPID == 0 {
+		t.Fatal("task did not write child.pid")
+	}
+	return taskID, pid, childPID
+}
+
+// processAlive reports whether a process exists and is not a zombie
+func processAlive(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name in parentheses
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// newTestSafeConn returns a safeConn connected to a test WebSocket server that discards all messages
+func newTestSafeConn(t *testing.T) *safeConn {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial test WebSocket server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &safeConn{conn: conn}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves the TLS certificate from the configured files and reloads it
+// when the files change on disk (e.g. after a Let's Encrypt renewal)
+type certReloader struct {
+	certPath    string
+	keyPath     string
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	mu          sync.RWMutex
+}
+
+// newCertReloader loads the certificate and key and returns a reloader for them
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	cr := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	certModTime, keyModTime, err := cr.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := cr.load(certModTime, keyModTime); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// modTimes returns the modification times of the certificate and key files
+func (cr *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(cr.certPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("TLS certificate file not accessible: %w", err)
+	}
+	keyInfo, err := os.Stat(cr.keyPath)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("TLS key file not accessible: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// load reads the certificate and key from disk and caches them
+func (cr *certReloader) load(certModTime, keyModTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.certModTime = certModTime
+	cr.keyModTime = keyModTime
+	cr.mu.Unlock()
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the files have changed.
+// If reloading fails (e.g. files only readable by root after dropping privileges or a half-written renewal),
+// the previously loaded certificate keeps being served.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certModTime, keyModTime, err := cr.modTimes()
+
+	cr.mu.RLock()
+	cert := cr.cert
+	changed := err == nil && (!certModTime.Equal(cr.certModTime) || !keyModTime.Equal(cr.keyModTime))
+	cr.mu.RUnlock()
+
+	if changed {
+		if err := cr.load(certModTime, keyModTime); err != nil {
+			log.Printf("[TLS] Failed to reload certificate, keeping previous one: %v", err)
+			return cert, nil
+		}
+		log.Printf("[TLS] Reloaded certificate from %s", cr.certPath)
+		cr.mu.RLock()
+		cert = cr.cert
+		cr.mu.RUnlock()
+	}
+	return cert, nil
+}
+
+// buildServerTLSConfig creates the server TLS config serving certificates from getCertificate,
+// based on the client auth config for mutual TLS if one is given
+func buildServerTLSConfig(clientTLSConfig *tls.Config, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	var tlsConfig *tls.Config
+	if clientTLSConfig != nil {
+		tlsConfig = clientTLSConfig.Clone()
+	} else {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tlsConfig.GetCertificate = getCertificate
+	return tlsConfig
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestServerCertificate writes a self-signed server certificate with the given serial number
+func writeTestServerCertificate(t *testing.T, certPath, keyPath string, serial int64) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+}
+
+// servedSerial connects to the server and returns the serial number of the presented certificate
+func servedSerial(t *testing.T, url string) int64 {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s = %v", url, err)
+	}
+	defer resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "tls-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	certPath := filepath.Join(tmpDir, "fullchain.pem")
+	keyPath := filepath.Join(tmpDir, "key.pem")
+	writeTestServerCertificate(t, certPath, keyPath, 1)
+
+	reloader, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("newCertReloader() = %v; want nil", err)
+	}
+
+	// httptest installs its own certificate, so serve via a plain TLS listener
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: reloader.GetCertificate})
+	if err != nil {
+		t.Fatalf("tls.Listen() = %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(listener)
+	defer server.Close()
+	url := "https://" + listener.Addr().String()
+
+	if serial := servedSerial(t, url); serial != 1 {
+		t.Fatalf("served certificate serial = %d; want 1", serial)
+	}
+
+	// Simulate a renewal: new files with a newer modification time
+	writeTestServerCertificate(t, certPath, keyPath, 2)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certPath, future, future)
+	os.Chtimes(keyPath, future, future)
+
+	if serial := servedSerial(t, url); serial != 2 {
+		t.Errorf("served certificate serial after renewal = %d; want 2", serial)
+	}
+
+	// A broken renewal keeps the previous certificate
+	if err := os.WriteFile(certPath, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	later := future.Add(time.Minute)
+	os.Chtimes(certPath, later, later)
+
+	if serial := servedSerial(t, url); serial != 2 {
+		t.Errorf("served certificate serial after broken renewal = %d; want 2", serial)
+	}
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	if _, err := newCertReloader("/nonexistent/cert.pem", "/nonexistent/key.pem"); err == nil {
+		t.Error("newCertReloader() with missing files = nil; want error")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL        = 24 * time.Hour
+	defaultCacheMaxEntries = 10000
+)
+
+// TTLCache is a concurrency-safe key/value cache with per-entry expiry and a bounded size.
+// It is shared by features that need to remember keys for a limited time
+// (e.g. idempotency keys, confirmation tokens, replayed token IDs).
+type TTLCache struct {
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]cacheEntry
+	mu         sync.Mutex
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+type cacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// NewTTLCache creates a new cache and starts its periodic eviction
+func NewTTLCache(ttl time.Duration, maxEntries int, cleanupInterval time.Duration) *TTLCache {
+	c := &TTLCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]cacheEntry),
+		stop:       make(chan struct{}),
+	}
+
+	// Start cleanup goroutine
+	go c.cleanup(cleanupInterval)
+
+	return c
+}
+
+// NewTTLCacheFromConfig creates a cache using the configured TTL and size bound
+func NewTTLCacheFromConfig(server ServerConfig) *TTLCache {
+	ttl := time.Duration(server.CacheTTL) * time.Second
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	maxEntries := server.CacheMaxEntries
+	if maxEntries == 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	// Evict several times per TTL, but not more often than once per second
+	interval := ttl / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return NewTTLCache(ttl, maxEntries, interval)
+}
+
+// cleanup removes expired entries periodically
+func (c *TTLCache) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			c.evictExpired(time.Now())
+			c.mu.Unlock()
+		}
+	}
+}
+
+// evictExpired removes expired entries (caller must hold mu)
+func (c *TTLCache) evictExpired(now time.Time) {
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// evictOldest removes the entry closest to expiry (caller must hold mu)
+func (c *TTLCache) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if oldest.IsZero() || entry.expiresAt.Before(oldest) {
+			oldestKey = key
+			oldest = entry.expiresAt
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+// Set stores a value, evicting expired and then oldest entries if the cache is full
+func (c *TTLCache) Set(key string, value interface{}) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evictExpired(now)
+		for len(c.entries) >= c.maxEntries {
+			c.evictOldest()
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+// Get returns the value for key if present and not expired
+func (c *TTLCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Delete removes a key
+func (c *TTLCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// Len returns the number of entries (including expired ones not yet evicted)
+func (c *TTLCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Stop stops the periodic eviction
+func (c *TTLCache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheExpiry(t *testing.T) {
+	cache := NewTTLCache(50*time.Millisecond, 100, 10*time.Millisecond)
+	defer cache.Stop()
+
+	cache.Set("key", "value")
+	if v, ok := cache.Get("key"); !ok || v != "value" {
+		t.Fatalf("Get() = %v, %v; want value, true", v, ok)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Get() after TTL = found; want expired")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Len() after TTL = %d; want 0 (evicted)", cache.Len())
+	}
+}
+
+func TestTTLCacheBoundedUnderChurn(t *testing.T) {
+	cache := NewTTLCache(time.Hour, 10, time.Hour)
+	defer cache.Stop()
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("key-%d", i), i)
+		if cache.Len() > 10 {
+			t.Fatalf("Len() = %d after %d inserts; want at most 10", cache.Len(), i+1)
+		}
+	}
+
+	// Most recent entries survive, oldest are evicted
+	if _, ok := cache.Get("key-999"); !ok {
+		t.Error("Get(key-999) = not found; want most recent entry kept")
+	}
+	if _, ok := cache.Get("key-0"); ok {
+		t.Error("Get(key-0) = found; want oldest entry evicted")
+	}
+
+	// Overwriting an existing key doesn't evict others
+	cache.Set("key-999", "updated")
+	if cache.Len() != 10 {
+		t.Errorf("Len() after overwrite = %d; want 10", cache.Len())
+	}
+}
+
+func TestTTLCacheDelete(t *testing.T) {
+	cache := NewTTLCache(time.Hour, 0, time.Hour)
+	defer cache.Stop()
+
+	cache.Set("key", 1)
+	cache.Delete("key")
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Get() after Delete() = found; want not found")
+	}
+}
+
+func TestNewTTLCacheFromConfig(t *testing.T) {
+	cache := NewTTLCacheFromConfig(ServerConfig{})
+	defer cache.Stop()
+	if cache.ttl != defaultCacheTTL || cache.maxEntries != defaultCacheMaxEntries {
+		t.Errorf("NewTTLCacheFromConfig() ttl=%v, maxEntries=%d; want defaults", cache.ttl, cache.maxEntries)
+	}
+
+	configured := NewTTLCacheFromConfig(ServerConfig{CacheTTL: 60, CacheMaxEntries: 5})
+	defer configured.Stop()
+	if configured.ttl != time.Minute || configured.maxEntries != 5 {
+		t.Errorf("NewTTLCacheFromConfig() ttl=%v, maxEntries=%d; want 1m, 5", configured.ttl, configured.maxEntries)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixSocketHealth(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "socket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	socketPath := filepath.Join(tmpDir, "vsTaskViewer.sock")
+
+	// Simulate a stale socket left behind by a previous run
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listenUnixSocket(socketPath)
+	if err != nil {
+		t.Fatalf("listenUnixSocket() = %v; want nil", err)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("socket not created: %v", err)
+	}
+	if info.Mode().Perm() != unixSocketMode {
+		t.Errorf("socket permissions = %o; want %o", info.Mode().Perm(), unixSocketMode)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", handleHealth)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://unix/health")
+	if err != nil {
+		t.Fatalf("GET /health over unix socket = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "OK" {
+		t.Errorf("GET /health = %d %q; want 200 \"OK\"", resp.StatusCode, string(body))
+	}
+}
+
+func TestListenUnixSocketRefusesRegularFile(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "not-a-socket-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	if _, err := listenUnixSocket(tmpFile.Name()); err == nil {
+		t.Error("listenUnixSocket() on regular file = nil; want error")
+	}
+	if _, err := os.Stat(tmpFile.Name()); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
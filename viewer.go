@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -20,19 +22,16 @@ func handleViewer(w http.ResponseWriter, r *http.Request, taskManager *TaskManag
 		return
 	}
 
-	taskID := r.URL.Query().Get("task_id")
-	if taskID == "" {
-		taskID = claims.TaskID
-	}
-
-	if taskID == "" {
-		log.Printf("[VIEWER] Missing task_id")
+	taskID, err := requestTaskID(r, claims, config.Server.TaskIDScheme)
+	if err != nil {
+		log.Printf("[VIEWER] Rejected task_id: %v", err)
 		serveErrorHTML(w, http.StatusBadRequest, htmlCache)
 		return
 	}
 
 	// Check if task exists BEFORE rendering viewer
-	_, err = taskManager.GetTask(taskID)
+	// Runs of archived tasks remain viewable after the task has been removed
+	task, err := taskManager.GetTaskOrArchived(taskID)
 	if err != nil {
 		log.Printf("[VIEWER] Task not found: task_id=%s, error=%v", taskID, err)
 		serveErrorHTML(w, http.StatusNotFound, htmlCache)
@@ -48,6 +47,13 @@ func handleViewer(w http.ResponseWriter, r *http.Request, taskManager *TaskManag
 		return
 	}
 
+	// Single-use viewer links can be opened only once
+	if err := taskManager.consumeViewerToken(task, claims, remoteIP(r), false); err != nil {
+		log.Printf("[VIEWER] Rejected viewer token: task_id=%s, error=%v", taskID, err)
+		serveErrorHTML(w, http.StatusUnauthorized, htmlCache)
+		return
+	}
+
 	// Build WebSocket URL
 	scheme := "ws"
 	if r.TLS != nil {
@@ -67,8 +73,78 @@ func handleViewer(w http.ResponseWriter, r *http.Request, taskManager *TaskManag
 	html := htmlTemplate
 	html = strings.ReplaceAll(html, "{{.TaskID}}", taskID)
 	html = strings.ReplaceAll(html, "{{.WebSocketURL}}", wsURL)
+	html = strings.ReplaceAll(html, "{{.Label}}", template.HTMLEscapeString(task.Label))
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Write([]byte(html))
 }
 
+// Placeholders of server.viewer_url_template
+const (
+	viewerURLTaskIDPlaceholder = "{{task_id}}"
+	viewerURLTokenPlaceholder  = "{{token}}"
+)
+
+// viewerURLs returns the viewer URL of a task without and with the viewer token: the built-in
+// /viewer on the requested host, or server.viewer_url_template for an external front-end
+func viewerURLs(r *http.Request, config *Config, taskID, token string) (base, full string) {
+	tmpl := config.Server.ViewerURLTemplate
+	if tmpl == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = fmt.Sprintf("%s://%s/viewer?task_id=%s", scheme, r.Host, taskID)
+		return base, base + "&token=" + token
+	}
+	return renderViewerURL(withoutTokenParameter(tmpl), taskID, ""), renderViewerURL(tmpl, taskID, token)
+}
+
+// renderViewerURL fills in the task ID and token placeholders of a viewer URL template
+func renderViewerURL(tmpl, taskID, token string) string {
+	return strings.NewReplacer(
+		viewerURLTaskIDPlaceholder, url.QueryEscape(taskID),
+		viewerURLTokenPlaceholder, url.QueryEscape(token),
+	).Replace(tmpl)
+}
+
+// withoutTokenParameter removes the query parameters holding the token placeholder from a viewer URL
+// template, so the URL without token doesn't end in an empty "token=". A placeholder elsewhere
+// (e.g. in the path) is left to be rendered empty.
+func withoutTokenParameter(tmpl string) string {
+	rest, fragment, hasFragment := strings.Cut(tmpl, "#")
+	path, query, hasQuery := strings.Cut(rest, "?")
+	if !hasQuery {
+		return tmpl
+	}
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		if !strings.Contains(param, viewerURLTokenPlaceholder) {
+			params = append(params, param)
+		}
+	}
+	result := path
+	if len(params) > 0 {
+		result += "?" + strings.Join(params, "&")
+	}
+	if hasFragment {
+		result += "#" + fragment
+	}
+	return result
+}
+
+// validateViewerURLTemplate checks that server.viewer_url_template yields an absolute http(s) URL
+// identifying the task
+func validateViewerURLTemplate(tmpl string) error {
+	if !strings.Contains(tmpl, viewerURLTaskIDPlaceholder) {
+		return fmt.Errorf("must contain %s", viewerURLTaskIDPlaceholder)
+	}
+	u, err := url.Parse(renderViewerURL(tmpl, "task", "token"))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL")
+	}
+	return nil
+}
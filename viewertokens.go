@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// singleUseWebSocketWindow is how long after /viewer consumed a single-use viewer token the page
+// may open (and reconnect) its WebSocket with the same token
+const singleUseWebSocketWindow = 60 * time.Second
+
+// viewerTokenUse records the first use of a single-use viewer token
+type viewerTokenUse struct {
+	usedAt    time.Time
+	expiresAt time.Time // Expiry (exp) of the token, the use is remembered until then
+	ip        string    // Remote IP of the first use, the paired WebSocket must come from the same client
+}
+
+// viewerTokenUses remembers the IDs (jti) of consumed single-use viewer tokens until the tokens
+// expire. Entries are never evicted early, a token would become usable again; once maxEntries
+// unexpired uses are stored, further tokens are rejected.
+type viewerTokenUses struct {
+	used       map[string]viewerTokenUse
+	maxEntries int
+	window     time.Duration
+	mu         sync.Mutex
+}
+
+// newViewerTokenUses creates the used-set for single-use viewer tokens
+func newViewerTokenUses(maxEntries int, window time.Duration) *viewerTokenUses {
+	return &viewerTokenUses{used: make(map[string]viewerTokenUse), maxEntries: maxEntries, window: window}
+}
+
+// consume records the use of a single-use viewer token expiring at expiresAt. The first use
+// succeeds; afterwards only WebSocket connections from the same IP within the window are
+// accepted, so the viewer page opened with the token can still connect.
+//
+// The IP is the remote address of the connection: behind a reverse proxy (or with
+// server.unix_socket) it is the proxy's, so any client of the proxy holding the token may open the
+// WebSocket within the window.
+func (u *viewerTokenUses) consume(tokenID string, expiresAt time.Time, ip string, webSocket bool) error {
+	if tokenID == "" {
+		return ErrViewerTokenNoID
+	}
+	if expiresAt.IsZero() {
+		return ErrViewerTokenNoExpiry
+	}
+
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if first, ok := u.used[tokenID]; ok && now.Before(first.expiresAt) {
+		if webSocket && first.ip == ip && now.Sub(first.usedAt) < u.window {
+			return nil
+		}
+		return ErrViewerTokenUsed
+	}
+	if len(u.used) >= u.maxEntries {
+		u.evictExpired(now)
+		if len(u.used) >= u.maxEntries {
+			return ErrViewerTokensFull
+		}
+	}
+	u.used[tokenID] = viewerTokenUse{usedAt: now, expiresAt: expiresAt, ip: ip}
+	return nil
+}
+
+// evictExpired removes the uses of expired tokens (caller must hold mu)
+func (u *viewerTokenUses) evictExpired(now time.Time) {
+	for tokenID, use := range u.used {
+		if !now.Before(use.expiresAt) {
+			delete(u.used, tokenID)
+		}
+	}
+}
+
+// consumeViewerToken applies single_use_viewer_token of the task's configuration to a viewer
+// token used for /viewer or /ws. Tokens of other tasks are not tracked.
+func (tm *TaskManager) consumeViewerToken(task *RunningTask, claims *Claims, ip string, webSocket bool) error {
+	taskConfig := findTaskConfig(tm.config.Tasks, task.TaskName)
+	if taskConfig == nil || !taskConfig.SingleUseViewerToken || tm.viewerUses == nil {
+		return nil
+	}
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return tm.viewerUses.consume(claims.ID, expiresAt, ip, webSocket)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestViewerTokenUsesConsume(t *testing.T) {
+	uses := newViewerTokenUses(100, 50*time.Millisecond)
+	exp := time.Now().Add(time.Hour)
+
+	if err := uses.consume("jti-1", exp, "192.0.2.1", false); err != nil {
+		t.Fatalf("first viewer use = %v; want nil", err)
+	}
+	if err := uses.consume("jti-1", exp, "192.0.2.1", false); !errors.Is(err, ErrViewerTokenUsed) {
+		t.Errorf("second viewer use = %v; want %v", err, ErrViewerTokenUsed)
+	}
+	if err := uses.consume("jti-1", exp, "192.0.2.1", true); err != nil {
+		t.Errorf("paired WebSocket within window = %v; want nil", err)
+	}
+	if err := uses.consume("jti-1", exp, "198.51.100.7", true); !errors.Is(err, ErrViewerTokenUsed) {
+		t.Errorf("WebSocket from other IP = %v; want %v", err, ErrViewerTokenUsed)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := uses.consume("jti-1", exp, "192.0.2.1", true); !errors.Is(err, ErrViewerTokenUsed) {
+		t.Errorf("WebSocket after window = %v; want %v", err, ErrViewerTokenUsed)
+	}
+
+	if err := uses.consume("", exp, "192.0.2.1", false); !errors.Is(err, ErrViewerTokenNoID) {
+		t.Errorf("token without jti = %v; want %v", err, ErrViewerTokenNoID)
+	}
+	if err := uses.consume("jti-2", time.Time{}, "192.0.2.1", false); !errors.Is(err, ErrViewerTokenNoExpiry) {
+		t.Errorf("token without exp = %v; want %v", err, ErrViewerTokenNoExpiry)
+	}
+}
+
+func TestViewerTokenUsesFull(t *testing.T) {
+	uses := newViewerTokenUses(2, time.Minute)
+	now := time.Now()
+
+	if err := uses.consume("short-lived", now.Add(50*time.Millisecond), "192.0.2.1", false); err != nil {
+		t.Fatalf("first token = %v; want nil", err)
+	}
+	if err := uses.consume("long-lived", now.Add(time.Hour), "192.0.2.1", false); err != nil {
+		t.Fatalf("second token = %v; want nil", err)
+	}
+
+	// Unexpired uses are never evicted, a token must not become usable again
+	if err := uses.consume("third", now.Add(time.Hour), "192.0.2.1", false); !errors.Is(err, ErrViewerTokensFull) {
+		t.Errorf("token while full = %v; want %v", err, ErrViewerTokensFull)
+	}
+	if err := uses.consume("long-lived", now.Add(time.Hour), "192.0.2.1", false); !errors.Is(err, ErrViewerTokenUsed) {
+		t.Errorf("reused token while full = %v; want %v", err, ErrViewerTokenUsed)
+	}
+
+	// Once a token has expired its slot is free again
+	time.Sleep(60 * time.Millisecond)
+	if err := uses.consume("third", now.Add(time.Hour), "192.0.2.1", false); err != nil {
+		t.Errorf("token after expiry freed a slot = %v; want nil", err)
+	}
+	if err := uses.consume("long-lived", now.Add(time.Hour), "192.0.2.1", false); !errors.Is(err, ErrViewerTokenUsed) {
+		t.Errorf("reused token after eviction = %v; want %v", err, ErrViewerTokenUsed)
+	}
+}
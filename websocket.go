@@ -7,33 +7,35 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
 
+// WSSubprotocol is the WebSocket subprotocol of the current message schema. Clients may request it
+// in the Sec-WebSocket-Protocol header, connections requesting only other subprotocols are rejected.
+const WSSubprotocol = "vstask.v1"
+
+// WSMessageVersion is the version of the message schema, sent in the initial "connected" message
+const WSMessageVersion = 1
+
 // createUpgrader creates a WebSocket upgrader with origin checking
-func createUpgrader(allowedOrigins []string) websocket.Upgrader {
+func createUpgrader(allowedOrigins []string, denyIfEmpty bool) websocket.Upgrader {
 	return websocket.Upgrader{
+		Subprotocols: []string{WSSubprotocol},
 		CheckOrigin: func(r *http.Request) bool {
-			// If no origins specified, allow all (for internal networks)
-			if len(allowedOrigins) == 0 {
-				return true
-			}
-			origin := r.Header.Get("Origin")
-			for _, allowed := range allowedOrigins {
-				if origin == allowed {
-					return true
-				}
-			}
-			return false
+			// If no origins specified, allow all (for internal networks) unless configured to deny
+			return isOriginAllowed(r.Header.Get("Origin"), allowedOrigins, denyIfEmpty)
 		},
 	}
 }
@@ -46,15 +48,72 @@ type WebSocketMessage struct {
 
 // SystemMessage represents a system message (connection status, PID, etc.)
 type SystemMessage struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
-	PID     int    `json:"pid,omitempty"`
+	Type        string `json:"type"`
+	Message     string `json:"message"`
+	PID         int    `json:"pid,omitempty"`
+	StdoutBytes *int64 `json:"stdout_bytes,omitempty"` // Total stdout bytes streamed (completion message only)
+	StderrBytes *int64 `json:"stderr_bytes,omitempty"` // Total stderr bytes streamed (completion message only)
+	ExitCode    *int   `json:"exit_code,omitempty"`    // Exit code of the task, 128+signal if killed (completion message only)
+	Signal      string `json:"signal,omitempty"`       // Signal that killed the task, e.g. "SIGKILL" (completion message only)
+	Version     int    `json:"version,omitempty"`      // Message schema version, WSMessageVersion ("connected" message only)
+}
+
+// HeartbeatMessage is sent periodically while a task runs, so viewers of silent tasks
+// can tell that the connection and the task are alive
+type HeartbeatMessage struct {
+	Type           string `json:"type"` // Always "heartbeat"
+	ElapsedSeconds int64  `json:"elapsed_seconds"`
+	StdoutBytes    int64  `json:"stdout_bytes"`
+	StderrBytes    int64  `json:"stderr_bytes"`
+}
+
+// defaultHeartbeatInterval is used if server.heartbeat_interval is not set
+const defaultHeartbeatInterval = 30 * time.Second
+
+// defaultStartupTimeout is used if server.startup_timeout is not set
+const defaultStartupTimeout = 10 * time.Second
+
+// startupKillWait bounds the wait for a killed wrapper to exit before its output directory is removed
+const startupKillWait = 5 * time.Second
+
+// defaultFileWaitTimeout is how long a viewer waits for an output file if server.file_wait_timeout is not set
+const defaultFileWaitTimeout = 60 * time.Second
+
+// defaultFilePollInterval is how often a missing output file is checked for if server.file_poll_interval is not set
+const defaultFilePollInterval = time.Second
+
+// fileWait controls how long the tailers wait for an output file to be created
+type fileWait struct {
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// newFileWait returns the output file wait of server.file_wait_timeout and server.file_poll_interval
+func newFileWait(cfg ServerConfig) fileWait {
+	wait := fileWait{
+		timeout:  time.Duration(cfg.FileWaitTimeout) * time.Second,
+		interval: time.Duration(cfg.FilePollInterval) * time.Millisecond,
+	}
+	if wait.timeout == 0 {
+		wait.timeout = defaultFileWaitTimeout
+	}
+	if wait.interval == 0 {
+		wait.interval = defaultFilePollInterval
+	}
+	return wait
+}
+
+// streamCounters tracks how many bytes of each output file have been streamed to a connection
+type streamCounters struct {
+	stdout atomic.Int64
+	stderr atomic.Int64
 }
 
 // safeConn wraps a websocket connection with a mutex for thread-safe writes
 type safeConn struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	conn     *websocket.Conn
+	mu       sync.Mutex
+	throttle *outputThrottle // Limits the bytes per second of task output (nil = unlimited)
 }
 
 func (sc *safeConn) WriteMessage(messageType int, data []byte) error {
@@ -63,6 +122,15 @@ func (sc *safeConn) WriteMessage(messageType int, data []byte) error {
 	return sc.conn.WriteMessage(messageType, data)
 }
 
+// writeOutput writes a message with task output once the throttle allows it. Other messages
+// (status, heartbeats, pings) are never delayed, the wait happens outside the write lock.
+func (sc *safeConn) writeOutput(ctx context.Context, messageType int, data []byte) error {
+	if err := sc.throttle.wait(ctx, len(data)); err != nil {
+		return err
+	}
+	return sc.WriteMessage(messageType, data)
+}
+
 // handleWebSocket handles WebSocket connections for live task output
 func handleWebSocket(w http.ResponseWriter, r *http.Request, taskManager *TaskManager, config *Config, upgrader websocket.Upgrader, wsManager *WebSocketManager) {
 	log.Printf("[WEBSOCKET] Connection attempt from %s", r.RemoteAddr)
@@ -74,76 +142,187 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, taskManager *TaskMa
 		log.Printf("[WEBSOCKET] Authentication failed: %v", err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Unauthorized: %v", err)})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Unauthorized: %v", err), Code: ErrCodeUnauthorized})
 		return
 	}
 
-	taskID := r.URL.Query().Get("task_id")
-	if taskID == "" {
-		taskID = claims.TaskID
+	taskID, err := requestTaskID(r, claims, config.Server.TaskIDScheme)
+	if err != nil {
+		log.Printf("[WEBSOCKET] Rejected task_id: %v", err)
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
 	}
 
-	if taskID == "" {
-		log.Printf("[WEBSOCKET] Missing task_id")
+	// Optionally replay only the last N lines of the existing output
+	tailLines, err := parseTailLines(r.URL.Query().Get("tail"))
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "task_id is required"})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid tail: %v", err), Code: ErrCodeInvalidRequest})
 		return
 	}
 
-	// Get task information
-	task, err := taskManager.GetTask(taskID)
+	// Get task information, runs of archived tasks are replayed from the archive once the task has been removed
+	task, err := taskManager.GetTaskOrArchived(taskID)
 	if err != nil {
 		log.Printf("[WEBSOCKET] Task not found: task_id=%s, error=%v", taskID, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Task not found: %v", err)})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Task not found: %v", err), Code: ErrCodeTaskNotFound})
 		return
 	}
 
-	// Upgrade connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Clients requesting subprotocols must support the current one, an unknown message schema
+	// would be misread silently
+	if !supportsSubprotocol(r) {
+		log.Printf("[WEBSOCKET] Unsupported subprotocols %v from %s", websocket.Subprotocols(r), r.RemoteAddr)
+		sendJSONError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Unsupported WebSocket subprotocol (supported: %s)", WSSubprotocol))
+		return
+	}
+
+	// Single-use viewer tokens are only accepted for the WebSocket of the viewer page that consumed them
+	if err := taskManager.consumeViewerToken(task, claims, remoteIP(r), true); err != nil {
+		log.Printf("[WEBSOCKET] Rejected viewer token: task_id=%s, error=%v", taskID, err)
+		sendJSONError(w, http.StatusUnauthorized, ErrCodeUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+		return
+	}
+
+	// Tasks embedded on their own origins accept those in addition to the global allowed_origins
+	if taskConfig := findTaskConfig(config.Tasks, task.TaskName); taskConfig != nil {
+		upgrader = withTaskOrigins(upgrader, taskConfig.AllowedOrigins)
+	}
+
+	// Reserve the tailers of this viewer, further viewers are rejected before the upgrade while the
+	// server is saturated. Tailers started below release their slot themselves when they end.
+	if !taskManager.tailers.Acquire(tailersPerViewer) {
+		log.Printf("[WEBSOCKET] Tailer limit reached (%d), rejecting viewer from %s for task_id=%s", config.Server.MaxTailers, r.RemoteAddr, taskID)
+		w.Header().Set("Retry-After", "1")
+		sendJSONError(w, http.StatusServiceUnavailable, ErrCodeTooManyConnections, fmt.Sprintf("Too many viewers (max %d output tailers)", config.Server.MaxTailers))
+		return
+	}
+	reservedTailers := tailersPerViewer
+	defer func() { taskManager.tailers.Release(reservedTailers) }()
+
+	// Upgrade connection to WebSocket. On failure the upgrader usually has already sent an error
+	// response or hijacked the connection, then nothing may be written anymore.
+	tracker := &upgradeTracker{ResponseWriter: w}
+	conn, err := upgrader.Upgrade(tracker, r, nil)
 	if err != nil {
+		if tracker.responded {
+			log.Printf("[WEBSOCKET] Failed to upgrade connection (response already sent): %v", err)
+			return
+		}
 		log.Printf("[WEBSOCKET] Failed to upgrade connection: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to upgrade connection: %v", err)})
+		sendJSONError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Failed to upgrade connection: %v", err))
 		return
 	}
 	defer conn.Close()
 
-	log.Printf("[WEBSOCKET] Socket connected: task_id=%s", taskID)
+	log.Printf("[WEBSOCKET] Socket connected: task_id=%s, subprotocol=%q", taskID, conn.Subprotocol())
 
 	// Wrap connection for thread-safe writes
-	safeConn := &safeConn{conn: conn}
+	safeConn := &safeConn{conn: conn, throttle: newOutputThrottle(config.Server.WSMaxBytesPerSec)}
 
 	// Register connection with manager
 	wsManager.Add(safeConn)
 	defer wsManager.Remove(safeConn)
 
+	// Archived runs have ended, their output is sent once instead of tailed
+	if task.Archived {
+		taskConfig := findTaskConfig(config.Tasks, task.TaskName)
+		binary := taskConfig != nil && taskConfig.BinaryOutput
+		coalesce := time.Duration(config.Server.WSCoalesceMs) * time.Millisecond
+		if replayArchivedTask(safeConn, taskManager.tailHandles, task, binary, coalesce, tailLines, taskProgressPrefix(taskConfig)) {
+			safeConn.mu.Lock()
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "task finished"))
+			safeConn.mu.Unlock()
+		}
+		return
+	}
+
+	// Refuse new tailers once cleanup has started, the output may disappear at any moment
+	if taskManager.IsFinishing(taskID) {
+		log.Printf("[WEBSOCKET] Task is finishing, not starting tailers: task_id=%s", taskID)
+		sendSystemMessage(safeConn, "completed", "Task has already finished", 0)
+		safeConn.mu.Lock()
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "task finished"))
+		safeConn.mu.Unlock()
+		return
+	}
+
+	// Count the viewer, tasks with terminate_on_disconnect end once the last one has left
+	taskManager.addViewer(taskID)
+	defer taskManager.removeViewer(taskID)
+
 	// Paths to output files
-	stdoutPath := filepath.Join(task.OutputDir, "stdout")
-	stderrPath := filepath.Join(task.OutputDir, "stderr")
+	taskConfig := findTaskConfig(config.Tasks, task.TaskName)
+	compressed := taskConfig != nil && taskConfig.CompressOutput
+	stdoutPath := filepath.Join(task.OutputDir, outputFileName("stdout", compressed))
+	stderrPath := filepath.Join(task.OutputDir, outputFileName("stderr", compressed))
 	pidPath := filepath.Join(task.OutputDir, "pid")
-	exitCodePath := filepath.Join(task.OutputDir, "exitcode")
 
 	// Try to read PID and send initial message
 	pid := readPID(pidPath)
 	if pid > 0 {
-		sendSystemMessage(safeConn, "connected", "WebSocket connected. Process started", pid)
+		sendConnectedMessage(safeConn, "WebSocket connected. Process started", pid)
 		log.Printf("[WEBSOCKET] Sent initial message with PID=%d for task_id=%s", pid, taskID)
 	} else {
-		sendSystemMessage(safeConn, "connected", "WebSocket connected. Waiting for process to start...", 0)
+		sendConnectedMessage(safeConn, "WebSocket connected. Waiting for process to start...", 0)
 		log.Printf("[WEBSOCKET] Sent initial message (no PID yet) for task_id=%s", taskID)
 	}
 
-	// Start monitoring process completion and timeout
-	ctx := r.Context()
-	go monitorProcess(ctx, safeConn, taskManager, taskID, pidPath, exitCodePath, task.OutputDir, task.MaxExecutionTime)
+	// Start monitoring process completion and timeout. The context ends when the client disconnects,
+	// the request context of a hijacked connection isn't cancelled by that.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	counters := &streamCounters{}
+	heartbeatInterval := time.Duration(config.Server.HeartbeatInterval) * time.Second
+	if heartbeatInterval == 0 {
+		heartbeatInterval = defaultHeartbeatInterval
+	}
+	startupTimeout := time.Duration(config.Server.StartupTimeout) * time.Second
+	if startupTimeout == 0 {
+		startupTimeout = defaultStartupTimeout
+	}
+	taskManager.monitor.Watch(&taskViewer{
+		ctx:               ctx,
+		safeConn:          safeConn,
+		taskID:            taskID,
+		outputDir:         task.OutputDir,
+		maxExecutionTime:  task.MaxExecutionTime,
+		counters:          counters,
+		startTime:         task.StartTime,
+		heartbeatInterval: heartbeatInterval,
+		startupTimeout:    startupTimeout,
+		stallTimeout:      taskStallTimeout(taskConfig),
+	})
 
-	// Start tailing stdout and stderr
-	go tailFile(ctx, safeConn, stdoutPath, "stdout", taskID)
-	go tailFile(ctx, safeConn, stderrPath, "stderr", taskID)
+	// Start tailing stdout and stderr, as raw chunks for tasks with binary output (always replayed in full)
+	// and decompressing for tasks with compressed output
+	coalesce := time.Duration(config.Server.WSCoalesceMs) * time.Millisecond
+	wait := newFileWait(config.Server)
+	progressPrefix := taskProgressPrefix(taskConfig)
+	tail := func(filePath, outputType string, streamed *atomic.Int64) {
+		tailFile(ctx, safeConn, taskManager.tailHandles, wait, filePath, outputType, taskID, streamed, coalesce, tailLines, progressPrefix, streamStepMarker(task, outputType))
+	}
+	if taskConfig != nil && taskConfig.BinaryOutput {
+		tail = func(filePath, outputType string, streamed *atomic.Int64) {
+			tailFileBinary(ctx, safeConn, taskManager.tailHandles, wait, filePath, outputType, taskID, streamed)
+		}
+	} else if compressed {
+		tail = func(filePath, outputType string, streamed *atomic.Int64) {
+			tailFileGzip(ctx, safeConn, taskManager.tailHandles, wait, filePath, outputType, taskID, streamed, coalesce, tailLines, progressPrefix, streamStepMarker(task, outputType))
+		}
+	}
+	startTail := func(filePath, outputType string, streamed *atomic.Int64) {
+		reservedTailers--
+		go func() {
+			defer taskManager.tailers.Release(1)
+			tail(filePath, outputType, streamed)
+		}()
+	}
+	startTail(stdoutPath, "stdout", &counters.stdout)
+	startTail(stderrPath, "stderr", &counters.stderr)
 
 	// Keep connection alive and handle ping/pong
 	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -158,6 +337,7 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request, taskManager *TaskMa
 
 	// Handle incoming messages (for pong)
 	go func() {
+		defer cancel()
 		for {
 			_, _, err := conn.ReadMessage()
 			if err != nil {
@@ -206,89 +386,93 @@ func isProcessRunning(pid int) bool {
 	return err == nil
 }
 
-// monitorProcess monitors the process and handles cleanup when it finishes
-func monitorProcess(ctx context.Context, safeConn *safeConn, taskManager *TaskManager, taskID, pidPath, exitCodePath, outputDir string, maxExecutionTime time.Duration) {
-	// Wait for PID file to be created
-	var pid int
-	for i := 0; i < 60; i++ {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-		pid = readPID(pidPath)
-		if pid > 0 {
-			break
-		}
-		time.Sleep(1 * time.Second)
+// processStartTime returns the start time of a process in clock ticks since boot (field 22 of /proc/<pid>/stat).
+// Together with the PID it identifies a process, as PIDs are reused after a process exits.
+func processStartTime(pid int) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	// The command name (field 2) is in parentheses and may contain spaces, fields after it are space separated
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat file for pid %d", pid)
 	}
+	fields := strings.Fields(string(data[end+1:]))
+	const startTimeIndex = 22 - 3 // Field 22, counted from field 3 (state)
+	if len(fields) <= startTimeIndex {
+		return 0, fmt.Errorf("malformed stat file for pid %d", pid)
+	}
+	return strconv.ParseUint(fields[startTimeIndex], 10, 64)
+}
 
-	if pid == 0 {
-		log.Printf("[MONITOR] PID not found for task_id=%s", taskID)
-		return
+// isTaskProcessRunning checks if the process with the given PID is running and is still the task's process.
+// A different start time means the PID has been reused by an unrelated process. startTime 0 (unknown)
+// only checks the PID.
+func isTaskProcessRunning(pid int, startTime uint64) bool {
+	if !isProcessRunning(pid) {
+		return false
+	}
+	if startTime == 0 {
+		return true
 	}
+	current, err := processStartTime(pid)
+	if err != nil {
+		// Process vanished between the checks
+		return false
+	}
+	return current == startTime
+}
 
-	log.Printf("[MONITOR] Monitoring process PID=%d for task_id=%s", pid, taskID)
+// handleStartupFailure marks a task whose wrapper never ran the command as failed, kills the
+// wrapper's process group, notifies the viewer and cleans up the task
+func handleStartupFailure(safeConn *safeConn, taskManager *TaskManager, taskID, outputDir string, startupTimeout time.Duration) {
+	log.Printf("[MONITOR] Command not started within %v, task failed to start: task_id=%s", startupTimeout, taskID)
 
-	// Start timeout monitor if max execution time is set
-	var timeoutTimer *time.Timer
-	var timeoutChan <-chan time.Time
-	if maxExecutionTime > 0 {
-		timeoutTimer = time.NewTimer(maxExecutionTime)
-		timeoutChan = timeoutTimer.C
-		log.Printf("[MONITOR] Max execution time set to %v for task_id=%s", maxExecutionTime, taskID)
+	taskManager.mu.Lock()
+	task, ok := taskManager.runningTasks[taskID]
+	var exited <-chan struct{}
+	if ok {
+		task.State = TaskStateFailed
+		task.Killed = true
+		exited = task.archived
+		delete(taskManager.runningTasks, taskID)
 	}
+	taskManager.mu.Unlock()
 
-	// Poll to check if process is still running
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
-	if timeoutTimer != nil {
-		defer timeoutTimer.Stop()
+	// The wrapper hangs before the command, nothing needs to shut down gracefully
+	if ok {
+		if pid := readPID(filepath.Join(outputDir, "pid")); pid > 0 {
+			if err := signalTask(pid, syscall.SIGKILL); err != nil {
+				log.Printf("[MONITOR] Failed to kill process group of PID %d: %v", pid, err)
+			}
+		}
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-timeoutChan:
-			// Max execution time exceeded
-			handleTimeout(safeConn, taskManager, taskID, pid)
-			timeoutChan = nil // Disable timeout channel after handling
-		case <-ticker.C:
-			if !isProcessRunning(pid) {
-				// Process has ended, read exit code
-				exitCode := readExitCode(exitCodePath)
-
-				// Send completion message
-				msg := fmt.Sprintf("Process ended with exit code: %d", exitCode)
-				sendSystemMessage(safeConn, "completed", msg, pid)
-				log.Printf("[MONITOR] Process ended: task_id=%s, pid=%d, exit_code=%d", taskID, pid, exitCode)
-
-				// Wait a bit for final output to be written and message to be sent
-				time.Sleep(2 * time.Second)
-
-				// Remove task from manager
-				taskManager.mu.Lock()
-				delete(taskManager.runningTasks, taskID)
-				taskManager.mu.Unlock()
-
-				// Close WebSocket connection (client should have closed it already, but close it here too)
-				safeConn.mu.Lock()
-				safeConn.conn.Close()
-				safeConn.mu.Unlock()
-
-				// Cleanup: remove task directory (after connection is closed)
-				time.Sleep(1 * time.Second)
-				if err := os.RemoveAll(outputDir); err != nil {
-					log.Printf("[MONITOR] Failed to cleanup directory %s: %v", outputDir, err)
-				} else {
-					log.Printf("[MONITOR] Cleaned up directory: %s", outputDir)
-				}
+	sendSystemMessage(safeConn, "error", fmt.Sprintf("Task failed to start: process did not start within %v", startupTimeout), 0)
 
-				return
-			}
+	safeConn.mu.Lock()
+	safeConn.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "task failed to start"))
+	safeConn.conn.Close()
+	safeConn.mu.Unlock()
+
+	if !ok {
+		// Already cleaned up by another viewer's monitor
+		return
+	}
+	// Remove the output only once the process is gone and its output archived, it may still be writing to it
+	if exited != nil {
+		select {
+		case <-exited:
+		case <-time.After(startupKillWait):
+			log.Printf("[MONITOR] Process of failed task did not exit within %v: task_id=%s", startupKillWait, taskID)
 		}
 	}
+	if err := taskManager.removeOutputDir(outputDir); err != nil {
+		log.Printf("[MONITOR] Failed to cleanup directory %s: %v", outputDir, err)
+	} else {
+		log.Printf("[MONITOR] Cleaned up directory: %s", outputDir)
+	}
 }
 
 // readExitCode reads the exit code from the exitcode file
@@ -317,28 +501,151 @@ func sendSystemMessage(safeConn *safeConn, msgType, message string, pid int) {
 	}
 }
 
-// tailFile tails a file and sends updates over WebSocket
-func tailFile(ctx context.Context, safeConn *safeConn, filePath, outputType, taskID string) {
-	log.Printf("[TAIL] Starting to tail file: %s (type=%s, task_id=%s)", filePath, outputType, taskID)
-	// Wait for file to be created (up to 60 seconds)
-	fileExists := false
-	for i := 0; i < 60; i++ {
-		select {
-		case <-ctx.Done():
-			log.Printf("[TAIL] Context cancelled while waiting for file: %s", filePath)
+// upgradeTracker wraps the ResponseWriter passed to the upgrader and records whether it has
+// written a response or hijacked the connection
+type upgradeTracker struct {
+	http.ResponseWriter
+	responded bool
+}
+
+func (ut *upgradeTracker) WriteHeader(statusCode int) {
+	ut.responded = true
+	ut.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (ut *upgradeTracker) Write(data []byte) (int, error) {
+	ut.responded = true
+	return ut.ResponseWriter.Write(data)
+}
+
+// Hijack forwards to the underlying writer, a failed hijack leaves the response unwritten
+func (ut *upgradeTracker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := ut.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		ut.responded = true
+	}
+	return conn, rw, err
+}
+
+// sendConnectedMessage sends the initial system message of a connection, including the message schema version
+func sendConnectedMessage(safeConn *safeConn, message string, pid int) {
+	sysMsg := SystemMessage{
+		Type:    "system",
+		Message: message,
+		PID:     pid,
+		Version: WSMessageVersion,
+	}
+	if data, err := json.Marshal(sysMsg); err == nil {
+		safeConn.WriteMessage(websocket.TextMessage, data)
+	}
+}
+
+// supportsSubprotocol reports whether the request asks for no subprotocol or includes WSSubprotocol
+func supportsSubprotocol(r *http.Request) bool {
+	protocols := websocket.Subprotocols(r)
+	if len(protocols) == 0 {
+		return true
+	}
+	for _, protocol := range protocols {
+		if protocol == WSSubprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// sendCompletionMessage sends the completion system message including the exit code and the streamed
+// byte counts, so clients don't have to parse the message and can verify they received all output
+func sendCompletionMessage(safeConn *safeConn, message string, pid int, exitCode int, counters *streamCounters) {
+	stdoutBytes := counters.stdout.Load()
+	stderrBytes := counters.stderr.Load()
+	sysMsg := SystemMessage{
+		Type:        "system",
+		Message:     message,
+		PID:         pid,
+		StdoutBytes: &stdoutBytes,
+		StderrBytes: &stderrBytes,
+		ExitCode:    &exitCode,
+		Signal:      exitSignal(exitCode),
+	}
+	if data, err := json.Marshal(sysMsg); err == nil {
+		safeConn.WriteMessage(websocket.TextMessage, data)
+	}
+}
+
+// sendHeartbeat sends a heartbeat message with the elapsed run time and the output streamed so far
+func sendHeartbeat(safeConn *safeConn, elapsed time.Duration, counters *streamCounters) {
+	msg := HeartbeatMessage{
+		Type:           "heartbeat",
+		ElapsedSeconds: int64(elapsed.Seconds()),
+		StdoutBytes:    counters.stdout.Load(),
+		StderrBytes:    counters.stderr.Load(),
+	}
+	if data, err := json.Marshal(msg); err == nil {
+		safeConn.WriteMessage(websocket.TextMessage, data)
+	}
+}
+
+// waitForStreams waits until the tailers have streamed the output files completely or the timeout expires
+func waitForStreams(counters *streamCounters, stdoutPath, stderrPath string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if streamedAll(&counters.stdout, stdoutPath) && streamedAll(&counters.stderr, stderrPath) {
+			return
+		}
+		if time.Now().After(deadline) {
 			return
-		default:
 		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// streamedAll reports whether the counter has reached the current size of the file.
+// For compressed files the uncompressed size from the gzip trailer is compared.
+func streamedAll(counter *atomic.Int64, filePath string) bool {
+	if strings.HasSuffix(filePath, gzipSuffix) {
+		size, err := gzipUncompressedSize(filePath)
+		if err != nil {
+			return true // Nothing to stream
+		}
+		return uint32(counter.Load()) == size
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return true // Nothing to stream
+	}
+	return counter.Load() >= info.Size()
+}
+
+// waitForOutputFile waits up to wait.timeout for an output file to be created, checking every wait.interval.
+// Returns false if the context was cancelled or the file did not appear (the client is notified).
+func waitForOutputFile(ctx context.Context, safeConn *safeConn, wait fileWait, filePath, outputType string) bool {
+	start := time.Now()
+	deadline := start.Add(wait.timeout)
+	fileExists := false
+	for {
 		if _, err := os.Stat(filePath); err == nil {
 			fileExists = true
-			log.Printf("[TAIL] File found: %s (after %d seconds)", filePath, i)
+			log.Printf("[TAIL] File found: %s (after %v)", filePath, time.Since(start).Round(time.Millisecond))
+			break
+		}
+		if !time.Now().Before(deadline) {
 			break
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			log.Printf("[TAIL] Context cancelled while waiting for file: %s", filePath)
+			return false
+		case <-time.After(min(wait.interval, time.Until(deadline))):
+		}
 	}
 
 	if !fileExists {
-		log.Printf("[TAIL] File not found after 60 seconds: %s", filePath)
+		log.Printf("[TAIL] File not found after %v: %s", wait.timeout, filePath)
 		// File doesn't exist yet, send waiting message
 		msg := WebSocketMessage{
 			Type: outputType,
@@ -347,21 +654,195 @@ func tailFile(ctx context.Context, safeConn *safeConn, filePath, outputType, tas
 		if data, err := json.Marshal(msg); err == nil {
 			safeConn.WriteMessage(websocket.TextMessage, data)
 		}
-		return
+		return false
+	}
+	return true
+}
+
+// maxCoalescedSize caps the payload of a message with coalesced lines
+const maxCoalescedSize = 64 * 1024
+
+// lineBatcher sends output lines as WebSocket messages. With a coalesce window, lines read
+// within the window are joined into one message to reduce the message rate.
+type lineBatcher struct {
+	ctx        context.Context // Ends waiting for the output throttle
+	safeConn   *safeConn
+	outputType string
+	window     time.Duration // 0 = one message per line
+	buf        strings.Builder
+	started    time.Time // When the first buffered line was read
+
+	progressPrefix string // Lines starting with it are sent as progress messages (empty = disabled)
+	stepMarker     string // Prefix of step marker lines, sent as step messages (empty = disabled)
+	heldBlank      bool   // A blank line was read that is dropped if a step marker follows
+}
+
+// add sends a line, or buffers it until the window has elapsed or the batch is full.
+// Step markers and progress lines are sent as step or progress messages after the lines before them.
+func (lb *lineBatcher) add(line string) error {
+	if lb.stepMarker != "" {
+		// The wrapper writes a newline before each marker; if the output before ended with a
+		// newline, this leaves a blank line that isn't part of the output
+		held := lb.heldBlank
+		lb.heldBlank = false
+		if step, ok := parseStepMarker(lb.stepMarker, strings.TrimSuffix(line, "\n")); ok {
+			return lb.sendEvent(step)
+		}
+		if held {
+			if err := lb.addOutput("\n"); err != nil {
+				return err
+			}
+		}
+		if line == "\n" {
+			lb.heldBlank = true
+			return nil
+		}
 	}
+	return lb.addOutput(line)
+}
 
-	// Open file for reading
-	file, err := os.Open(filePath)
+// addOutput sends an output or progress line, or buffers it like add
+func (lb *lineBatcher) addOutput(line string) error {
+	if progress, ok := parseProgressLine(lb.progressPrefix, strings.TrimSuffix(line, "\n")); ok {
+		return lb.sendEvent(progress)
+	}
+	if lb.window <= 0 {
+		return lb.send(line)
+	}
+	if lb.buf.Len() == 0 {
+		lb.started = time.Now()
+	}
+	lb.buf.WriteString(line)
+	if lb.buf.Len() >= maxCoalescedSize || time.Since(lb.started) >= lb.window {
+		return lb.flush()
+	}
+	return nil
+}
+
+// flush sends the buffered lines, called once all currently available lines have been read
+func (lb *lineBatcher) flush() error {
+	if lb.buf.Len() == 0 {
+		return nil
+	}
+	data := lb.buf.String()
+	lb.buf.Reset()
+	return lb.send(data)
+}
+
+// sendEvent flushes the buffered lines and sends a step or progress message
+func (lb *lineBatcher) sendEvent(event interface{}) error {
+	if err := lb.flush(); err != nil {
+		return err
+	}
+	msg, err := json.Marshal(event)
+	if err != nil {
+		return nil
+	}
+	return lb.safeConn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// send writes one output message. Encoding errors drop the message, write errors are returned.
+func (lb *lineBatcher) send(data string) error {
+	msg, err := json.Marshal(WebSocketMessage{Type: lb.outputType, Data: data})
 	if err != nil {
-		log.Printf("[TAIL] Failed to open file: %s, error: %v", filePath, err)
+		return nil
+	}
+	return lb.safeConn.writeOutput(lb.ctx, websocket.TextMessage, msg)
+}
+
+// parseTailLines parses the tail query parameter of /ws, the number of lines of existing output
+// to replay (0 = all, also if the parameter is missing)
+func parseTailLines(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non-negative integer")
+	}
+	return n, nil
+}
+
+// tailScanBlockSize is the size of the blocks read backwards from the end of a file to find the last lines
+const tailScanBlockSize = 8 * 1024
+
+// lastLinesOffset returns the offset at which the last n lines of the first size bytes of a file start,
+// scanning backwards from the end. A final line without newline counts as a line.
+func lastLinesOffset(file *os.File, size int64, n int) (int64, error) {
+	if n <= 0 {
+		return size, nil
+	}
+	buf := make([]byte, tailScanBlockSize)
+	end := size
+	newlines := 0
+	for end > 0 {
+		start := max(0, end-tailScanBlockSize)
+		block := buf[:end-start]
+		if _, err := file.ReadAt(block, start); err != nil {
+			return 0, err
+		}
+		for i := len(block) - 1; i >= 0; i-- {
+			if block[i] != '\n' || start+int64(i) == size-1 {
+				continue // The newline at the very end terminates the last line
+			}
+			newlines++
+			if newlines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// tailFile tails a file and sends updates over WebSocket.
+// Lines read within the coalesce window (0 = disabled) are joined into one message.
+// With tailLines > 0, only the last tailLines lines of the existing content are replayed.
+// The number of bytes consumed from the file (including skipped ones) is stored in streamed.
+// Lines starting with progressPrefix (empty = disabled) are sent as progress messages, step marker
+// lines of the run (stepMarker, empty = disabled) as step messages.
+func tailFile(ctx context.Context, safeConn *safeConn, handles *tailHandleGuard, wait fileWait, filePath, outputType, taskID string, streamed *atomic.Int64, coalesce time.Duration, tailLines int, progressPrefix, stepMarker string) {
+	log.Printf("[TAIL] Starting to tail file: %s (type=%s, task_id=%s)", filePath, outputType, taskID)
+	if !waitForOutputFile(ctx, safeConn, wait, filePath, outputType) {
+		return
+	}
+
+	// Open file for reading, the handle is kept open for the whole tail
+	file, ok := openTailFile(handles, safeConn, filePath)
+	if !ok {
 		return
 	}
-	defer file.Close()
+	defer handles.Close(file)
+
+	// Skip to the last lines of the existing content
+	if tailLines > 0 {
+		info, err := file.Stat()
+		if err != nil {
+			log.Printf("[TAIL] Failed to stat file: %s, error: %v", filePath, err)
+			return
+		}
+		offset, err := lastLinesOffset(file, info.Size(), tailLines)
+		if err != nil {
+			log.Printf("[TAIL] Failed to find last %d lines: %s, error: %v", tailLines, filePath, err)
+			return
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+	}
+
+	// Lines read within the coalesce window are sent as one message
+	batcher := &lineBatcher{ctx: ctx, safeConn: safeConn, outputType: outputType, window: coalesce, progressPrefix: progressPrefix, stepMarker: stepMarker}
 
 	// Read existing content first
 	// Note: bufio.Scanner preserves ANSI escape sequences as they are part of the text
 	// ANSI codes (like \x1b[31m) will be included in scanner.Text() and sent to the client
-	scanner := bufio.NewScanner(file)
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return
+	}
+	var scanned int64
+	scanner := newOutputScanner(file, &scanned)
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
@@ -369,24 +850,22 @@ func tailFile(ctx context.Context, safeConn *safeConn, filePath, outputType, tas
 		default:
 		}
 		// scanner.Text() preserves all bytes including ANSI escape sequences
-		msg := WebSocketMessage{
-			Type: outputType,
-			Data: scanner.Text() + "\n",
-		}
-		if data, err := json.Marshal(msg); err == nil {
-			if err := safeConn.WriteMessage(websocket.TextMessage, data); err != nil {
-				return
-			}
+		if err := batcher.add(scanner.Text() + "\n"); err != nil {
+			return
 		}
 	}
+	if err := batcher.flush(); err != nil {
+		return
+	}
 
 	// Get current position
-	lastPos, err := file.Seek(0, io.SeekEnd)
+	lastPos, err := readPosition(file, scanner, start+scanned)
 	if err != nil {
 		return
 	}
+	streamed.Store(lastPos)
 
-	// Tail the file by polling for new content
+	// Tail the file by polling for new content, reading on from the last position of the open handle
 	ticker := time.NewTicker(200 * time.Millisecond) // Poll every 200ms
 	defer ticker.Stop()
 
@@ -395,58 +874,151 @@ func tailFile(ctx context.Context, safeConn *safeConn, filePath, outputType, tas
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// Check if file still exists
-			if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			// Get current file size, the output file is never truncated or replaced
+			info, err := file.Stat()
+			if err != nil || info.Size() <= lastPos {
 				continue
 			}
 
-			// Get current file size
-			info, err := os.Stat(filePath)
-			if err != nil {
-				continue
+			// Read new lines
+			// Note: ANSI escape sequences are preserved in scanner.Text()
+			scanned = 0
+			scanner := newOutputScanner(file, &scanned)
+			for scanner.Scan() {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+				// scanner.Text() preserves all bytes including ANSI escape sequences
+				if err := batcher.add(scanner.Text() + "\n"); err != nil {
+					return
+				}
+			}
+			if err := batcher.flush(); err != nil {
+				return
 			}
 
-			currentSize := info.Size()
+			// Update last position
+			if lastPos, err = readPosition(file, scanner, lastPos+scanned); err != nil {
+				return
+			}
+			streamed.Store(lastPos)
+		}
+	}
+}
 
-			// If file has grown, read new content
-			if currentSize > lastPos {
-				// Reopen file to read new content
-				file.Close()
-				file, err = os.Open(filePath)
-				if err != nil {
-					log.Printf("[TAIL] Failed to reopen file: %s, error: %v", filePath, err)
-					continue
-				}
+// readPosition returns the position up to which a file has been scanned and moves the file
+// offset there, so that bytes left unscanned (an incomplete UTF-8 character) are read again with
+// the next new output. If the scanner failed (e.g. on a line longer than its buffer), the rest of
+// the file is skipped.
+func readPosition(file *os.File, scanner *bufio.Scanner, scannedPos int64) (int64, error) {
+	if err := scanner.Err(); err != nil {
+		log.Printf("[TAIL] Failed to read file: %s, error: %v", file.Name(), err)
+		return file.Seek(0, io.SeekEnd)
+	}
+	return file.Seek(scannedPos, io.SeekStart)
+}
 
-				// Seek to last known position
-				file.Seek(lastPos, io.SeekStart)
-
-				// Read new lines
-				// Note: ANSI escape sequences are preserved in scanner.Text()
-				scanner := bufio.NewScanner(file)
-				for scanner.Scan() {
-					select {
-					case <-ctx.Done():
-						file.Close()
-						return
-					default:
-					}
-					// scanner.Text() preserves all bytes including ANSI escape sequences
-					msg := WebSocketMessage{
-						Type: outputType,
-						Data: scanner.Text() + "\n",
-					}
-					if data, err := json.Marshal(msg); err == nil {
-						if err := safeConn.WriteMessage(websocket.TextMessage, data); err != nil {
-							file.Close()
-							return
-						}
-					}
+// newOutputScanner returns a line scanner for output files that adds the number of bytes consumed
+// by its lines to scanned. Lines are split like bufio.ScanLines, but an unterminated last line
+// (output still being written) is only returned up to its last complete UTF-8 character: the bytes
+// of a character split across writes are left for the next scan instead of being sent as an
+// invalid fragment.
+func newOutputScanner(r io.Reader, scanned *int64) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if atEOF && advance == len(data) && advance > 0 && data[len(data)-1] != '\n' {
+			if complete := completeUTF8Len(data); complete < len(data) {
+				advance, token = complete, data[:complete]
+				if complete == 0 {
+					token = nil // Nothing to send until the character is complete
 				}
+			}
+		}
+		*scanned += int64(advance)
+		return advance, token, err
+	})
+	return scanner
+}
 
-				// Update last position
-				lastPos, _ = file.Seek(0, io.SeekEnd)
+// completeUTF8Len returns the length of data without an incomplete UTF-8 character at its end.
+// Invalid bytes that can't start a character count as complete, they are sent as they are.
+func completeUTF8Len(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if utf8.FullRune(data[i:]) {
+				return len(data)
 			}
+			return i
+		}
+	}
+	return len(data)
+}
+
+// Binary output framing: each binary WebSocket message starts with a tag byte identifying
+// the stream, followed by up to binaryChunkSize raw bytes of output
+const (
+	binaryChunkSize      = 32 * 1024
+	binaryTagStdout byte = 1
+	binaryTagStderr byte = 2
+)
+
+// binaryStreamTag returns the frame tag byte for an output type
+func binaryStreamTag(outputType string) byte {
+	if outputType == "stderr" {
+		return binaryTagStderr
+	}
+	return binaryTagStdout
+}
+
+// tailFileBinary tails a file and sends its content byte-exact in fixed-size chunks as binary
+// WebSocket messages, for tasks whose output is not newline-delimited text (e.g. images).
+// The number of bytes consumed from the file is stored in streamed.
+func tailFileBinary(ctx context.Context, safeConn *safeConn, handles *tailHandleGuard, wait fileWait, filePath, outputType, taskID string, streamed *atomic.Int64) {
+	log.Printf("[TAIL] Starting to tail binary file: %s (type=%s, task_id=%s)", filePath, outputType, taskID)
+	if !waitForOutputFile(ctx, safeConn, wait, filePath, outputType) {
+		return
+	}
+
+	file, ok := openTailFile(handles, safeConn, filePath)
+	if !ok {
+		return
+	}
+	defer handles.Close(file)
+
+	ticker := time.NewTicker(200 * time.Millisecond) // Poll every 200ms at the end of the file
+	defer ticker.Stop()
+
+	frame := make([]byte, 1+binaryChunkSize)
+	frame[0] = binaryStreamTag(outputType)
+	var pos int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		n, err := file.Read(frame[1:])
+		if n > 0 {
+			if err := safeConn.writeOutput(ctx, websocket.BinaryMessage, frame[:1+n]); err != nil {
+				return
+			}
+			pos += int64(n)
+			streamed.Store(pos)
+			continue
+		}
+		if err != nil && err != io.EOF {
+			log.Printf("[TAIL] Failed to read file: %s, error: %v", filePath, err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
 }
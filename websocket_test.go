@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
 )
 
 func TestReadPID(t *testing.T) {
@@ -157,6 +169,73 @@ func TestIsProcessRunning(t *testing.T) {
 	}
 }
 
+func TestIsTaskProcessRunningDetectsPIDReuse(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process start times are read from /proc")
+	}
+
+	currentPID := os.Getpid()
+	startTime, err := processStartTime(currentPID)
+	if err != nil {
+		t.Fatalf("processStartTime(%d) = %v", currentPID, err)
+	}
+	if startTime == 0 {
+		t.Fatalf("processStartTime(%d) = 0; want start time", currentPID)
+	}
+
+	if !isTaskProcessRunning(currentPID, startTime) {
+		t.Errorf("isTaskProcessRunning(%d, %d) = false; want true (same process)", currentPID, startTime)
+	}
+	// A stale PID now belonging to another process has a different start time
+	if isTaskProcessRunning(currentPID, startTime+1) {
+		t.Errorf("isTaskProcessRunning(%d, %d) = true; want false (PID reused)", currentPID, startTime+1)
+	}
+	if !isTaskProcessRunning(currentPID, 0) {
+		t.Errorf("isTaskProcessRunning(%d, 0) = false; want true (start time unknown)", currentPID)
+	}
+	if isTaskProcessRunning(999999999, startTime) {
+		t.Error("isTaskProcessRunning(999999999) = true; want false (invalid PID)")
+	}
+}
+
+func TestTaskManagerRecordsProcessStartTime(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process start times are read from /proc")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Tasks:  []TaskConfig{{Name: "sleep-task", Command: "sleep 1"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("sleep-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	pid := readPID(filepath.Join(tmpDir, taskID, "pid"))
+	startTime := taskManager.ProcessStartTime(taskID)
+	if startTime == 0 {
+		t.Fatal("ProcessStartTime() = 0; want recorded start time")
+	}
+	if !isTaskProcessRunning(pid, startTime) {
+		t.Errorf("isTaskProcessRunning(%d, %d) = false; want true while the task runs", pid, startTime)
+	}
+
+	if _, ok := taskManager.WaitExitCode(taskID, 5*time.Second); !ok {
+		t.Fatal("task did not exit")
+	}
+	if isTaskProcessRunning(pid, startTime) {
+		t.Errorf("isTaskProcessRunning(%d, %d) = true; want false after exit", pid, startTime)
+	}
+}
+
 func TestCreateUpgrader(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -187,7 +266,7 @@ func TestCreateUpgrader(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			upgrader := createUpgrader(tt.allowedOrigins)
+			upgrader := createUpgrader(tt.allowedOrigins, false)
 
 		// Test CheckOrigin function
 		req := &http.Request{
@@ -220,6 +299,15 @@ func TestCreateUpgrader(t *testing.T) {
 	}
 }
 
+func TestCreateUpgraderDenyIfEmpty(t *testing.T) {
+	upgrader := createUpgrader(nil, true)
+	req := &http.Request{Header: make(http.Header)}
+	req.Header.Set("Origin", "http://localhost:8080")
+	if upgrader.CheckOrigin(req) {
+		t.Errorf("createUpgrader(nil, true) CheckOrigin() = true; want false (deny all)")
+	}
+}
+
 func TestSendSystemMessage(t *testing.T) {
 	// Note: sendSystemMessage requires a real WebSocket connection
 	// For unit testing, we skip this test as it would panic with nil connection
@@ -228,3 +316,1266 @@ func TestSendSystemMessage(t *testing.T) {
 	t.Skip("sendSystemMessage requires real WebSocket connection - tested via integration tests")
 }
 
+
+func TestHandleWebSocketFinishingTask(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+	}
+	taskManager := NewTaskManager(config)
+	wsManager := NewWebSocketManager()
+
+	// Task whose process has ended and whose output directory is already gone
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	taskManager.mu.Lock()
+	taskManager.runningTasks[taskID] = &RunningTask{
+		ID:        taskID,
+		TaskName:  "test-task",
+		StartTime: time.Now(),
+		OutputDir: filepath.Join(tmpDir, taskID),
+		State:     TaskStateRunning,
+	}
+	taskManager.mu.Unlock()
+	taskManager.MarkFinishing(taskID)
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, wsManager, taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// First message is the completion notice
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage() = %v; want completion message", err)
+	}
+	var msg SystemMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("message is not valid JSON: %v", err)
+	}
+	if msg.Type != "system" || !strings.Contains(msg.Message, "already finished") {
+		t.Errorf("message = %+v; want system message about finished task", msg)
+	}
+
+	// Then the connection is closed normally without any output messages
+	_, data, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("ReadMessage() = %q, %v; want normal closure", data, err)
+	}
+}
+
+func TestHandleWebSocketCompletionByteCounts(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "output-task", Command: "printf 'hello\\nworld\\n'; printf 'oops\\n' >&2; sleep 0.5"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("output-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	received := map[string]int64{}
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before completion message", err)
+		}
+		var msg struct {
+			Type        string `json:"type"`
+			Data        string `json:"data"`
+			Message     string `json:"message"`
+			StdoutBytes *int64 `json:"stdout_bytes"`
+			StderrBytes *int64 `json:"stderr_bytes"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if msg.Type == "stdout" || msg.Type == "stderr" {
+			received[msg.Type] += int64(len(msg.Data))
+			continue
+		}
+		if msg.StdoutBytes == nil {
+			continue // Other system messages (e.g. connected)
+		}
+
+		if *msg.StdoutBytes != int64(len("hello\nworld\n")) {
+			t.Errorf("completion stdout_bytes = %d; want %d", *msg.StdoutBytes, len("hello\nworld\n"))
+		}
+		if msg.StderrBytes == nil || *msg.StderrBytes != int64(len("oops\n")) {
+			t.Errorf("completion stderr_bytes = %v; want %d", msg.StderrBytes, len("oops\n"))
+		}
+		if received["stdout"] != *msg.StdoutBytes || received["stderr"] != *msg.StderrBytes {
+			t.Errorf("received stdout=%d stderr=%d bytes; completion message reports %d/%d", received["stdout"], received["stderr"], *msg.StdoutBytes, *msg.StderrBytes)
+		}
+		return
+	}
+}
+
+func TestHandleWebSocketBinaryOutput(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Several chunks of data that is neither valid UTF-8 nor newline-delimited
+	payload := make([]byte, 2*binaryChunkSize+123)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	payloadPath := filepath.Join(tmpDir, "payload.bin")
+	if err := os.WriteFile(payloadPath, payload, 0600); err != nil {
+		t.Fatalf("Failed to write payload: %v", err)
+	}
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks")},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "binary-task", Command: "cat " + payloadPath + "; head -c 10 " + payloadPath + " >&2; sleep 0.5", BinaryOutput: true},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("binary-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	received := map[byte][]byte{}
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before completion message", err)
+		}
+		if messageType == websocket.BinaryMessage {
+			if len(data) < 2 || len(data) > 1+binaryChunkSize {
+				t.Fatalf("binary frame has length %d; want 2..%d", len(data), 1+binaryChunkSize)
+			}
+			received[data[0]] = append(received[data[0]], data[1:]...)
+			continue
+		}
+
+		var msg struct {
+			Type        string `json:"type"`
+			StdoutBytes *int64 `json:"stdout_bytes"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("text message is not valid JSON: %v", err)
+		}
+		if msg.Type == "stdout" || msg.Type == "stderr" {
+			t.Fatalf("received %s as text message; want binary frames", msg.Type)
+		}
+		if msg.StdoutBytes != nil {
+			break
+		}
+	}
+
+	if !bytes.Equal(received[binaryTagStdout], payload) {
+		t.Errorf("stdout = %d bytes; want %d bytes identical to payload", len(received[binaryTagStdout]), len(payload))
+	}
+	if !bytes.Equal(received[binaryTagStderr], payload[:10]) {
+		t.Errorf("stderr = %v; want %v", received[binaryTagStderr], payload[:10])
+	}
+}
+
+func TestHandleWebSocketCoalesceOutput(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: filepath.Join(tmpDir, "tasks"), WSCoalesceMs: 50},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "chatty-task", Command: "seq 1 1000; seq 1 500 >&2; sleep 0.5"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("chatty-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	received := map[string]*strings.Builder{"stdout": {}, "stderr": {}}
+	messages := map[string]int{}
+	for {
+		var msg struct {
+			Type        string `json:"type"`
+			Data        string `json:"data"`
+			StdoutBytes *int64 `json:"stdout_bytes"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() = %v before completion message", err)
+		}
+		if msg.Type == "stdout" || msg.Type == "stderr" {
+			received[msg.Type].WriteString(msg.Data)
+			messages[msg.Type]++
+			continue
+		}
+		if msg.Type == "system" && msg.StdoutBytes != nil {
+			break
+		}
+	}
+
+	seq := func(n int) string {
+		var sb strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&sb, "%d\n", i)
+		}
+		return sb.String()
+	}
+	if got, want := received["stdout"].String(), seq(1000); got != want {
+		t.Errorf("stdout = %d bytes; want %d bytes of seq output in order", len(got), len(want))
+	}
+	if got, want := received["stderr"].String(), seq(500); got != want {
+		t.Errorf("stderr = %d bytes; want %d bytes of seq output in order", len(got), len(want))
+	}
+	if messages["stdout"] >= 100 {
+		t.Errorf("stdout messages = %d; want far fewer than 1000 lines", messages["stdout"])
+	}
+	if messages["stderr"] >= 50 {
+		t.Errorf("stderr messages = %d; want far fewer than 500 lines", messages["stderr"])
+	}
+}
+
+// dialTestWebSocket serves handleWebSocket on a test server and connects to it with a valid viewer token
+func dialTestWebSocket(t *testing.T, taskManager *TaskManager, config *Config, wsManager *WebSocketManager, taskID string) (*websocket.Conn, func()) {
+	t.Helper()
+	return dialTestWebSocketQuery(t, taskManager, config, wsManager, taskID, "")
+}
+
+// dialTestWebSocketQuery is dialTestWebSocket with additional query parameters (e.g. "&tail=10")
+func dialTestWebSocketQuery(t *testing.T, taskManager *TaskManager, config *Config, wsManager *WebSocketManager, taskID, query string) (*websocket.Conn, func()) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, taskManager, config, createUpgrader(nil, false), wsManager)
+	}))
+
+	token, err := generateViewerToken(taskID, config.Auth.Secret, time.Hour)
+	if err != nil {
+		server.Close()
+		t.Fatalf("generateViewerToken() = %v", err)
+	}
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?task_id=" + taskID + "&token=" + token + query
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Dial() = %v", err)
+	}
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestHandleWebSocketOutlivesWriteTimeout(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTimeout := 1
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, WriteTimeout: &writeTimeout},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "slow-task", Command: "for i in 1 2 3; do echo line$i; sleep 1; done"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("slow-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	// Serve with the production server settings, so the write timeout applies
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, taskManager, config, createUpgrader(nil, false), NewWebSocketManager())
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.Config = newHTTPServer("", handler, config.Server)
+	server.Start()
+	defer server.Close()
+
+	token, err := generateViewerToken(taskID, config.Auth.Secret, time.Hour)
+	if err != nil {
+		t.Fatalf("generateViewerToken() = %v", err)
+	}
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?task_id=" + taskID + "&token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+
+	var stdout strings.Builder
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v after %q; stream was cut before completion", err, stdout.String())
+		}
+		var msg struct {
+			Type        string `json:"type"`
+			Data        string `json:"data"`
+			StdoutBytes *int64 `json:"stdout_bytes"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if msg.Type == "stdout" {
+			stdout.WriteString(msg.Data)
+			continue
+		}
+		if msg.StdoutBytes != nil {
+			break
+		}
+	}
+
+	if want := "line1\nline2\nline3\n"; stdout.String() != want {
+		t.Errorf("streamed stdout = %q; want %q", stdout.String(), want)
+	}
+}
+
+func TestHandleWebSocketHeartbeat(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, HeartbeatInterval: 1},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "silent-task", Command: "echo started; sleep 3.5"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("silent-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(15 * time.Second))
+
+	var heartbeats []HeartbeatMessage
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before completion message", err)
+		}
+		var msg struct {
+			Type        string `json:"type"`
+			StdoutBytes *int64 `json:"stdout_bytes"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if msg.Type == "heartbeat" {
+			var hb HeartbeatMessage
+			if err := json.Unmarshal(data, &hb); err != nil {
+				t.Fatalf("heartbeat is not valid JSON: %v", err)
+			}
+			heartbeats = append(heartbeats, hb)
+			continue
+		}
+		if msg.Type == "system" && msg.StdoutBytes != nil {
+			break // Completion message
+		}
+	}
+
+	// Roughly one heartbeat per second while the task runs for ~3.5s
+	if len(heartbeats) < 2 || len(heartbeats) > 5 {
+		t.Fatalf("got %d heartbeats; want about 3", len(heartbeats))
+	}
+	for i := 1; i < len(heartbeats); i++ {
+		if heartbeats[i].ElapsedSeconds < heartbeats[i-1].ElapsedSeconds {
+			t.Errorf("heartbeat %d elapsed_seconds = %d; want non-decreasing (previous %d)", i, heartbeats[i].ElapsedSeconds, heartbeats[i-1].ElapsedSeconds)
+		}
+	}
+	if last := heartbeats[len(heartbeats)-1]; last.StdoutBytes != int64(len("started\n")) {
+		t.Errorf("last heartbeat stdout_bytes = %d; want %d", last.StdoutBytes, len("started\n"))
+	}
+}
+
+func TestHandleWebSocketOutputRetention(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, OutputRetention: 60},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "quick-task", Command: "echo done"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("quick-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	// readOutput connects a viewer and returns the streamed stdout once the completion message arrives
+	readOutput := func() string {
+		conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+		defer cleanup()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		var stdout strings.Builder
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() = %v before completion message", err)
+			}
+			var msg struct {
+				Type        string `json:"type"`
+				Data        string `json:"data"`
+				StdoutBytes *int64 `json:"stdout_bytes"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("message is not valid JSON: %v", err)
+			}
+			if msg.Type == "stdout" {
+				stdout.WriteString(msg.Data)
+			}
+			if msg.Type == "system" && msg.StdoutBytes != nil {
+				return stdout.String()
+			}
+		}
+	}
+
+	if got := readOutput(); got != "done\n" {
+		t.Errorf("first viewer stdout = %q; want %q", got, "done\n")
+	}
+
+	// Give the monitor time to finish its post-completion steps
+	time.Sleep(2500 * time.Millisecond)
+
+	task, err := taskManager.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() after completion = %v; want retained task", err)
+	}
+	if !task.Completed {
+		t.Error("task.Completed = false; want true")
+	}
+	if _, err := os.Stat(task.OutputDir); err != nil {
+		t.Errorf("output directory removed during retention: %v", err)
+	}
+
+	// A viewer opening the link after completion still gets the full output
+	if got := readOutput(); got != "done\n" {
+		t.Errorf("late viewer stdout = %q; want %q", got, "done\n")
+	}
+}
+
+func TestHandleWebSocketReportsExitStatusOfKilledWrapper(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			// The wrapper is killed before it can write the exitcode file
+			{Name: "killed-task", Command: "sleep 0.5; kill -9 $PPID"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("killed-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before completion message", err)
+		}
+		var msg SystemMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if !strings.HasPrefix(msg.Message, "Process ended") {
+			continue
+		}
+		if want := "Process ended with exit code: 137"; msg.Message != want {
+			t.Errorf("completion message = %q; want %q", msg.Message, want)
+		}
+		return
+	}
+}
+
+func TestHandleWebSocketStartupTimeout(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// A shell that hangs instead of running the wrapper, so the task command never starts
+	shell := filepath.Join(tmpDir, "hung-shell")
+	if err := os.WriteFile(shell, []byte("#!/bin/sh\nexec sleep 30\n"), 0700); err != nil {
+		t.Fatalf("Failed to create shell: %v", err)
+	}
+	taskDir := filepath.Join(tmpDir, "tasks")
+	config := &Config{
+		Server: ServerConfig{TaskDir: taskDir, StartupTimeout: 1, Shell: shell},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks:  []TaskConfig{{Name: "hung-task", Command: "echo never"}},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID, err := taskManager.StartTask("hung-task", nil)
+	if err != nil {
+		t.Fatalf("StartTask() = %v", err)
+	}
+	task, err := taskManager.GetTask(taskID)
+	if err != nil {
+		t.Fatalf("GetTask() = %v", err)
+	}
+	outputDir := task.OutputDir
+	exited := taskManager.Exited(taskID)
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before startup failure message", err)
+		}
+		var msg SystemMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if strings.Contains(msg.Message, "failed to start") {
+			break
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("startup failure reported after %v; want about 1s", elapsed)
+	}
+
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("connection still open after startup failure")
+	}
+	if _, err := taskManager.GetTask(taskID); err == nil {
+		t.Error("failed task is still registered")
+	}
+	select {
+	case <-exited:
+	case <-time.After(3 * time.Second):
+		t.Error("process of failed task still running; want its process group killed")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(outputDir); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Error("output directory of failed task was not removed")
+}
+
+func TestHandleWebSocketFileWaitTimeout(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, FileWaitTimeout: 1, FilePollInterval: 50},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+	}
+	taskManager := NewTaskManager(config)
+
+	// Task whose output files were never created
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	outputDir := filepath.Join(tmpDir, taskID)
+	if err := os.MkdirAll(outputDir, 0700); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	taskManager.runningTasks[taskID] = &RunningTask{
+		ID:        taskID,
+		TaskName:  "slow-task",
+		StartTime: time.Now(),
+		OutputDir: outputDir,
+		State:     TaskStateRunning,
+	}
+
+	conn, cleanup := dialTestWebSocket(t, taskManager, config, NewWebSocketManager(), taskID)
+	defer cleanup()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	waiting := make(map[string]bool)
+	for len(waiting) < 2 {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v before waiting messages of both streams", err)
+		}
+		var msg WebSocketMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if msg.Data == "Waiting for output file..." {
+			waiting[msg.Type] = true
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("waiting message sent after %v; want about 1s", elapsed)
+	}
+	if !waiting["stdout"] || !waiting["stderr"] {
+		t.Errorf("waiting messages for %v; want stdout and stderr", waiting)
+	}
+}
+
+func TestNewFileWait(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          ServerConfig
+		wantTimeout  time.Duration
+		wantInterval time.Duration
+	}{
+		{"defaults", ServerConfig{}, defaultFileWaitTimeout, defaultFilePollInterval},
+		{"configured", ServerConfig{FileWaitTimeout: 300, FilePollInterval: 100}, 300 * time.Second, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait := newFileWait(tt.cfg)
+			if wait.timeout != tt.wantTimeout || wait.interval != tt.wantInterval {
+				t.Errorf("newFileWait() = %v/%v; want %v/%v", wait.timeout, wait.interval, tt.wantTimeout, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocketSubprotocol(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+	}
+	taskManager := NewTaskManager(config)
+
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	outputDir := filepath.Join(tmpDir, taskID)
+	if err := os.MkdirAll(outputDir, 0700); err != nil {
+		t.Fatalf("Failed to create output dir: %v", err)
+	}
+	taskManager.runningTasks[taskID] = &RunningTask{
+		ID:        taskID,
+		TaskName:  "test-task",
+		StartTime: time.Now(),
+		OutputDir: outputDir,
+		State:     TaskStateRunning,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, taskManager, config, createUpgrader(nil, false), NewWebSocketManager())
+	}))
+	defer server.Close()
+	token, err := generateViewerToken(taskID, config.Auth.Secret, time.Hour)
+	if err != nil {
+		t.Fatalf("generateViewerToken() = %v", err)
+	}
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?task_id=" + taskID + "&token=" + token
+
+	tests := []struct {
+		name            string
+		subprotocols    []string
+		wantSubprotocol string
+		wantStatus      int // 0 = upgrade succeeds
+	}{
+		{name: "no subprotocol", subprotocols: nil, wantSubprotocol: ""},
+		{name: "current subprotocol", subprotocols: []string{WSSubprotocol}, wantSubprotocol: WSSubprotocol},
+		{name: "current among others", subprotocols: []string{"vstask.v0", WSSubprotocol}, wantSubprotocol: WSSubprotocol},
+		{name: "unknown subprotocol", subprotocols: []string{"vstask.v0"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tt.subprotocols}
+			conn, resp, err := dialer.Dial(wsURL, nil)
+			if tt.wantStatus != 0 {
+				if err == nil {
+					conn.Close()
+					t.Fatalf("Dial() succeeded; want status %d", tt.wantStatus)
+				}
+				if resp == nil || resp.StatusCode != tt.wantStatus {
+					t.Fatalf("Dial() response = %v; want status %d", resp, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial() = %v", err)
+			}
+			defer conn.Close()
+
+			if got := conn.Subprotocol(); got != tt.wantSubprotocol {
+				t.Errorf("Subprotocol() = %q; want %q", got, tt.wantSubprotocol)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() = %v", err)
+			}
+			var msg SystemMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("message is not valid JSON: %v", err)
+			}
+			if msg.Type != "system" || msg.Version != WSMessageVersion {
+				t.Errorf("initial message = %s; want system message with version %d", data, WSMessageVersion)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocketUpgradeFailure(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+	}
+	taskManager := NewTaskManager(config)
+	taskID := "550e8400-e29b-41d4-a716-446655440000"
+	taskManager.runningTasks[taskID] = &RunningTask{
+		ID:        taskID,
+		TaskName:  "test-task",
+		StartTime: time.Now(),
+		OutputDir: filepath.Join(tmpDir, taskID),
+		State:     TaskStateRunning,
+	}
+	token, err := generateViewerToken(taskID, config.Auth.Secret, time.Hour)
+	if err != nil {
+		t.Fatalf("generateViewerToken() = %v", err)
+	}
+
+	handshake := func(r *http.Request) {
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	}
+	tests := []struct {
+		name       string
+		upgrader   websocket.Upgrader
+		prepare    func(r *http.Request)
+		wantStatus int
+	}{
+		{name: "not a WebSocket handshake", upgrader: createUpgrader(nil, false), prepare: func(r *http.Request) {}, wantStatus: http.StatusBadRequest},
+		{name: "origin not allowed", upgrader: createUpgrader([]string{"https://allowed.example.com"}, false), prepare: func(r *http.Request) {
+			handshake(r)
+			r.Header.Set("Origin", "https://evil.example.com")
+		}, wantStatus: http.StatusForbidden},
+		// httptest.ResponseRecorder can't be hijacked
+		{name: "hijack not supported", upgrader: createUpgrader(nil, false), prepare: handshake, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws?task_id="+taskID+"&token="+token, nil)
+			tt.prepare(req)
+			w := httptest.NewRecorder()
+
+			handleWebSocket(w, req, taskManager, config, tt.upgrader, NewWebSocketManager())
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleWebSocket() status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			// Only the upgrader's error response, no second error written after it
+			if body := w.Body.String(); strings.Contains(body, `"code"`) || strings.Count(body, "\n") != 1 {
+				t.Errorf("handleWebSocket() body = %q; want the upgrader's error only", body)
+			}
+		})
+	}
+}
+
+func TestParseTailLines(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"25", 25, false},
+		{"-1", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTailLines(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTailLines(%q) = %d, %v; want %d, wantErr %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLastLinesOffset(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	long := strings.Repeat("x", 3*tailScanBlockSize) + "\n"
+	tests := []struct {
+		name    string
+		content string
+		n       int
+		want    string // Content from the returned offset
+	}{
+		{"last two lines", "a\nb\nc\n", 2, "b\nc\n"},
+		{"more lines than available", "a\nb\n", 5, "a\nb\n"},
+		{"partial last line", "a\nb\nc", 2, "b\nc"},
+		{"empty lines", "a\n\n\n", 2, "\n\n"},
+		{"empty file", "", 3, ""},
+		{"lines spanning blocks", "a\n" + long + long, 2, long + long},
+		{"zero lines", "a\nb\n", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, "stdout")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Failed to open file: %v", err)
+			}
+			defer file.Close()
+
+			offset, err := lastLinesOffset(file, int64(len(tt.content)), tt.n)
+			if err != nil {
+				t.Fatalf("lastLinesOffset() error = %v", err)
+			}
+			if got := tt.content[offset:]; got != tt.want {
+				t.Errorf("lastLinesOffset(%d) = %d, content %q; want content %q", tt.n, offset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocketTail(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "plain-task", Command: "seq 1 1000; sleep 1; seq 1001 1003"},
+			{Name: "compressed-task", Command: "seq 1 1000; seq 1 3 >&2", CompressOutput: true},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	// readOutput connects a viewer with ?tail=10 and returns the streamed output once the completion message arrives
+	readOutput := func(taskID string) (string, string) {
+		conn, cleanup := dialTestWebSocketQuery(t, taskManager, config, NewWebSocketManager(), taskID, "&tail=10")
+		defer cleanup()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+		var stdout, stderr strings.Builder
+		for {
+			var msg struct {
+				Type        string `json:"type"`
+				Data        string `json:"data"`
+				StdoutBytes *int64 `json:"stdout_bytes"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("ReadJSON() = %v before completion message", err)
+			}
+			switch {
+			case msg.Type == "stdout":
+				stdout.WriteString(msg.Data)
+			case msg.Type == "stderr":
+				stderr.WriteString(msg.Data)
+			case msg.Type == "system" && msg.StdoutBytes != nil:
+				return stdout.String(), stderr.String()
+			}
+		}
+	}
+
+	t.Run("running task", func(t *testing.T) {
+		taskID, err := taskManager.StartTask("plain-task", nil)
+		if err != nil {
+			t.Fatalf("StartTask() = %v", err)
+		}
+		// Connect once the first part of the output has been written
+		stdoutPath := filepath.Join(tmpDir, taskID, "stdout")
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if info, err := os.Stat(stdoutPath); err == nil && info.Size() == int64(len(seqOutput(1000))) {
+				break
+			}
+		}
+
+		stdout, _ := readOutput(taskID)
+		// The last 10 existing lines are replayed, new output follows in full
+		if want := strings.TrimPrefix(seqOutput(1003), seqOutput(990)); stdout != want {
+			t.Errorf("stdout with tail=10 = %q; want %q", stdout, want)
+		}
+	})
+
+	t.Run("compressed output", func(t *testing.T) {
+		taskID, err := taskManager.StartTask("compressed-task", nil)
+		if err != nil {
+			t.Fatalf("StartTask() = %v", err)
+		}
+		if _, ok := taskManager.WaitExitCode(taskID, 5*time.Second); !ok {
+			t.Fatal("WaitExitCode() ok = false; want task to finish")
+		}
+
+		stdout, stderr := readOutput(taskID)
+		if want := strings.TrimPrefix(seqOutput(1000), seqOutput(990)); stdout != want {
+			t.Errorf("stdout with tail=10 = %q; want %q", stdout, want)
+		}
+		if want := seqOutput(3); stderr != want {
+			t.Errorf("stderr with tail=10 = %q; want %q", stderr, want)
+		}
+	})
+}
+
+func TestHandleWebSocketCompletionExitCode(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "success-task", Command: "echo done"},
+			{Name: "failing-task", Command: "exit 3"},
+			{Name: "killed-task", Command: "kill -KILL $$"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		taskName   string
+		wantCode   int
+		wantSignal string
+	}{
+		{"success-task", 0, ""},
+		{"failing-task", 3, ""},
+		{"killed-task", 137, "SIGKILL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.taskName, func(t *testing.T) {
+			taskID, err := taskManager.StartTask(tt.taskName, nil)
+			if err != nil {
+				t.Fatalf("StartTask() = %v", err)
+			}
+			conn, cleanup := dialTestWebSocketQuery(t, taskManager, config, NewWebSocketManager(), taskID, "")
+			defer cleanup()
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+			var msg SystemMessage
+			for msg.StdoutBytes == nil {
+				msg = SystemMessage{}
+				if err := conn.ReadJSON(&msg); err != nil {
+					t.Fatalf("ReadJSON() = %v before completion message", err)
+				}
+			}
+
+			if msg.ExitCode == nil {
+				t.Fatalf("completion message = %+v; want exit_code", msg)
+			}
+			// The output directory is removed only after the completion message has been sent
+			if fileCode := readExitCode(filepath.Join(tmpDir, taskID, "exitcode")); *msg.ExitCode != fileCode {
+				t.Errorf("completion exit_code = %d; want %d from exitcode file", *msg.ExitCode, fileCode)
+			}
+			if *msg.ExitCode != tt.wantCode {
+				t.Errorf("completion exit_code = %d; want %d", *msg.ExitCode, tt.wantCode)
+			}
+			if msg.Signal != tt.wantSignal {
+				t.Errorf("completion signal = %q; want %q", msg.Signal, tt.wantSignal)
+			}
+			if want := fmt.Sprintf("Process ended with exit code: %d", tt.wantCode); msg.Message != want {
+				t.Errorf("completion message = %q; want %q", msg.Message, want)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocketMalformedTaskID(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+	}
+	taskManager := NewTaskManager(config)
+
+	tests := []struct {
+		name       string
+		claim      string
+		query      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"malformed query", "550e8400-e29b-41d4-a716-446655440000", "not-a-task-id", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"malformed claim", "../../etc", "", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"missing", "", "", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"unknown task", "550e8400-e29b-41d4-a716-446655440000", "", http.StatusNotFound, ErrCodeTaskNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := createTestToken(t, config.Auth.Secret, "viewer", tt.claim, time.Hour)
+			req := httptest.NewRequest(http.MethodGet, "/ws?token="+token+"&task_id="+tt.query, nil)
+			w := httptest.NewRecorder()
+			handleWebSocket(w, req, taskManager, config, createUpgrader(nil, false), NewWebSocketManager())
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleWebSocket() status = %d; want %d", w.Code, tt.wantStatus)
+			}
+			var errResp ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+				t.Fatalf("handleWebSocket() body %q is not JSON: %v", w.Body.String(), err)
+			}
+			if errResp.Code != tt.wantCode {
+				t.Errorf("handleWebSocket() error code = %q; want %q", errResp.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHandleWebSocketTaskAllowedOrigins(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		Server: ServerConfig{TaskDir: tmpDir, AllowedOrigins: []string{"https://viewer.example.com"}},
+		Auth:   AuthConfig{Secret: "test-secret-key"},
+		Tasks: []TaskConfig{
+			{Name: "embedded-task", Command: "echo test", AllowedOrigins: []string{"https://*.embed.example.com"}},
+			{Name: "test-task", Command: "echo test"},
+		},
+	}
+	taskManager := NewTaskManager(config)
+	embeddedID := "11111111-1111-1111-1111-111111111111"
+	otherID := "22222222-2222-2222-2222-222222222222"
+	taskManager.runningTasks[embeddedID] = &RunningTask{ID: embeddedID, TaskName: "embedded-task", OutputDir: tmpDir, StartTime: time.Now()}
+	taskManager.runningTasks[otherID] = &RunningTask{ID: otherID, TaskName: "test-task", OutputDir: tmpDir, StartTime: time.Now()}
+
+	upgrader := createUpgrader(config.Server.AllowedOrigins, config.Server.DenyAllOriginsIfEmpty)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleWebSocket(w, r, taskManager, config, upgrader, NewWebSocketManager())
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		taskID string
+		origin string
+		wantOK bool
+	}{
+		{"task origin for its task", embeddedID, "https://app.embed.example.com", true},
+		{"task origin for other task", otherID, "https://app.embed.example.com", false},
+		{"global origin for task with own origins", embeddedID, "https://viewer.example.com", true},
+		{"global origin for other task", otherID, "https://viewer.example.com", true},
+		{"unknown origin", embeddedID, "https://evil.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := generateViewerToken(tt.taskID, config.Auth.Secret, time.Hour)
+			if err != nil {
+				t.Fatalf("generateViewerToken() = %v", err)
+			}
+			wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?task_id=" + tt.taskID + "&token=" + token
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {tt.origin}})
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("Dial() with origin %s = %v; want connection", tt.origin, err)
+				}
+				conn.Close()
+				return
+			}
+			if err == nil {
+				conn.Close()
+				t.Fatalf("Dial() with origin %s connected; want rejection", tt.origin)
+			}
+			if resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Dial() with origin %s response = %v; want %d", tt.origin, resp, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestCompleteUTF8Len(t *testing.T) {
+	euro := []byte("€") // 3 bytes
+	tests := []struct {
+		data []byte
+		want int
+	}{
+		{[]byte("abc"), 3},
+		{[]byte("ab€"), 5},
+		{append([]byte("ab"), euro[:1]...), 2},
+		{append([]byte("ab"), euro[:2]...), 2},
+		{euro[:2], 0},
+		{[]byte{'a', 0xff}, 2}, // Invalid byte, not the start of a character
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := completeUTF8Len(tt.data); got != tt.want {
+			t.Errorf("completeUTF8Len(%q) = %d; want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestTailFileSplitUTF8(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "websocket-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "stdout")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	// The tailer writes on the server side of a connection, the test reads as the client
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() = %v", err)
+	}
+	defer client.Close()
+	serverConn := <-conns
+	defer serverConn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var streamed atomic.Int64
+	go tailFile(ctx, &safeConn{conn: serverConn}, newTailHandleGuard(0), newFileWait(ServerConfig{}), path, "stdout", "test-task", &streamed, 0, 0, "", "")
+
+	// Write a multi-byte character one byte per poll cycle
+	writes := [][]byte{[]byte("price: ")}
+	for _, b := range []byte("€") {
+		writes = append(writes, []byte{b})
+	}
+	writes = append(writes, []byte(" 5\n"))
+	for _, data := range writes {
+		if _, err := file.Write(data); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	var received strings.Builder
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for !strings.HasSuffix(received.String(), " 5\n") {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() = %v after %q", err, received.String())
+		}
+		var msg WebSocketMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("message is not valid JSON: %v", err)
+		}
+		if !utf8.ValidString(msg.Data) || strings.ContainsRune(msg.Data, utf8.RuneError) {
+			t.Errorf("message data %q contains an invalid UTF-8 fragment", msg.Data)
+		}
+		received.WriteString(msg.Data)
+	}
+
+	// Unterminated output is sent as it arrives, each part as its own line
+	if got := strings.ReplaceAll(received.String(), "\n", ""); got != "price: € 5" {
+		t.Errorf("received %q; want the character intact in %q", received.String(), "price: € 5")
+	}
+	want := int64(len("price: € 5\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for streamed.Load() != want && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if got := streamed.Load(); got != want {
+		t.Errorf("streamed = %d; want %d", got, want)
+	}
+}